@@ -13,7 +13,7 @@ func help() {
 dukptcli is a tool for both tdes and aes derived unique key per transaction (dukpt) key management.
 
 USAGE
-   dukptcli [-v] [-algorithm] [-ik] [-tk] [-ep] [-dp] [-gm] [-en] [-de]
+   dukptcli [-v] [-algorithm] [-ik] [-tk] [-ep] [-dp] [-pb] [-gm] [-en] [-de] [-xk] [-explain] [-insecure]
 
 EXAMPLES
   dukptcli -v          Print the version of dukptcli (Example: %s)
@@ -22,9 +22,13 @@ EXAMPLES
   dukptcli -tk         Derive transaction key (current transaction key) from initial key and key serial number
   dukptcli -ep         Encrypt pin block using dukpt transaction key
   dukptcli -dp         Decrypt pin block using dukpt transaction key
+  dukptcli -pb         Decode clear pin block fields and detect its ISO format (decrypted first when pb.tk is given)
   dukptcli -gm         Generate mac using dukpt transaction key
   dukptcli -en         Encrypt data using dukpt transaction key
   dukptcli -de         Decrypt data using dukpt transaction key
+  dukptcli -xk         Export initial key, transaction key or working key wrapped under aes key encryption key (RFC 3394, RFC 5649)
+  dukptcli -explain    Print derivation trace of transaction key or working key (keys as key check values)
+  dukptcli -insecure   Print clear keys in derivation trace
 
 FLAGS
 `), dukpt.Version)
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/des" //nolint:gosec
+	"fmt"
+
+	"github.com/chmike/cmac-go"
+	"github.com/moov-io/dukpt/pkg"
+	dukptaes "github.com/moov-io/dukpt/pkg/aes"
+	dukptdes "github.com/moov-io/dukpt/pkg/des"
+	"github.com/moov-io/dukpt/pkg/server"
+)
+
+// explain prints derivation trace of transaction key (empty usage) or working key of usage
+func explain(params server.UnifiedParams, usage string) {
+	if !*flagExplain || params.ValidateAlgorithm() != nil {
+		return
+	}
+
+	tracer := pkg.TracerFunc(func(step pkg.TraceStep) {
+		name := step.Name
+		if step.Detail != "" {
+			name += " (" + step.Detail + ")"
+		}
+
+		switch {
+		case len(step.Value) == 0:
+			fmt.Printf("TRACE: %s\n", name)
+		case step.Secret && !*flagInsecure:
+			fmt.Printf("TRACE: %s: KCV %s\n", name, keyCheckValue(params.Algorithm, step.Value))
+		default:
+			fmt.Printf("TRACE: %s: %s\n", name, pkg.HexEncode(step.Value))
+		}
+	})
+
+	var err error
+	switch {
+	case usage == "" && params.Algorithm == pkg.AlgorithmAes:
+		_, err = dukptaes.DeriveCurrentTransactionKeyWithTracer(pkg.HexDecode(params.IK), pkg.HexDecode(params.KSN), tracer)
+	case usage == "":
+		_, err = dukptdes.DeriveCurrentTransactionKeyWithTracer(pkg.HexDecode(params.IK), pkg.HexDecode(params.KSN), tracer)
+	case params.Algorithm == pkg.AlgorithmAes:
+		_, err = dukptaes.DeriveWorkingKey(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), usage, params.AlgorithmKey, params.Action, tracer)
+	case usage == pkg.UsageData:
+		var mode dukptdes.DataKeyMode
+		if mode, err = dukptdes.ParseDataKeyMode(params.DataKeyMode); err == nil {
+			action := pkg.Request
+			if params.Action == pkg.ActionResponse {
+				action = pkg.Response
+			}
+			_, err = dukptdes.DeriveDataKey(pkg.HexDecode(params.TK), action, mode, tracer)
+		}
+	default:
+		_, err = dukptdes.DeriveWorkingKey(pkg.HexDecode(params.TK), usage, params.Action, tracer)
+	}
+
+	if err != nil {
+		fmt.Printf("TRACE: %s\n", err.Error())
+	}
+}
+
+// keyCheckValue identifies key without revealing it
+//
+//   - des: leftmost 3 bytes of TDES encryption of a block of zeros
+//   - aes: leftmost 5 bytes of AES-CMAC of a block of zeros
+func keyCheckValue(algorithm string, key []byte) string {
+	if algorithm == pkg.AlgorithmAes {
+		cm, err := cmac.New(aes.NewCipher, key)
+		if err != nil {
+			return fmt.Sprintf("unavailable (%d bytes key)", len(key))
+		}
+		cm.Write(make([]byte, aes.BlockSize))
+		return pkg.HexEncode(cm.Sum(nil)[:5])
+	}
+
+	tripleDESKey := append(append([]byte{}, key...), key[:min(len(key), des.BlockSize)]...)
+	// codeql[go/weak-cryptographic-algorithm] DES/3DES required by ANSI X9.24 DUKPT
+	block, err := des.NewTripleDESCipher(tripleDESKey) //nolint:gosec
+	if err != nil {
+		return fmt.Sprintf("unavailable (%d bytes key)", len(key))
+	}
+
+	kcv := make([]byte, des.BlockSize)
+	block.Encrypt(kcv, kcv)
+	return pkg.HexEncode(kcv[:3])
+}
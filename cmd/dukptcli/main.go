@@ -4,17 +4,26 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/moov-io/dukpt"
 	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
 	"github.com/moov-io/dukpt/pkg/server"
 )
 
 var (
 	flagVersion = flag.Bool("v", false, "Print dupkt cli version")
 
-	flagAlgorithm        = flag.String("algorithm", "des", "data encryption algorithm (options: des, aes)")
+	flagAlgorithm        = flag.String("algorithm", "des", fmt.Sprintf("data encryption algorithm (options: %s)", strings.Join(server.Algorithms(), ", ")))
 	flagAlgorithmKeyType = flag.String("algorithm.key_type", "aes128", "key type of aes (options: aes128, aes192, aes256")
+	flagDataKeyMode      = flag.String("algorithm.data_key_mode", "", "data key derivation mode of des (options: owf, variant) (default owf)")
+
+	flagRandSeed = flag.String("rand.seed", "", "seed of deterministic random source of pin block fill (reproducible output for golden tests, crypto/rand when empty)")
+
+	flagExplain  = flag.Bool("explain", false, "print derivation trace of transaction key (tk) or working key (ep, dp, gm, en, de)")
+	flagInsecure = flag.Bool("insecure", false, "print clear keys in derivation trace (key check values are printed otherwise)")
 
 	flagInitialKey    = flag.Bool("ik", false, "derive initial key from base derivative key and key serial number (or initial key id)")
 	flagInitialKeyBKD = flag.String("ik.bdk", "", "base derivative key")
@@ -38,6 +47,12 @@ var (
 	flagDecryptPinPan    = flag.String("dp.pan", "", "not formatted pan string")
 	flagDecryptPinFormat = flag.String("dp.format", "", "pin block format (ISO-0, ISO-1, ISO-2, ISO-3, ISO-4, ANSI, ECI1, ECI2, ECI3, ECI4, VISA1, VISA2, VISA3, VISA4)")
 
+	flagPinBlock      = flag.Bool("pb", false, "decode clear pin block fields (pin block is decrypted first when pb.tk is given)")
+	flagPinBlockTK    = flag.String("pb.tk", "", "current transaction key (empty when pin block is clear)")
+	flagPinBlockKSN   = flag.String("pb.ksn", "", "key serial number")
+	flagPinBlockBlock = flag.String("pb.block", "", "encrypted or clear pin block")
+	flagPinBlockPan   = flag.String("pb.pan", "", "not formatted pan string (required by ISO-0, ISO-3 and ISO-4 blocks)")
+
 	flagGenerateMac       = flag.Bool("gm", false, "generate mac using dukpt transaction key")
 	flagGenerateMacTK     = flag.String("gm.tk", "", "current transaction key")
 	flagGenerateMacKSN    = flag.String("gm.ksn", "", "key serial number")
@@ -58,6 +73,16 @@ var (
 	flagDecryptIV     = flag.String("de.iv", "", "initial vector (not formatted string)")
 	flagDecryptData   = flag.String("de.data", "", "encrypted text transformed from plaintext using an encryption algorithm")
 	flagDecryptAction = flag.String("de.action", "request", "request or response action")
+
+	flagExportKey       = flag.Bool("xk", false, "export initial key, transaction key or working key wrapped under aes key encryption key")
+	flagExportKeyKEK    = flag.String("xk.kek", "", "aes key encryption key")
+	flagExportKeyKey    = flag.String("xk.key", "", "exported key (ik, tk, pin, mac, data)")
+	flagExportKeyBDK    = flag.String("xk.bdk", "", "base derivative key (exporting ik)")
+	flagExportKeyIK     = flag.String("xk.ik", "", "initial key (exporting tk)")
+	flagExportKeyTK     = flag.String("xk.tk", "", "current transaction key (exporting working key)")
+	flagExportKeyKSN    = flag.String("xk.ksn", "", "key serial number")
+	flagExportKeyAction = flag.String("xk.action", "request", "request or response action")
+	flagExportKeyMode   = flag.String("xk.mode", "rfc5649", "key wrap mode (options: rfc3394, rfc5649)")
 )
 
 func main() {
@@ -79,6 +104,17 @@ func main() {
 
 	params.Algorithm = *flagAlgorithm
 	params.AlgorithmKey = *flagAlgorithmKeyType
+	params.DataKeyMode = *flagDataKeyMode
+
+	// checking random source
+	if *flagRandSeed != "" {
+		seed, err := strconv.ParseInt(*flagRandSeed, 10, 64)
+		if err != nil {
+			fmt.Printf("invalid seed of rand.seed flag\n")
+			os.Exit(1)
+		}
+		params.Rand = pkg.NewSeededReader(seed)
+	}
 
 	// checking ik params
 	if *flagInitialKey {
@@ -112,6 +148,7 @@ func main() {
 		params.IK = *flagTransactionKeyIK
 		params.KSN = *flagTransactionKeyKSN
 
+		explain(params, "")
 		makeFuncCall(server.TransactionKey, params)
 		return
 	}
@@ -149,6 +186,7 @@ func main() {
 		params.KSN = *flagEncryptPinKSN
 		params.Format = *flagEncryptPinFormat
 
+		explain(params, pkg.UsagePin)
 		makeFuncCall(server.EncryptPin, params)
 		return
 	}
@@ -186,10 +224,41 @@ func main() {
 		params.KSN = *flagDecryptPinKSN
 		params.Format = *flagDecryptPinFormat
 
+		explain(params, pkg.UsagePin)
 		makeFuncCall(server.DecryptPin, params)
 		return
 	}
 
+	// checking pin block params
+	if *flagPinBlock {
+		if *flagPinBlockBlock == "" {
+			fmt.Printf("please select pin block with pb.block flag\n")
+			os.Exit(1)
+		}
+		if *flagAlgorithm == pkg.AlgorithmAes && *flagPinBlockTK != "" {
+			if *flagPinBlockKSN == "" {
+				fmt.Printf("please select key serial number with pb.ksn flag\n")
+				os.Exit(1)
+			}
+			if *flagPinBlockPan == "" {
+				fmt.Printf("please select pan string with pb.pan flag\n")
+				os.Exit(1)
+			}
+		}
+
+		if err := params.ValidateAlgorithm(); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(2)
+		}
+
+		err := decodePinBlock(*flagAlgorithm, *flagAlgorithmKeyType, *flagPinBlockTK, *flagPinBlockKSN, *flagPinBlockBlock, *flagPinBlockPan)
+		if err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(2)
+		}
+		return
+	}
+
 	// checking generate mac params
 	if *flagGenerateMac {
 		if *flagGenerateMacTK == "" {
@@ -222,6 +291,12 @@ func main() {
 		params.MacType = *flagGenerateMacType
 		params.KSN = *flagGenerateMacKSN
 
+		// hmac working key has the length of aes key type, as in service mac generation
+		if keyType, err := aes.ParseKeyType(params.AlgorithmKey); err == nil && params.MacType == pkg.MaxTypeHmac {
+			params.AlgorithmKey = keyType.HMAC().String()
+		}
+
+		explain(params, pkg.UsageMac)
 		makeFuncCall(server.GenerateMac, params)
 		return
 	}
@@ -254,6 +329,7 @@ func main() {
 		params.KSN = *flagEncryptKSN
 		params.Action = *flagEncryptAction
 
+		explain(params, pkg.UsageData)
 		makeFuncCall(server.EncryptData, params)
 		return
 	}
@@ -286,10 +362,57 @@ func main() {
 		params.KSN = *flagDecryptKSN
 		params.Action = *flagDecryptAction
 
+		explain(params, pkg.UsageData)
 		makeFuncCall(server.DecryptData, params)
 		return
 	}
 
+	// checking export key params
+	if *flagExportKey {
+		if *flagExportKeyKEK == "" {
+			fmt.Printf("please select key encryption key with xk.kek flag\n")
+			os.Exit(1)
+		}
+
+		switch *flagExportKeyKey {
+		case server.ExportKeyInitial:
+			if *flagExportKeyBDK == "" {
+				fmt.Printf("please select base derivative key with xk.bdk flag\n")
+				os.Exit(1)
+			}
+		case server.ExportKeyTransaction:
+			if *flagExportKeyIK == "" {
+				fmt.Printf("please select initial key with xk.ik flag\n")
+				os.Exit(1)
+			}
+		case pkg.UsagePin, pkg.UsageMac, pkg.UsageData:
+			if *flagExportKeyTK == "" {
+				fmt.Printf("please select current transaction key with xk.tk flag\n")
+				os.Exit(1)
+			}
+		default:
+			fmt.Printf("please select exported key with xk.key flag\n")
+			os.Exit(1)
+		}
+
+		if *flagExportKeyKSN == "" && (*flagAlgorithm == pkg.AlgorithmAes || *flagExportKeyKey == server.ExportKeyInitial || *flagExportKeyKey == server.ExportKeyTransaction) {
+			fmt.Printf("please select key serial number with xk.ksn flag\n")
+			os.Exit(1)
+		}
+
+		params.KEK = *flagExportKeyKEK
+		params.ExportKey = *flagExportKeyKey
+		params.BKD = *flagExportKeyBDK
+		params.IK = *flagExportKeyIK
+		params.TK = *flagExportKeyTK
+		params.KSN = *flagExportKeyKSN
+		params.Action = *flagExportKeyAction
+		params.KeyWrapMode = *flagExportKeyMode
+
+		makeFuncCall(server.ExportKey, params)
+		return
+	}
+
 	flag.Usage()
 	os.Exit(1)
 }
@@ -299,6 +422,10 @@ func makeFuncCall(f server.WrapperCall, params server.UnifiedParams) {
 		fmt.Printf("%s\n", err.Error())
 		os.Exit(2)
 	}
+	if err := params.ValidateDataKeyMode(); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(2)
+	}
 
 	result, err := f(params)
 	if err != nil {
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/moov-io/dukpt/pkg"
+	dukptaes "github.com/moov-io/dukpt/pkg/aes"
+	dukptdes "github.com/moov-io/dukpt/pkg/des"
+	"github.com/moov-io/dukpt/pkg/pin"
+)
+
+// decodePinBlock decrypts pin block (when transaction key is given) and prints fields of the clear block
+func decodePinBlock(algorithm, keyType, tk, ksn, block, pan string) error {
+	clearBlock := pkg.HexDecode(block)
+	if clearBlock == nil {
+		return fmt.Errorf("invalid pin block %q", block)
+	}
+
+	if tk != "" {
+		var err error
+		if algorithm == pkg.AlgorithmAes {
+			clearBlock, err = decryptAesPinBlock(keyType, tk, ksn, clearBlock, pan)
+		} else {
+			clearBlock, err = dukptdes.DecryptPinBlock(pkg.HexDecode(tk), clearBlock)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("CLEAR BLOCK: %s\n", strings.ToUpper(pkg.HexEncode(clearBlock)))
+
+	fields, err := pin.DecodeBlock(clearBlock, pan)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("FORMAT: %s\n", fields.Format)
+	fmt.Printf("PIN LENGTH: %d\n", fields.PinLength)
+	fmt.Printf("PIN: %s\n", fields.Pin)
+	fmt.Printf("FILL: %s\n", fields.Fill)
+	if fields.Random != "" {
+		fmt.Printf("RANDOM: %s\n", fields.Random)
+	}
+
+	return nil
+}
+
+func decryptAesPinBlock(keyType, tk, ksn string, ciphertext []byte, pan string) ([]byte, error) {
+	workingKeyType, err := dukptaes.ParseKeyType(strings.ToUpper(keyType))
+	if err != nil {
+		return nil, err
+	}
+
+	panField, err := pin.ISO4PanField(pan)
+	if err != nil {
+		return nil, err
+	}
+
+	return dukptaes.DecryptPinBlock(pkg.HexDecode(tk), pkg.HexDecode(ksn), ciphertext, panField, workingKeyType)
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,6 +16,9 @@ import (
 	"github.com/moov-io/base/http/bind"
 	"github.com/moov-io/base/log"
 	"github.com/moov-io/dukpt"
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/host"
+	"github.com/moov-io/dukpt/pkg/hsm"
 	"github.com/moov-io/dukpt/pkg/server"
 
 	kitlog "github.com/go-kit/log"
@@ -49,7 +53,7 @@ func main() {
 
 	// Setup underlying dukpt service
 	r := server.NewRepositoryInMemory(logger)
-	svc = server.NewService(r)
+	svc = server.NewService(r, serviceOptions(logger)...)
 
 	// Create HTTP server
 	handler = server.MakeHTTPHandler(svc)
@@ -130,3 +134,52 @@ func main() {
 		logger.LogError(err)
 	}
 }
+
+// serviceOptions enables optional service features from environment
+//
+//   - DEVICE_BDK_DES and DEVICE_BDK_AES (hex base derivative keys) enable /decrypt_device
+//   - DEVICE_FULL_PAN_TOKENS (comma separated bearer tokens) read unmasked PAN
+//   - KEY_EXPORT_TOKENS (comma separated bearer tokens) export wrapped keys with /export_key
+//   - KEY_PROVIDER_MASTER_KEY (hex aes key) keeps machine keys in software HSM, base derivative keys of machines are key handles
+func serviceOptions(logger log.Logger) []server.ServiceOption {
+	tokens := map[string][]string{}
+	for env, permission := range map[string]string{"DEVICE_FULL_PAN_TOKENS": server.PermissionFullPAN, "KEY_EXPORT_TOKENS": server.PermissionExportKey} {
+		for _, token := range strings.Split(os.Getenv(env), ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens[token] = append(tokens[token], permission)
+			}
+		}
+	}
+	opts := []server.ServiceOption{server.WithAuthorizer(server.NewTokenAuthorizer(tokens))}
+
+	if v := os.Getenv("KEY_PROVIDER_MASTER_KEY"); v != "" {
+		provider, err := hsm.NewSoftware(pkg.HexDecode(v))
+		if err != nil {
+			logger.LogErrorf("invalid KEY_PROVIDER_MASTER_KEY: %v", err)
+			os.Exit(1)
+		}
+		logger.Logf("software key provider is enabled")
+		opts = append(opts, server.WithKeyProvider(provider))
+	}
+
+	bdks := map[string][]byte{}
+	for algorithm, env := range map[string]string{pkg.AlgorithmDes: "DEVICE_BDK_DES", pkg.AlgorithmAes: "DEVICE_BDK_AES"} {
+		if v := os.Getenv(env); v != "" {
+			bdks[algorithm] = pkg.HexDecode(v)
+		}
+	}
+	if len(bdks) == 0 {
+		return opts
+	}
+	logger.Logf("device decryption is enabled")
+
+	resolver := host.BDKResolverFunc(func(algorithm string, _ []byte) ([]byte, error) {
+		bdk, ok := bdks[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("%s bdk is not configured", algorithm)
+		}
+		return bdk, nil
+	})
+
+	return append(opts, server.WithHost(host.NewHost(resolver, host.NewIKCacheInMemory())))
+}
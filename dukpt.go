@@ -0,0 +1,355 @@
+package dukpt
+
+/*
+Unified DUKPT api: TDES (ANSI X9.24-1) or AES (ANSI X9.24-3) DUKPT is chosen by key serial number length or an option
+*/
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+)
+
+const minMacLength = 4
+
+var ErrMacMismatch = errors.New("mac mismatch")
+
+// Options are parameters of DUKPT functions resolved from defaults and Option values
+type Options struct {
+	Algorithm   string
+	KeyType     aes.KeyType
+	PinFormat   des.PinFormat
+	MacType     pkg.MacType
+	Action      pkg.Action
+	DataKeyMode des.DataKeyMode
+	Rand        io.Reader
+}
+
+// Option changes default parameters of DUKPT functions
+type Option func(*Options)
+
+// WithAlgorithm selects des or aes instead of detecting algorithm from key serial number length
+func WithAlgorithm(algorithm string) Option {
+	return func(o *Options) {
+		o.Algorithm = algorithm
+	}
+}
+
+// WithKeyType selects aes working key type (default key type of transaction key length)
+func WithKeyType(keyType aes.KeyType) Option {
+	return func(o *Options) {
+		o.KeyType = keyType
+	}
+}
+
+// WithPinFormat selects des pin block format (default ISO-0)
+func WithPinFormat(format des.PinFormat) Option {
+	return func(o *Options) {
+		o.PinFormat = format
+	}
+}
+
+// WithMacType selects aes mac type (default CMAC)
+func WithMacType(macType pkg.MacType) Option {
+	return func(o *Options) {
+		o.MacType = macType
+	}
+}
+
+// WithAction selects request or response working keys (default request)
+func WithAction(action pkg.Action) Option {
+	return func(o *Options) {
+		o.Action = action
+	}
+}
+
+// WithDataKeyMode selects des data key derivation mode (default owf)
+func WithDataKeyMode(mode des.DataKeyMode) Option {
+	return func(o *Options) {
+		o.DataKeyMode = mode
+	}
+}
+
+// WithRand selects random source of pin block fill (default crypto/rand), see pkg.NewSeededReader for tests
+func WithRand(random io.Reader) Option {
+	return func(o *Options) {
+		o.Rand = random
+	}
+}
+
+// ResolveOptions applies opts to default parameters, algorithm is detected from key serial number length unless WithAlgorithm is given
+//
+// NOTE:
+//   - KeyProvider implementations read parameters of their calls with it
+//
+// Params:
+//   - ksn is 10 bytes (des) or 12 bytes (aes) key serial number
+//   - opts are options of the call
+//
+// Return Params:
+//   - result is resolved options
+//   - err
+func ResolveOptions(ksn []byte, opts ...Option) (*Options, error) {
+	o := &Options{
+		PinFormat: des.ISO0,
+		MacType:   pkg.CMAC,
+		Action:    pkg.Request,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.Algorithm != "" {
+		if o.Algorithm != pkg.AlgorithmDes && o.Algorithm != pkg.AlgorithmAes {
+			return nil, errors.New("invalid encrypt/decrypt algorithm")
+		}
+		return o, nil
+	}
+
+	switch len(ksn) {
+	case pkg.LegacyKsnLength:
+		o.Algorithm = pkg.AlgorithmDes
+	case pkg.AesKsnLength:
+		o.Algorithm = pkg.AlgorithmAes
+	default:
+		return nil, fmt.Errorf("key serial number length must be %d or %d bytes", pkg.LegacyKsnLength, pkg.AesKsnLength)
+	}
+	return o, nil
+}
+
+// AESKeyType returns aes working key type option or key type of transaction key length
+func (o *Options) AESKeyType(keyLen int) (aes.KeyType, error) {
+	if o.KeyType != 0 {
+		return o.KeyType, nil
+	}
+	return aes.KeyTypeOfLength(keyLen)
+}
+
+// AESMacKeyType returns aes key type of mac working key,
+// hmac key type of the same length is returned for HMAC mac type
+func (o *Options) AESMacKeyType(keyLen int) (aes.KeyType, error) {
+	keyType, err := o.AESKeyType(keyLen)
+	if err != nil || o.MacType != pkg.HMAC {
+		return keyType, err
+	}
+	return keyType.HMAC(), nil
+}
+
+// DeriveIK derives initial key from base derivative key
+//
+// Params:
+//   - bdk is base derivative key
+//   - ksn is 10 bytes (des) or 12 bytes (aes) key serial number
+//
+// Return Params:
+//   - result is initial key
+//   - err
+func DeriveIK(bdk, ksn []byte, opts ...Option) ([]byte, error) {
+	o, err := ResolveOptions(ksn, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Algorithm == pkg.AlgorithmAes {
+		return aes.DerivationOfInitialKey(bdk, ksn)
+	}
+	return des.DerivationOfInitialKey(bdk, ksn)
+}
+
+// DeriveTransactionKey derives current transaction key from initial key
+//
+// Params:
+//   - ik is initial key
+//   - ksn is 10 bytes (des) or 12 bytes (aes) key serial number
+//
+// Return Params:
+//   - result is transaction key
+//   - err
+func DeriveTransactionKey(ik, ksn []byte, opts ...Option) ([]byte, error) {
+	o, err := ResolveOptions(ksn, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Algorithm == pkg.AlgorithmAes {
+		return aes.DeriveCurrentTransactionKey(ik, ksn)
+	}
+	return des.DeriveCurrentTransactionKey(ik, ksn)
+}
+
+// EncryptPin encrypts PIN block with pin key of transaction key
+//
+// NOTE:
+//   - des pin block format follows WithPinFormat (default ISO-0), aes pin block is ISO 9564-1 format 4
+//   - random fill of ISO-1, ISO-3 and ISO-4 pin blocks follows WithRand (default crypto/rand)
+//   - ECI4 and VISA4 pin blocks are filled by pinblock formats and return an error with WithRand
+//
+// Params:
+//   - tk is transaction key
+//   - ksn is key serial number of transaction key
+//   - pin is not formatted pin
+//   - pan is not formatted pan
+//
+// Return Params:
+//   - result is encrypted pin block
+//   - err
+func EncryptPin(tk, ksn, pin, pan []byte, opts ...Option) ([]byte, error) {
+	o, err := ResolveOptions(ksn, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Algorithm == pkg.AlgorithmAes {
+		keyType, err := o.AESKeyType(len(tk))
+		if err != nil {
+			return nil, err
+		}
+		return aes.EncryptPinWithRand(tk, ksn, pin, pan, keyType, o.Rand)
+	}
+	return des.EncryptPinWithRand(tk, pin, pan, o.PinFormat, o.Rand)
+}
+
+// DecryptPin decrypts PIN block with pin key of transaction key
+//
+// Params:
+//   - tk is transaction key
+//   - ksn is key serial number of transaction key
+//   - ciphertext is encrypted pin block
+//   - pan is not formatted pan
+//
+// Return Params:
+//   - result is pin
+//   - err
+func DecryptPin(tk, ksn, ciphertext, pan []byte, opts ...Option) ([]byte, error) {
+	o, err := ResolveOptions(ksn, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Algorithm == pkg.AlgorithmAes {
+		keyType, err := o.AESKeyType(len(tk))
+		if err != nil {
+			return nil, err
+		}
+		return aes.DecryptPinV2(tk, ksn, ciphertext, pan, keyType)
+	}
+	return des.DecryptPinV2(tk, ciphertext, pan, o.PinFormat)
+}
+
+// MAC generates message authentication code with mac key of transaction key
+//
+// Params:
+//   - tk is transaction key
+//   - ksn is key serial number of transaction key
+//   - msg is transaction data
+//
+// Return Params:
+//   - result is 8 bytes (des, tdes working keys of aes), 16 bytes (aes cmac) or 32 bytes (aes hmac) mac
+//   - err
+func MAC(tk, ksn, msg []byte, opts ...Option) ([]byte, error) {
+	o, err := ResolveOptions(ksn, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Algorithm == pkg.AlgorithmAes {
+		keyType, err := o.AESMacKeyType(len(tk))
+		if err != nil {
+			return nil, err
+		}
+		return aes.GenerateMacV2(tk, ksn, msg, keyType, o.MacType, o.Action)
+	}
+	return des.GenerateMacV2(tk, msg, o.Action)
+}
+
+// VerifyMAC verifies message authentication code, mac may be truncated to its left-most bytes (at least 4 bytes)
+//
+// Params:
+//   - tk is transaction key
+//   - ksn is key serial number of transaction key
+//   - msg is transaction data
+//   - mac is received mac
+//
+// Return Params:
+//   - err is ErrMacMismatch when mac doesn't match
+func VerifyMAC(tk, ksn, msg, mac []byte, opts ...Option) error {
+	expected, err := MAC(tk, ksn, msg, opts...)
+	if err != nil {
+		return err
+	}
+
+	return compareMac(expected, mac)
+}
+
+// compareMac compares mac with the left-most bytes of expected mac in constant time
+func compareMac(expected, mac []byte) error {
+	if len(mac) < minMacLength || len(mac) > len(expected) {
+		return fmt.Errorf("mac length must be between %d and %d bytes", minMacLength, len(expected))
+	}
+	if subtle.ConstantTimeCompare(expected[:len(mac)], mac) != 1 {
+		return ErrMacMismatch
+	}
+	return nil
+}
+
+// EncryptData encrypts data in CBC mode with data key of transaction key
+//
+// NOTE:
+//   - Plain text is padded with zeros to cipher block length
+//
+// Params:
+//   - tk is transaction key
+//   - ksn is key serial number of transaction key
+//   - iv is initial vector (nil is zero iv)
+//   - plaintext is transaction data
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func EncryptData(tk, ksn, iv, plaintext []byte, opts ...Option) ([]byte, error) {
+	o, err := ResolveOptions(ksn, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Algorithm == pkg.AlgorithmAes {
+		keyType, err := o.AESKeyType(len(tk))
+		if err != nil {
+			return nil, err
+		}
+		return aes.EncryptDataV2(tk, ksn, iv, plaintext, keyType, o.Action)
+	}
+	return des.EncryptDataWithMode(tk, iv, plaintext, o.Action, o.DataKeyMode)
+}
+
+// DecryptData decrypts data in CBC mode with data key of transaction key
+//
+// Params:
+//   - tk is transaction key
+//   - ksn is key serial number of transaction key
+//   - iv is initial vector (nil is zero iv)
+//   - ciphertext is encrypted data
+//
+// Return Params:
+//   - result is plain text (a multiple of cipher block length)
+//   - err
+func DecryptData(tk, ksn, iv, ciphertext []byte, opts ...Option) ([]byte, error) {
+	o, err := ResolveOptions(ksn, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Algorithm == pkg.AlgorithmAes {
+		keyType, err := o.AESKeyType(len(tk))
+		if err != nil {
+			return nil, err
+		}
+		return aes.DecryptDataV2(tk, ksn, iv, ciphertext, keyType, o.Action)
+	}
+	return des.DecryptDataWithMode(tk, iv, ciphertext, o.Action, o.DataKeyMode)
+}
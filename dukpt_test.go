@@ -0,0 +1,215 @@
+package dukpt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDes(t *testing.T) {
+	ksn := pkg.HexDecode("FFFF9876543210E00001")
+
+	ik, err := DeriveIK(pkg.HexDecode("0123456789ABCDEFFEDCBA9876543210"), ksn)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("6AC292FAA1315B4D858AB3A3D7D5933A"), ik)
+
+	tk, err := DeriveTransactionKey(ik, ksn)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("042666B49184CFA368DE9628D0397BC9"), tk)
+
+	block, err := EncryptPin(tk, ksn, []byte("1234"), []byte("4012345678909"))
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("1B9C1845EB993A7A"), block)
+
+	pin, err := DecryptPin(tk, ksn, block, []byte("4012345678909"), WithPinFormat(des.ISO0))
+	require.NoError(t, err)
+	require.Equal(t, "1234", string(pin))
+
+	msg := []byte("4012345678909D987")
+	mac, err := MAC(tk, ksn, msg)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("9CCC78173FC4FB64"), mac)
+	require.NoError(t, VerifyMAC(tk, ksn, msg, mac[:4]))
+	require.ErrorIs(t, VerifyMAC(tk, ksn, []byte("tampered"), mac), ErrMacMismatch)
+
+	ciphertext, err := EncryptData(tk, ksn, nil, msg)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("FC0D53B7EA1FDA9EE68AAF2E70D9B9506229BE2AA993F04F"), ciphertext)
+
+	plaintext, err := DecryptData(tk, ksn, nil, ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, msg, bytes.TrimRight(plaintext, "\x00"))
+
+	// variant data key
+	ciphertext, err = EncryptData(tk, ksn, nil, msg, WithDataKeyMode(des.DataKeyVariant))
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("E93B3B0570ECBCDF94453F22D7046D0F05169055540ABE86"), ciphertext)
+}
+
+func TestAes(t *testing.T) {
+	ksn := pkg.HexDecode("123456789012345600000001")
+
+	ik, err := DeriveIK(pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1"), ksn)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("1273671EA26AC29AFA4D1084127652A1"), ik)
+
+	tk, err := DeriveTransactionKey(ik, ksn)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44"), tk)
+
+	pin, err := DecryptPin(tk, ksn, pkg.HexDecode("1dd48e0fc64d89836fa3b71cf4aa3783"), []byte("4111111111111111"))
+	require.NoError(t, err)
+	require.Equal(t, "1234", string(pin))
+
+	block, err := EncryptPin(tk, ksn, []byte("1234"), []byte("4111111111111111"), WithKeyType(aes.AES128))
+	require.NoError(t, err)
+	pin, err = DecryptPin(tk, ksn, block, []byte("4111111111111111"))
+	require.NoError(t, err)
+	require.Equal(t, "1234", string(pin))
+
+	msg := []byte("4012345678909D987")
+	mac, err := MAC(tk, ksn, msg)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("A2EB5C1C35809E58404E873C3C411E31"), mac)
+	require.NoError(t, VerifyMAC(tk, ksn, msg, mac[:8]))
+
+	mac, err = MAC(tk, ksn, msg, WithMacType(pkg.HMAC))
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("B6F8B3159CD4E140159DA87A68C0FB7AF2F123D222662E98988C76386E8E8A02"), mac)
+
+	ciphertext, err := EncryptData(tk, ksn, nil, msg)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("E5AFA5B408A3310E3D779C8A9A2AE29448BD5B4232582090DB703AF647205A79"), ciphertext)
+
+	plaintext, err := DecryptData(tk, ksn, nil, ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, msg, bytes.TrimRight(plaintext, "\x00"))
+}
+
+func TestOptions(t *testing.T) {
+	_, err := DeriveIK(nil, pkg.HexDecode("FFFF9876543210E0"))
+	require.EqualError(t, err, "key serial number length must be 10 or 12 bytes")
+
+	_, err = DeriveIK(nil, nil, WithAlgorithm("rsa"))
+	require.EqualError(t, err, "invalid encrypt/decrypt algorithm")
+
+	// explicit algorithm overrides detection
+	ik, err := DeriveIK(pkg.HexDecode("0123456789ABCDEFFEDCBA9876543210"), pkg.HexDecode("FFFF9876543210E00001"), WithAlgorithm(pkg.AlgorithmDes))
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("6AC292FAA1315B4D858AB3A3D7D5933A"), ik)
+
+	_, err = MAC(pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44"), pkg.HexDecode("123456789012345600000001"), nil, WithKeyType(aes.AES256))
+	require.EqualError(t, err, "mismatched key length and key type")
+
+	// seeded random source makes pin block fill reproducible
+	tk, ksn := pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44"), pkg.HexDecode("123456789012345600000001")
+	encrypted, err := EncryptPin(tk, ksn, []byte("1234"), []byte("4111111111111111"), WithRand(pkg.NewSeededReader(7)))
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("488414CE467A5CF1411AAD8FD4A5346A"), encrypted)
+
+	tk, ksn = pkg.HexDecode("042666B49184CFA368DE9628D0397BC9"), pkg.HexDecode("FFFF9876543210E00001")
+	encrypted, err = EncryptPin(tk, ksn, []byte("1234"), []byte("4012345678909"), WithPinFormat(des.ISO3), WithRand(pkg.NewSeededReader(7)))
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("A73793448849CB3F"), encrypted)
+}
+
+func TestHmacOptions(t *testing.T) {
+	tk, ksn := pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44"), pkg.HexDecode("123456789012345600000001")
+	msg := []byte("4012345678909D987")
+
+	// hmac mac type of shared options only applies to mac working key
+	opts := []Option{WithMacType(pkg.HMAC)}
+
+	block, err := EncryptPin(tk, ksn, []byte("1234"), []byte("4111111111111111"), opts...)
+	require.NoError(t, err)
+	pin, err := DecryptPin(tk, ksn, block, []byte("4111111111111111"), opts...)
+	require.NoError(t, err)
+	require.Equal(t, "1234", string(pin))
+
+	mac, err := MAC(tk, ksn, msg, opts...)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("B6F8B3159CD4E140159DA87A68C0FB7AF2F123D222662E98988C76386E8E8A02"), mac)
+	require.NoError(t, VerifyMAC(tk, ksn, msg, mac[:16], opts...))
+
+	ciphertext, err := EncryptData(tk, ksn, nil, msg, opts...)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("E5AFA5B408A3310E3D779C8A9A2AE29448BD5B4232582090DB703AF647205A79"), ciphertext)
+
+	plaintext, err := DecryptData(tk, ksn, nil, ciphertext, opts...)
+	require.NoError(t, err)
+	require.Equal(t, msg, bytes.TrimRight(plaintext, "\x00"))
+
+	keyType, err := (&Options{MacType: pkg.HMAC}).AESKeyType(len(tk))
+	require.NoError(t, err)
+	require.Equal(t, aes.AES128, keyType)
+}
+
+// hexProvider is KeyProvider of hex encoded clear keys
+type hexProvider struct{}
+
+func (hexProvider) DeriveIK(bdk KeyHandle, ksn []byte, opts ...Option) (KeyHandle, error) {
+	ik, err := DeriveIK(pkg.HexDecode(string(bdk)), ksn, opts...)
+	return KeyHandle(pkg.HexEncode(ik)), err
+}
+
+func (hexProvider) DeriveTransactionKey(ik KeyHandle, ksn []byte, opts ...Option) (KeyHandle, error) {
+	tk, err := DeriveTransactionKey(pkg.HexDecode(string(ik)), ksn, opts...)
+	return KeyHandle(pkg.HexEncode(tk)), err
+}
+
+func (hexProvider) EncryptPin(tk KeyHandle, ksn, pin, pan []byte, opts ...Option) ([]byte, error) {
+	return EncryptPin(pkg.HexDecode(string(tk)), ksn, pin, pan, opts...)
+}
+
+func (hexProvider) DecryptPin(tk KeyHandle, ksn, ciphertext, pan []byte, opts ...Option) ([]byte, error) {
+	return DecryptPin(pkg.HexDecode(string(tk)), ksn, ciphertext, pan, opts...)
+}
+
+func (hexProvider) MAC(tk KeyHandle, ksn, msg []byte, opts ...Option) ([]byte, error) {
+	return MAC(pkg.HexDecode(string(tk)), ksn, msg, opts...)
+}
+
+func (hexProvider) EncryptData(tk KeyHandle, ksn, iv, plaintext []byte, opts ...Option) ([]byte, error) {
+	return EncryptData(pkg.HexDecode(string(tk)), ksn, iv, plaintext, opts...)
+}
+
+func (hexProvider) DecryptData(tk KeyHandle, ksn, iv, ciphertext []byte, opts ...Option) ([]byte, error) {
+	return DecryptData(pkg.HexDecode(string(tk)), ksn, iv, ciphertext, opts...)
+}
+
+func TestProvider(t *testing.T) {
+	_, err := NewProvider(nil)
+	require.EqualError(t, err, "key provider is required")
+
+	p, err := NewProvider(hexProvider{})
+	require.NoError(t, err)
+
+	ksn := pkg.HexDecode("FFFF9876543210E00001")
+	tk, err := p.DeriveTransactionKeyFromBDK("0123456789abcdeffedcba9876543210", ksn)
+	require.NoError(t, err)
+	require.Equal(t, KeyHandle("042666b49184cfa368de9628d0397bc9"), tk)
+
+	block, err := p.EncryptPin(tk, ksn, []byte("1234"), []byte("4012345678909"))
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("1B9C1845EB993A7A"), block)
+
+	pin, err := p.DecryptPin(tk, ksn, block, []byte("4012345678909"))
+	require.NoError(t, err)
+	require.Equal(t, "1234", string(pin))
+
+	msg := []byte("4012345678909D987")
+	mac, err := p.MAC(tk, ksn, msg)
+	require.NoError(t, err)
+	require.NoError(t, p.VerifyMAC(tk, ksn, msg, mac[:4]))
+	require.ErrorIs(t, p.VerifyMAC(tk, ksn, []byte("4012345678909D988"), mac), ErrMacMismatch)
+
+	ciphertext, err := p.EncryptData(tk, ksn, nil, msg)
+	require.NoError(t, err)
+	plaintext, err := p.DecryptData(tk, ksn, nil, ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, msg, plaintext[:len(msg)])
+}
@@ -0,0 +1,231 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// default initial value of RFC 3394 section 2.2.3.1
+var keyWrapDefaultIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+const keyWrapBlockLen = 8
+
+// AesKeyWrap wraps key data with key encryption key
+//
+// NOTE:
+//   - RFC 3394 2.2.1 Key Wrap
+//   - key data must be at least 16 bytes and a multiple of 8 bytes
+func AesKeyWrap(kek, keyData []byte) ([]byte, error) {
+	if len(keyData) < 2*keyWrapBlockLen || len(keyData)%keyWrapBlockLen != 0 {
+		return nil, fmt.Errorf("key data length must be a multiple of %d bytes and at least %d bytes", keyWrapBlockLen, 2*keyWrapBlockLen)
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	return wrapBlocks(block.Encrypt, keyWrapDefaultIV, keyData), nil
+}
+
+// AesKeyUnwrap unwraps key data wrapped with key encryption key
+//
+// NOTE:
+//   - RFC 3394 2.2.2 Key Unwrap
+//   - integrity check value is verified before key data is returned
+func AesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 3*keyWrapBlockLen || len(wrapped)%keyWrapBlockLen != 0 {
+		return nil, fmt.Errorf("wrapped key length must be a multiple of %d bytes and at least %d bytes", keyWrapBlockLen, 3*keyWrapBlockLen)
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	a, keyData := unwrapBlocks(block.Decrypt, wrapped)
+
+	if subtle.ConstantTimeCompare(a, keyWrapDefaultIV) != 1 {
+		return nil, errors.New("key unwrap integrity check failed")
+	}
+
+	return keyData, nil
+}
+
+// wrapBlocks runs the RFC 3394 wrap rounds over key data with initial value iv
+func wrapBlocks(encrypt func(dst, src []byte), iv, keyData []byte) []byte {
+	n := len(keyData) / keyWrapBlockLen
+	out := make([]byte, len(keyData)+keyWrapBlockLen)
+	copy(out, iv)
+	copy(out[keyWrapBlockLen:], keyData)
+
+	buf := make([]byte, aes.BlockSize)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf, out[:keyWrapBlockLen])
+			copy(buf[keyWrapBlockLen:], out[i*keyWrapBlockLen:(i+1)*keyWrapBlockLen])
+			encrypt(buf, buf)
+
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:keyWrapBlockLen], binary.BigEndian.Uint64(buf[:keyWrapBlockLen])^t)
+			copy(out[i*keyWrapBlockLen:(i+1)*keyWrapBlockLen], buf[keyWrapBlockLen:])
+		}
+	}
+
+	return out
+}
+
+// unwrapBlocks runs the RFC 3394 unwrap rounds and returns integrity check register and key data
+func unwrapBlocks(decrypt func(dst, src []byte), wrapped []byte) ([]byte, []byte) {
+	n := len(wrapped)/keyWrapBlockLen - 1
+
+	a := make([]byte, keyWrapBlockLen)
+	copy(a, wrapped[:keyWrapBlockLen])
+	r := make([]byte, n*keyWrapBlockLen)
+	copy(r, wrapped[keyWrapBlockLen:])
+
+	buf := make([]byte, aes.BlockSize)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:keyWrapBlockLen], binary.BigEndian.Uint64(a)^t)
+			copy(buf[keyWrapBlockLen:], r[(i-1)*keyWrapBlockLen:i*keyWrapBlockLen])
+			decrypt(buf, buf)
+
+			copy(a, buf[:keyWrapBlockLen])
+			copy(r[(i-1)*keyWrapBlockLen:i*keyWrapBlockLen], buf[keyWrapBlockLen:])
+		}
+	}
+
+	return a, r
+}
+
+// alternative initial value prefix of RFC 5649 section 3
+var keyWrapPadIVPrefix = []byte{0xA6, 0x59, 0x59, 0xA6}
+
+// AesKeyWrapPad wraps key data of any length with key encryption key
+//
+// NOTE:
+//   - RFC 5649 4.1 Extended Key Wrapping Process
+//   - key data is padded with zeros to a multiple of 8 bytes, its length is kept in the alternative initial value
+func AesKeyWrapPad(kek, keyData []byte) ([]byte, error) {
+	if len(keyData) == 0 || uint64(len(keyData)) > 0xFFFFFFFF {
+		return nil, errors.New("key data length must be between 1 and 2^32-1 bytes")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	iv := make([]byte, keyWrapBlockLen)
+	copy(iv, keyWrapPadIVPrefix)
+	binary.BigEndian.PutUint32(iv[4:], uint32(len(keyData)))
+
+	padded := make([]byte, (len(keyData)+keyWrapBlockLen-1)/keyWrapBlockLen*keyWrapBlockLen)
+	copy(padded, keyData)
+
+	// single block of padded key data is encrypted with initial value in ECB mode
+	if len(padded) == keyWrapBlockLen {
+		out := append(iv, padded...)
+		block.Encrypt(out, out)
+		return out, nil
+	}
+
+	return wrapBlocks(block.Encrypt, iv, padded), nil
+}
+
+// AesKeyUnwrapPad unwraps key data wrapped with key encryption key by AesKeyWrapPad
+//
+// NOTE:
+//   - RFC 5649 4.2 Extended Key Unwrapping Process
+//   - alternative initial value and zero padding are verified before key data is returned
+func AesKeyUnwrapPad(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 2*keyWrapBlockLen || len(wrapped)%keyWrapBlockLen != 0 {
+		return nil, fmt.Errorf("wrapped key length must be a multiple of %d bytes and at least %d bytes", keyWrapBlockLen, 2*keyWrapBlockLen)
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	var a, padded []byte
+	if len(wrapped) == 2*keyWrapBlockLen {
+		buf := make([]byte, aes.BlockSize)
+		block.Decrypt(buf, wrapped)
+		a, padded = buf[:keyWrapBlockLen], buf[keyWrapBlockLen:]
+	} else {
+		a, padded = unwrapBlocks(block.Decrypt, wrapped)
+	}
+
+	mli := int(binary.BigEndian.Uint32(a[4:]))
+	valid := subtle.ConstantTimeCompare(a[:4], keyWrapPadIVPrefix)
+	if mli <= len(padded)-keyWrapBlockLen || mli > len(padded) {
+		valid = 0
+	} else {
+		valid &= subtle.ConstantTimeCompare(padded[mli:], make([]byte, len(padded)-mli))
+	}
+	if valid != 1 {
+		return nil, errors.New("key unwrap integrity check failed")
+	}
+
+	return padded[:mli], nil
+}
+
+// KeyWrapMode selects key wrap algorithm
+type KeyWrapMode uint8
+
+const (
+	// KeyWrapRFC3394 is AES key wrap, key data must be a multiple of 8 bytes and at least 16 bytes
+	KeyWrapRFC3394 KeyWrapMode = iota + 1
+	// KeyWrapRFC5649 is AES key wrap with padding
+	KeyWrapRFC5649
+)
+
+// ParseKeyWrapMode returns key wrap mode of name ("rfc3394" or "rfc5649")
+func ParseKeyWrapMode(name string) (KeyWrapMode, error) {
+	switch strings.ToLower(name) {
+	case "rfc3394":
+		return KeyWrapRFC3394, nil
+	case "rfc5649":
+		return KeyWrapRFC5649, nil
+	}
+	return 0, fmt.Errorf("invalid key wrap mode %q", name)
+}
+
+func (m KeyWrapMode) String() string {
+	switch m {
+	case KeyWrapRFC3394:
+		return "rfc3394"
+	case KeyWrapRFC5649:
+		return "rfc5649"
+	}
+	return ""
+}
+
+// WrapKey wraps key with key encryption key in key wrap mode
+func WrapKey(kek, key []byte, mode KeyWrapMode) ([]byte, error) {
+	switch mode {
+	case KeyWrapRFC3394:
+		return AesKeyWrap(kek, key)
+	case KeyWrapRFC5649:
+		return AesKeyWrapPad(kek, key)
+	}
+	return nil, errors.New("invalid key wrap mode")
+}
+
+// UnwrapKey unwraps key wrapped with key encryption key in key wrap mode
+func UnwrapKey(kek, wrapped []byte, mode KeyWrapMode) ([]byte, error) {
+	switch mode {
+	case KeyWrapRFC3394:
+		return AesKeyUnwrap(kek, wrapped)
+	case KeyWrapRFC5649:
+		return AesKeyUnwrapPad(kek, wrapped)
+	}
+	return nil, errors.New("invalid key wrap mode")
+}
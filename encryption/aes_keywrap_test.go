@@ -0,0 +1,133 @@
+package encryption
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// RFC 3394 4. Test Vectors
+func TestAesKeyWrap(t *testing.T) {
+	tests := []struct {
+		name    string
+		kek     string
+		keyData string
+		wrapped string
+	}{
+		{
+			name:    "4.1 Wrap 128 bits of Key Data with a 128-bit KEK",
+			kek:     "000102030405060708090A0B0C0D0E0F",
+			keyData: "00112233445566778899AABBCCDDEEFF",
+			wrapped: "1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5",
+		},
+		{
+			name:    "4.6 Wrap 256 bits of Key Data with a 256-bit KEK",
+			kek:     "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			keyData: "00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+			wrapped: "28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped, err := AesKeyWrap(mustHex(t, tt.kek), mustHex(t, tt.keyData))
+			require.NoError(t, err)
+			require.Equal(t, mustHex(t, tt.wrapped), wrapped)
+
+			keyData, err := AesKeyUnwrap(mustHex(t, tt.kek), wrapped)
+			require.NoError(t, err)
+			require.Equal(t, mustHex(t, tt.keyData), keyData)
+		})
+	}
+}
+
+func TestAesKeyUnwrap_Errors(t *testing.T) {
+	kek := mustHex(t, "000102030405060708090A0B0C0D0E0F")
+	wrapped := mustHex(t, "1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5")
+
+	wrapped[0] ^= 0x01
+	_, err := AesKeyUnwrap(kek, wrapped)
+	require.EqualError(t, err, "key unwrap integrity check failed")
+
+	_, err = AesKeyUnwrap(kek, wrapped[:16])
+	require.Error(t, err)
+
+	_, err = AesKeyWrap(kek, []byte{0x01, 0x02})
+	require.Error(t, err)
+}
+
+// RFC 5649 6. Padded Key Wrap Examples
+func TestAesKeyWrapPad(t *testing.T) {
+	tests := []struct {
+		name    string
+		kek     string
+		keyData string
+		wrapped string
+	}{
+		{
+			name:    "Wrap 20 octets with a 192-bit key",
+			kek:     "5840DF6E29B02AF1AB493B705BF16EA1AE8338F4DCC176A8",
+			keyData: "C37B7E6492584340BED12207808941155068F738",
+			wrapped: "138BDEAA9B8FA7FC61F97742E72248EE5AE6AE5360D1AE6A5F54F373FA543B6A",
+		},
+		{
+			name:    "Wrap 7 octets with a 192-bit key",
+			kek:     "5840DF6E29B02AF1AB493B705BF16EA1AE8338F4DCC176A8",
+			keyData: "466F7250617369",
+			wrapped: "AFBEB0F07DFBF5419200F2CCB50BB24F",
+		},
+		{
+			// generated by openssl id-aes128-wrap-pad
+			name:    "Wrap 21 octets with a 128-bit key",
+			kek:     "000102030405060708090A0B0C0D0E0F",
+			keyData: "00112233445566778899AABBCCDDEEFF0011223344",
+			wrapped: "57E4B7A2E41EBDF53924BCD56729E298085F1D34A623D3049F1CA7E294E8235C",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped, err := WrapKey(mustHex(t, tt.kek), mustHex(t, tt.keyData), KeyWrapRFC5649)
+			require.NoError(t, err)
+			require.Equal(t, mustHex(t, tt.wrapped), wrapped)
+
+			keyData, err := UnwrapKey(mustHex(t, tt.kek), wrapped, KeyWrapRFC5649)
+			require.NoError(t, err)
+			require.Equal(t, mustHex(t, tt.keyData), keyData)
+		})
+	}
+}
+
+func TestAesKeyUnwrapPad_Errors(t *testing.T) {
+	kek := mustHex(t, "5840DF6E29B02AF1AB493B705BF16EA1AE8338F4DCC176A8")
+
+	for _, wrapped := range []string{"138BDEAA9B8FA7FC61F97742E72248EE5AE6AE5360D1AE6A5F54F373FA543B6A", "AFBEB0F07DFBF5419200F2CCB50BB24F"} {
+		tampered := mustHex(t, wrapped)
+		tampered[len(tampered)-1] ^= 0x01
+		_, err := AesKeyUnwrapPad(kek, tampered)
+		require.EqualError(t, err, "key unwrap integrity check failed")
+	}
+
+	// RFC 3394 wrapped key doesn't carry the alternative initial value
+	wrapped, err := AesKeyWrap(kek, mustHex(t, "00112233445566778899AABBCCDDEEFF"))
+	require.NoError(t, err)
+	_, err = AesKeyUnwrapPad(kek, wrapped)
+	require.EqualError(t, err, "key unwrap integrity check failed")
+
+	_, err = AesKeyWrapPad(kek, nil)
+	require.Error(t, err)
+
+	mode, err := ParseKeyWrapMode("RFC3394")
+	require.NoError(t, err)
+	require.Equal(t, KeyWrapRFC3394, mode)
+	_, err = ParseKeyWrapMode("tr-31")
+	require.EqualError(t, err, `invalid key wrap mode "tr-31"`)
+}
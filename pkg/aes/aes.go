@@ -2,15 +2,10 @@ package aes
 
 import (
 	"crypto/aes"
-	"crypto/cipher"
-	"crypto/hmac"
-	"crypto/sha256"
 	"fmt"
+	"strings"
 
-	"github.com/chmike/cmac-go"
-	"github.com/moov-io/dukpt/encryption"
 	"github.com/moov-io/dukpt/pkg"
-	"github.com/moov-io/pinblock/formats"
 )
 
 /*
@@ -24,6 +19,8 @@ const (
 	KeyHMAC128Type = "HMAC128"
 	KeyHMAC192Type = "HMAC192"
 	KeyHMAC256Type = "HMAC256"
+	KeyTDES2Type   = "TDES2"
+	KeyTDES3Type   = "TDES3"
 )
 
 // Derive Initial Key (IK) from Base Derivative Key and Initial Key ID
@@ -31,6 +28,7 @@ const (
 // NOTE:
 //   - ANSI X9.24-3-2017 6.3.1 Algorithm
 //   - ANSI X9.24-3-2017 6.3.3 “Create Derivation Data” (Local Subroutine)
+//   - Only initial key id (first 8 bytes) of ksn is used, see DerivationOfInitialKeyByID
 //
 // Params:
 //   - bdk is base derivative key (lenth will change by encryption algorithm)
@@ -45,12 +43,16 @@ func DerivationOfInitialKey(bdk, ksn []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	derivationData, err := createDerivationData(usageForKeyInitialKey, keyType, ksn[:8], 0)
+	if len(ksn) < initialKeyIdLength {
+		return nil, fmt.Errorf("initial key id length must be at least %d bytes", initialKeyIdLength)
+	}
+
+	derivationData, err := createDerivationData(usageForKeyInitialKey, keyType, initialKeyIDOf(ksn), 0)
 	if err != nil {
 		return nil, err
 	}
 
-	return derivationKey(bdk, derivationData)
+	return derivationKey(bdk, &derivationData, nil)
 }
 
 // Derive DUKPT transaction key (current transaction key) from Initial Key and Key Serial Number
@@ -66,17 +68,61 @@ func DerivationOfInitialKey(bdk, ksn []byte) ([]byte, error) {
 //   - result is transaction key of ik's length
 //   - err
 func DeriveCurrentTransactionKey(ik, ksn []byte) ([]byte, error) {
+	return appendCurrentTransactionKey(nil, ik, ksn, nil)
+}
+
+// Derive DUKPT transaction key (current transaction key) and report every derivation step to tracer
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 6.1, 6.3.1, 6.3.3
+//   - Steps are initial key, working transaction counter, derivation data blocks,
+//     intermediate keys and transaction key
+//
+// Params:
+//   - ik is initial key
+//   - ksn is 12 bytes key serial number
+//   - tracer observes derivation steps (keys are reported as secret steps)
+//
+// Return Params:
+//   - result is transaction key of ik's length
+//   - err
+func DeriveCurrentTransactionKeyWithTracer(ik, ksn []byte, tracer pkg.Tracer) ([]byte, error) {
+	return appendCurrentTransactionKey(nil, ik, ksn, tracer)
+}
+
+// Append DUKPT transaction key (current transaction key) derived from Initial Key and Key Serial Number to dst
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 6.1, 6.3.1, 6.3.3
+//   - Intermediate keys and derivation data are kept in fixed buffers, only the aes key
+//     schedule of each intermediate key is allocated
+//
+// Params:
+//   - dst is destination buffer, the transaction key is appended to it
+//   - ik is initial key
+//   - ksn is 12 bytes key serial number
+//
+// Return Params:
+//   - result is dst extended by transaction key
+//   - err
+func AppendCurrentTransactionKey(dst, ik, ksn []byte) ([]byte, error) {
+	return appendCurrentTransactionKey(dst, ik, ksn, nil)
+}
+
+func appendCurrentTransactionKey(dst, ik, ksn []byte, tracer pkg.Tracer) ([]byte, error) {
 	keyType, err := getDerivationKeyType(len(ik))
 	if err != nil {
 		return nil, err
 	}
 
-	transactionKey := make([]byte, len(ik))
-	copy(transactionKey, ik)
+	var keyBuf [keyAES256Bits / 8]byte
+	transactionKey := keyBuf[:copy(keyBuf[:], ik)]
 
+	id := initialKeyIDOf(ksn)
 	tc := pkg.GetAesTcFromKsn(ksn)
 	var workingTc uint32
-	var derivationData *keyDerivationData
+
+	pkg.TraceValue(tracer, "initial key", "", transactionKey, true)
 
 	for mask := uint32(0x80000000); mask != 0; mask >>= 1 {
 		if tc&mask == 0 {
@@ -84,156 +130,165 @@ func DeriveCurrentTransactionKey(ik, ksn []byte) ([]byte, error) {
 		}
 
 		workingTc |= mask
-		derivationData, err = createDerivationData(usageForKeyDerivation, keyType, ksn, workingTc)
+		if tracer != nil {
+			pkg.TraceValue(tracer, "transaction counter", fmt.Sprintf("%08X", workingTc), nil, false)
+		}
+
+		derivationData, err := createDerivationData(usageForKeyDerivation, keyType, id, workingTc)
 		if err != nil {
 			return nil, err
 		}
 
-		transactionKey, err = derivationKey(transactionKey, derivationData)
+		block, err := aes.NewCipher(transactionKey)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("creating cipher: %w", err)
+		}
+		transactionKey = appendDerivedKey(keyBuf[:0], block, &derivationData, tracer)
+
+		if tracer != nil {
+			pkg.TraceValue(tracer, "intermediate key", fmt.Sprintf("%08X", workingTc), transactionKey, true)
 		}
 	}
 
-	return transactionKey, nil
+	pkg.TraceValue(tracer, "transaction key", "", transactionKey, true)
+
+	return append(dst, transactionKey...), nil
 }
 
-// Encrypt PIN block using DUKPT transaction key
+// Derive working key of usage from transaction key and report every derivation step to tracer
 //
 // NOTE:
-//   - ANSI X9.24-3:2017 9.4.2
-//   - Support only ISO 9564-1:2017 PIN block format 4
+//   - ANSI X9.24-3:2017 6.3.3, 6.5.4 (key usage of pin, mac and data working keys)
+//   - Steps are derivation data blocks and working key
 //
 // Params:
-//   - current key is 16 bytes transaction key
+//   - current key is transaction key
 //   - ksn is 12 bytes key serial number
-//   - pin is not formatted pin string
-//   - pan is not formatted pan string
-//   - key type is AES128, AES192, AES256
+//   - usage is pin, mac or data
+//   - key type is AES128, AES192, AES256, TDES2, TDES3 (HMAC128, HMAC192, HMAC256 for mac usage)
+//   - action is request or response action (pin key doesn't depend on action)
+//   - tracer observes derivation steps, nil tracer is allowed
 //
 // Return Params:
-//   - result is cipher text
+//   - result is working key
 //   - err
-func EncryptPin(currentKey, ksn []byte, pin, pan string, keyType string) ([]byte, error) {
-	if err := checkWorkingKeyLength(len(currentKey), keyType); err != nil {
-		return nil, err
+func DeriveWorkingKey(currentKey, ksn []byte, usage, keyType, action string, tracer pkg.Tracer) ([]byte, error) {
+	var err error
+	if strings.HasPrefix(keyType, "HMAC") {
+		err = checkWorkingKeyLengthHmac(len(currentKey), keyType)
+	} else {
+		err = checkWorkingKeyLength(len(currentKey), keyType)
 	}
-
-	pinKey, err := generateDerivationKey(derivationParams{
-		KeyUsage:   usageForPinEncryption,
-		KeyType:    keyType,
-		Ksn:        ksn,
-		CurrentKey: currentKey,
-	})
 	if err != nil {
 		return nil, err
 	}
 
-	cipher, err := encryption.NewAesECB(pinKey)
+	keyUsage, err := workingKeyUsage(usage, action == pkg.ActionResponse)
 	if err != nil {
 		return nil, err
 	}
 
-	formatter := formats.NewISO4(cipher)
-	blockstr, err := formatter.Encode(pin, pan)
-	if err != nil {
-		return nil, err
-	}
+	return generateDerivationKey(derivationParams{
+		KeyUsage:   keyUsage,
+		KeyType:    keyType,
+		Ksn:        ksn,
+		CurrentKey: currentKey,
+		Tracer:     tracer,
+	})
+}
 
-	return pkg.HexDecode(blockstr), nil
+// Encrypt PIN block using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 9.4.2
+//   - Support only ISO 9564-1:2017 PIN block format 4 (format 0 of TDES2 and TDES3 key types)
+//   - Wrapper of EncryptPinV2
+//
+// Params:
+//   - current key is 16, 24 or 32 bytes transaction key
+//   - ksn is 12 bytes key serial number
+//   - pin is not formatted pin string
+//   - pan is not formatted pan string
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func EncryptPin(currentKey, ksn []byte, pin, pan string, keyType string) ([]byte, error) {
+	return EncryptPinV2(currentKey, ksn, []byte(pin), []byte(pan), legacyKeyType(keyType))
 }
 
 // Decrypt PIN block using DUKPT transaction key
 //
 // NOTE:
 //   - ANSI X9.24-3:2017 9.4.2
-//   - Support only ISO 9564-1:2017 PIN block format 4
+//   - Support only ISO 9564-1:2017 PIN block format 4 (format 0 of TDES2 and TDES3 key types)
+//   - Wrapper of DecryptPinV2
 //
 // Params:
-//   - current key is 16 bytes transaction key
+//   - current key is 16, 24 or 32 bytes transaction key
 //   - ksn is 12 bytes key serial number
 //   - pin is not formatted pin string
 //   - pan is not formatted pan string
-//   - key type is AES128, AES192, AES256
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
 //
 // Return Params:
 //   - result is plain text
 //   - err
 func DecryptPin(currentKey, ksn, ciphertext []byte, pan string, keyType string) (string, error) {
-	if err := checkWorkingKeyLength(len(currentKey), keyType); err != nil {
-		return "", err
-	}
-
-	pinKey, err := generateDerivationKey(derivationParams{
-		KeyUsage:   usageForPinEncryption,
-		KeyType:    keyType,
-		Ksn:        ksn,
-		CurrentKey: currentKey,
-	})
-	if err != nil {
-		return "", err
-	}
-
-	cipher, err := encryption.NewAesECB(pinKey)
+	pin, err := DecryptPinV2(currentKey, ksn, ciphertext, []byte(pan), legacyKeyType(keyType))
 	if err != nil {
 		return "", err
 	}
 
-	formatter := formats.NewISO4(cipher)
-	blockstr, err := formatter.Decode(pkg.HexEncode(ciphertext), pan)
-	if err != nil {
-		return "", err
-	}
+	return string(pin), nil
+}
 
-	return blockstr, nil
+// Re-encrypt PIN block under the same or another DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 9.4.2
+//   - No format translation happens: ISO 9564-1:2017 allows only PIN block format 4 with AES keys
+//     (formats 0, 1 and 3 are 8 bytes TDEA blocks), so the block stays ISO-4 and is re-encoded with fresh random fill
+//   - Translation from or to ISO-0, ISO-1 and ISO-3 needs a TDES key on the other side, see pin.Translate
+//   - The clear PIN never leaves this function, only the new cipher text is returned
+//   - Wrapper of ReencryptPinV2
+//
+// Params:
+//   - current key is transaction key
+//   - ksn is 12 bytes key serial number
+//   - cipher text is encrypted pin block
+//   - pan is not formatted pan string
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
+//   - output key is transaction key used to encrypt result (current key is used when output key is nil)
+//     key type of result follows output key length
+//   - output ksn is 12 bytes key serial number of output key (ksn is used when output ksn is nil)
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func ReencryptPin(currentKey, ksn, ciphertext []byte, pan string, keyType string, outputKey, outputKsn []byte) ([]byte, error) {
+	return ReencryptPinV2(currentKey, ksn, ciphertext, []byte(pan), legacyKeyType(keyType), outputKey, outputKsn)
 }
 
 // Generate AES-CMAC for transaction request using transaction key
 //
 // NOTE:
 //   - ANSI X9.24-3:2017 6.3.1, 6.3.4
+//   - Wrapper of GenerateMacV2, unknown action falls back to request
 //
 // Params:
-//   - current key is 16 bytes transaction key
+//   - current key is 16, 24 or 32 bytes transaction key
 //   - ksn is 12 bytes key serial number
 //   - plain text is transaction request data
-//   - key type is AES128, AES192, AES256
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
 //   - action is request or response action
 //
 // Return Params:
 //   - result is 16bytes generated cmac
 //   - err
 func GenerateCMAC(currentKey, ksn []byte, plaintext string, keyType string, action string) ([]byte, error) {
-	if err := checkWorkingKeyLength(len(currentKey), keyType); err != nil {
-		return nil, err
-	}
-
-	if action != pkg.ActionRequest && action != pkg.ActionResponse {
-		action = pkg.ActionRequest
-	}
-
-	params := derivationParams{
-		KeyUsage:   usageForMessageGeneration,
-		KeyType:    keyType,
-		Ksn:        ksn,
-		CurrentKey: currentKey,
-	}
-	if action == pkg.ActionResponse {
-		params.KeyUsage = usageForMessageVerification
-	}
-
-	macKey, err := generateDerivationKey(params)
-	if err != nil {
-		return nil, err
-	}
-
-	cm, err := cmac.New(aes.NewCipher, macKey)
-	if err != nil {
-		return nil, err
-	}
-	cm.Write([]byte(plaintext))
-
-	return cm.Sum(nil), nil
+	return GenerateMacV2(currentKey, ksn, []byte(plaintext), legacyKeyType(keyType), pkg.CMAC, legacyAction(action))
 }
 
 // Generate AES-CMAC for transaction request using transaction key
@@ -241,182 +296,65 @@ func GenerateCMAC(currentKey, ksn []byte, plaintext string, keyType string, acti
 // NOTE:
 //   - ANSI X9.24-3:2017 6.3.1, 6.3.4
 //   - Support only HMAC 256
+//   - Wrapper of GenerateMacV2, unknown action falls back to request
 //
 // Params:
-//   - current key is 16 bytes transaction key
+//   - current key is 16, 24 or 32 bytes transaction key
 //   - ksn is 12 bytes key serial number
 //   - plain text is transaction request data
-//   - key type is AES128, AES192, AES256
+//   - key type is AES128, AES192, AES256 (not longer than current key)
 //   - action is request or response action
 //
 // Return Params:
 //   - result is 16bytes generated cmac
 //   - err
 func GenerateHMAC(currentKey, ksn []byte, plaintext string, keyType string, action string) ([]byte, error) {
-	if err := checkWorkingKeyLengthHmac(len(currentKey), keyType); err != nil {
-		return nil, err
-	}
-
-	if action != pkg.ActionRequest && action != pkg.ActionResponse {
-		action = pkg.ActionRequest
-	}
-
-	params := derivationParams{
-		KeyUsage:   usageForMessageGeneration,
-		KeyType:    keyType,
-		Ksn:        ksn,
-		CurrentKey: currentKey,
-	}
-	if action == pkg.ActionResponse {
-		params.KeyUsage = usageForMessageVerification
-	}
-
-	macKey, err := generateDerivationKey(params)
-	if err != nil {
-		return nil, err
-	}
-
-	mac := hmac.New(sha256.New, macKey)
-	mac.Write([]byte(plaintext))
-
-	return mac.Sum(nil), nil
+	return GenerateMacV2(currentKey, ksn, []byte(plaintext), legacyKeyType(keyType), pkg.HMAC, legacyAction(action))
 }
 
 // Encrypt Data using DUKPT transaction key
 //
 // NOTE:
 //   - ANSI X9.24-3:2017 6.3.3, 6.5.4
+//   - Wrapper of EncryptDataV2, unknown action falls back to request
 //
 // Params:
-//   - current key is 16 bytes transaction key
+//   - current key is 16, 24 or 32 bytes transaction key
 //   - ksn is 12 bytes key serial number
 //   - iv is initial vector
 //   - plain text is transaction request data
-//   - key type is AES128, AES192, AES256
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
 //   - action is request or response action
 //
 // Return Params:
 //   - result is encrypted data
 //   - err
 func EncryptData(currentKey, ksn, iv []byte, plaintext, keyType, action string) ([]byte, error) {
-	if err := checkWorkingKeyLength(len(currentKey), keyType); err != nil {
-		return nil, err
-	}
-
-	if action != pkg.ActionRequest && action != pkg.ActionResponse {
-		action = pkg.ActionRequest
-	}
-
-	params := derivationParams{
-		KeyUsage:   usageForDataEncrypt,
-		KeyType:    keyType,
-		Ksn:        ksn,
-		CurrentKey: currentKey,
-	}
-	if action == pkg.ActionResponse {
-		params.KeyUsage = usageForDataDecrypt
-	}
-
-	dataKey, err := generateDerivationKey(params)
-	if err != nil {
-		return nil, err
-	}
-
-	if iv == nil {
-		// default null
-		iv = make([]byte, aes.BlockSize)
-	}
-	if len(iv) < aes.BlockSize {
-		iv = append(iv, make([]byte, aes.BlockSize-len(iv))...)
-	}
-
-	repeatCnt := len(plaintext) / aes.BlockSize
-	if len(plaintext)%aes.BlockSize > 0 {
-		repeatCnt++
-	}
-	serializePlaintext := make([]byte, repeatCnt*aes.BlockSize)
-	copy(serializePlaintext, plaintext)
-
-	ciphertext := make([]byte, len(serializePlaintext))
-
-	block, err := aes.NewCipher(dataKey)
-	if err != nil {
-		return nil, fmt.Errorf("making cipher from datakey: %w", err)
-	}
-
-	mode := cipher.NewCBCEncrypter(block, iv)
-	mode.CryptBlocks(ciphertext, serializePlaintext)
-
-	return ciphertext, nil
+	return EncryptDataV2(currentKey, ksn, iv, []byte(plaintext), legacyKeyType(keyType), legacyAction(action))
 }
 
 // Decrypt Data using DUKPT transaction key
 //
 // NOTE:
 //   - ANSI X9.24-3:2017 6.3.3, 6.5.4
+//   - Wrapper of DecryptDataV2, unknown action falls back to request
 //
 // Params:
-//   - current key is 16 bytes transaction key
+//   - current key is 16, 24 or 32 bytes transaction key
 //   - ksn is 12 bytes key serial number
 //   - iv is initial vector
 //   - cipher text is encrypted data
-//   - key type is AES128, AES192, AES256
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
 //   - action is request or response action
 //
 // Return Params:
 //   - result is transaction request data ( must be a multiple of aes block length [16])
 //   - err
 func DecryptData(currentKey, ksn, ciphertext, iv []byte, keyType, action string) (string, error) {
-	if err := checkWorkingKeyLength(len(currentKey), keyType); err != nil {
-		return "", err
-	}
-
-	if action != pkg.ActionRequest && action != pkg.ActionResponse {
-		action = pkg.ActionRequest
-	}
-
-	params := derivationParams{
-		KeyUsage:   usageForDataEncrypt,
-		KeyType:    keyType,
-		Ksn:        ksn,
-		CurrentKey: currentKey,
-	}
-	if action == pkg.ActionResponse {
-		params.KeyUsage = usageForDataDecrypt
-	}
-
-	dataKey, err := generateDerivationKey(params)
+	plainText, err := DecryptDataV2(currentKey, ksn, iv, ciphertext, legacyKeyType(keyType), legacyAction(action))
 	if err != nil {
 		return "", err
 	}
 
-	if iv == nil {
-		// default null
-		iv = make([]byte, aes.BlockSize)
-	}
-
-	if len(iv) < aes.BlockSize {
-		iv = append(iv, make([]byte, aes.BlockSize-len(iv))...)
-	} else if len(iv) > aes.BlockSize {
-		iv = iv[:aes.BlockSize]
-	}
-
-	repeatCnt := len(ciphertext) / aes.BlockSize
-	if len(ciphertext)%aes.BlockSize > 0 {
-		repeatCnt++
-	}
-	serializePlaintext := make([]byte, repeatCnt*aes.BlockSize)
-	copy(serializePlaintext, ciphertext)
-
-	plaintext := make([]byte, len(serializePlaintext))
-
-	block, err := aes.NewCipher(dataKey)
-	if err != nil {
-		return "", fmt.Errorf("making cipher from datakey: %w", err)
-	}
-
-	mode := cipher.NewCBCDecrypter(block, iv)
-	mode.CryptBlocks(plaintext, serializePlaintext)
-
-	return string(plaintext), nil
+	return string(plainText), nil
 }
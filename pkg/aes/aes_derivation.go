@@ -0,0 +1,134 @@
+package aes
+
+import (
+	"crypto/aes"
+	"fmt"
+	"strings"
+
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// Derivation data of initial key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 6.3.3, 6.4 (derivation data of initial key)
+//   - Derived key is the leftmost key length bytes of derivation data encrypted with base key in ECB mode,
+//     so keys may be derived inside of an HSM (e.g. PKCS#11 CKM_AES_ECB_ENCRYPT_DATA)
+//
+// Params:
+//   - key length is 16, 24 or 32 bytes length of base derivative key
+//   - ksn is 12 bytes key serial number (only initial key id is used)
+//
+// Return Params:
+//   - result is derivation data (one 16 bytes block per 16 bytes of derived key)
+//   - err
+func InitialKeyDerivationData(keyLen int, ksn []byte) ([]byte, error) {
+	keyType, err := getDerivationKeyType(keyLen)
+	if err != nil {
+		return nil, err
+	}
+	if len(ksn) < initialKeyIdLength {
+		return nil, fmt.Errorf("initial key id length must be at least %d bytes", initialKeyIdLength)
+	}
+
+	derivationData, err := createDerivationData(usageForKeyInitialKey, keyType, initialKeyIDOf(ksn), 0)
+	if err != nil {
+		return nil, err
+	}
+	return derivationBlocks(&derivationData), nil
+}
+
+// Derivation data of intermediate keys from initial key to transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 6.1, 6.3.1, 6.3.3
+//   - Every intermediate key is derived from previous key (initial key first), the last one is transaction key
+//
+// Params:
+//   - key length is 16, 24 or 32 bytes length of initial key
+//   - ksn is 12 bytes key serial number
+//
+// Return Params:
+//   - result is derivation data of every intermediate key (one for every bit set in transaction counter)
+//   - err
+func TransactionKeyDerivationData(keyLen int, ksn []byte) ([][]byte, error) {
+	keyType, err := getDerivationKeyType(keyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	id := initialKeyIDOf(ksn)
+	tc := pkg.GetAesTcFromKsn(ksn)
+	var workingTc uint32
+	var steps [][]byte
+
+	for mask := uint32(0x80000000); mask != 0; mask >>= 1 {
+		if tc&mask == 0 {
+			continue
+		}
+
+		workingTc |= mask
+		derivationData, err := createDerivationData(usageForKeyDerivation, keyType, id, workingTc)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, derivationBlocks(&derivationData))
+	}
+
+	return steps, nil
+}
+
+// Derivation data of working key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 6.3.3, 6.5.4 (key usage of pin, mac and data working keys)
+//
+// Params:
+//   - key length is 16, 24 or 32 bytes length of transaction key
+//   - ksn is 12 bytes key serial number
+//   - usage is pin, mac or data
+//   - key type is AES128, AES192, AES256, TDES2, TDES3 (HMAC128, HMAC192, HMAC256 for mac usage)
+//   - action is request or response action (pin key doesn't depend on action)
+//
+// Return Params:
+//   - result is derivation data
+//   - err
+func WorkingKeyDerivationData(keyLen int, ksn []byte, usage string, keyType KeyType, action pkg.Action) ([]byte, error) {
+	var err error
+	if strings.HasPrefix(keyType.String(), "HMAC") {
+		err = checkWorkingKeyLengthHmac(keyLen, keyType.String())
+	} else {
+		err = checkWorkingKeyLength(keyLen, keyType.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := action.Validate(); err != nil {
+		return nil, err
+	}
+
+	keyUsage, err := workingKeyUsage(usage, action == pkg.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	derivationData, err := createDerivationData(keyUsage, keyType.String(), initialKeyIDOf(ksn), pkg.GetAesTcFromKsn(ksn))
+	if err != nil {
+		return nil, err
+	}
+	return derivationBlocks(&derivationData), nil
+}
+
+// derivationBlocks serializes derivation data blocks of derived key length
+func derivationBlocks(derivationData *keyDerivationData) []byte {
+	var data [aes.BlockSize]byte
+	derivedKeyLen := int(derivationData.Length / 8)
+
+	blocks := make([]byte, 0, derivedKeyLen+aes.BlockSize)
+	for offset := 0; offset < derivedKeyLen; offset += aes.BlockSize {
+		derivationData.put(&data)
+		blocks = append(blocks, data[:]...)
+		derivationData.KeyBlockCounter++
+	}
+	return blocks
+}
@@ -0,0 +1,73 @@
+package aes
+
+import (
+	"github.com/moov-io/dukpt/encryption"
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// Export initial key wrapped under key encryption key
+//
+// NOTE:
+//   - Initial key never leaves the function in clear
+//
+// Params:
+//   - kek is 16, 24 or 32 bytes AES key encryption key
+//   - bdk is base derivative key
+//   - ksn is 12 bytes key serial number
+//   - mode is encryption.KeyWrapRFC3394 or encryption.KeyWrapRFC5649
+//
+// Return Params:
+//   - result is wrapped initial key
+//   - err
+func ExportInitialKey(kek, bdk, ksn []byte, mode encryption.KeyWrapMode) ([]byte, error) {
+	ik, err := DerivationOfInitialKey(bdk, ksn)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.WrapKey(kek, ik, mode)
+}
+
+// Export current transaction key wrapped under key encryption key
+//
+// Params:
+//   - kek is 16, 24 or 32 bytes AES key encryption key
+//   - ik is initial key
+//   - ksn is 12 bytes key serial number
+//   - mode is encryption.KeyWrapRFC3394 or encryption.KeyWrapRFC5649
+//
+// Return Params:
+//   - result is wrapped transaction key
+//   - err
+func ExportTransactionKey(kek, ik, ksn []byte, mode encryption.KeyWrapMode) ([]byte, error) {
+	currentKey, err := DeriveCurrentTransactionKey(ik, ksn)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.WrapKey(kek, currentKey, mode)
+}
+
+// Export working key of transaction key wrapped under key encryption key
+//
+// Params:
+//   - kek is 16, 24 or 32 bytes AES key encryption key
+//   - current key is 16, 24 or 32 bytes transaction key
+//   - ksn is 12 bytes key serial number
+//   - usage is pkg.UsagePin, pkg.UsageMac or pkg.UsageData
+//   - key type is AES128, AES192, AES256, TDES2, TDES3, HMAC128, HMAC192, HMAC256 (not longer than current key)
+//   - action is pkg.Request or pkg.Response
+//   - mode is encryption.KeyWrapRFC3394 or encryption.KeyWrapRFC5649
+//
+// Return Params:
+//   - result is wrapped working key
+//   - err
+func ExportWorkingKey(kek, currentKey, ksn []byte, usage string, keyType KeyType, action pkg.Action, mode encryption.KeyWrapMode) ([]byte, error) {
+	if err := action.Validate(); err != nil {
+		return nil, err
+	}
+
+	key, err := DeriveWorkingKey(currentKey, ksn, usage, keyType.String(), action.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.WrapKey(kek, key, mode)
+}
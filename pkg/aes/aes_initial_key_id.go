@@ -0,0 +1,224 @@
+package aes
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// InitialKeyID identifies initial key of AES DUKPT device
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 6.1 (Initial Key ID is BDK ID followed by Derivation ID)
+//   - Key serial number is Initial Key ID followed by 4 bytes transaction counter
+type InitialKeyID struct {
+	BDKID        [derivationKeyIdLength]byte
+	DerivationID [derivationIdLength]byte
+}
+
+// Create initial key id from BDK ID and derivation ID
+//
+// Params:
+//   - bdk id is 4 bytes base derivation key id
+//   - derivation id is 4 bytes derivation id
+//
+// Return Params:
+//   - result is initial key id
+//   - err
+func NewInitialKeyID(bdkID, derivationID []byte) (InitialKeyID, error) {
+	var id InitialKeyID
+
+	if len(bdkID) != derivationKeyIdLength {
+		return id, fmt.Errorf("bdk id length must be %d bytes", derivationKeyIdLength)
+	}
+	if len(derivationID) != derivationIdLength {
+		return id, fmt.Errorf("derivation id length must be %d bytes", derivationIdLength)
+	}
+
+	copy(id.BDKID[:], bdkID)
+	copy(id.DerivationID[:], derivationID)
+
+	return id, nil
+}
+
+// Parse initial key id
+//
+// Params:
+//   - data is 8 bytes initial key id
+//
+// Return Params:
+//   - result is initial key id
+//   - err
+func ParseInitialKeyID(data []byte) (InitialKeyID, error) {
+	if len(data) != initialKeyIdLength {
+		return InitialKeyID{}, fmt.Errorf("initial key id length must be %d bytes", initialKeyIdLength)
+	}
+
+	return initialKeyIDOf(data), nil
+}
+
+// Get initial key id of key serial number
+//
+// Params:
+//   - ksn is 12 bytes key serial number
+//
+// Return Params:
+//   - result is initial key id
+//   - err
+func InitialKeyIDFromKsn(ksn []byte) (InitialKeyID, error) {
+	if len(ksn) != initialKeyIdLength+transactionCounterLength {
+		return InitialKeyID{}, fmt.Errorf("key serial number length must be %d bytes", initialKeyIdLength+transactionCounterLength)
+	}
+
+	return initialKeyIDOf(ksn), nil
+}
+
+// Get initial key id and transaction counter of legacy 10 bytes key serial number (compatibility mode)
+//
+// NOTE:
+//   - See pkg.LegacyKsnToAesKsn for the mapping, id.KSN(counter) builds the aes key serial number
+//   - Lets AES DUKPT devices run behind message formats of TDES key serial number
+//
+// Params:
+//   - ksn is 10 bytes legacy key serial number
+//
+// Return Params:
+//   - id is initial key id
+//   - counter is transaction counter
+//   - err
+func InitialKeyIDFromLegacyKsn(ksn []byte) (id InitialKeyID, counter uint32, err error) {
+	aesKsn, err := pkg.LegacyKsnToAesKsn(ksn)
+	if err != nil {
+		return id, 0, err
+	}
+
+	return initialKeyIDOf(aesKsn), pkg.GetAesTcFromKsn(aesKsn), nil
+}
+
+// Bytes returns 8 bytes initial key id
+func (id InitialKeyID) Bytes() []byte {
+	out := make([]byte, 0, initialKeyIdLength)
+	out = append(out, id.BDKID[:]...)
+	return append(out, id.DerivationID[:]...)
+}
+
+// Build key serial number of initial key id and transaction counter
+//
+// NOTE:
+//   - Counter 0 gives the key serial number loaded into the device with the initial key
+//   - Counter of transaction must have at most 16 one bits (ANSI X9.24-3:2017 6.1)
+//
+// Params:
+//   - counter is transaction counter
+//
+// Return Params:
+//   - result is 12 bytes key serial number
+//   - err
+func (id InitialKeyID) KSN(counter uint32) ([]byte, error) {
+	ksn := make([]byte, initialKeyIdLength+transactionCounterLength)
+	copy(ksn, id.BDKID[:])
+	copy(ksn[derivationKeyIdLength:], id.DerivationID[:])
+	binary.BigEndian.PutUint32(ksn[initialKeyIdLength:], counter)
+
+	if counter != 0 && !pkg.IsValidAesKsn(ksn) {
+		return nil, errors.New("transaction counter must have at most 16 one bits")
+	}
+
+	return ksn, nil
+}
+
+// Build legacy 10 bytes key serial number of initial key id and transaction counter (compatibility mode)
+//
+// NOTE:
+//   - Reverse of InitialKeyIDFromLegacyKsn, see pkg.AesKsnToLegacyKsn
+//
+// Params:
+//   - counter is transaction counter (at most 21 bits)
+//
+// Return Params:
+//   - result is 10 bytes legacy key serial number
+//   - err
+func (id InitialKeyID) LegacyKSN(counter uint32) ([]byte, error) {
+	ksn, err := id.KSN(counter)
+	if err != nil {
+		return nil, err
+	}
+
+	return pkg.AesKsnToLegacyKsn(ksn)
+}
+
+// Derive Initial Key (IK) from Base Derivative Key and Initial Key ID
+//
+// NOTE:
+//   - ANSI X9.24-3-2017 6.3.1 Algorithm
+//   - ANSI X9.24-3-2017 6.3.3 “Create Derivation Data” (Local Subroutine)
+//
+// Params:
+//   - bdk is base derivative key (lenth will change by encryption algorithm)
+//   - id is initial key id
+//
+// Return Params:
+//   - result is initial key of bdk's length
+//   - err
+func DerivationOfInitialKeyByID(bdk []byte, id InitialKeyID) ([]byte, error) {
+	keys, err := DerivationOfInitialKeys(bdk, id.BDKID[:], id.DerivationID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return keys[0], nil
+}
+
+// Derive Initial Keys (IK) of several derivation ids under one Base Derivative Key
+//
+// NOTE:
+//   - ANSI X9.24-3-2017 6.3.1 Algorithm
+//   - The key schedule of base derivative key is expanded once for all derivation ids
+//
+// Params:
+//   - bdk is base derivative key (lenth will change by encryption algorithm)
+//   - bdk id is 4 bytes base derivation key id
+//   - derivation ids are 4 bytes derivation ids
+//
+// Return Params:
+//   - result is initial keys in the order of derivation ids
+//   - err
+func DerivationOfInitialKeys(bdk, bdkID []byte, derivationIDs ...[]byte) ([][]byte, error) {
+	keyType, err := getDerivationKeyType(len(bdk))
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(bdk)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	keys := make([][]byte, 0, len(derivationIDs))
+	for _, derivationID := range derivationIDs {
+		id, err := NewInitialKeyID(bdkID, derivationID)
+		if err != nil {
+			return nil, err
+		}
+
+		derivationData, err := createDerivationData(usageForKeyInitialKey, keyType, id, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, appendDerivedKey(nil, block, &derivationData, nil))
+	}
+
+	return keys, nil
+}
+
+// initialKeyIDOf copies initial key id from the leading bytes of initial key id or key serial number
+func initialKeyIDOf(data []byte) InitialKeyID {
+	var id InitialKeyID
+	n := copy(id.BDKID[:], data)
+	copy(id.DerivationID[:], data[n:])
+	return id
+}
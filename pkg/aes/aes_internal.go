@@ -2,10 +2,12 @@ package aes
 
 import (
 	"crypto/aes"
+	"crypto/cipher"
 	"encoding/binary"
 	"errors"
+	"fmt"
 
-	"github.com/moov-io/dukpt/encryption"
+	"github.com/chmike/cmac-go"
 	"github.com/moov-io/dukpt/pkg"
 )
 
@@ -55,11 +57,6 @@ const (
 	usageForKeyInitialKey         = 0x8001
 )
 
-const (
-	keyTDES2Type = "TDES2"
-	keyTDES3Type = "TDES3"
-)
-
 // Key Derivation Data
 //
 //	6.3.2 Derivation Data
@@ -70,34 +67,17 @@ type keyDerivationData struct {
 	KeyUsageIndicator  uint16
 	AlgorithmIndicator uint16
 	Length             uint16
-	InitialKeyID       []byte
+	InitialKeyID       [initialKeyIdLength]byte
 }
 
-func (k *keyDerivationData) Bytes() []byte {
-	if k == nil {
-		return nil
-	}
-
-	var result []byte
-	b := make([]byte, 2)
-
-	result = append(result, k.Version)
-	result = append(result, k.KeyBlockCounter)
-	{
-		binary.BigEndian.PutUint16(b, k.KeyUsageIndicator)
-		result = append(result, b...)
-	}
-	{
-		binary.BigEndian.PutUint16(b, k.AlgorithmIndicator)
-		result = append(result, b...)
-	}
-	{
-		binary.BigEndian.PutUint16(b, k.Length)
-		result = append(result, b...)
-	}
-	result = append(result, k.InitialKeyID...)
-
-	return result
+// put serializes derivation data into one aes block, so derivation does not allocate
+func (k *keyDerivationData) put(dst *[aes.BlockSize]byte) {
+	dst[0] = k.Version
+	dst[1] = k.KeyBlockCounter
+	binary.BigEndian.PutUint16(dst[2:4], k.KeyUsageIndicator)
+	binary.BigEndian.PutUint16(dst[4:6], k.AlgorithmIndicator)
+	binary.BigEndian.PutUint16(dst[6:8], k.Length)
+	copy(dst[8:], k.InitialKeyID[:])
 }
 
 type derivationParams struct {
@@ -105,6 +85,7 @@ type derivationParams struct {
 	KeyType    string
 	Ksn        []byte
 	CurrentKey []byte
+	Tracer     pkg.Tracer
 }
 
 func getDerivationKeyType(keyLen int) (string, error) {
@@ -119,32 +100,40 @@ func getDerivationKeyType(keyLen int) (string, error) {
 	return "", errors.New("unsupported key length yet")
 }
 
-func derivationKey(key []byte, derivationData *keyDerivationData) ([]byte, error) {
-	var derivedKey []byte
-	derivedKeyLen := int(derivationData.Length / 8)
-
-	aesEcb, err := encryption.NewAesECB(key)
+func derivationKey(key []byte, derivationData *keyDerivationData, tracer pkg.Tracer) ([]byte, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("creating cipher: %w", err)
 	}
 
+	return appendDerivedKey(nil, block, derivationData, tracer), nil
+}
+
+// appendDerivedKey encrypts derivation data blocks with the cipher of derivation key and appends them to dst
+//
+//	the derived key is the leftmost length bits of the encrypted blocks (AES-192 keys drop the last 8 bytes)
+func appendDerivedKey(dst []byte, block cipher.Block, derivationData *keyDerivationData, tracer pkg.Tracer) []byte {
+	var data [aes.BlockSize]byte
+	derivedKeyLen := int(derivationData.Length / 8)
+	end := len(dst) + derivedKeyLen
+
 	for offset := 0; offset < derivedKeyLen; offset += aes.BlockSize {
-		data := derivationData.Bytes()
-		encrypted, encErr := aesEcb.Encrypt(data)
-		if encErr != nil {
-			return nil, encErr
+		derivationData.put(&data)
+		if tracer != nil {
+			pkg.TraceValue(tracer, "derivation data", fmt.Sprintf("block %d", derivationData.KeyBlockCounter), data[:], false)
 		}
-		derivedKey = append(derivedKey, encrypted...)
+		block.Encrypt(data[:], data[:])
+		dst = append(dst, data[:]...)
 		derivationData.KeyBlockCounter++
 	}
 
-	return derivedKey, nil
+	return dst[:end]
 }
 
 // Create key derivation data
 //
 //	6.3.3 “Create Derivation Data” (Local Subroutine)
-func createDerivationData(keyUsage uint16, keyType string, initialKeyID []byte, tc uint32) (*keyDerivationData, error) {
+func createDerivationData(keyUsage uint16, keyType string, id InitialKeyID, tc uint32) (keyDerivationData, error) {
 	data := keyDerivationData{
 		Version:           derivationDataVersion,
 		KeyBlockCounter:   0x01,
@@ -152,10 +141,10 @@ func createDerivationData(keyUsage uint16, keyType string, initialKeyID []byte,
 	}
 
 	switch keyType {
-	case keyTDES2Type:
+	case KeyTDES2Type:
 		data.AlgorithmIndicator = algorithmTwoKeyTDEA
 		data.Length = keyTDES2Bits
-	case keyTDES3Type:
+	case KeyTDES3Type:
 		data.AlgorithmIndicator = algorithmThreeKeyTDEA
 		data.Length = keyTDES3Bits
 	case KeyAES128Type:
@@ -177,20 +166,20 @@ func createDerivationData(keyUsage uint16, keyType string, initialKeyID []byte,
 		data.AlgorithmIndicator = algorithmHMAC
 		data.Length = keyHMAC256Bits
 	default:
-		return nil, errors.New("unsupported key type")
+		return data, errors.New("unsupported key type")
 	}
 
 	switch keyUsage {
 	case usageForKeyInitialKey:
-		data.InitialKeyID = make([]byte, initialKeyIdLength)
-		copy(data.InitialKeyID, initialKeyID)
+		copy(data.InitialKeyID[:derivationKeyIdLength], id.BDKID[:])
+		copy(data.InitialKeyID[derivationKeyIdLength:], id.DerivationID[:])
 	default:
-		tcBuf := make([]byte, 4)
-		binary.BigEndian.PutUint32(tcBuf, tc)
-		data.InitialKeyID = append(initialKeyID[derivationKeyIdLength:derivationKeyIdLength+derivationIdLength], tcBuf...)
+		// derivation id followed by transaction counter
+		copy(data.InitialKeyID[:derivationIdLength], id.DerivationID[:])
+		binary.BigEndian.PutUint32(data.InitialKeyID[derivationIdLength:], tc)
 	}
 
-	return &data, nil
+	return data, nil
 }
 
 func checkWorkingKeyLengthHmac(keyLen int, keyType string) error {
@@ -213,7 +202,8 @@ func checkWorkingKeyLengthHmac(keyLen int, keyType string) error {
 		return errors.New("unsupported key type")
 	}
 
-	if keyLen != workingKeyLength {
+	// working key must not be stronger than transaction key (AES-256 transaction key derives HMAC128 working keys)
+	if workingKeyLength > keyLen {
 		return errors.New("mismatched key length and key type")
 	}
 
@@ -236,28 +226,182 @@ func checkWorkingKeyLength(keyLen int, keyType string) error {
 		workingKeyLength = keyAES192Bits / 8
 	case KeyAES256Type:
 		workingKeyLength = keyAES256Bits / 8
+	case KeyTDES2Type, KeyTDES3Type:
+		// 2TDEA and 3TDEA working keys are weaker than any aes transaction key
+		return nil
 	default:
 		return errors.New("unsupported key type")
 	}
 
-	if keyLen != workingKeyLength {
+	// working key must not be stronger than transaction key (AES-256 transaction key derives AES-128 working keys)
+	if workingKeyLength > keyLen {
 		return errors.New("mismatched key length and key type")
 	}
 
 	return nil
 }
 
+// key usage indicator of working key usage (pin, mac or data)
+func workingKeyUsage(usage string, response bool) (uint16, error) {
+	switch {
+	case usage == pkg.UsagePin:
+		return usageForPinEncryption, nil
+	case usage == pkg.UsageMac && response:
+		return usageForMessageVerification, nil
+	case usage == pkg.UsageMac:
+		return usageForMessageGeneration, nil
+	case usage == pkg.UsageData && response:
+		return usageForDataDecrypt, nil
+	case usage == pkg.UsageData:
+		return usageForDataEncrypt, nil
+	}
+	return 0, errors.New("invalid working key usage")
+}
+
 func generateDerivationKey(params derivationParams) ([]byte, error) {
 	tc := pkg.GetAesTcFromKsn(params.Ksn)
-	derivationData, err := createDerivationData(params.KeyUsage, params.KeyType, params.Ksn, tc)
+	derivationData, err := createDerivationData(params.KeyUsage, params.KeyType, initialKeyIDOf(params.Ksn), tc)
 	if err != nil {
 		return nil, err
 	}
 
-	newKey, err := derivationKey(params.CurrentKey, derivationData)
+	newKey, err := derivationKey(params.CurrentKey, &derivationData, params.Tracer)
 	if err != nil {
 		return nil, err
 	}
 
+	pkg.TraceValue(params.Tracer, "working key", "", newKey, true)
+
 	return newKey, nil
 }
+
+// appendCmac appends cmac of plain text to dst, cmac of tdes mac keys is 8 bytes
+func appendCmac(dst []byte, block cipher.Block, plainText []byte) ([]byte, error) {
+	if block.BlockSize() != aes.BlockSize {
+		return appendCmac64(dst, block, plainText), nil
+	}
+
+	cm, err := cmac.New(func([]byte) (cipher.Block, error) { return block, nil }, nil)
+	if err != nil {
+		return nil, err
+	}
+	cm.Write(plainText)
+
+	return cm.Sum(dst), nil
+}
+
+// appendCmac64 appends NIST SP 800-38B cmac of 64 bits block cipher (TDEA) to dst
+//
+//	cmac-go derives subkeys of 128 bits blocks only, 64 bits blocks use constant Rb 0x1B
+func appendCmac64(dst []byte, block cipher.Block, plainText []byte) []byte {
+	const blockLen = 8
+
+	var l, x, last [blockLen]byte
+	block.Encrypt(l[:], l[:])
+	k1 := doubleBlock64(binary.BigEndian.Uint64(l[:]))
+	k2 := doubleBlock64(k1)
+
+	n := (len(plainText) + blockLen - 1) / blockLen
+	complete := n > 0 && len(plainText)%blockLen == 0
+	if n == 0 {
+		n = 1
+	}
+
+	for i := 0; i < n-1; i++ {
+		for j := range x {
+			x[j] ^= plainText[i*blockLen+j]
+		}
+		block.Encrypt(x[:], x[:])
+	}
+
+	rest := plainText[(n-1)*blockLen:]
+	copy(last[:], rest)
+	subkey := k1
+	if !complete {
+		last[len(rest)] = 0x80
+		subkey = k2
+	}
+	binary.BigEndian.PutUint64(last[:], binary.BigEndian.Uint64(last[:])^subkey)
+
+	for j := range x {
+		x[j] ^= last[j]
+	}
+	block.Encrypt(x[:], x[:])
+
+	return append(dst, x[:]...)
+}
+
+func doubleBlock64(v uint64) uint64 {
+	if v>>63 == 1 {
+		return v<<1 ^ 0x1B
+	}
+	return v << 1
+}
+
+// Encryption of the data uses AES (or TDES of tdes data keys) in CBC mode, the data is padded with zeros to cipher block length [16 or 8]
+func appendCBCEncrypted(dst []byte, block cipher.Block, iv, plainText []byte) []byte {
+	bs := block.BlockSize()
+
+	// default null, shorter iv is padded with zeros and longer iv is truncated
+	var ivBlock [aes.BlockSize]byte
+	copy(ivBlock[:bs], iv)
+
+	size := (len(plainText) + bs - 1) / bs * bs
+	dst, out := grow(dst, size)
+	copy(out, plainText)
+	for i := len(plainText); i < size; i++ {
+		out[i] = 0
+	}
+
+	// CBC chaining is done in place with the block cipher, so no mode or iv copy is allocated
+	chain := ivBlock[:bs]
+	for offset := 0; offset < size; offset += bs {
+		part := out[offset : offset+bs]
+		for i := range part {
+			part[i] ^= chain[i]
+		}
+		block.Encrypt(part, part)
+		chain = part
+	}
+	return dst
+}
+
+func appendCBCDecrypted(dst []byte, block cipher.Block, iv, cipherText []byte) []byte {
+	bs := block.BlockSize()
+
+	// default null, shorter iv is padded with zeros and longer iv is truncated
+	var ivBlock [aes.BlockSize]byte
+	copy(ivBlock[:bs], iv)
+
+	size := (len(cipherText) + bs - 1) / bs * bs
+	dst, out := grow(dst, size)
+	copy(out, cipherText)
+	for i := len(cipherText); i < size; i++ {
+		out[i] = 0
+	}
+
+	var next [aes.BlockSize]byte
+	for offset := 0; offset < size; offset += bs {
+		part := out[offset : offset+bs]
+		copy(next[:], part)
+		block.Decrypt(part, part)
+		for i := range part {
+			part[i] ^= ivBlock[i]
+		}
+		ivBlock = next
+	}
+	return dst
+}
+
+// grow extends dst by n bytes and returns extended slice and its last n bytes
+func grow(dst []byte, n int) ([]byte, []byte) {
+	total := len(dst) + n
+	if cap(dst) < total {
+		extended := make([]byte, total)
+		copy(extended, dst)
+		dst = extended
+	} else {
+		dst = dst[:total]
+	}
+	return dst, dst[total-n:]
+}
@@ -0,0 +1,183 @@
+package aes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// KeySchedule holds expanded ciphers of all working keys of one DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 6.3.3, 6.5.4, 9.4.2
+//   - Working keys are derived and expanded once, so a host processing several operations
+//     under the same transaction key does not derive and expand them again for each of them
+//   - Methods are safe for concurrent use
+type KeySchedule struct {
+	keyType   KeyType
+	pin       ecbCipher
+	mac       [2]cipher.Block
+	dataBlock [2]cipher.Block
+}
+
+// Create key schedule of DUKPT transaction key
+//
+// Params:
+//   - current key is transaction key
+//   - ksn is 12 bytes key serial number
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
+//
+// Return Params:
+//   - result is key schedule of pin, cmac and data working keys (request and response)
+//   - err
+func NewKeySchedule(currentKey, ksn []byte, keyType KeyType) (*KeySchedule, error) {
+	if err := checkWorkingKeyLength(len(currentKey), keyType.String()); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(currentKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	id := initialKeyIDOf(ksn)
+	tc := pkg.GetAesTcFromKsn(ksn)
+	workingKey := func(keyUsage uint16) (cipher.Block, []byte, error) {
+		derivationData, err := createDerivationData(keyUsage, keyType.String(), id, tc)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var keyBuf [keyAES256Bits / 8]byte
+		key := appendDerivedKey(keyBuf[:0], block, &derivationData, nil)
+
+		working, err := newWorkingCipher(key, keyType)
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating cipher: %w", err)
+		}
+		return working, key, nil
+	}
+
+	ks := &KeySchedule{keyType: keyType}
+
+	_, pinKey, err := workingKey(usageForPinEncryption)
+	if err != nil {
+		return nil, err
+	}
+	if ks.pin, err = newPinCipher(pinKey, keyType); err != nil {
+		return nil, err
+	}
+
+	if ks.mac[0], _, err = workingKey(usageForMessageGeneration); err != nil {
+		return nil, err
+	}
+	if ks.mac[1], _, err = workingKey(usageForMessageVerification); err != nil {
+		return nil, err
+	}
+	if ks.dataBlock[0], _, err = workingKey(usageForDataEncrypt); err != nil {
+		return nil, err
+	}
+	if ks.dataBlock[1], _, err = workingKey(usageForDataDecrypt); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+func actionIndex(action pkg.Action) int {
+	if action == pkg.Response {
+		return 1
+	}
+	return 0
+}
+
+// Append encrypted PIN block to dst
+//
+// NOTE:
+//   - Same result as EncryptPinV2, ISO 9564-1:2017 PIN block format 4 (format 0 of TDES2 and TDES3 key types)
+//
+// Params:
+//   - dst is destination buffer
+//   - pin is not formatted pin
+//   - pan is not formatted pan
+//
+// Return Params:
+//   - result is dst extended by 16 bytes (8 bytes of tdes key types) cipher text
+//   - err
+func (ks *KeySchedule) AppendEncryptedPin(dst, pin, pan []byte) ([]byte, error) {
+	block, err := encryptPinOf(ks.pin, pin, pan, ks.keyType, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(dst, block...), nil
+}
+
+// Decrypt PIN block
+//
+// NOTE:
+//   - Same result as DecryptPinV2, ISO 9564-1:2017 PIN block format 4 (format 0 of TDES2 and TDES3 key types)
+//
+// Params:
+//   - cipher text is encrypted pin block
+//   - pan is not formatted pan
+//
+// Return Params:
+//   - result is pin (plain text)
+//   - err
+func (ks *KeySchedule) DecryptPin(ciphertext, pan []byte) ([]byte, error) {
+	return decryptPinOf(ks.pin, ciphertext, pan, ks.keyType)
+}
+
+// Append AES-CMAC of plain text to dst
+//
+// NOTE:
+//   - Same result as GenerateMacV2 with pkg.CMAC
+//
+// Params:
+//   - dst is destination buffer
+//   - plain text is transaction request data
+//   - action is pkg.Request or pkg.Response
+//
+// Return Params:
+//   - result is dst extended by 16 bytes cmac
+//   - err
+func (ks *KeySchedule) AppendCMAC(dst, plainText []byte, action pkg.Action) ([]byte, error) {
+	return appendCmac(dst, ks.mac[actionIndex(action)], plainText)
+}
+
+// Append encrypted data to dst
+//
+// NOTE:
+//   - Same result as EncryptDataV2, plain text is padded with zeros to aes block length [16]
+//
+// Params:
+//   - dst is destination buffer
+//   - iv is initial vector
+//   - plain text is transaction request data
+//   - action is pkg.Request or pkg.Response
+//
+// Return Params:
+//   - result is dst extended by cipher text
+func (ks *KeySchedule) AppendEncryptedData(dst, iv, plainText []byte, action pkg.Action) []byte {
+	return appendCBCEncrypted(dst, ks.dataBlock[actionIndex(action)], iv, plainText)
+}
+
+// Append decrypted data to dst
+//
+// NOTE:
+//   - Same result as DecryptDataV2
+//
+// Params:
+//   - dst is destination buffer
+//   - iv is initial vector
+//   - cipher text is encrypted data
+//   - action is pkg.Request or pkg.Response
+//
+// Return Params:
+//   - result is dst extended by plain text
+func (ks *KeySchedule) AppendDecryptedData(dst, iv, ciphertext []byte, action pkg.Action) []byte {
+	return appendCBCDecrypted(dst, ks.dataBlock[actionIndex(action)], iv, ciphertext)
+}
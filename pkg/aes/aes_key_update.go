@@ -0,0 +1,165 @@
+package aes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/moov-io/dukpt/encryption"
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// Initial key update payload sent from host to terminal
+//
+//	ANSI X9.24-3:2017 "Update Initial Key" (host and terminal)
+type InitialKeyUpdate struct {
+	// 8 bytes initial key id (bdk id and new derivation id)
+	InitialKeyID []byte
+	// new initial key wrapped under key encryption key (RFC 3394)
+	WrappedKey []byte
+}
+
+// Bytes returns initial key id followed by wrapped key
+func (u *InitialKeyUpdate) Bytes() []byte {
+	if u == nil {
+		return nil
+	}
+
+	var result []byte
+	result = append(result, u.InitialKeyID...)
+	result = append(result, u.WrappedKey...)
+
+	return result
+}
+
+// ParseInitialKeyUpdate reads payload created by InitialKeyUpdate.Bytes
+func ParseInitialKeyUpdate(payload []byte) (*InitialKeyUpdate, error) {
+	if len(payload) <= initialKeyIdLength {
+		return nil, errors.New("invalid initial key update payload length")
+	}
+
+	update := &InitialKeyUpdate{
+		InitialKeyID: make([]byte, initialKeyIdLength),
+		WrappedKey:   make([]byte, len(payload)-initialKeyIdLength),
+	}
+	copy(update.InitialKeyID, payload[:initialKeyIdLength])
+	copy(update.WrappedKey, payload[initialKeyIdLength:])
+
+	return update, nil
+}
+
+// Generate initial key update on host side
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 "Update Initial Key" (host and terminal)
+//   - key encryption key is derived from transaction key of current ksn (key usage 0x0002)
+//   - new initial key is derived from bdk and new initial key id, then wrapped using AES key wrap (RFC 3394)
+//
+// Params:
+//   - bdk is base derivative key
+//   - ksn is 12 bytes current key serial number of the terminal
+//   - new initial key id is 8 bytes (same bdk id, new derivation id)
+//
+// Return Params:
+//   - result is initial key update payload
+//   - err
+func GenerateInitialKeyUpdate(bdk, ksn, newInitialKeyID []byte) (*InitialKeyUpdate, error) {
+	if len(ksn) != initialKeyIdLength+transactionCounterLength {
+		return nil, fmt.Errorf("key serial number length must be %d bytes", initialKeyIdLength+transactionCounterLength)
+	}
+	if len(newInitialKeyID) != initialKeyIdLength {
+		return nil, fmt.Errorf("initial key id length must be %d bytes", initialKeyIdLength)
+	}
+	if !bytes.Equal(ksn[:derivationKeyIdLength], newInitialKeyID[:derivationKeyIdLength]) {
+		return nil, errors.New("new initial key id must keep the bdk id of key serial number")
+	}
+
+	ik, err := DerivationOfInitialKey(bdk, ksn)
+	if err != nil {
+		return nil, err
+	}
+
+	currentKey, err := DeriveCurrentTransactionKey(ik, ksn)
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := keyEncryptionKey(currentKey, ksn)
+	if err != nil {
+		return nil, err
+	}
+
+	newIK, err := DerivationOfInitialKey(bdk, newInitialKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := encryption.AesKeyWrap(kek, newIK)
+	if err != nil {
+		return nil, err
+	}
+
+	update := &InitialKeyUpdate{
+		InitialKeyID: make([]byte, initialKeyIdLength),
+		WrappedKey:   wrapped,
+	}
+	copy(update.InitialKeyID, newInitialKeyID)
+
+	return update, nil
+}
+
+// Apply initial key update on terminal side
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 "Update Initial Key" (host and terminal)
+//   - transaction counter is reset, the result ksn is the first ksn of new initial key id
+//
+// Params:
+//   - current key is transaction key of ksn
+//   - ksn is 12 bytes current key serial number
+//   - update is initial key update payload from host
+//
+// Return Params:
+//   - ik is new initial key
+//   - new ksn is 12 bytes key serial number of new initial key
+//   - err
+func ApplyInitialKeyUpdate(currentKey, ksn []byte, update *InitialKeyUpdate) ([]byte, []byte, error) {
+	if update == nil || len(update.InitialKeyID) != initialKeyIdLength {
+		return nil, nil, errors.New("invalid initial key update")
+	}
+
+	kek, err := keyEncryptionKey(currentKey, ksn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ik, err := encryption.AesKeyUnwrap(kek, update.WrappedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// reset transaction counter
+	newKsn := make([]byte, initialKeyIdLength+transactionCounterLength)
+	copy(newKsn, update.InitialKeyID)
+
+	newKsn, err = pkg.GenerateNextAesKsn(newKsn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ik, newKsn, nil
+}
+
+func keyEncryptionKey(currentKey, ksn []byte) ([]byte, error) {
+	keyType, err := getDerivationKeyType(len(currentKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return generateDerivationKey(derivationParams{
+		KeyUsage:   usageForKeyEncryption,
+		KeyType:    keyType,
+		Ksn:        ksn,
+		CurrentKey: currentKey,
+	})
+}
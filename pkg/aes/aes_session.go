@@ -0,0 +1,259 @@
+package aes
+
+import (
+	"fmt"
+
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// Session bundles key serial number and working keys of one DUKPT transaction
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 6.3.3, 6.5.4, 9.4.2
+//   - Working keys are derived once when the session is created and wiped on Close (see pkg.KeyHolder)
+//   - Methods have the same signature as methods of des.Session and are safe for concurrent use
+//   - ISO 9564-1:2017 PIN block format 4 and AES-CMAC (PIN block format 0 and TDES CMAC of TDES2 and TDES3 key types)
+type Session struct {
+	keyType KeyType
+	keys    *pkg.KeyHolder
+}
+
+// Create session from Initial Key (IK) and Key Serial Number
+//
+// Params:
+//   - ik is initial key
+//   - ksn is 12 bytes key serial number
+//   - key type is AES128, AES192, AES256, TDES2 or TDES3 (working key type)
+//
+// Return Params:
+//   - result is session of transaction
+//   - err
+func NewSession(ik, ksn []byte, keyType string) (*Session, error) {
+	var keyBuf [keyAES256Bits / 8]byte
+	currentKey, err := AppendCurrentTransactionKey(keyBuf[:0], ik, ksn)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(keyBuf[:])
+
+	if err := checkWorkingKeyLength(len(currentKey), keyType); err != nil {
+		return nil, err
+	}
+
+	var keys pkg.SessionKeys
+	workingKey := func(keyUsage uint16) ([]byte, error) {
+		return generateDerivationKey(derivationParams{
+			KeyUsage:   keyUsage,
+			KeyType:    keyType,
+			Ksn:        ksn,
+			CurrentKey: currentKey,
+		})
+	}
+
+	if keys.Pin, err = workingKey(usageForPinEncryption); err != nil {
+		return nil, err
+	}
+	usages := [2][2]uint16{
+		{usageForMessageGeneration, usageForMessageVerification},
+		{usageForDataEncrypt, usageForDataDecrypt},
+	}
+	for i := range keys.Mac {
+		if keys.Mac[i], err = workingKey(usages[0][i]); err != nil {
+			keys.Wipe()
+			return nil, err
+		}
+		if keys.Data[i], err = workingKey(usages[1][i]); err != nil {
+			keys.Wipe()
+			return nil, err
+		}
+	}
+
+	return &Session{keyType: legacyKeyType(keyType), keys: pkg.NewKeyHolder(ksn, keys)}, nil
+}
+
+// Create session from Base Derivative Key and Key Serial Number
+//
+// Params:
+//   - bdk is base derivative key
+//   - ksn is 12 bytes key serial number
+//   - key type is AES128, AES192, AES256, TDES2 or TDES3 (working key type)
+//
+// Return Params:
+//   - result is session of transaction
+//   - err
+func NewSessionFromBDK(bdk, ksn []byte, keyType string) (*Session, error) {
+	ik, err := DerivationOfInitialKey(bdk, ksn)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(ik)
+
+	return NewSession(ik, ksn, keyType)
+}
+
+// KSN returns copy of key serial number of session
+func (s *Session) KSN() []byte {
+	return s.keys.KSN()
+}
+
+// Encrypt PIN using PIN working key
+//
+// Params:
+//   - pin is not formatted pin string
+//   - pan is not formatted pan string
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func (s *Session) EncryptPin(pin, pan string) ([]byte, error) {
+	var block []byte
+	err := s.keys.Use(func(keys *pkg.SessionKeys) error {
+		cipher, err := newPinCipher(keys.Pin, s.keyType)
+		if err != nil {
+			return err
+		}
+
+		block, err = encryptPinOf(cipher, []byte(pin), []byte(pan), s.keyType, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// Decrypt PIN using PIN working key
+//
+// Params:
+//   - cipher text is encrypted pin block
+//   - pan is not formatted pan string
+//
+// Return Params:
+//   - result is pin string (plain text)
+//   - err
+func (s *Session) DecryptPin(ciphertext []byte, pan string) (string, error) {
+	var pin []byte
+	err := s.keys.Use(func(keys *pkg.SessionKeys) error {
+		cipher, err := newPinCipher(keys.Pin, s.keyType)
+		if err != nil {
+			return err
+		}
+
+		pin, err = decryptPinOf(cipher, ciphertext, []byte(pan), s.keyType)
+		return err
+	})
+
+	return string(pin), err
+}
+
+// Generate AES-CMAC using MAC working key
+//
+// NOTE:
+//   - Same result as GenerateCMAC
+//
+// Params:
+//   - plain text is transaction request data
+//   - action is request or response action
+//
+// Return Params:
+//   - result is 16 bytes cmac (8 bytes of tdes key types)
+//   - err
+func (s *Session) MAC(plainText []byte, action string) ([]byte, error) {
+	var result []byte
+	err := s.keys.Use(func(keys *pkg.SessionKeys) (err error) {
+		result, err = sessionCmac(keys, s.keyType, plainText, action)
+		return err
+	})
+
+	return result, err
+}
+
+// Verify AES-CMAC using MAC working key
+//
+// NOTE:
+//   - Truncated mac is compared with the leftmost bytes of the generated cmac in constant time
+//
+// Params:
+//   - plain text is transaction request data
+//   - mac is 4 to 16 bytes (8 bytes of tdes key types) received mac
+//   - action is request or response action
+//
+// Return Params:
+//   - result is true when mac matches
+//   - err
+func (s *Session) VerifyMAC(plainText, mac []byte, action string) (bool, error) {
+	return s.keys.VerifyMAC(mac, pinBlockLength(s.keyType), func(keys *pkg.SessionKeys) ([]byte, error) {
+		return sessionCmac(keys, s.keyType, plainText, action)
+	})
+}
+
+func sessionCmac(keys *pkg.SessionKeys, keyType KeyType, plainText []byte, action string) ([]byte, error) {
+	block, err := newWorkingCipher(keys.Mac[actionIndex(legacyAction(action))], keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendCmac(nil, block, plainText)
+}
+
+// Encrypt data using data working key
+//
+// NOTE:
+//   - Same result as EncryptData, plain text is padded with zeros to cipher block length [16 or 8]
+//
+// Params:
+//   - iv is initial vector
+//   - plain text is transaction request data
+//   - action is request or response action
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func (s *Session) EncryptData(iv, plainText []byte, action string) ([]byte, error) {
+	var result []byte
+	err := s.keys.Use(func(keys *pkg.SessionKeys) error {
+		block, err := newWorkingCipher(keys.Data[actionIndex(legacyAction(action))], s.keyType)
+		if err != nil {
+			return fmt.Errorf("creating cipher: %w", err)
+		}
+
+		result = appendCBCEncrypted(nil, block, iv, plainText)
+		return nil
+	})
+
+	return result, err
+}
+
+// Decrypt data using data working key
+//
+// NOTE:
+//   - Same result as DecryptData
+//
+// Params:
+//   - iv is initial vector
+//   - cipher text is encrypted data
+//   - action is request or response action
+//
+// Return Params:
+//   - result is plain text
+//   - err
+func (s *Session) DecryptData(iv, ciphertext []byte, action string) ([]byte, error) {
+	var result []byte
+	err := s.keys.Use(func(keys *pkg.SessionKeys) error {
+		block, err := newWorkingCipher(keys.Data[actionIndex(legacyAction(action))], s.keyType)
+		if err != nil {
+			return fmt.Errorf("creating cipher: %w", err)
+		}
+
+		result = appendCBCDecrypted(nil, block, iv, ciphertext)
+		return nil
+	})
+
+	return result, err
+}
+
+// Close wipes working keys, any later call of the session returns an error
+func (s *Session) Close() error {
+	return s.keys.Close()
+}
@@ -1,11 +1,15 @@
 package aes
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
+	"testing/iotest"
 
+	"github.com/moov-io/dukpt/encryption"
 	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/testvectors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -20,87 +24,28 @@ type SequenceItem struct {
 	DataResponse string
 }
 
-var InitialSequence = []SequenceItem{
-	{
-		Ksn:          "123456789012345600000001",
-		CurrentKey:   "4F21B565BAD9835E112B6465635EAE44",
-		CMACRequest:  "A2EB5C1C35809E58404E873C3C411E31",
-		CMACResponse: "DD4E1895FD9BF53D8DAF25568ABF551D",
-		HMACRequest:  "B6F8B3159CD4E140159DA87A68C0FB7AF2F123D222662E98988C76386E8E8A02",
-		HMACResponse: "DDE7CB7BDE05AC9A934919B5D94C37954F2376BAF5D45031A56FE74D94C4B64D",
-		DataRequest:  "E5AFA5B408A3310E3D779C8A9A2AE29448BD5B4232582090DB703AF647205A79",
-		DataResponse: "84904DFC6B5201A4F1FE2EAA49E70B8C01838EF53030790FF785D630AB3916B4",
-	},
-	{
-		Ksn:          "123456789012345600000002",
-		CurrentKey:   "2F34D68DE10F68D38091A73B9E7C437C",
-		CMACRequest:  "4D104704C53491A60944193DCAF6B411",
-		CMACResponse: "008CEAAE2B913BEEE76209EE9406DAC1",
-		HMACRequest:  "A884FD458692820E8458AD9FF4FCE58D6B809AB3AE617AE1FC6B70E9E9C12B08",
-		HMACResponse: "32C4EEBF5FB820C4E90A580C0769E5BF8188007191AFAEF53F7C585B03285314",
-		DataRequest:  "A17A9658EE0F451D6CA11B65B592EF9C5F90BB175D926F1457B63B3273042476",
-		DataResponse: "699ED7BC85994F1C11DE1C40177A629530E85262EA0B02FB80771255DC1F65B6",
-	},
-	{
-		Ksn:          "123456789012345600000003",
-		CurrentKey:   "031504E530365CF81264238540518318",
-		CMACRequest:  "6A18904AA8E966A03505F5EEBDAEBD82",
-		CMACResponse: "40CB2F0C2E043D060063FAA916A849E1",
-		HMACRequest:  "5CCB50FB8001C07C51191502BFC7586E165219F59D87938C8A7A84D41AF44F18",
-		HMACResponse: "255E08E91BB5AFFD26C695110FAB5167AB36C9A3C61C78BD2401292A8EFE7C0A",
-		DataRequest:  "E289C32A084A39419A6C147DE40B53A67BABE2BD22DC3D5EE09B002E83B93ACE",
-		DataResponse: "0F440D9321C1ABBEF9CA7008B40CF18B3045A84D5435D6CF9A4955E3451C49E9",
-	},
-	{
-		Ksn:          "123456789012345600000004",
-		CurrentKey:   "0EEFC7ADA628BA68878DA9165A8A1887",
-		CMACRequest:  "77BBD3FB827158B9B12880B0794D1C74",
-		CMACResponse: "51FDE192BC484F769AA0A86F8BC5CB30",
-		HMACRequest:  "5E3C734C41496A10130BE415CBDB8E4F9027250997803DC742D4988123C2963F",
-		HMACResponse: "6493E4DB82E4ACFB3AA3830EBEF7365A31B0D7E3B463304FB3EBBC372A866292",
-		DataRequest:  "D074C0F81866781B92D008C8EA6A3947319B94361C7ECD3C1736020E0D57E629",
-		DataResponse: "08A3D4FB24E9CA4864F63E1AB518C69327D87A6A709B9336E3E60FA7F312759A",
-	},
-	{
-		Ksn:          "123456789012345600000005",
-		CurrentKey:   "C2A7AC328A5DA2D6002D62465BFC028B",
-		CMACRequest:  "C0CE6DA1F4434AD16EEEBF486C9616CD",
-		CMACResponse: "4B508F51104D184A9190061ED854200E",
-		HMACRequest:  "E49D95FD38DF4E39371FD6FD0789E1A2E9A663E20764BF935B457ECEEAE46CBD",
-		HMACResponse: "401CBB584E48C3E08A212A3117E087520D38E4EC054749747D1435B421C9DCE6",
-		DataRequest:  "8F222D79AE7F942BF74D3EA9E364C0000D0C94AD7EA0A8CCF23330FCA4B1D0D0",
-		DataResponse: "400E67A22795467C145D7A5D2475DFD8041C233C6E2D004CEDBEBA25AE5CE1A7",
-	},
-	{
-		Ksn:          "123456789012345600000006",
-		CurrentKey:   "D30F7D9351DA58448A2F5E92B4EE3B7D",
-		CMACRequest:  "05222AE91471C590B3A9471523324C14",
-		CMACResponse: "ED90BD8AE4571C75CF08EA3EA195ABF8",
-		HMACRequest:  "D5B61390352342D2027DFD120F32E77831B32BF34700FBA8232D80780D492766",
-		HMACResponse: "6644594E7C867C2BF0FBA7F00CAF2E184823AE0D9C40FCAA6F48A3605737094D",
-		DataRequest:  "19C254B0498CBD32E597D882B7D993513994EE913836E65711EA41F8D4B34728",
-		DataResponse: "1524F027685DCDA7197E0DE3CB87EF83C6D61D2E483D47678C4914DEC2320B08",
-	},
-	{
-		Ksn:          "123456789012345600000007",
-		CurrentKey:   "A8253CEED9AC042C54F75D35C8352278",
-		CMACRequest:  "37FA54258AAF805A44AA1273C0DF928C",
-		CMACResponse: "52D6C1D3A95B1D21761E20870D9A7E8C",
-		HMACRequest:  "E0A41D52B44C96D5D730BB0DC2F747E7FE5970CE58C0043C42CD3769F6CA3925",
-		HMACResponse: "55F096E89E56A27438F6D01A6DCBF9D396E29EA076C3CC9823B2BB5996ED17EE",
-		DataRequest:  "0710C8F0EB136629E30ADFB148562F6F80B786EC1074428B7C7511B7301EAAF0",
-		DataResponse: "8CA084F21F599C7361C8F2B1565D993779C62EC3496F0113DF17B5C29EA4E404",
-	},
-	{
-		Ksn:          "123456789012345600000008",
-		CurrentKey:   "718EE6CF0B27E53D5F7AF99C4D8146A2",
-		CMACRequest:  "85C7CA68F55F51E7DCAC969B8C111130",
-		CMACResponse: "B45399DB90C0BA75DB4B86605758695A",
-		HMACRequest:  "D7CAB17596E6D193B83E0E13CAB35A179B7296DC04A42D8145B9DF6E60A2A47F",
-		HMACResponse: "32403C170DD07A7C823247DF37649D49FDE905482D1B1C6A62E03E735FA2E384",
-		DataRequest:  "304EC254A7B528D9892E47F7C1315D498C0D88EA8CD71CA52D38F362721AEA67",
-		DataResponse: "8CD1A54538B9DC79A5DF4544ADE8D1F1A085AC1F89AA59BFF49351A68957DE1B",
-	},
+// B.1 AES-128 keys from AES-128 BDK, shared with downstream conformance tests by package testvectors
+var InitialSequence = sequenceOf(testvectors.X9243AES128)
+
+func sequenceOf(suite testvectors.AesSuite) []SequenceItem {
+	hex := func(b []byte) string {
+		return strings.ToUpper(pkg.HexEncode(b))
+	}
+
+	items := make([]SequenceItem, len(suite.Vectors))
+	for i, v := range suite.Vectors {
+		items[i] = SequenceItem{
+			Ksn:          hex(v.KSN),
+			CurrentKey:   hex(v.TransactionKey),
+			CMACRequest:  hex(v.CMACRequest),
+			CMACResponse: hex(v.CMACResponse),
+			HMACRequest:  hex(v.HMACRequest),
+			HMACResponse: hex(v.HMACResponse),
+			DataRequest:  hex(v.DataRequest),
+			DataResponse: hex(v.DataResponse),
+		}
+	}
+	return items
 }
 
 // B.1. Sample Test Vectors for Generating AES-128 keys from AES-128 BDK
@@ -182,3 +127,963 @@ func TestAES128(t *testing.T) {
 		})
 	}
 }
+
+// Working keys shorter than transaction key, KSN 123456789012345600000001
+//
+//	Not part of X9.24-3 test data, the values are reproduced with the openssl 3.0 command line:
+//	  - ik and tk: leftmost length bits of openssl enc -aes-<bdk bits>-ecb -nopad -K <key> over derivation data
+//	    01 <block counter> <usage> <algorithm> <length> <id> (X9.24-3 6.3.2), usage 8001 with id 1234567890123456
+//	    derives ik, usage 8000 with id 9012345600000001 derives tk (counter 1 has a single derivation step)
+//	  - working keys: the same derivation from tk with id 9012345600000001 and usage 2000/2001 (mac) or 3000/3001 (data),
+//	    algorithm 0002, 0003, 0004 (AES-128, AES-192, AES-256) or 0005 (HMAC)
+//	  - cmac: openssl mac -cipher AES-<bits>-CBC -macopt hexkey:<working key> CMAC
+//	  - data: openssl enc -aes-<bits>-cbc -nopad -iv 00000000000000000000000000000000 -K <working key> over zero padded text
+//	  - hmac: openssl mac -digest SHA256 -macopt hexkey:<working key> HMAC
+//	The same steps reproduce the X9.24-3 AES-128 transaction key 4F21B565BAD9835E112B6465635EAE44
+var WorkingKeySequence = []struct {
+	Bdk          string
+	CurrentKey   string
+	KeyType      string
+	CMACRequest  string
+	CMACResponse string
+	DataRequest  string
+	DataResponse string
+}{
+	{
+		Bdk:          "FEDCBA9876543210F1F1F1F1F1F1F1F1FEDCBA9876543210",
+		CurrentKey:   "1387E87CF91556E340947CDBB154AF263ECFCFEA3655EBFE",
+		KeyType:      KeyAES128Type,
+		CMACRequest:  "5CAA004DA00C101D17A7FA131C199611",
+		CMACResponse: "F8300D3AF18E9C3F3FFD8D1F449FE633",
+		DataRequest:  "4B1C7AC96512BBFA65F0ED51511D4E721926E31063FD56BE12D5A7E44E7441F6",
+		DataResponse: "16F05A88AF0CC682B5A9325120D6F8CAA9531C92DF1E1C81FAEBD29BE36CB4C5",
+	},
+	{
+		Bdk:          "FEDCBA9876543210F1F1F1F1F1F1F1F1FEDCBA9876543210",
+		CurrentKey:   "1387E87CF91556E340947CDBB154AF263ECFCFEA3655EBFE",
+		KeyType:      KeyAES192Type,
+		CMACRequest:  "9797BDA625ED02A7CFAEA84964FAA7E3",
+		CMACResponse: "02B8679775A5D5624582E2D2F22AA3E9",
+		DataRequest:  "A4B6B9953F27E01F2A2E234688C05F710E7171233D97FA660668CB99D211031A",
+		DataResponse: "D149237AB7C301A6FB670F77061890AE80056CDF22C0074F8212E65B4FE28F26",
+	},
+	{
+		Bdk:          "FEDCBA9876543210F1F1F1F1F1F1F1F1FEDCBA9876543210F1F1F1F1F1F1F1F1",
+		CurrentKey:   "54AC2B32B145EA4A554CB8BC44B17467063A799856B1CCC2A138D36E8DBF78B3",
+		KeyType:      KeyAES128Type,
+		CMACRequest:  "C94CF5495EEC750788C26C29E3BF2E3E",
+		CMACResponse: "BAD2C4429D06CF8B9735859776352BFA",
+		DataRequest:  "90D417E83B22D858ACB8D98D4F2F66D9331FFCA232E65B16D6398D52394EA20D",
+		DataResponse: "CB7BBE5046344DB1EF0B181BB9A7850396D6DE2A58C698FA3F65518EA0D03777",
+	},
+	{
+		Bdk:          "FEDCBA9876543210F1F1F1F1F1F1F1F1FEDCBA9876543210F1F1F1F1F1F1F1F1",
+		CurrentKey:   "54AC2B32B145EA4A554CB8BC44B17467063A799856B1CCC2A138D36E8DBF78B3",
+		KeyType:      KeyAES192Type,
+		CMACRequest:  "F69002A59859F6E4DEDA11DC26462782",
+		CMACResponse: "6E9D9EE20BB4C6433F190834077BDE60",
+		DataRequest:  "3C9A3AB2889AE7F7CFC4F4368F8019CFB920A1D2B8D4046965B023E49760C342",
+		DataResponse: "8127134BB7C7BDC9191E12A784F8077688BFDAE5F8FD715E387DC97475E5F5B6",
+	},
+	{
+		Bdk:          "FEDCBA9876543210F1F1F1F1F1F1F1F1FEDCBA9876543210F1F1F1F1F1F1F1F1",
+		CurrentKey:   "54AC2B32B145EA4A554CB8BC44B17467063A799856B1CCC2A138D36E8DBF78B3",
+		KeyType:      KeyAES256Type,
+		CMACRequest:  "B2072B93EACB70AF0A7FA3F81F25EC31",
+		CMACResponse: "19DE172C16D1817FBEBD5AF77707FF23",
+		DataRequest:  "A3F8560CC7E0E0CB9DAE191E0FE182E1C86D658366564448B5DB6499313F7BFF",
+		DataResponse: "773535871245AADF8F4DC987D3E1968733D41E93FD58D4962250AF57F0E79364",
+	},
+}
+
+// HMAC working keys of transaction keys of WorkingKeySequence, KSN 123456789012345600000001 (reproduced like WorkingKeySequence)
+var HMACWorkingKeySequence = []struct {
+	CurrentKey   string
+	KeyType      string
+	HMACRequest  string
+	HMACResponse string
+}{
+	{
+		CurrentKey:   "1387E87CF91556E340947CDBB154AF263ECFCFEA3655EBFE",
+		KeyType:      KeyHMAC128Type,
+		HMACRequest:  "B6D0E08333B60EE6EDB3036BD1C4D1D74CD4AB7D06EADB080942BF71E623F334",
+		HMACResponse: "6C951416C2E93D46C82474865BB646FE1C2B29D290C37ADB3CC3F737A5A01513",
+	},
+	{
+		CurrentKey:   "1387E87CF91556E340947CDBB154AF263ECFCFEA3655EBFE",
+		KeyType:      KeyHMAC192Type,
+		HMACRequest:  "B952689B9A75ED9B7D112A51C961492CF877C613AC6AF5759F918179EF0CB500",
+		HMACResponse: "E18E59B03D756DC1FB10189196D1246D788CF381070DFDFB80998F4D472DF5BD",
+	},
+	{
+		CurrentKey:   "54AC2B32B145EA4A554CB8BC44B17467063A799856B1CCC2A138D36E8DBF78B3",
+		KeyType:      KeyHMAC128Type,
+		HMACRequest:  "FE8D7B082920270705EA72F0CAFB0C0F5F6716E57A06E5305C7524061DAB9AE5",
+		HMACResponse: "BE00D551FDA57AB3F95770D9D0D6308975949A8E6DC490FC77297BCDAF05591F",
+	},
+	{
+		CurrentKey:   "54AC2B32B145EA4A554CB8BC44B17467063A799856B1CCC2A138D36E8DBF78B3",
+		KeyType:      KeyHMAC192Type,
+		HMACRequest:  "06A5B9BE3A6F6A892460C34A7BA0F20E7E59B30FE0324A680ECCC4D16F237E98",
+		HMACResponse: "5A4B544118C7D0F7878B1122DA2263C4853C6647006F7CC20E52E770DCF36F59",
+	},
+	{
+		CurrentKey:   "54AC2B32B145EA4A554CB8BC44B17467063A799856B1CCC2A138D36E8DBF78B3",
+		KeyType:      KeyHMAC256Type,
+		HMACRequest:  "DCA9F4A17503CC60DED639EBABEEB57F56B184E51F42F3C191985796FC17FE73",
+		HMACResponse: "0C6BFF0296E3BD1892B0E6A63FC14A0547E184533E0172979F9B0F7E12C7441D",
+	},
+}
+
+// TDES working keys, KSN 123456789012345600000001 (reproduced like WorkingKeySequence)
+//
+//	Algorithm 0000 (2TDEA, 128 bits) or 0001 (3TDEA, 192 bits), 2TDEA keys are used as K1 K2 K1:
+//	  - pin: openssl enc -des-ede3-ecb -nopad -K <pin key> over ISO 9564-1 format 0 block of pin 1234 and pan 4111111111111111
+//	  - cmac: openssl mac -cipher DES-EDE3-CBC -macopt hexkey:<working key> CMAC
+//	  - data: openssl enc -des-ede3-cbc -nopad -iv 0000000000000000 -K <working key> over zero padded text
+var TDESWorkingKeySequence = []struct {
+	CurrentKey   string
+	KeyType      string
+	PinBlock     string
+	CMACRequest  string
+	CMACResponse string
+	DataRequest  string
+	DataResponse string
+}{
+	{
+		CurrentKey:   "4F21B565BAD9835E112B6465635EAE44",
+		KeyType:      KeyTDES2Type,
+		PinBlock:     "99E27D3947AB25F3",
+		CMACRequest:  "44992DECE189AEDB",
+		CMACResponse: "D5E659F3D8919232",
+		DataRequest:  "AC8B2166615E553BAF8717272E2250E8DB9D1EADE4063F19",
+		DataResponse: "58973145A02F02F00F59B313262F7720E8225A16999958BF",
+	},
+	{
+		CurrentKey:   "4F21B565BAD9835E112B6465635EAE44",
+		KeyType:      KeyTDES3Type,
+		PinBlock:     "899F574F5C7D1E11",
+		CMACRequest:  "462098CBD28A4CFF",
+		CMACResponse: "0756FCA4EB299AB3",
+		DataRequest:  "51B580E4AB22A91C879CBB2339445544CBFBE382016F9DDA",
+		DataResponse: "E43B37101F6DA93D5764A0D2BAC2E391B4680DB94790C57F",
+	},
+	{
+		CurrentKey:   "54AC2B32B145EA4A554CB8BC44B17467063A799856B1CCC2A138D36E8DBF78B3",
+		KeyType:      KeyTDES3Type,
+		PinBlock:     "8577E3E0DAB311C9",
+		CMACRequest:  "4A55E7A5750D8A5A",
+		CMACResponse: "76527B701430FC4A",
+		DataRequest:  "DE7C6D1EEC0FC451FBBB7B6756487963A5D6AAE1890E8E49",
+		DataResponse: "2AA8DF4A5CD74DE1F88D9765BC75B72D7C62E79BD07DC4A4",
+	},
+}
+
+func TestWorkingKeyType(t *testing.T) {
+	pin := "1234"
+	pan := "4111111111111111"
+	macData := "4012345678909D987"
+	ksn := pkg.HexDecode("123456789012345600000001")
+
+	for _, item := range WorkingKeySequence {
+		t.Run(fmt.Sprintf("%d bits bdk %s", len(item.Bdk)*4, item.KeyType), func(t *testing.T) {
+			ik, err := DerivationOfInitialKey(pkg.HexDecode(item.Bdk), ksn)
+			require.NoError(t, err)
+			require.Len(t, ik, len(item.Bdk)/2)
+
+			transactionKey, err := DeriveCurrentTransactionKey(ik, ksn)
+			require.NoError(t, err)
+			require.Equal(t, item.CurrentKey, strings.ToUpper(pkg.HexEncode(transactionKey)))
+
+			encPinblock, err := EncryptPin(transactionKey, ksn, pin, pan, item.KeyType)
+			require.NoError(t, err)
+
+			decPinblock, err := DecryptPin(transactionKey, ksn, encPinblock, pan, item.KeyType)
+			require.NoError(t, err)
+			require.Equal(t, pin, decPinblock)
+
+			genMac, err := GenerateCMAC(transactionKey, ksn, macData, item.KeyType, pkg.ActionRequest)
+			require.NoError(t, err)
+			require.Equal(t, item.CMACRequest, strings.ToUpper(pkg.HexEncode(genMac)))
+
+			genMac, err = GenerateCMAC(transactionKey, ksn, macData, item.KeyType, pkg.ActionResponse)
+			require.NoError(t, err)
+			require.Equal(t, item.CMACResponse, strings.ToUpper(pkg.HexEncode(genMac)))
+
+			encData, err := EncryptData(transactionKey, ksn, nil, macData, item.KeyType, pkg.ActionRequest)
+			require.NoError(t, err)
+			require.Equal(t, item.DataRequest, strings.ToUpper(pkg.HexEncode(encData)))
+
+			encData, err = EncryptData(transactionKey, ksn, nil, macData, item.KeyType, pkg.ActionResponse)
+			require.NoError(t, err)
+			require.Equal(t, item.DataResponse, strings.ToUpper(pkg.HexEncode(encData)))
+
+			decData, err := DecryptData(transactionKey, ksn, encData, nil, item.KeyType, pkg.ActionResponse)
+			require.NoError(t, err)
+			require.Equal(t, macData, decData[:len(macData)])
+		})
+	}
+
+	for _, item := range HMACWorkingKeySequence {
+		t.Run(fmt.Sprintf("%d bits tk %s", len(item.CurrentKey)*4, item.KeyType), func(t *testing.T) {
+			transactionKey := pkg.HexDecode(item.CurrentKey)
+
+			genMac, err := GenerateHMAC(transactionKey, ksn, macData, item.KeyType, pkg.ActionRequest)
+			require.NoError(t, err)
+			require.Equal(t, item.HMACRequest, strings.ToUpper(pkg.HexEncode(genMac)))
+
+			genMac, err = GenerateHMAC(transactionKey, ksn, macData, item.KeyType, pkg.ActionResponse)
+			require.NoError(t, err)
+			require.Equal(t, item.HMACResponse, strings.ToUpper(pkg.HexEncode(genMac)))
+		})
+	}
+
+	// working key stronger than transaction key
+	_, err := EncryptPin(pkg.HexDecode(WorkingKeySequence[0].CurrentKey), ksn, pin, pan, KeyAES256Type)
+	require.EqualError(t, err, "mismatched key length and key type")
+
+	// tdes working keys have no hmac
+	_, err = GenerateHMAC(pkg.HexDecode(WorkingKeySequence[0].CurrentKey), ksn, macData, KeyTDES2Type, pkg.ActionRequest)
+	require.EqualError(t, err, "unsupported key type")
+}
+
+// Three key TDEA cmac, key and empty and 64 bits messages of NIST SP 800-38B D.4,
+// tags of longer messages are reproduced with openssl mac -cipher DES-EDE3-CBC CMAC
+func TestCmac64(t *testing.T) {
+	ecb, err := encryption.NewTripleDesECB(pkg.HexDecode("8AA83BF8CBDA10620BC1BF19FBB6CD58BC313D4A371CA8B5"))
+	require.NoError(t, err)
+
+	for msg, expected := range map[string]string{
+		"":                 "B7A688E122FFAF95",
+		"6BC1BEE22E409F96": "8E8F293136283797",
+		"6BC1BEE22E409F96E93D7E117393172AAE8A4A57":                         "9A61F7DF85ECDEA9",
+		"6BC1BEE22E409F96E93D7E117393172AAE8A4A571E03AC9C9EB76FAC45AF8E51": "C0F4D00752F4CEBB",
+	} {
+		mac, err := appendCmac(nil, ecb.GetBlock(), pkg.HexDecode(msg))
+		require.NoError(t, err)
+		require.Equal(t, expected, strings.ToUpper(pkg.HexEncode(mac)))
+	}
+}
+
+func TestTDESWorkingKeyType(t *testing.T) {
+	pin := "1234"
+	pan := "4111111111111111"
+	macData := "4012345678909D987"
+	ksn := pkg.HexDecode("123456789012345600000001")
+
+	for _, item := range TDESWorkingKeySequence {
+		t.Run(fmt.Sprintf("%d bits tk %s", len(item.CurrentKey)*4, item.KeyType), func(t *testing.T) {
+			transactionKey := pkg.HexDecode(item.CurrentKey)
+			keyType, err := ParseKeyType(item.KeyType)
+			require.NoError(t, err)
+
+			// iso-0 pin block has no random fill
+			encPinblock, err := EncryptPin(transactionKey, ksn, pin, pan, item.KeyType)
+			require.NoError(t, err)
+			require.Equal(t, item.PinBlock, strings.ToUpper(pkg.HexEncode(encPinblock)))
+
+			decPin, err := DecryptPin(transactionKey, ksn, encPinblock, pan, item.KeyType)
+			require.NoError(t, err)
+			require.Equal(t, pin, decPin)
+
+			genMac, err := GenerateCMAC(transactionKey, ksn, macData, item.KeyType, pkg.ActionRequest)
+			require.NoError(t, err)
+			require.Equal(t, item.CMACRequest, strings.ToUpper(pkg.HexEncode(genMac)))
+
+			genMac, err = GenerateCMAC(transactionKey, ksn, macData, item.KeyType, pkg.ActionResponse)
+			require.NoError(t, err)
+			require.Equal(t, item.CMACResponse, strings.ToUpper(pkg.HexEncode(genMac)))
+
+			encData, err := EncryptData(transactionKey, ksn, nil, macData, item.KeyType, pkg.ActionRequest)
+			require.NoError(t, err)
+			require.Equal(t, item.DataRequest, strings.ToUpper(pkg.HexEncode(encData)))
+
+			encData, err = EncryptData(transactionKey, ksn, nil, macData, item.KeyType, pkg.ActionResponse)
+			require.NoError(t, err)
+			require.Equal(t, item.DataResponse, strings.ToUpper(pkg.HexEncode(encData)))
+
+			decData, err := DecryptData(transactionKey, ksn, encData, nil, item.KeyType, pkg.ActionResponse)
+			require.NoError(t, err)
+			require.Len(t, decData, 24)
+			require.Equal(t, macData, decData[:len(macData)])
+
+			// key schedule has the same working keys
+			ks, err := NewKeySchedule(transactionKey, ksn, keyType)
+			require.NoError(t, err)
+
+			encPinblock, err = ks.AppendEncryptedPin(nil, []byte(pin), []byte(pan))
+			require.NoError(t, err)
+			require.Equal(t, item.PinBlock, strings.ToUpper(pkg.HexEncode(encPinblock)))
+
+			clearPin, err := ks.DecryptPin(encPinblock, []byte(pan))
+			require.NoError(t, err)
+			require.Equal(t, pin, string(clearPin))
+
+			genMac, err = ks.AppendCMAC(nil, []byte(macData), pkg.Response)
+			require.NoError(t, err)
+			require.Equal(t, item.CMACResponse, strings.ToUpper(pkg.HexEncode(genMac)))
+
+			encData = ks.AppendEncryptedData(nil, nil, []byte(macData), pkg.Request)
+			require.Equal(t, item.DataRequest, strings.ToUpper(pkg.HexEncode(encData)))
+
+			// clear pin block fields
+			pinBlock, err := DecryptPinBlock(transactionKey, ksn, encPinblock, nil, keyType)
+			require.NoError(t, err)
+			require.Equal(t, "041225EEEEEEEEEE", strings.ToUpper(pkg.HexEncode(pinBlock)))
+
+			_, err = EncryptPinBlock(transactionKey, ksn, pinBlock, make([]byte, 16), keyType)
+			require.EqualError(t, err, "pan field is only used by ISO-4 pin block")
+
+			_, err = DecryptPinV2(transactionKey, ksn, encPinblock[:4], []byte(pan), keyType)
+			require.EqualError(t, err, "pin block length must be 8 bytes")
+		})
+	}
+}
+
+func TestKeyTypeOfLength(t *testing.T) {
+	for keyLen, expected := range map[int]KeyType{16: AES128, 24: AES192, 32: AES256} {
+		keyType, err := KeyTypeOfLength(keyLen)
+		require.NoError(t, err)
+		require.Equal(t, expected, keyType)
+	}
+
+	_, err := KeyTypeOfLength(8)
+	require.EqualError(t, err, "invalid transaction key length")
+}
+
+func TestKeyTypeHMAC(t *testing.T) {
+	for keyType, expected := range map[KeyType]KeyType{AES128: HMAC128, AES192: HMAC192, AES256: HMAC256, HMAC192: HMAC192} {
+		require.Equal(t, expected, keyType.HMAC())
+	}
+}
+
+func TestReencryptPin(t *testing.T) {
+	pin := "1234"
+	pan := "4111111111111111"
+
+	bdk := []byte{0xFE, 0xDC, 0xBA, 0x98, 0x76, 0x54, 0x32, 0x10, 0xF1, 0xF1, 0xF1, 0xF1, 0xF1, 0xF1, 0xF1, 0xF1}
+	ksn := pkg.HexDecode(InitialSequence[0].Ksn)
+	outputKsn := pkg.HexDecode(InitialSequence[1].Ksn)
+	currentKey := pkg.HexDecode(InitialSequence[0].CurrentKey)
+	outputKey := pkg.HexDecode(InitialSequence[1].CurrentKey)
+
+	ik, err := DerivationOfInitialKey(bdk, ksn)
+	require.NoError(t, err)
+	transactionKey, err := DeriveCurrentTransactionKey(ik, ksn)
+	require.NoError(t, err)
+	require.Equal(t, currentKey, transactionKey)
+
+	encPinblock, err := EncryptPin(currentKey, ksn, pin, pan, KeyAES128Type)
+	require.NoError(t, err)
+
+	t.Run("same key", func(t *testing.T) {
+		translated, err := ReencryptPin(currentKey, ksn, encPinblock, pan, KeyAES128Type, nil, nil)
+		require.NoError(t, err)
+		require.Len(t, translated, 16)
+
+		decrypted, err := DecryptPin(currentKey, ksn, translated, pan, KeyAES128Type)
+		require.NoError(t, err)
+		require.Equal(t, pin, decrypted)
+	})
+
+	t.Run("output key", func(t *testing.T) {
+		translated, err := ReencryptPin(currentKey, ksn, encPinblock, pan, KeyAES128Type, outputKey, outputKsn)
+		require.NoError(t, err)
+
+		decrypted, err := DecryptPin(outputKey, outputKsn, translated, pan, KeyAES128Type)
+		require.NoError(t, err)
+		require.Equal(t, pin, decrypted)
+	})
+
+	t.Run("invalid output key", func(t *testing.T) {
+		_, err := ReencryptPin(currentKey, ksn, encPinblock, pan, KeyAES128Type, []byte{0x01}, outputKsn)
+		require.Error(t, err)
+	})
+}
+
+func TestInitialKeyUpdate(t *testing.T) {
+	bdk := []byte{0xFE, 0xDC, 0xBA, 0x98, 0x76, 0x54, 0x32, 0x10, 0xF1, 0xF1, 0xF1, 0xF1, 0xF1, 0xF1, 0xF1, 0xF1}
+	ksn := pkg.HexDecode(InitialSequence[4].Ksn)
+	newInitialKeyID := pkg.HexDecode("1234567890123457")
+
+	// host side
+	update, err := GenerateInitialKeyUpdate(bdk, ksn, newInitialKeyID)
+	require.NoError(t, err)
+	require.Equal(t, newInitialKeyID, update.InitialKeyID)
+	require.Len(t, update.WrappedKey, 24)
+
+	parsed, err := ParseInitialKeyUpdate(update.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, update, parsed)
+
+	// terminal side
+	currentKey := pkg.HexDecode(InitialSequence[4].CurrentKey)
+	ik, newKsn, err := ApplyInitialKeyUpdate(currentKey, ksn, parsed)
+	require.NoError(t, err)
+	require.Equal(t, "123456789012345700000001", strings.ToUpper(pkg.HexEncode(newKsn)))
+
+	expectedIK, err := DerivationOfInitialKey(bdk, newKsn)
+	require.NoError(t, err)
+	require.Equal(t, expectedIK, ik)
+
+	// new initial key works for transactions
+	transactionKey, err := DeriveCurrentTransactionKey(ik, newKsn)
+	require.NoError(t, err)
+	encPinblock, err := EncryptPin(transactionKey, newKsn, "1234", "4111111111111111", KeyAES128Type)
+	require.NoError(t, err)
+	decPinblock, err := DecryptPin(transactionKey, newKsn, encPinblock, "4111111111111111", KeyAES128Type)
+	require.NoError(t, err)
+	require.Equal(t, "1234", decPinblock)
+
+	// payload wrapped for another ksn is rejected
+	otherKey := pkg.HexDecode(InitialSequence[5].CurrentKey)
+	_, _, err = ApplyInitialKeyUpdate(otherKey, pkg.HexDecode(InitialSequence[5].Ksn), parsed)
+	require.Error(t, err)
+
+	// bdk id must not change
+	_, err = GenerateInitialKeyUpdate(bdk, ksn, pkg.HexDecode("AABBCCDD90123457"))
+	require.Error(t, err)
+}
+
+func TestKeySchedule(t *testing.T) {
+	pin := "1234"
+	pan := "4111111111111111"
+	macData := []byte("4012345678909D987")
+	ik := pkg.HexDecode("1273671EA26AC29AFA4D1084127652A1")
+
+	for index, item := range InitialSequence {
+		t.Run(fmt.Sprintf("Sequence #%d KSN: %s", index+1, item.Ksn), func(t *testing.T) {
+			ksn := pkg.HexDecode(item.Ksn)
+			original := append([]byte(nil), ksn...)
+
+			prefix := []byte{0xAA}
+			transactionKey, err := AppendCurrentTransactionKey(prefix, ik, ksn)
+			require.NoError(t, err)
+			require.Equal(t, byte(0xAA), transactionKey[0])
+			transactionKey = transactionKey[1:]
+			require.Equal(t, item.CurrentKey, strings.ToUpper(pkg.HexEncode(transactionKey)))
+
+			ks, err := NewKeySchedule(transactionKey, ksn, AES128)
+			require.NoError(t, err)
+
+			encPinblock, err := ks.AppendEncryptedPin(nil, []byte(pin), []byte(pan))
+			require.NoError(t, err)
+
+			decPin, err := DecryptPin(transactionKey, ksn, encPinblock, pan, KeyAES128Type)
+			require.NoError(t, err)
+			require.Equal(t, pin, decPin)
+
+			clearPin, err := ks.DecryptPin(encPinblock, []byte(pan))
+			require.NoError(t, err)
+			require.Equal(t, pin, string(clearPin))
+
+			genMac, err := ks.AppendCMAC(nil, macData, pkg.Request)
+			require.NoError(t, err)
+			require.Equal(t, item.CMACRequest, strings.ToUpper(pkg.HexEncode(genMac)))
+
+			genMac, err = ks.AppendCMAC(nil, macData, pkg.Response)
+			require.NoError(t, err)
+			require.Equal(t, item.CMACResponse, strings.ToUpper(pkg.HexEncode(genMac)))
+
+			encData := ks.AppendEncryptedData(nil, nil, macData, pkg.Request)
+			require.Equal(t, item.DataRequest, strings.ToUpper(pkg.HexEncode(encData)))
+			require.Equal(t, macData, ks.AppendDecryptedData(nil, nil, encData, pkg.Request)[:len(macData)])
+
+			encData = ks.AppendEncryptedData(nil, nil, macData, pkg.Response)
+			require.Equal(t, item.DataResponse, strings.ToUpper(pkg.HexEncode(encData)))
+			require.Equal(t, macData, ks.AppendDecryptedData(nil, nil, encData, pkg.Response)[:len(macData)])
+
+			// derivation must never modify the caller's key serial number
+			require.Equal(t, original, ksn)
+		})
+	}
+
+	_, err := NewKeySchedule(ik, pkg.HexDecode(InitialSequence[0].Ksn), AES256)
+	require.EqualError(t, err, "mismatched key length and key type")
+}
+
+func TestSession(t *testing.T) {
+	pin := "1234"
+	pan := "4111111111111111"
+	macData := []byte("4012345678909D987")
+	ik := pkg.HexDecode("1273671EA26AC29AFA4D1084127652A1")
+
+	for index, item := range InitialSequence {
+		t.Run(fmt.Sprintf("Sequence #%d KSN: %s", index+1, item.Ksn), func(t *testing.T) {
+			ksn := pkg.HexDecode(item.Ksn)
+
+			s, err := NewSession(ik, ksn, KeyAES128Type)
+			require.NoError(t, err)
+			require.Equal(t, ksn, s.KSN())
+
+			encPinblock, err := s.EncryptPin(pin, pan)
+			require.NoError(t, err)
+
+			transactionKey := pkg.HexDecode(item.CurrentKey)
+			decPin, err := DecryptPin(transactionKey, ksn, encPinblock, pan, KeyAES128Type)
+			require.NoError(t, err)
+			require.Equal(t, pin, decPin)
+
+			decPin, err = s.DecryptPin(encPinblock, pan)
+			require.NoError(t, err)
+			require.Equal(t, pin, decPin)
+
+			genMac, err := s.MAC(macData, pkg.ActionRequest)
+			require.NoError(t, err)
+			require.Equal(t, item.CMACRequest, strings.ToUpper(pkg.HexEncode(genMac)))
+
+			genMac, err = s.MAC(macData, pkg.ActionResponse)
+			require.NoError(t, err)
+			require.Equal(t, item.CMACResponse, strings.ToUpper(pkg.HexEncode(genMac)))
+
+			verified, err := s.VerifyMAC(macData, genMac[:8], pkg.ActionResponse)
+			require.NoError(t, err)
+			require.True(t, verified)
+
+			verified, err = s.VerifyMAC(macData, genMac, pkg.ActionRequest)
+			require.NoError(t, err)
+			require.False(t, verified)
+
+			encData, err := s.EncryptData(nil, macData, pkg.ActionRequest)
+			require.NoError(t, err)
+			require.Equal(t, item.DataRequest, strings.ToUpper(pkg.HexEncode(encData)))
+
+			decData, err := s.DecryptData(nil, encData, pkg.ActionRequest)
+			require.NoError(t, err)
+			require.Equal(t, macData, decData[:len(macData)])
+
+			encData, err = s.EncryptData(nil, macData, pkg.ActionResponse)
+			require.NoError(t, err)
+			require.Equal(t, item.DataResponse, strings.ToUpper(pkg.HexEncode(encData)))
+
+			var pinKey []byte
+			require.NoError(t, s.keys.Use(func(keys *pkg.SessionKeys) error {
+				pinKey = keys.Pin
+				return nil
+			}))
+			require.NoError(t, s.Close())
+			require.Equal(t, make([]byte, len(pinKey)), pinKey)
+
+			_, err = s.DecryptPin(encPinblock, pan)
+			require.EqualError(t, err, "session is closed")
+			_, err = s.EncryptData(nil, macData, pkg.ActionRequest)
+			require.EqualError(t, err, "session is closed")
+		})
+	}
+
+	_, err := NewSession(ik, pkg.HexDecode(InitialSequence[0].Ksn), KeyAES256Type)
+	require.EqualError(t, err, "mismatched key length and key type")
+}
+
+func TestV2(t *testing.T) {
+	item := InitialSequence[0]
+	transactionKey := pkg.HexDecode(item.CurrentKey)
+	ksn := pkg.HexDecode(item.Ksn)
+	pan := []byte("4111111111111111")
+	macData := []byte("4012345678909D987")
+
+	encPinblock, err := EncryptPinV2(transactionKey, ksn, []byte("1234"), pan, AES128)
+	require.NoError(t, err)
+
+	pin, err := DecryptPinV2(transactionKey, ksn, encPinblock, pan, AES128)
+	require.NoError(t, err)
+	require.Equal(t, []byte("1234"), pin)
+
+	genMac, err := GenerateMacV2(transactionKey, ksn, macData, AES128, pkg.CMAC, pkg.Request)
+	require.NoError(t, err)
+	require.Equal(t, item.CMACRequest, strings.ToUpper(pkg.HexEncode(genMac)))
+
+	genMac, err = GenerateMacV2(transactionKey, ksn, macData, HMAC128, pkg.HMAC, pkg.Response)
+	require.NoError(t, err)
+	require.Equal(t, item.HMACResponse, strings.ToUpper(pkg.HexEncode(genMac)))
+
+	encData, err := EncryptDataV2(transactionKey, ksn, nil, macData, AES128, pkg.Response)
+	require.NoError(t, err)
+	require.Equal(t, item.DataResponse, strings.ToUpper(pkg.HexEncode(encData)))
+
+	decData, err := DecryptDataV2(transactionKey, ksn, nil, encData, AES128, pkg.Response)
+	require.NoError(t, err)
+	require.Equal(t, macData, decData[:len(macData)])
+
+	keyType, err := ParseKeyType("AES256")
+	require.NoError(t, err)
+	require.Equal(t, AES256, keyType)
+
+	_, err = ParseKeyType("aes128")
+	require.EqualError(t, err, "unsupported key type")
+
+	_, err = GenerateMacV2(transactionKey, ksn, macData, AES128, pkg.MacType(0), pkg.Request)
+	require.EqualError(t, err, "invalid mac type")
+
+	_, err = GenerateMacV2(transactionKey, ksn, macData, HMAC128, pkg.CMAC, pkg.Request)
+	require.EqualError(t, err, "unsupported key type")
+
+	_, err = EncryptDataV2(transactionKey, ksn, nil, macData, AES128, pkg.Action(0))
+	require.EqualError(t, err, "invalid action")
+
+	// v1 keeps falling back to request on unknown action
+	genMac, err = GenerateCMAC(transactionKey, ksn, string(macData), KeyAES128Type, "unknown")
+	require.NoError(t, err)
+	require.Equal(t, item.CMACRequest, strings.ToUpper(pkg.HexEncode(genMac)))
+}
+
+func BenchmarkDeriveCurrentTransactionKey(b *testing.B) {
+	ik := pkg.HexDecode("1273671EA26AC29AFA4D1084127652A1")
+	ksn := pkg.HexDecode("123456789012345600000007")
+
+	b.Run("allocating", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := DeriveCurrentTransactionKey(ik, ksn); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("append", func(b *testing.B) {
+		b.ReportAllocs()
+		dst := make([]byte, 0, len(ik))
+		for i := 0; i < b.N; i++ {
+			if _, err := AppendCurrentTransactionKey(dst[:0], ik, ksn); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGenerateCMAC(b *testing.B) {
+	transactionKey := pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44")
+	ksn := pkg.HexDecode("123456789012345600000001")
+	macData := "4012345678909D987"
+
+	b.Run("one-shot", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := GenerateCMAC(transactionKey, ksn, macData, KeyAES128Type, pkg.ActionRequest); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("key-schedule", func(b *testing.B) {
+		ks, err := NewKeySchedule(transactionKey, ksn, AES128)
+		require.NoError(b, err)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		buf := make([]byte, 0, 16)
+		for i := 0; i < b.N; i++ {
+			if _, err := ks.AppendCMAC(buf[:0], []byte(macData), pkg.Request); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkEncryptData(b *testing.B) {
+	transactionKey := pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44")
+	ksn := pkg.HexDecode("123456789012345600000001")
+	data := "4012345678909D987"
+
+	b.Run("one-shot", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := EncryptData(transactionKey, ksn, nil, data, KeyAES128Type, pkg.ActionRequest); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("key-schedule", func(b *testing.B) {
+		ks, err := NewKeySchedule(transactionKey, ksn, AES128)
+		require.NoError(b, err)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		buf := make([]byte, 0, 32)
+		for i := 0; i < b.N; i++ {
+			buf = ks.AppendEncryptedData(buf[:0], nil, []byte(data), pkg.Request)
+		}
+	})
+}
+
+func TestInitialKeyID(t *testing.T) {
+	bdk := pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1")
+	expectedIK := pkg.HexDecode("1273671EA26AC29AFA4D1084127652A1")
+
+	id, err := NewInitialKeyID(pkg.HexDecode("12345678"), pkg.HexDecode("90123456"))
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("1234567890123456"), id.Bytes())
+
+	parsed, err := ParseInitialKeyID(id.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, id, parsed)
+
+	ksn, err := id.KSN(1)
+	require.NoError(t, err)
+	require.Equal(t, InitialSequence[0].Ksn, strings.ToUpper(pkg.HexEncode(ksn)))
+
+	fromKsn, err := InitialKeyIDFromKsn(ksn)
+	require.NoError(t, err)
+	require.Equal(t, id, fromKsn)
+
+	ik, err := DerivationOfInitialKeyByID(bdk, id)
+	require.NoError(t, err)
+	require.Equal(t, expectedIK, ik)
+
+	ik, err = DerivationOfInitialKey(bdk, ksn)
+	require.NoError(t, err)
+	require.Equal(t, expectedIK, ik)
+
+	keys, err := DerivationOfInitialKeys(bdk, id.BDKID[:], pkg.HexDecode("90123456"), pkg.HexDecode("90123457"))
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, expectedIK, keys[0])
+
+	other, err := DerivationOfInitialKey(bdk, pkg.HexDecode("1234567890123457"))
+	require.NoError(t, err)
+	require.Equal(t, other, keys[1])
+
+	_, err = id.KSN(0x0001FFFF)
+	require.EqualError(t, err, "transaction counter must have at most 16 one bits")
+
+	// compatibility mode of legacy 10 bytes key serial number
+	legacyID, counter, err := InitialKeyIDFromLegacyKsn(pkg.HexDecode("FFFF9876543210E0000B"))
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("FFFF9876543210E0"), legacyID.Bytes())
+	require.Equal(t, uint32(0x0B), counter)
+
+	legacyKsn, err := legacyID.KSN(counter)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("FFFF9876543210E00000000B"), legacyKsn)
+
+	legacyKsn, err = legacyID.LegacyKSN(0x1F0000)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("FFFF9876543210FF0000"), legacyKsn)
+
+	_, err = id.LegacyKSN(1)
+	require.EqualError(t, err, "key serial number is not mapped from legacy key serial number")
+
+	_, err = legacyID.LegacyKSN(0x00200000)
+	require.EqualError(t, err, "key serial number is not mapped from legacy key serial number")
+
+	_, _, err = InitialKeyIDFromLegacyKsn(ksn)
+	require.EqualError(t, err, "legacy key serial number length must be 10 bytes")
+
+	_, err = NewInitialKeyID(pkg.HexDecode("123456"), pkg.HexDecode("90123456"))
+	require.EqualError(t, err, "bdk id length must be 4 bytes")
+
+	_, err = NewInitialKeyID(pkg.HexDecode("12345678"), pkg.HexDecode("901234"))
+	require.EqualError(t, err, "derivation id length must be 4 bytes")
+
+	_, err = ParseInitialKeyID(ksn)
+	require.EqualError(t, err, "initial key id length must be 8 bytes")
+
+	_, err = InitialKeyIDFromKsn(id.Bytes())
+	require.EqualError(t, err, "key serial number length must be 12 bytes")
+
+	_, err = DerivationOfInitialKey(bdk, pkg.HexDecode("12345678"))
+	require.EqualError(t, err, "initial key id length must be at least 8 bytes")
+}
+
+func TestDeriveCurrentTransactionKeyWithTracer(t *testing.T) {
+	ik := pkg.HexDecode("1273671EA26AC29AFA4D1084127652A1")
+	item := InitialSequence[2]
+	ksn := pkg.HexDecode(item.Ksn)
+
+	var steps []pkg.TraceStep
+	tracer := pkg.TracerFunc(func(step pkg.TraceStep) {
+		steps = append(steps, step)
+	})
+
+	transactionKey, err := DeriveCurrentTransactionKeyWithTracer(ik, ksn, tracer)
+	require.NoError(t, err)
+	require.Equal(t, item.CurrentKey, strings.ToUpper(pkg.HexEncode(transactionKey)))
+
+	// counter 3 takes two derivation steps, each one reports counter, derivation data and intermediate key
+	require.Len(t, steps, 1+2*3+1)
+	require.Equal(t, "initial key", steps[0].Name)
+	require.Equal(t, "transaction counter", steps[1].Name)
+	require.Equal(t, "00000002", steps[1].Detail)
+	require.Equal(t, "derivation data", steps[2].Name)
+	require.Equal(t, pkg.HexDecode("01018000000200809012345600000002"), steps[2].Value)
+	require.False(t, steps[2].Secret)
+	require.Equal(t, "00000003", steps[4].Detail)
+	require.Equal(t, "transaction key", steps[7].Name)
+	require.True(t, steps[7].Secret)
+	require.Equal(t, transactionKey, steps[7].Value)
+
+	steps = nil
+	macKey, err := DeriveWorkingKey(transactionKey, ksn, pkg.UsageMac, KeyAES128Type, pkg.ActionRequest, tracer)
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+	require.Equal(t, pkg.HexDecode("01012000000200809012345600000003"), steps[0].Value)
+	require.Equal(t, macKey, steps[1].Value)
+
+	_, err = DeriveWorkingKey(transactionKey, ksn, "kek", KeyAES128Type, pkg.ActionRequest, nil)
+	require.EqualError(t, err, "invalid working key usage")
+}
+
+func TestExportKey(t *testing.T) {
+	kek := pkg.HexDecode("000102030405060708090A0B0C0D0E0F")
+	ksn := pkg.HexDecode("123456789012345600000001")
+	ik := pkg.HexDecode("1273671EA26AC29AFA4D1084127652A1")
+	currentKey := pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44")
+
+	for _, mode := range []encryption.KeyWrapMode{encryption.KeyWrapRFC3394, encryption.KeyWrapRFC5649} {
+		wrapped, err := ExportInitialKey(kek, pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1"), ksn, mode)
+		require.NoError(t, err)
+		key, err := encryption.UnwrapKey(kek, wrapped, mode)
+		require.NoError(t, err)
+		require.Equal(t, ik, key)
+
+		wrapped, err = ExportTransactionKey(kek, ik, ksn, mode)
+		require.NoError(t, err)
+		key, err = encryption.UnwrapKey(kek, wrapped, mode)
+		require.NoError(t, err)
+		require.Equal(t, currentKey, key)
+
+		wrapped, err = ExportWorkingKey(kek, currentKey, ksn, pkg.UsageMac, HMAC128, pkg.Response, mode)
+		require.NoError(t, err)
+		key, err = encryption.UnwrapKey(kek, wrapped, mode)
+		require.NoError(t, err)
+		expected, err := DeriveWorkingKey(currentKey, ksn, pkg.UsageMac, KeyHMAC128Type, pkg.ActionResponse, nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, key)
+	}
+
+	_, err := ExportWorkingKey(kek, currentKey, ksn, pkg.UsageData, AES256, pkg.Request, encryption.KeyWrapRFC3394)
+	require.EqualError(t, err, "mismatched key length and key type")
+}
+
+func TestEncryptPinWithRand(t *testing.T) {
+	item := InitialSequence[0]
+	transactionKey := pkg.HexDecode(item.CurrentKey)
+	ksn := pkg.HexDecode(item.Ksn)
+	pan := []byte("4111111111111111")
+
+	encrypted, err := EncryptPinWithRand(transactionKey, ksn, []byte("1234"), pan, AES128, pkg.NewSeededReader(7))
+	require.NoError(t, err)
+	require.Equal(t, "488414CE467A5CF1411AAD8FD4A5346A", strings.ToUpper(pkg.HexEncode(encrypted)))
+
+	again, err := EncryptPinWithRand(transactionKey, ksn, []byte("1234"), pan, AES128, pkg.NewSeededReader(7))
+	require.NoError(t, err)
+	require.Equal(t, encrypted, again)
+
+	other, err := EncryptPinWithRand(transactionKey, ksn, []byte("1234"), pan, AES128, pkg.NewSeededReader(8))
+	require.NoError(t, err)
+	require.NotEqual(t, encrypted, other)
+
+	panField, err := iso4PanField(pan)
+	require.NoError(t, err)
+	pinField, err := DecryptPinBlock(transactionKey, ksn, encrypted, panField, AES128)
+	require.NoError(t, err)
+	require.Equal(t, "441234AAAAAAAAAA", strings.ToUpper(pkg.HexEncode(pinField[:8])))
+
+	pin, err := DecryptPinV2(transactionKey, ksn, encrypted, pan, AES128)
+	require.NoError(t, err)
+	require.Equal(t, []byte("1234"), pin)
+
+	_, err = EncryptPinWithRand(transactionKey, ksn, []byte("1234"), pan, AES128, iotest.ErrReader(errors.New("no entropy")))
+	require.EqualError(t, err, "generating random field: no entropy")
+
+	_, err = EncryptPinWithRand(transactionKey, ksn, []byte("123"), pan, AES128, nil)
+	require.EqualError(t, err, "pin must be 4 to 12 digits")
+
+	_, err = EncryptPinWithRand(transactionKey, ksn, []byte("1234"), []byte("41111111111"), AES128, nil)
+	require.EqualError(t, err, "pan must be 12 to 19 digits")
+}
+
+func TestClearPin(t *testing.T) {
+	for field, expected := range map[string]string{
+		"441234AAAAAAAAAA": "1234",
+		"4C123456789012AA": "123456789012",
+	} {
+		pin, err := clearPin(pkg.HexDecode(field+"0102030405060708"), '4', "A")
+		require.NoError(t, err)
+		require.Equal(t, expected, string(pin))
+	}
+
+	for field, expected := range map[string]string{
+		"041234FFFFFFFFFF": "invalid pin block format",
+		"4312AAAAAAAAAAAA": "invalid pin length 3",
+		"44123BAAAAAAAAAA": "invalid pin digits",
+		"441234AAAAAAAAFA": "invalid fill of ISO-4 pin block",
+	} {
+		_, err := clearPin(pkg.HexDecode(field+"0102030405060708"), '4', "A")
+		require.EqualError(t, err, expected)
+	}
+
+	// encoder of key schedule and session is the one of EncryptPinWithRand
+	item := InitialSequence[0]
+	transactionKey, ksn := pkg.HexDecode(item.CurrentKey), pkg.HexDecode(item.Ksn)
+	ks, err := NewKeySchedule(transactionKey, ksn, AES128)
+	require.NoError(t, err)
+	block, err := EncryptPinWithRand(transactionKey, ksn, []byte("1234"), []byte("4111111111111111"), AES128, pkg.NewSeededReader(7))
+	require.NoError(t, err)
+	pin, err := ks.DecryptPin(block, []byte("4111111111111111"))
+	require.NoError(t, err)
+	require.Equal(t, "1234", string(pin))
+
+	_, err = ks.DecryptPin(block[:8], []byte("4111111111111111"))
+	require.EqualError(t, err, "pin block length must be 16 bytes")
+}
+
+func TestDerivationData(t *testing.T) {
+	bdk := pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1")
+	ksn := pkg.HexDecode("123456789012345600000001")
+
+	// derived key is derivation data encrypted with base key in ecb mode
+	derive := func(key, data []byte, keyLen int) []byte {
+		cipher, err := encryption.NewAesECB(key)
+		require.NoError(t, err)
+
+		var derived []byte
+		for i := 0; i < len(data); i += 16 {
+			block, err := cipher.Encrypt(data[i : i+16])
+			require.NoError(t, err)
+			derived = append(derived, block...)
+		}
+		return derived[:keyLen]
+	}
+
+	data, err := InitialKeyDerivationData(len(bdk), ksn)
+	require.NoError(t, err)
+	ik := derive(bdk, data, len(bdk))
+	require.Equal(t, "1273671EA26AC29AFA4D1084127652A1", strings.ToUpper(pkg.HexEncode(ik)))
+
+	for _, item := range InitialSequence {
+		steps, err := TransactionKeyDerivationData(len(ik), pkg.HexDecode(item.Ksn))
+		require.NoError(t, err)
+
+		tk := ik
+		for _, step := range steps {
+			tk = derive(tk, step, len(ik))
+		}
+		require.Equal(t, item.CurrentKey, strings.ToUpper(pkg.HexEncode(tk)))
+	}
+
+	tk := pkg.HexDecode(InitialSequence[0].CurrentKey)
+	for _, keyType := range []KeyType{AES128, HMAC128} {
+		data, err = WorkingKeyDerivationData(len(tk), ksn, pkg.UsageMac, keyType, pkg.Response)
+		require.NoError(t, err)
+
+		expected, err := DeriveWorkingKey(tk, ksn, pkg.UsageMac, keyType.String(), pkg.ActionResponse, nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, derive(tk, data, len(expected)))
+	}
+
+	// aes-192 keys are the leftmost 24 bytes of two blocks
+	data, err = InitialKeyDerivationData(24, ksn)
+	require.NoError(t, err)
+	require.Len(t, data, 32)
+
+	_, err = WorkingKeyDerivationData(len(tk), ksn, pkg.UsagePin, AES256, pkg.Request)
+	require.EqualError(t, err, "mismatched key length and key type")
+
+	_, err = WorkingKeyDerivationData(len(tk), ksn, "kek", AES128, pkg.Request)
+	require.EqualError(t, err, "invalid working key usage")
+
+	_, err = InitialKeyDerivationData(20, ksn)
+	require.Error(t, err)
+}
@@ -0,0 +1,730 @@
+package aes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des" //nolint:gosec
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/moov-io/dukpt/encryption"
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// KeyType is type of working key derived from transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 6.2.1, AES, HMAC, 2TDEA and 3TDEA working keys (algorithm 0000 and 0001)
+//   - TDES2 and TDES3 pin keys encrypt ISO 9564-1 format 0 pin blocks, mac keys generate TDES CMAC
+//     and data keys encrypt in TDES CBC mode
+type KeyType uint8
+
+const (
+	AES128 KeyType = iota + 1
+	AES192
+	AES256
+	HMAC128
+	HMAC192
+	HMAC256
+	TDES2
+	TDES3
+)
+
+var keyTypeNames = [...]string{
+	AES128:  KeyAES128Type,
+	AES192:  KeyAES192Type,
+	AES256:  KeyAES256Type,
+	HMAC128: KeyHMAC128Type,
+	HMAC192: KeyHMAC192Type,
+	HMAC256: KeyHMAC256Type,
+	TDES2:   KeyTDES2Type,
+	TDES3:   KeyTDES3Type,
+}
+
+// Parse key type from name ("AES128", "AES192", "AES256", "HMAC128", "HMAC192", "HMAC256", "TDES2", "TDES3")
+func ParseKeyType(name string) (KeyType, error) {
+	for keyType, keyTypeName := range keyTypeNames {
+		if keyTypeName != "" && keyTypeName == name {
+			return KeyType(keyType), nil
+		}
+	}
+	return 0, errors.New("unsupported key type")
+}
+
+func (k KeyType) String() string {
+	if int(k) < len(keyTypeNames) {
+		return keyTypeNames[k]
+	}
+	return ""
+}
+
+// KeyTypeOfLength returns AES working key type of the same length as a transaction key
+//
+// Params:
+//   - key length is 16, 24 or 32 bytes length of transaction key
+//
+// Return Params:
+//   - result is AES128, AES192 or AES256
+//   - err
+func KeyTypeOfLength(keyLen int) (KeyType, error) {
+	name, err := getDerivationKeyType(keyLen)
+	if err != nil {
+		return 0, errors.New("invalid transaction key length")
+	}
+	return ParseKeyType(name)
+}
+
+// HMAC returns hmac key type of the same length as aes key type, hmac and tdes key types are returned unchanged
+func (k KeyType) HMAC() KeyType {
+	switch k {
+	case AES128:
+		return HMAC128
+	case AES192:
+		return HMAC192
+	case AES256:
+		return HMAC256
+	}
+	return k
+}
+
+// tdes is true for 2TDEA and 3TDEA working keys
+func (k KeyType) tdes() bool {
+	return k == TDES2 || k == TDES3
+}
+
+// Validate returns error when key type is unknown
+func (k KeyType) Validate() error {
+	if k.String() == "" {
+		return errors.New("unsupported key type")
+	}
+	return nil
+}
+
+// legacyKeyType keeps unknown names invalid, so the length of the key is checked before its type
+func legacyKeyType(name string) KeyType {
+	keyType, _ := ParseKeyType(name)
+	return keyType
+}
+
+func legacyAction(action string) pkg.Action {
+	if action == pkg.ActionResponse {
+		return pkg.Response
+	}
+	return pkg.Request
+}
+
+// ecbCipher is ecb cipher of aes or tdes pin working key
+type ecbCipher interface {
+	Encrypt(plainText []byte) ([]byte, error)
+	Decrypt(cipherText []byte) ([]byte, error)
+}
+
+// newPinCipher returns ecb cipher of pin working key
+func newPinCipher(pinKey []byte, keyType KeyType) (ecbCipher, error) {
+	if !keyType.tdes() {
+		return encryption.NewAesECB(pinKey)
+	}
+
+	ecb, err := encryption.NewTripleDesECB(pinKey)
+	if err != nil {
+		return nil, err
+	}
+	return ecb, nil
+}
+
+// newWorkingCipher returns block cipher of mac or data working key
+func newWorkingCipher(workingKey []byte, keyType KeyType) (cipher.Block, error) {
+	if !keyType.tdes() {
+		return aes.NewCipher(workingKey)
+	}
+
+	ecb, err := encryption.NewTripleDesECB(workingKey)
+	if err != nil {
+		return nil, err
+	}
+	return ecb.GetBlock(), nil
+}
+
+// pinBlockLength is 16 bytes ISO-4 pin block of aes keys or 8 bytes ISO-0 pin block of tdes keys
+func pinBlockLength(keyType KeyType) int {
+	if keyType.tdes() {
+		return des.BlockSize
+	}
+	return aes.BlockSize
+}
+
+func pinKeyOf(currentKey, ksn []byte, keyType KeyType) (ecbCipher, error) {
+	if err := checkWorkingKeyLength(len(currentKey), keyType.String()); err != nil {
+		return nil, err
+	}
+
+	pinKey, err := generateDerivationKey(derivationParams{
+		KeyUsage:   usageForPinEncryption,
+		KeyType:    keyType.String(),
+		Ksn:        ksn,
+		CurrentKey: currentKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newPinCipher(pinKey, keyType)
+}
+
+func dataKeyOf(currentKey, ksn []byte, keyType KeyType, action pkg.Action) ([]byte, error) {
+	if err := checkWorkingKeyLength(len(currentKey), keyType.String()); err != nil {
+		return nil, err
+	}
+	if err := action.Validate(); err != nil {
+		return nil, err
+	}
+
+	params := derivationParams{
+		KeyUsage:   usageForDataEncrypt,
+		KeyType:    keyType.String(),
+		Ksn:        ksn,
+		CurrentKey: currentKey,
+	}
+	if action == pkg.Response {
+		params.KeyUsage = usageForDataDecrypt
+	}
+
+	return generateDerivationKey(params)
+}
+
+// Encrypt PIN block using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 9.4.2
+//   - Support only ISO 9564-1:2017 PIN block format 4 (format 0 of TDES2 and TDES3 key types)
+//
+// Params:
+//   - current key is transaction key
+//   - ksn is 12 bytes key serial number
+//   - pin is not formatted pin
+//   - pan is not formatted pan
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
+//
+// Return Params:
+//   - result is 16 bytes cipher text
+//   - err
+func EncryptPinV2(currentKey, ksn, pin, pan []byte, keyType KeyType) ([]byte, error) {
+	return EncryptPinWithRand(currentKey, ksn, pin, pan, keyType, nil)
+}
+
+// Encrypt PIN block using DUKPT transaction key and random field of random source
+//
+// NOTE:
+//   - Same as EncryptPinV2, 8 bytes random field of ISO 9564-1:2017 PIN block format 4 is read from random source
+//   - A seeded random source (see pkg.NewSeededReader) makes the cipher text reproducible in tests
+//
+// Params:
+//   - current key is transaction key
+//   - ksn is 12 bytes key serial number
+//   - pin is not formatted pin
+//   - pan is not formatted pan
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
+//   - random is random source (crypto/rand.Reader when nil)
+//
+// Return Params:
+//   - result is 16 bytes cipher text
+//   - err
+func EncryptPinWithRand(currentKey, ksn, pin, pan []byte, keyType KeyType, random io.Reader) ([]byte, error) {
+	if keyType.tdes() {
+		pinBlock, err := iso0PinBlock(pin, pan)
+		if err != nil {
+			return nil, err
+		}
+		defer clear(pinBlock)
+
+		return EncryptPinBlock(currentKey, ksn, pinBlock, nil, keyType)
+	}
+
+	pinField, err := iso4PinField(pin, random)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pinField)
+
+	panField, err := iso4PanField(pan)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncryptPinBlock(currentKey, ksn, pinField, panField, keyType)
+}
+
+// Decrypt PIN block using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 9.4.2
+//   - Support only ISO 9564-1:2017 PIN block format 4 (format 0 of TDES2 and TDES3 key types)
+//
+// Params:
+//   - current key is transaction key
+//   - ksn is 12 bytes key serial number
+//   - cipher text is encrypted pin block
+//   - pan is not formatted pan
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
+//
+// Return Params:
+//   - result is pin (plain text)
+//   - err
+func DecryptPinV2(currentKey, ksn, ciphertext, pan []byte, keyType KeyType) ([]byte, error) {
+	cipher, err := pinKeyOf(currentKey, ksn, keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptPinOf(cipher, ciphertext, pan, keyType)
+}
+
+// Encrypt clear formatted PIN block using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 9.4.2, ISO 9564-1:2017 format 4 encipherment
+//   - The pin field is encrypted, xored with pan field and encrypted again,
+//     when pan field is nil the pin block is encrypted once
+//   - No pin block formatting is applied
+//
+// Params:
+//   - current key is transaction key
+//   - ksn is 12 bytes key serial number
+//   - pin block is 16 bytes clear pin field (8 bytes ISO-0 pin block of tdes key types)
+//   - pan field is 16 bytes plain text pan field (see pin.ISO4PanField) or nil (always nil of tdes key types)
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
+//
+// Return Params:
+//   - result is 16 bytes cipher text
+//   - err
+func EncryptPinBlock(currentKey, ksn, pinBlock, panField []byte, keyType KeyType) ([]byte, error) {
+	if err := checkPinBlock(pinBlock, panField, keyType); err != nil {
+		return nil, err
+	}
+
+	cipher, err := pinKeyOf(currentKey, ksn, keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryptIso4(cipher, pinBlock, panField)
+}
+
+// Decrypt PIN block to clear formatted PIN block using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 9.4.2, ISO 9564-1:2017 format 4 decipherment
+//   - The cipher text is decrypted, xored with pan field and decrypted again,
+//     when pan field is nil the cipher text is decrypted once
+//   - The clear block is returned as is, see pin.DecodeBlock to read its fields
+//
+// Params:
+//   - current key is transaction key
+//   - ksn is 12 bytes key serial number
+//   - cipher text is 16 bytes encrypted pin block (8 bytes of tdes key types)
+//   - pan field is 16 bytes plain text pan field (see pin.ISO4PanField) or nil (always nil of tdes key types)
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
+//
+// Return Params:
+//   - result is 16 bytes clear pin field
+//   - err
+func DecryptPinBlock(currentKey, ksn, ciphertext, panField []byte, keyType KeyType) ([]byte, error) {
+	if err := checkPinBlock(ciphertext, panField, keyType); err != nil {
+		return nil, err
+	}
+
+	cipher, err := pinKeyOf(currentKey, ksn, keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptIso4(cipher, ciphertext, panField)
+}
+
+// encryptPinOf encodes pin block of key type (ISO-4 of aes keys, ISO-0 of tdes keys) and encrypts it
+func encryptPinOf(cipher ecbCipher, pin, pan []byte, keyType KeyType, random io.Reader) ([]byte, error) {
+	if keyType.tdes() {
+		return encryptIso0Pin(cipher, pin, pan)
+	}
+	return encryptIso4Pin(cipher, pin, pan, random)
+}
+
+// decryptPinOf decrypts pin block of key type (ISO-4 of aes keys, ISO-0 of tdes keys) and returns its pin
+func decryptPinOf(cipher ecbCipher, ciphertext, pan []byte, keyType KeyType) ([]byte, error) {
+	if keyType.tdes() {
+		return decryptIso0Pin(cipher, ciphertext, pan)
+	}
+	return decryptIso4Pin(cipher, ciphertext, pan)
+}
+
+// encryptIso4 encrypts pin field, xors it with pan field and encrypts it again (once when pan field is nil)
+func encryptIso4(cipher ecbCipher, pinField, panField []byte) ([]byte, error) {
+	block, err := cipher.Encrypt(pinField)
+	if err != nil || panField == nil {
+		return block, err
+	}
+
+	for i := range block {
+		block[i] ^= panField[i]
+	}
+
+	return cipher.Encrypt(block)
+}
+
+// decryptIso4 decrypts cipher text, xors it with pan field and decrypts it again (once when pan field is nil)
+func decryptIso4(cipher ecbCipher, ciphertext, panField []byte) ([]byte, error) {
+	block, err := cipher.Decrypt(ciphertext)
+	if err != nil || panField == nil {
+		return block, err
+	}
+
+	for i := range block {
+		block[i] ^= panField[i]
+	}
+
+	return cipher.Decrypt(block)
+}
+
+// encryptIso4Pin encodes pin and pan as ISO 9564-1:2017 format 4 fields and encrypts them
+func encryptIso4Pin(cipher ecbCipher, pin, pan []byte, random io.Reader) ([]byte, error) {
+	pinField, err := iso4PinField(pin, random)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pinField)
+
+	panField, err := iso4PanField(pan)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryptIso4(cipher, pinField, panField)
+}
+
+// decryptIso4Pin decrypts ISO 9564-1:2017 format 4 pin block and returns its pin
+func decryptIso4Pin(cipher ecbCipher, ciphertext, pan []byte) ([]byte, error) {
+	if err := checkPinBlock(ciphertext, nil, AES128); err != nil {
+		return nil, err
+	}
+
+	panField, err := iso4PanField(pan)
+	if err != nil {
+		return nil, err
+	}
+
+	pinField, err := decryptIso4(cipher, ciphertext, panField)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pinField)
+
+	return clearPin(pinField, '4', "A")
+}
+
+// encryptIso0Pin encodes pin and pan as ISO 9564-1 format 0 pin block and encrypts it with tdes pin key
+func encryptIso0Pin(cipher ecbCipher, pin, pan []byte) ([]byte, error) {
+	pinBlock, err := iso0PinBlock(pin, pan)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pinBlock)
+
+	return cipher.Encrypt(pinBlock)
+}
+
+// decryptIso0Pin decrypts ISO 9564-1 format 0 pin block of tdes pin key and returns its pin
+func decryptIso0Pin(cipher ecbCipher, ciphertext, pan []byte) ([]byte, error) {
+	if err := checkPinBlock(ciphertext, nil, TDES2); err != nil {
+		return nil, err
+	}
+
+	panField, err := iso0PanField(pan)
+	if err != nil {
+		return nil, err
+	}
+
+	pinBlock, err := cipher.Decrypt(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pinBlock)
+
+	for i := range pinBlock {
+		pinBlock[i] ^= panField[i]
+	}
+
+	return clearPin(pinBlock, '0', "F")
+}
+
+// iso4PinField is control field 4, pin length, pin, fill digits A and 8 bytes of random source
+func iso4PinField(pin []byte, random io.Reader) ([]byte, error) {
+	if len(pin) < 4 || len(pin) > 12 || !isDigits(pin) {
+		return nil, errors.New("pin must be 4 to 12 digits")
+	}
+
+	field := make([]byte, aes.BlockSize)
+	nibbles := fmt.Sprintf("4%X%s", len(pin), pin)
+	nibbles += strings.Repeat("A", aes.BlockSize-len(nibbles))
+	copy(field, pkg.HexDecode(nibbles))
+
+	if _, err := io.ReadFull(pkg.RandReader(random), field[aes.BlockSize/2:]); err != nil {
+		return nil, fmt.Errorf("generating random field: %w", err)
+	}
+	return field, nil
+}
+
+// clearPin returns pin of clear pin field of control field and fill digits
+func clearPin(field []byte, control byte, fill string) ([]byte, error) {
+	nibbles := strings.ToUpper(pkg.HexEncode(field[:des.BlockSize]))
+	if nibbles[0] != control {
+		return nil, errors.New("invalid pin block format")
+	}
+
+	pinLen := int(field[0] & 0x0F)
+	if pinLen < 4 || pinLen > 12 {
+		return nil, fmt.Errorf("invalid pin length %d", pinLen)
+	}
+
+	pin := nibbles[2 : 2+pinLen]
+	if !isDigits([]byte(pin)) {
+		return nil, errors.New("invalid pin digits")
+	}
+	if strings.Trim(nibbles[2+pinLen:], fill) != "" {
+		return nil, fmt.Errorf("invalid fill of ISO-%c pin block", control)
+	}
+
+	return []byte(pin), nil
+}
+
+// iso0PinBlock is ISO 9564-1 format 0 pin field (control field 0, pin length, pin, fill digits F) xored with pan field
+func iso0PinBlock(pin, pan []byte) ([]byte, error) {
+	if len(pin) < 4 || len(pin) > 12 || !isDigits(pin) {
+		return nil, errors.New("pin must be 4 to 12 digits")
+	}
+
+	panField, err := iso0PanField(pan)
+	if err != nil {
+		return nil, err
+	}
+
+	nibbles := fmt.Sprintf("0%X%s", len(pin), pin)
+	nibbles += strings.Repeat("F", 2*des.BlockSize-len(nibbles))
+	block := pkg.HexDecode(nibbles)
+	for i := range block {
+		block[i] ^= panField[i]
+	}
+	return block, nil
+}
+
+// iso0PanField is 4 zeros and rightmost 12 digits of pan excluding check digit
+func iso0PanField(pan []byte) ([]byte, error) {
+	if len(pan) < 13 || len(pan) > 19 || !isDigits(pan) {
+		return nil, errors.New("pan must be 13 to 19 digits")
+	}
+	return pkg.HexDecode("0000" + string(pan[len(pan)-13:len(pan)-1])), nil
+}
+
+// iso4PanField is pan length minus 12, pan and zero padding
+func iso4PanField(pan []byte) ([]byte, error) {
+	if len(pan) < 12 || len(pan) > 19 || !isDigits(pan) {
+		return nil, errors.New("pan must be 12 to 19 digits")
+	}
+
+	nibbles := fmt.Sprintf("%d%s", len(pan)-12, pan)
+	nibbles += strings.Repeat("0", 2*aes.BlockSize-len(nibbles))
+	return pkg.HexDecode(nibbles), nil
+}
+
+func isDigits(s []byte) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+func checkPinBlock(pinBlock, panField []byte, keyType KeyType) error {
+	if len(pinBlock) != pinBlockLength(keyType) {
+		return fmt.Errorf("pin block length must be %d bytes", pinBlockLength(keyType))
+	}
+	if panField != nil && keyType.tdes() {
+		return errors.New("pan field is only used by ISO-4 pin block")
+	}
+	if panField != nil && len(panField) != aes.BlockSize {
+		return fmt.Errorf("pan field length must be %d bytes", aes.BlockSize)
+	}
+	return nil
+}
+
+// Re-encrypt PIN block under the same or another DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 9.4.2
+//   - PIN block stays ISO 9564-1:2017 format 4 (the only format allowed with AES keys), it is re-encoded with fresh random fill
+//   - The clear PIN never leaves this function, only the new cipher text is returned
+//
+// Params:
+//   - current key is transaction key
+//   - ksn is 12 bytes key serial number
+//   - cipher text is encrypted pin block
+//   - pan is not formatted pan
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
+//   - output key is transaction key used to encrypt result (current key is used when output key is nil)
+//     key type of result follows output key length
+//   - output ksn is 12 bytes key serial number of output key (ksn is used when output ksn is nil)
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func ReencryptPinV2(currentKey, ksn, ciphertext, pan []byte, keyType KeyType, outputKey, outputKsn []byte) ([]byte, error) {
+	pin, err := DecryptPinV2(currentKey, ksn, ciphertext, pan, keyType)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pin)
+
+	outputKeyType := keyType
+	if outputKey == nil {
+		outputKey = currentKey
+	} else {
+		outputKeyType, err = KeyTypeOfLength(len(outputKey))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if outputKsn == nil {
+		outputKsn = ksn
+	}
+
+	return EncryptPinV2(outputKey, outputKsn, pin, pan, outputKeyType)
+}
+
+// Generate MAC using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 6.3.1, 6.3.4
+//   - CMAC needs AES key type, HMAC needs HMAC key type
+//
+// Params:
+//   - current key is transaction key
+//   - ksn is 12 bytes key serial number
+//   - plain text is transaction request data
+//   - key type is AES128, AES192, AES256, TDES2, TDES3 (CMAC) or HMAC128, HMAC192, HMAC256 (HMAC), not longer than current key
+//   - mac type is pkg.CMAC or pkg.HMAC
+//   - action is pkg.Request or pkg.Response
+//
+// Return Params:
+//   - result is 16 bytes cmac (8 bytes of tdes key types) or 32 bytes hmac
+//   - err
+func GenerateMacV2(currentKey, ksn, plainText []byte, keyType KeyType, macType pkg.MacType, action pkg.Action) ([]byte, error) {
+	if err := macType.Validate(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if macType == pkg.HMAC {
+		err = checkWorkingKeyLengthHmac(len(currentKey), keyType.String())
+	} else {
+		err = checkWorkingKeyLength(len(currentKey), keyType.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := action.Validate(); err != nil {
+		return nil, err
+	}
+
+	params := derivationParams{
+		KeyUsage:   usageForMessageGeneration,
+		KeyType:    keyType.String(),
+		Ksn:        ksn,
+		CurrentKey: currentKey,
+	}
+	if action == pkg.Response {
+		params.KeyUsage = usageForMessageVerification
+	}
+
+	macKey, err := generateDerivationKey(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if macType == pkg.HMAC {
+		mac := hmac.New(sha256.New, macKey)
+		mac.Write(plainText)
+		return mac.Sum(nil), nil
+	}
+
+	block, err := newWorkingCipher(macKey, keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendCmac(nil, block, plainText)
+}
+
+// Encrypt data using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 6.3.3, 6.5.4
+//   - Plain text is padded with zeros to aes block length [16]
+//
+// Params:
+//   - current key is transaction key
+//   - ksn is 12 bytes key serial number
+//   - iv is initial vector
+//   - plain text is transaction request data
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
+//   - action is pkg.Request or pkg.Response
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func EncryptDataV2(currentKey, ksn, iv, plainText []byte, keyType KeyType, action pkg.Action) ([]byte, error) {
+	dataKey, err := dataKeyOf(currentKey, ksn, keyType, action)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := newWorkingCipher(dataKey, keyType)
+	if err != nil {
+		return nil, fmt.Errorf("making cipher from datakey: %w", err)
+	}
+
+	return appendCBCEncrypted(nil, block, iv, plainText), nil
+}
+
+// Decrypt data using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-3:2017 6.3.3, 6.5.4
+//
+// Params:
+//   - current key is transaction key
+//   - ksn is 12 bytes key serial number
+//   - iv is initial vector
+//   - cipher text is encrypted data
+//   - key type is AES128, AES192, AES256 (not longer than current key), TDES2 or TDES3
+//   - action is pkg.Request or pkg.Response
+//
+// Return Params:
+//   - result is plain text (a multiple of aes block length [16])
+//   - err
+func DecryptDataV2(currentKey, ksn, iv, ciphertext []byte, keyType KeyType, action pkg.Action) ([]byte, error) {
+	dataKey, err := dataKeyOf(currentKey, ksn, keyType, action)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := newWorkingCipher(dataKey, keyType)
+	if err != nil {
+		return nil, fmt.Errorf("making cipher from datakey: %w", err)
+	}
+
+	return appendCBCDecrypted(nil, block, iv, ciphertext), nil
+}
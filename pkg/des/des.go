@@ -5,13 +5,10 @@ ANS X9.24-1:2009 (Retail Financial Services Symmetric Key Management)
 */
 
 import (
-	"crypto/cipher"
 	"fmt"
-	"strings"
 
 	"github.com/moov-io/dukpt/encryption"
 	"github.com/moov-io/dukpt/pkg"
-	"github.com/moov-io/pinblock/formats"
 )
 
 // Derive Initial Key (IK) from Base Derivative Key and Key Serial Number
@@ -66,53 +63,116 @@ func DerivationOfInitialKey(bdk, ksn []byte) ([]byte, error) {
 //   - result is 16 bytes transaction key
 //   - err
 func DeriveCurrentTransactionKey(ik, ksn []byte) ([]byte, error) {
-	keyBytes := make([]byte, keyLen)
-	copy(keyBytes, ik)
-	ksnBytes := make([]byte, keySerialLen)
-	serializedKsn := serializeKeySerialNumber(ksn)
-	copy(ksnBytes, serializedKsn)
+	return appendCurrentTransactionKey(make([]byte, 0, keyLen), ik, ksn, nil)
+}
 
-	removeTransactionCounter(ksnBytes)
+// Derive DUKPT transaction key (current transaction key) and report every derivation step to tracer
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.3
+//   - Steps are initial key, ksn register, shift register bits, intermediate keys and transaction key
+//
+// Params:
+//   - ik is 16 bytes initial key
+//   - ksn is 10 bytes key serial number
+//   - tracer observes derivation steps (keys are reported as secret steps)
+//
+// Return Params:
+//   - result is 16 bytes transaction key
+//   - err
+func DeriveCurrentTransactionKeyWithTracer(ik, ksn []byte, tracer pkg.Tracer) ([]byte, error) {
+	return appendCurrentTransactionKey(make([]byte, 0, keyLen), ik, ksn, tracer)
+}
+
+// Append DUKPT transaction key (current transaction key) derived from Initial Key and Key Serial Number to dst
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.3
+//   - The key and key serial number registers are kept on the stack, only the des key
+//     schedules of the non-reversible key generation are allocated
+//
+// Params:
+//   - dst is destination buffer, the transaction key is appended to it
+//   - ik is 16 bytes initial key
+//   - ksn is 10 bytes key serial number
+//
+// Return Params:
+//   - result is dst extended by 16 bytes transaction key
+//   - err
+func AppendCurrentTransactionKey(dst, ik, ksn []byte) ([]byte, error) {
+	return appendCurrentTransactionKey(dst, ik, ksn, nil)
+}
+
+func appendCurrentTransactionKey(dst, ik, ksn []byte, tracer pkg.Tracer) ([]byte, error) {
+	var keyReg [keyLen]byte
+	copy(keyReg[:], ik)
+
+	var serializedKsn, ksnReg [keySerialLen]byte
+	serializeKeySerialNumberTo(&serializedKsn, ksn)
+	ksnReg = serializedKsn
+
+	removeTransactionCounter(ksnReg[:])
+
+	pkg.TraceValue(tracer, "initial key", "", keyReg[:], true)
+	pkg.TraceValue(tracer, "ksn register", "transaction counter removed", ksnReg[:], false)
 
 	for shiftBit := tcBits; shiftBit > 0; shiftBit-- {
-		var shiftRegVal byte
-		shiftReg := make([]byte, 3)
+		var shiftReg [3]byte
 
 		shiftRegIdx := (shiftBit - 1) >> 3
-		shiftRegVal = 0x1 << ((shiftBit - 1) & 0x7)
-		shiftReg[shiftRegIdx] = shiftRegVal
-
-		var flag bool
-		for i := 0; i < 3; i++ {
-			_flag := (shiftReg[i] & serializedKsn[keySerialLen-1-i]) > 0
-			if i == 0 {
-				flag = !_flag
-			} else {
-				flag = flag && !_flag
-			}
-		}
+		shiftReg[shiftRegIdx] = 0x1 << ((shiftBit - 1) & 0x7)
 
-		if flag {
+		if shiftReg[shiftRegIdx]&serializedKsn[keySerialLen-1-shiftRegIdx] == 0 {
 			// Skip this shift bit
 			continue
 		}
 
 		// Set shift bit in KSN register
-		for i := 0; i < 3; i++ {
-			ksnBytes[keySerialLen-1-i] |= shiftReg[i]
-		}
+		ksnReg[keySerialLen-1-shiftRegIdx] |= shiftReg[shiftRegIdx]
 
-		if nextKey, err := makeNonReversibleKey(ksnBytes, keyBytes); err != nil {
+		if err := makeNonReversibleKey(ksnReg[:], &keyReg); err != nil {
 			return nil, err
-		} else {
-			copy(keyBytes, nextKey)
 		}
+
+		if tracer != nil {
+			detail := fmt.Sprintf("bit %d", shiftBit)
+			pkg.TraceValue(tracer, "shift register", detail, []byte{shiftReg[2], shiftReg[1], shiftReg[0]}, false)
+			pkg.TraceValue(tracer, "ksn register", detail, ksnReg[:], false)
+			pkg.TraceValue(tracer, "intermediate key", detail, keyReg[:], true)
+		}
+	}
+
+	pkg.TraceValue(tracer, "transaction key", "", keyReg[:], true)
+
+	return append(dst, keyReg[:]...), nil
+}
+
+// Derive working key (variant of transaction key) and report every derivation step to tracer
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key (table A-1, figure A-2)
+//   - Steps are variant mask, data variant (before it is encrypted with itself) and working key
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - usage is pin, mac or data
+//   - action is request or response action (pin key doesn't depend on action)
+//   - tracer observes derivation steps, nil tracer is allowed
+//
+// Return Params:
+//   - result is 16 bytes working key
+//   - err
+func DeriveWorkingKey(currentKey []byte, usage, action string, tracer pkg.Tracer) ([]byte, error) {
+	if len(currentKey) != keyLen {
+		return nil, fmt.Errorf("current key length must be %d bytes", keyLen)
 	}
 
-	transactionKey := make([]byte, keyLen)
-	copy(transactionKey, keyBytes)
+	key, err := workingKey(currentKey, usage, action, tracer)
+	if err != nil {
+		return nil, err
+	}
 
-	return transactionKey, nil
+	return key[:], nil
 }
 
 // Encrypt PIN block using DUKPT transaction key
@@ -120,6 +180,7 @@ func DeriveCurrentTransactionKey(ik, ksn []byte) ([]byte, error) {
 // NOTE:
 //   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
 //   - ANSI X9.24-1:2009 A.2 Processing Algorithms ("Request PIN Entry 2")
+//   - Wrapper of EncryptPinV2
 //
 // Params:
 //   - current key is 16 bytes transaction key
@@ -132,17 +193,12 @@ func DeriveCurrentTransactionKey(ik, ksn []byte) ([]byte, error) {
 //   - result is cipher text
 //   - err
 func EncryptPin(currentKey []byte, pin, pan string, format string) ([]byte, error) {
-	formatter, err := formats.NewFormatter(strings.ToUpper(format))
-	if err != nil {
-		return nil, err
-	}
-
-	blockstr, err := formatter.Encode(pin, pan)
+	pinFormat, err := ParsePinFormat(format)
 	if err != nil {
 		return nil, err
 	}
 
-	return encryptPinblock(currentKey, pkg.HexDecode(blockstr))
+	return EncryptPinV2(currentKey, []byte(pin), []byte(pan), pinFormat)
 }
 
 // Decrypt PIN block using DUKPT transaction key
@@ -150,6 +206,7 @@ func EncryptPin(currentKey []byte, pin, pan string, format string) ([]byte, erro
 // NOTE:
 //   - A.4.1 Variants of the Current Key
 //   - A.2 Processing Algorithms ("Request PIN Entry 2")
+//   - Wrapper of DecryptPinV2
 //
 // Params:
 //   - current key is 16 bytes transaction key
@@ -162,95 +219,103 @@ func EncryptPin(currentKey []byte, pin, pan string, format string) ([]byte, erro
 //   - result is pin string (plain text)
 //   - err
 func DecryptPin(currentKey, ciphertext []byte, pan string, format string) (string, error) {
-	formatter, err := formats.NewFormatter(strings.ToUpper(format))
-	if err != nil {
-		return "", err
-	}
-
-	pinBlock, err := decryptPinblock(currentKey, ciphertext)
+	pinFormat, err := ParsePinFormat(format)
 	if err != nil {
 		return "", err
 	}
 
-	pinstr, err := formatter.Decode(pkg.HexEncode(pinBlock), pan)
+	pin, err := DecryptPinV2(currentKey, ciphertext, []byte(pan), pinFormat)
 	if err != nil {
 		return "", err
 	}
 
-	return pinstr, nil
+	return string(pin), nil
 }
 
-// Generate MAC using DUKPT transaction key
+// Encrypt clear formatted PIN block using DUKPT transaction key
 //
 // NOTE:
 //   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
-//   - ANSI X9.24-1:2009 A.4 DUKPT Test Data Examples (CBC procedure described in ISO 16609 section C.4)
+//   - The block is encrypted as is, no pin block formatting is applied
 //
 // Params:
 //   - current key is 16 bytes transaction key
-//   - plain text is transaction request data
-//   - action is request or response action
+//   - pin block is 8 bytes clear formatted pin block
 //
 // Return Params:
-//   - result is generated mac (use the first 4 bytes of this result)
+//   - result is 8 bytes cipher text
 //   - err
-func GenerateMac(currentKey []byte, plainText, action string) ([]byte, error) {
-	dataKey := make([]byte, keyLen)
-	copy(dataKey, currentKey)
-
-	if action != pkg.ActionRequest && action != pkg.ActionResponse {
-		action = pkg.ActionRequest
-	}
-
-	// ANSI X9.24-1:2009 A.4.1, table A-1
-	if action == pkg.ActionRequest {
-		dataKey[6] ^= 0xFF
-		dataKey[14] ^= 0xFF
-	} else {
-		dataKey[4] ^= 0xFF
-		dataKey[12] ^= 0xFF
-	}
-
-	leftKey := dataKey[:desBlockLen]
-	rightKey := dataKey[desBlockLen:]
+func EncryptPinBlock(currentKey, pinBlock []byte) ([]byte, error) {
+	return encryptPinblock(currentKey, pinBlock)
+}
 
-	plainTextBytes := []byte(plainText)
-	repeatCnt := len(plainTextBytes) / desBlockLen
-	if len(plainTextBytes)%desBlockLen > 0 {
-		repeatCnt++
-	}
-	serializePlaintext := make([]byte, repeatCnt*desBlockLen)
-	copy(serializePlaintext, plainTextBytes)
-
-	var err error
-	initialVector := make([]byte, desBlockLen)
-	leftCipher, _ := encryption.NewDesECB(leftKey)
-	for partNum := 0; partNum < repeatCnt; partNum++ {
-		macPart := serializePlaintext[partNum*desBlockLen : (partNum+1)*desBlockLen]
-		for in := range initialVector {
-			initialVector[in] = initialVector[in] ^ macPart[in]
-		}
-		initialVector, err = leftCipher.Encrypt(initialVector)
-		if err != nil {
-			return nil, err
-		}
-	}
+// Decrypt PIN block to clear formatted PIN block using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
+//   - The clear block is returned as is, see pin.DecodeBlock to read its fields
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - cipher text is 8 bytes encrypted pin block
+//
+// Return Params:
+//   - result is 8 bytes clear formatted pin block
+//   - err
+func DecryptPinBlock(currentKey, ciphertext []byte) ([]byte, error) {
+	return decryptPinblock(currentKey, ciphertext)
+}
 
-	var ciphertext []byte
-	rightCipher, _ := encryption.NewDesECB(rightKey)
-	// Decrypt the result from step (e) with the right half of the MAC Encryption Key
-	ciphertext, err = rightCipher.Decrypt(initialVector)
+// Translate PIN block from one format to another using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
+//   - The clear PIN never leaves this function, only the new cipher text is returned
+//   - PIN block of AES DUKPT key is moved to another transaction key with aes.ReencryptPin
+//   - Wrapper of TranslatePinFormatV2
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - cipher text is encrypted pin block
+//   - pan is not formatted pan string
+//   - in format is pinblock format of cipher text
+//   - out format is pinblock format of result
+//     ("ISO-0", "ISO-1", "ISO-2", "ISO-3", "ISO-4", "ANSI", "ECI1", "ECI2", "ECI3", "ECI4", "VISA1", "VISA2", "VISA3", "VISA4")
+//   - output key is 16 bytes transaction key used to encrypt result (current key is used when output key is nil)
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func TranslatePinFormat(currentKey, ciphertext []byte, pan, inFormat, outFormat string, outputKey []byte) ([]byte, error) {
+	inPinFormat, err := ParsePinFormat(inFormat)
 	if err != nil {
 		return nil, err
 	}
-
-	// Encrypt the result from step (g) with the left half of the MAC Encryption Key
-	ciphertext, err = leftCipher.Encrypt(ciphertext)
+	outPinFormat, err := ParsePinFormat(outFormat)
 	if err != nil {
 		return nil, err
 	}
 
-	return ciphertext, nil
+	return TranslatePinFormatV2(currentKey, ciphertext, []byte(pan), inPinFormat, outPinFormat, outputKey)
+}
+
+// Generate MAC using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
+//   - ANSI X9.24-1:2009 A.4 DUKPT Test Data Examples (CBC procedure described in ISO 16609 section C.4)
+//   - Wrapper of GenerateMacV2, unknown action falls back to request
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - plain text is transaction request data
+//   - action is request or response action
+//
+// Return Params:
+//   - result is generated mac (use the first 4 bytes of this result)
+//   - err
+func GenerateMac(currentKey []byte, plainText, action string) ([]byte, error) {
+	return GenerateMacV2(currentKey, []byte(plainText), legacyAction(action))
 }
 
 // Encrypt Data using DUKPT transaction key
@@ -259,6 +324,7 @@ func GenerateMac(currentKey []byte, plainText, action string) ([]byte, error) {
 //   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
 //   - ANSI X9.24-1:2009 A.4.1, figure A-2
 //   - Encryption of the data should use T-DEA in CBC mode.
+//   - Wrapper of EncryptDataV2, unknown action falls back to request
 //
 // Params:
 //   - current key is 16 bytes transaction key
@@ -270,61 +336,7 @@ func GenerateMac(currentKey []byte, plainText, action string) ([]byte, error) {
 //   - result is encrypted data
 //   - err
 func EncryptData(currentKey, iv []byte, plainText, action string) ([]byte, error) {
-	dataKey := make([]byte, keyLen)
-	copy(dataKey, currentKey)
-
-	if action != pkg.ActionRequest && action != pkg.ActionResponse {
-		action = pkg.ActionRequest
-	}
-
-	// ANSI X9.24-1:2009 A.4.1, table A-1
-	if action == pkg.ActionRequest {
-		dataKey[5] ^= 0xFF
-		dataKey[13] ^= 0xFF
-	} else {
-		dataKey[3] ^= 0xFF
-		dataKey[11] ^= 0xFF
-	}
-
-	keyCipher, _ := encryption.NewTripleDesECB(dataKey)
-	leftKey, err := keyCipher.Encrypt(dataKey[:desBlockLen])
-	if err != nil {
-		return nil, err
-	}
-
-	rightKey, err := keyCipher.Encrypt(dataKey[desBlockLen:])
-	if err != nil {
-		return nil, err
-	}
-
-	newKey := append(leftKey, rightKey...)
-	dataCipher, _ := encryption.NewTripleDesECB(newKey)
-
-	plainTextBytes := []byte(plainText)
-	repeatCnt := len(plainTextBytes) / desBlockLen
-	if len(plainTextBytes)%desBlockLen > 0 {
-		repeatCnt++
-	}
-	serializePlaintext := make([]byte, repeatCnt*desBlockLen)
-	copy(serializePlaintext, plainTextBytes)
-
-	ciphertext := make([]byte, len(serializePlaintext))
-	// A.4.1 Variants of the Current Key
-	// 	Encryption of the data should use T-DEA in CBC mode.
-	if iv == nil {
-		// default null
-		iv = make([]byte, desBlockLen)
-	}
-	if len(iv) < desBlockLen {
-		iv = append(iv, make([]byte, desBlockLen-len(iv))...)
-	}
-
-	// codeql[go/weak-cryptographic-algorithm] TDEA/CBC required by ANSI X9.24-1 DUKPT
-	mode := cipher.NewCBCEncrypter(dataCipher.GetBlock(), iv)
-	// codeql[go/weak-cryptographic-algorithm] TDEA/CBC required by ANSI X9.24-1 DUKPT
-	mode.CryptBlocks(ciphertext, serializePlaintext)
-
-	return ciphertext, nil
+	return EncryptDataV2(currentKey, iv, []byte(plainText), legacyAction(action))
 }
 
 // Decrypt Data using DUKPT transaction key
@@ -333,6 +345,7 @@ func EncryptData(currentKey, iv []byte, plainText, action string) ([]byte, error
 //   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
 //   - ANSI X9.24-1:2009 A.4.1 figure A-2
 //   - Encryption of the data should use T-DEA in CBC mode.
+//   - Wrapper of DecryptDataV2, unknown action falls back to request
 //
 // Params:
 //   - current key is 16 bytes transaction key
@@ -344,59 +357,10 @@ func EncryptData(currentKey, iv []byte, plainText, action string) ([]byte, error
 //   - result is transaction request data ( must be a multiple of tdes block length [8])
 //   - err
 func DecryptData(currentKey, ciphertext, iv []byte, action string) (string, error) {
-	dataKey := make([]byte, keyLen)
-	copy(dataKey, currentKey)
-
-	if action != pkg.ActionRequest && action != pkg.ActionResponse {
-		action = pkg.ActionRequest
-	}
-
-	// ANSI X9.24-1:2009 A.4.1, table A-1
-	if action == pkg.ActionRequest {
-		dataKey[5] ^= 0xFF
-		dataKey[13] ^= 0xFF
-	} else {
-		dataKey[3] ^= 0xFF
-		dataKey[11] ^= 0xFF
-	}
-
-	keyCipher, _ := encryption.NewTripleDesECB(dataKey)
-	leftKey, err := keyCipher.Encrypt(dataKey[:desBlockLen])
-	if err != nil {
-		return "", err
-	}
-
-	rightKey, err := keyCipher.Encrypt(dataKey[desBlockLen:])
+	plainText, err := DecryptDataV2(currentKey, iv, ciphertext, legacyAction(action))
 	if err != nil {
 		return "", err
 	}
 
-	newKey := append(leftKey, rightKey...)
-	dataCipher, _ := encryption.NewTripleDesECB(newKey)
-
-	repeatCnt := len(ciphertext) / desBlockLen
-	if len(ciphertext)%desBlockLen > 0 {
-		repeatCnt++
-	}
-	serializePlaintext := make([]byte, repeatCnt*desBlockLen)
-	copy(serializePlaintext, ciphertext)
-
-	plaintext := make([]byte, len(serializePlaintext))
-	// A.4.1 Variants of the Current Key
-	// 	Encryption of the data should use T-DEA in CBC mode.
-	if iv == nil {
-		// default null
-		iv = make([]byte, desBlockLen)
-	}
-
-	if len(iv) > desBlockLen {
-		iv = iv[:desBlockLen]
-	}
-
-	// codeql[go/weak-cryptographic-algorithm] TDEA/CBC required by ANSI X9.24-1 DUKPT
-	mode := cipher.NewCBCDecrypter(dataCipher.GetBlock(), iv)
-	// codeql[go/weak-cryptographic-algorithm] TDEA/CBC required by ANSI X9.24-1 DUKPT
-	mode.CryptBlocks(plaintext, serializePlaintext)
-
-	return string(plaintext), nil
+	return string(plainText), nil
 }
@@ -0,0 +1,91 @@
+package des
+
+import (
+	"github.com/moov-io/dukpt/encryption"
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// Export initial key wrapped under key encryption key
+//
+// NOTE:
+//   - Initial key never leaves the function in clear
+//
+// Params:
+//   - kek is 16, 24 or 32 bytes AES key encryption key
+//   - bdk is base derivative key
+//   - ksn is 10 bytes key serial number
+//   - mode is encryption.KeyWrapRFC3394 or encryption.KeyWrapRFC5649
+//
+// Return Params:
+//   - result is wrapped initial key
+//   - err
+func ExportInitialKey(kek, bdk, ksn []byte, mode encryption.KeyWrapMode) ([]byte, error) {
+	ik, err := DerivationOfInitialKey(bdk, ksn)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.WrapKey(kek, ik, mode)
+}
+
+// Export current transaction key wrapped under key encryption key
+//
+// Params:
+//   - kek is 16, 24 or 32 bytes AES key encryption key
+//   - ik is initial key
+//   - ksn is 10 bytes key serial number
+//   - mode is encryption.KeyWrapRFC3394 or encryption.KeyWrapRFC5649
+//
+// Return Params:
+//   - result is wrapped transaction key
+//   - err
+func ExportTransactionKey(kek, ik, ksn []byte, mode encryption.KeyWrapMode) ([]byte, error) {
+	currentKey, err := DeriveCurrentTransactionKey(ik, ksn)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.WrapKey(kek, currentKey, mode)
+}
+
+// Export working key of transaction key wrapped under key encryption key
+//
+// Params:
+//   - kek is 16, 24 or 32 bytes AES key encryption key
+//   - current key is 16 bytes transaction key
+//   - usage is pkg.UsagePin, pkg.UsageMac or pkg.UsageData (owf data key)
+//   - action is pkg.Request or pkg.Response
+//   - mode is encryption.KeyWrapRFC3394 or encryption.KeyWrapRFC5649
+//
+// Return Params:
+//   - result is wrapped 16 bytes working key
+//   - err
+func ExportWorkingKey(kek, currentKey []byte, usage string, action pkg.Action, mode encryption.KeyWrapMode) ([]byte, error) {
+	if err := action.Validate(); err != nil {
+		return nil, err
+	}
+
+	key, err := DeriveWorkingKey(currentKey, usage, action.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.WrapKey(kek, key, mode)
+}
+
+// Export data key of transaction key wrapped under key encryption key
+//
+// Params:
+//   - kek is 16, 24 or 32 bytes AES key encryption key
+//   - current key is 16 bytes transaction key
+//   - action is pkg.Request or pkg.Response
+//   - data key mode is DataKeyOWF or DataKeyVariant
+//   - mode is encryption.KeyWrapRFC3394 or encryption.KeyWrapRFC5649
+//
+// Return Params:
+//   - result is wrapped 16 bytes data key
+//   - err
+func ExportDataKey(kek, currentKey []byte, action pkg.Action, dataKeyMode DataKeyMode, mode encryption.KeyWrapMode) ([]byte, error) {
+	key, err := DeriveDataKey(currentKey, action, dataKeyMode, nil)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.WrapKey(kek, key, mode)
+}
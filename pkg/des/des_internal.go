@@ -2,9 +2,12 @@ package des
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"crypto/des" //nolint:gosec
+	"errors"
 	"fmt"
-	"github.com/moov-io/dukpt/encryption"
+
+	"github.com/moov-io/dukpt/pkg"
 )
 
 const (
@@ -12,6 +15,7 @@ const (
 	keySerialLen = 10
 	tcBits       = 21
 	desBlockLen  = 8
+	minMacLen    = 4
 )
 
 func serializeKeySerialNumber(ksn []byte) []byte {
@@ -28,6 +32,19 @@ func serializeKeySerialNumber(ksn []byte) []byte {
 	return ksnBytes
 }
 
+// serializeKeySerialNumberTo is serializeKeySerialNumber writing into a caller-supplied register
+func serializeKeySerialNumberTo(dst *[keySerialLen]byte, ksn []byte) {
+	if len(ksn) < keySerialLen {
+		pad := keySerialLen - len(ksn)
+		for i := 0; i < pad; i++ {
+			dst[i] = 0xFF
+		}
+		copy(dst[pad:], ksn)
+		return
+	}
+	copy(dst[:], ksn)
+}
+
 // Extract transaction counter value from KSN. The transaction counter is the last 21 bits of the KSN.
 func removeTransactionCounter(ksn []byte) {
 	if len(ksn) != keySerialLen {
@@ -56,100 +73,343 @@ func serializeKeyWithHexadecimal(key []byte) {
 }
 
 // Non-reversible Key Generation Process
-func makeNonReversibleKey(ksnBytes, keyBytes []byte) ([]byte, error) {
-	if len(keyBytes) < keyLen {
-		return nil, fmt.Errorf("key register length must be at least %d bytes", keyLen)
-	}
+//
+// The key register is replaced with the generated key, no intermediate slices are allocated.
+func makeNonReversibleKey(ksnBytes []byte, keyReg *[keyLen]byte) error {
 	if len(ksnBytes) < des.BlockSize {
-		return nil, fmt.Errorf("ksn length must be at least %d bytes", des.BlockSize)
+		return fmt.Errorf("ksn length must be at least %d bytes", des.BlockSize)
 	}
 
-	var err error
-	cryptoReg1 := make([]byte, des.BlockSize)
-	cryptoReg2 := make([]byte, des.BlockSize)
+	var cryptoReg1, cryptoReg2 [des.BlockSize]byte
 
 	// The 64 right-most bits of the Key Serial Number Register is transferred into Crypto Register-1
-	copy(cryptoReg1, ksnBytes[len(ksnBytes)-des.BlockSize:])
-
-	// Split the 16-byte key register so index math stays in bounds for static analysis.
-	leftKey := keyBytes[:des.BlockSize]
-	rightKey := keyBytes[des.BlockSize:keyLen]
+	copy(cryptoReg1[:], ksnBytes[len(ksnBytes)-des.BlockSize:])
 
 	// 1) Crypto Register-1 XORed with the right half of the Key Register goes to Crypto Register-2
 	for index := range cryptoReg2 {
-		cryptoReg2[index] = cryptoReg1[index] ^ rightKey[index]
+		cryptoReg2[index] = cryptoReg1[index] ^ keyReg[des.BlockSize+index]
 	}
 
 	// 2) Crypto Register-2 DEA-encrypted using, as the key, the left half of the Key Register goes to Crypto Register-2
-	cipher1, _ := encryption.NewDesECB(leftKey)
-	cryptoReg2, err = cipher1.Encrypt(cryptoReg2)
+	// codeql[go/weak-cryptographic-algorithm] DES required by ANSI X9.24 DUKPT
+	cipher1, err := des.NewCipher(keyReg[:des.BlockSize]) //nolint:gosec
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("creating cipher: %w", err)
 	}
+	cipher1.Encrypt(cryptoReg2[:], cryptoReg2[:])
 
 	// 3) Crypto Register-2 XORed with the right half of the Key Register goes to Crypto Register-2
 	for index := range cryptoReg2 {
-		cryptoReg2[index] = cryptoReg2[index] ^ rightKey[index]
+		cryptoReg2[index] ^= keyReg[des.BlockSize+index]
 	}
 
 	// See https://github.com/Abirdcfly/dupword/issues/26 for a fix to needing nolint
 	//nolint:dupword
 	// 4) XOR the Key Register with hexadecimal C0C0 C0C0 0000 0000 C0C0 C0C0 0000 0000
-	serializeKeyWithHexadecimal(keyBytes)
-	// Re-slice after the key register XOR (left half may have changed).
-	leftKey = keyBytes[:des.BlockSize]
-	rightKey = keyBytes[des.BlockSize:keyLen]
+	serializeKeyWithHexadecimal(keyReg[:])
 
 	// 5) Crypto Register-1 XORed with the right half of the Key Register goes to Crypto Register-1
 	for index := range cryptoReg1 {
-		cryptoReg1[index] = cryptoReg1[index] ^ rightKey[index]
+		cryptoReg1[index] ^= keyReg[des.BlockSize+index]
 	}
 
 	// 6) Crypto Register-1 DEA-encrypted using, as the key, the left half of the Key Register goes to Crypto Register-1
-	cipher2, _ := encryption.NewDesECB(leftKey)
-	cryptoReg1, err = cipher2.Encrypt(cryptoReg1)
+	// codeql[go/weak-cryptographic-algorithm] DES required by ANSI X9.24 DUKPT
+	cipher2, err := des.NewCipher(keyReg[:des.BlockSize]) //nolint:gosec
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("creating cipher: %w", err)
 	}
+	cipher2.Encrypt(cryptoReg1[:], cryptoReg1[:])
 
 	// 7) Crypto Register-1 XORed with the right half of the Key Register goes to Crypto Register-1
 	for index := range cryptoReg1 {
-		cryptoReg1[index] = cryptoReg1[index] ^ rightKey[index]
+		cryptoReg1[index] ^= keyReg[des.BlockSize+index]
 	}
 
-	return append(cryptoReg1, cryptoReg2...), nil
+	copy(keyReg[:des.BlockSize], cryptoReg1[:])
+	copy(keyReg[des.BlockSize:], cryptoReg2[:])
+
+	return nil
 }
 
-func encryptPinblock(currentKey, pinblock []byte) ([]byte, error) {
+// newTripleDesCipher creates two-key TDES cipher without copying the key into a new slice
+func newTripleDesCipher(key *[keyLen]byte) (cipher.Block, error) {
+	var tripleDESKey [keyLen + des.BlockSize]byte
+	copy(tripleDESKey[:], key[:])
+	copy(tripleDESKey[keyLen:], key[:des.BlockSize])
+
+	// codeql[go/weak-cryptographic-algorithm] DES/3DES required by ANSI X9.24 DUKPT
+	block, err := des.NewTripleDESCipher(tripleDESKey[:]) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	return block, nil
+}
+
+func normalizeAction(action string) string {
+	if action != pkg.ActionRequest && action != pkg.ActionResponse {
+		return pkg.ActionRequest
+	}
+	return action
+}
+
+// Variant mask of working key
+//
+//	ANSI X9.24-1:2009 A.4.1, table A-1
+func variantMask(usage, action string) ([keyLen]byte, error) {
+	var mask [keyLen]byte
+
+	request := normalizeAction(action) == pkg.ActionRequest
+	switch {
+	case usage == pkg.UsagePin:
+		mask[7], mask[15] = 0xFF, 0xFF
+	case usage == pkg.UsageMac && request:
+		mask[6], mask[14] = 0xFF, 0xFF
+	case usage == pkg.UsageMac:
+		mask[4], mask[12] = 0xFF, 0xFF
+	case usage == pkg.UsageData && request:
+		mask[5], mask[13] = 0xFF, 0xFF
+	case usage == pkg.UsageData:
+		mask[3], mask[11] = 0xFF, 0xFF
+	default:
+		return mask, errors.New("invalid working key usage")
+	}
+
+	return mask, nil
+}
+
+// Working key (variant of transaction key) of usage and action
+func workingKey(currentKey []byte, usage, action string, tracer pkg.Tracer) ([keyLen]byte, error) {
+	return workingKeyOfMode(currentKey, usage, action, DataKeyOWF, tracer)
+}
+
+// Working key of usage and action, data variant is encrypted with itself only in owf mode
+func workingKeyOfMode(currentKey []byte, usage, action string, mode DataKeyMode, tracer pkg.Tracer) ([keyLen]byte, error) {
+	var key [keyLen]byte
+	copy(key[:], currentKey)
+
+	mask, err := variantMask(usage, action)
+	if err != nil {
+		return key, err
+	}
+	if tracer != nil {
+		pkg.TraceValue(tracer, "variant mask", usage+" "+normalizeAction(action), mask[:], false)
+	}
+
+	for i := range key {
+		key[i] ^= mask[i]
+	}
+
+	if usage == pkg.UsageData && mode == DataKeyOWF {
+		pkg.TraceValue(tracer, "data variant", "", key[:], true)
+
+		if err := encryptWithItself(&key); err != nil {
+			return key, err
+		}
+	}
+
+	pkg.TraceValue(tracer, "working key", usage, key[:], true)
+
+	return key, nil
+}
+
+// ANSI X9.24-1:2009 A.4.1, figure A-2 (data variant encrypted with itself)
+//
+// The cipher works on a copy, so the caller's key register stays on the stack.
+func encryptWithItself(key *[keyLen]byte) error {
+	keyCipher, err := newTripleDesCipher(key)
+	if err != nil {
+		return err
+	}
+
+	encrypted := *key
+	keyCipher.Encrypt(encrypted[:desBlockLen], encrypted[:desBlockLen])
+	keyCipher.Encrypt(encrypted[desBlockLen:], encrypted[desBlockLen:])
+	*key = encrypted
+
+	return nil
+}
+
+// PIN encryption cipher of transaction key
+func pinCipher(currentKey []byte) (cipher.Block, error) {
 	if len(currentKey) != keyLen {
 		return nil, fmt.Errorf("current key length must be %d bytes", keyLen)
 	}
 
-	pinKey := make([]byte, keyLen)
-	copy(pinKey, currentKey)
+	pinKey, err := workingKey(currentKey, pkg.UsagePin, pkg.ActionRequest, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTripleDesCipher(&pinKey)
+}
+
+// Left and right single DES ciphers of MAC variant of transaction key
+func macCiphers(currentKey []byte, action string) (cipher.Block, cipher.Block, error) {
+	macKey, err := workingKey(currentKey, pkg.UsageMac, action, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return singleDesCiphers(&macKey)
+}
+
+// Left and right single DES ciphers of MAC working key
+func singleDesCiphers(macKey *[keyLen]byte) (cipher.Block, cipher.Block, error) {
+	// codeql[go/weak-cryptographic-algorithm] DES required by ANSI X9.24 DUKPT
+	left, err := des.NewCipher(macKey[:desBlockLen]) //nolint:gosec
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	// codeql[go/weak-cryptographic-algorithm] DES required by ANSI X9.24 DUKPT
+	right, err := des.NewCipher(macKey[desBlockLen:]) //nolint:gosec
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	return left, right, nil
+}
 
-	// ANSI X9.24-1:2009 A.4.1, table A-1
-	pinKey[7] ^= 0xFF
-	pinKey[15] ^= 0xFF
+// Data encryption cipher of transaction key
+func dataCipher(currentKey []byte, action string) (cipher.Block, error) {
+	return dataCipherOfMode(currentKey, action, DataKeyOWF)
+}
 
-	cipher, _ := encryption.NewTripleDesECB(pinKey)
+func dataCipherOfMode(currentKey []byte, action string, mode DataKeyMode) (cipher.Block, error) {
+	dataKey, err := workingKeyOfMode(currentKey, pkg.UsageData, action, mode, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	return cipher.Encrypt(pinblock)
+	return newTripleDesCipher(&dataKey)
+}
+
+func encryptPinblock(currentKey, pinblock []byte) ([]byte, error) {
+	block, err := pinCipher(currentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendEncryptedBlock(nil, block, pinblock)
 }
 
 func decryptPinblock(currentKey, ciphertext []byte) ([]byte, error) {
-	if len(currentKey) != keyLen {
-		return nil, fmt.Errorf("current key length must be %d bytes", keyLen)
+	block, err := pinCipher(currentKey)
+	if err != nil {
+		return nil, err
 	}
 
-	pinKey := make([]byte, keyLen)
-	copy(pinKey, currentKey)
+	return appendDecryptedBlock(nil, block, ciphertext)
+}
 
-	// ANSI X9.24-1:2009 A.4.1, table A-1
-	pinKey[7] ^= 0xFF
-	pinKey[15] ^= 0xFF
+func appendEncryptedBlock(dst []byte, block cipher.Block, plainText []byte) ([]byte, error) {
+	if len(plainText) != des.BlockSize {
+		return nil, fmt.Errorf("plain text length must be %d bytes", des.BlockSize)
+	}
 
-	cipher, _ := encryption.NewTripleDesECB(pinKey)
+	dst, out := grow(dst, des.BlockSize)
+	// codeql[go/weak-cryptographic-algorithm] DES/3DES required by ANSI X9.24 DUKPT
+	block.Encrypt(out, plainText)
+	return dst, nil
+}
+
+func appendDecryptedBlock(dst []byte, block cipher.Block, cipherText []byte) ([]byte, error) {
+	if len(cipherText) != des.BlockSize {
+		return nil, fmt.Errorf("cipher text length must be %d bytes", des.BlockSize)
+	}
+
+	dst, out := grow(dst, des.BlockSize)
+	// codeql[go/weak-cryptographic-algorithm] DES/3DES required by ANSI X9.24 DUKPT
+	block.Decrypt(out, cipherText)
+	return dst, nil
+}
+
+// ANSI X9.24-1:2009 A.4 DUKPT Test Data Examples (CBC procedure described in ISO 16609 section C.4)
+func appendMac(dst []byte, left, right cipher.Block, plainText []byte) []byte {
+	var mac [desBlockLen]byte
+
+	repeatCnt := (len(plainText) + desBlockLen - 1) / desBlockLen
+	for partNum := 0; partNum < repeatCnt; partNum++ {
+		macPart := plainText[partNum*desBlockLen:]
+		if len(macPart) > desBlockLen {
+			macPart = macPart[:desBlockLen]
+		}
+		// the last part is padded with zeros, so XOR with zero is skipped
+		for in := range macPart {
+			mac[in] ^= macPart[in]
+		}
+		left.Encrypt(mac[:], mac[:])
+	}
+
+	// Decrypt the result from step (e) with the right half of the MAC Encryption Key
+	right.Decrypt(mac[:], mac[:])
+
+	// Encrypt the result from step (g) with the left half of the MAC Encryption Key
+	left.Encrypt(mac[:], mac[:])
+
+	return append(dst, mac[:]...)
+}
+
+// Encryption of the data should use T-DEA in CBC mode (A.4.1 Variants of the Current Key)
+func appendCBCEncrypted(dst []byte, block cipher.Block, iv, plainText []byte) []byte {
+	// default null, shorter iv is padded with zeros and longer iv is truncated
+	var ivBlock [desBlockLen]byte
+	copy(ivBlock[:], iv)
 
-	return cipher.Decrypt(ciphertext)
+	size := (len(plainText) + desBlockLen - 1) / desBlockLen * desBlockLen
+	dst, out := grow(dst, size)
+	copy(out, plainText)
+	for i := len(plainText); i < size; i++ {
+		out[i] = 0
+	}
+
+	// CBC chaining is done in place with the block cipher, so no mode or iv copy is allocated
+	chain := ivBlock[:]
+	for offset := 0; offset < size; offset += desBlockLen {
+		part := out[offset : offset+desBlockLen]
+		for i := range part {
+			part[i] ^= chain[i]
+		}
+		block.Encrypt(part, part)
+		chain = part
+	}
+	return dst
+}
+
+func appendCBCDecrypted(dst []byte, block cipher.Block, iv, cipherText []byte) []byte {
+	// default null, shorter iv is padded with zeros and longer iv is truncated
+	var ivBlock [desBlockLen]byte
+	copy(ivBlock[:], iv)
+
+	size := (len(cipherText) + desBlockLen - 1) / desBlockLen * desBlockLen
+	dst, out := grow(dst, size)
+	copy(out, cipherText)
+	for i := len(cipherText); i < size; i++ {
+		out[i] = 0
+	}
+
+	var next [desBlockLen]byte
+	for offset := 0; offset < size; offset += desBlockLen {
+		part := out[offset : offset+desBlockLen]
+		copy(next[:], part)
+		block.Decrypt(part, part)
+		for i := range part {
+			part[i] ^= ivBlock[i]
+		}
+		ivBlock = next
+	}
+	return dst
+}
+
+// grow extends dst by n bytes and returns extended slice and its last n bytes
+func grow(dst []byte, n int) ([]byte, []byte) {
+	total := len(dst) + n
+	if cap(dst) < total {
+		extended := make([]byte, total)
+		copy(extended, dst)
+		dst = extended
+	} else {
+		dst = dst[:total]
+	}
+	return dst, dst[total-n:]
 }
@@ -0,0 +1,133 @@
+package des
+
+import (
+	"crypto/cipher"
+
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// KeySchedule holds expanded ciphers of all variants of one DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
+//   - Key schedules are built once, so a host processing several operations under
+//     the same transaction key does not expand DES keys again for each of them
+//   - Append methods write into caller-supplied buffers and are safe for concurrent use
+type KeySchedule struct {
+	pin       cipher.Block
+	macLeft   [2]cipher.Block
+	macRight  [2]cipher.Block
+	dataBlock [2]cipher.Block
+}
+
+// Create key schedule of DUKPT transaction key
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//
+// Return Params:
+//   - result is key schedule of pin, mac and data variants (request and response)
+//   - err
+func NewKeySchedule(currentKey []byte) (*KeySchedule, error) {
+	ks := &KeySchedule{}
+
+	var err error
+	if ks.pin, err = pinCipher(currentKey); err != nil {
+		return nil, err
+	}
+
+	for i, action := range []string{pkg.ActionRequest, pkg.ActionResponse} {
+		if ks.macLeft[i], ks.macRight[i], err = macCiphers(currentKey, action); err != nil {
+			return nil, err
+		}
+		if ks.dataBlock[i], err = dataCipher(currentKey, action); err != nil {
+			return nil, err
+		}
+	}
+
+	return ks, nil
+}
+
+func actionIndex(action pkg.Action) int {
+	if action == pkg.Response {
+		return 1
+	}
+	return 0
+}
+
+// Append encrypted PIN block to dst
+//
+// Params:
+//   - dst is destination buffer
+//   - pinblock is 8 bytes formatted pin block
+//
+// Return Params:
+//   - result is dst extended by 8 bytes cipher text
+//   - err
+func (ks *KeySchedule) AppendEncryptedPinblock(dst, pinblock []byte) ([]byte, error) {
+	return appendEncryptedBlock(dst, ks.pin, pinblock)
+}
+
+// Append decrypted PIN block to dst
+//
+// Params:
+//   - dst is destination buffer
+//   - cipher text is 8 bytes encrypted pin block
+//
+// Return Params:
+//   - result is dst extended by 8 bytes formatted pin block
+//   - err
+func (ks *KeySchedule) AppendDecryptedPinblock(dst, ciphertext []byte) ([]byte, error) {
+	return appendDecryptedBlock(dst, ks.pin, ciphertext)
+}
+
+// Append MAC of plain text to dst
+//
+// NOTE:
+//   - Same result as GenerateMac
+//
+// Params:
+//   - dst is destination buffer
+//   - plain text is transaction request data
+//   - action is pkg.Request or pkg.Response
+//
+// Return Params:
+//   - result is dst extended by 8 bytes mac (use the first 4 bytes of the mac)
+func (ks *KeySchedule) AppendMac(dst, plainText []byte, action pkg.Action) []byte {
+	i := actionIndex(action)
+	return appendMac(dst, ks.macLeft[i], ks.macRight[i], plainText)
+}
+
+// Append encrypted data to dst
+//
+// NOTE:
+//   - Same result as EncryptData, plain text is padded with zeros to tdes block length [8]
+//
+// Params:
+//   - dst is destination buffer
+//   - iv is initial vector
+//   - plain text is transaction request data
+//   - action is pkg.Request or pkg.Response
+//
+// Return Params:
+//   - result is dst extended by cipher text
+func (ks *KeySchedule) AppendEncryptedData(dst, iv, plainText []byte, action pkg.Action) []byte {
+	return appendCBCEncrypted(dst, ks.dataBlock[actionIndex(action)], iv, plainText)
+}
+
+// Append decrypted data to dst
+//
+// NOTE:
+//   - Same result as DecryptData
+//
+// Params:
+//   - dst is destination buffer
+//   - iv is initial vector
+//   - cipher text is encrypted text
+//   - action is pkg.Request or pkg.Response
+//
+// Return Params:
+//   - result is dst extended by plain text
+func (ks *KeySchedule) AppendDecryptedData(dst, iv, ciphertext []byte, action pkg.Action) []byte {
+	return appendCBCDecrypted(dst, ks.dataBlock[actionIndex(action)], iv, ciphertext)
+}
@@ -0,0 +1,97 @@
+package des
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/pinblock/formats"
+)
+
+// pinFormatter encodes and decodes clear pin blocks of one format
+//
+// NOTE:
+//   - Fill digits of ISO-1 and ISO-3 are read from random source (crypto/rand.Reader when nil)
+//   - Random fill of ECI4 and VISA4 is made by pinblock formats, so they don't accept a random source
+//   - Other formats have no random fill and are encoded by pinblock formats
+type pinFormatter struct {
+	format    PinFormat
+	random    io.Reader
+	formatter formats.Formatter
+}
+
+// newPinFormatter returns formatter of format, random is nil or source of fill digits
+func newPinFormatter(format PinFormat, random io.Reader) (*pinFormatter, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	if random != nil && (format == ECI4 || format == VISA4) {
+		return nil, fmt.Errorf("%s pin block format doesn't support random source", format)
+	}
+
+	formatter, err := formats.NewFormatter(format.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &pinFormatter{format: format, random: random, formatter: formatter}, nil
+}
+
+// encode returns clear pin block
+func (f *pinFormatter) encode(pin, pan []byte) ([]byte, error) {
+	if f.format != ISO1 && f.format != ISO3 {
+		blockstr, err := f.formatter.Encode(string(pin), string(pan))
+		if err != nil {
+			return nil, err
+		}
+		return pkg.HexDecode(blockstr), nil
+	}
+
+	if len(pin) < 4 || len(pin) > 12 || !isDigits(pin) {
+		return nil, errors.New("pin must be 4 to 12 digits")
+	}
+
+	control, minFill := 1, byte(0x0)
+	if f.format == ISO3 {
+		control, minFill = 3, 0xA
+	}
+
+	fill, err := pkg.RandomNibbles(f.random, 2*desBlockLen-2-len(pin), minFill)
+	if err != nil {
+		return nil, fmt.Errorf("generating fill digits: %w", err)
+	}
+
+	block := pkg.HexDecode(fmt.Sprintf("%d%X%s%s", control, len(pin), pin, fill))
+	if f.format == ISO1 {
+		return block, nil
+	}
+
+	// pan field is rightmost 12 digits excluding check digit
+	if len(pan) < 13 || !isDigits(pan) {
+		return nil, errors.New("pan must be at least 13 digits")
+	}
+	panField := pkg.HexDecode("0000" + string(pan[len(pan)-13:len(pan)-1]))
+	for i := range block {
+		block[i] ^= panField[i]
+	}
+	return block, nil
+}
+
+// decode returns pin of clear pin block
+func (f *pinFormatter) decode(pinBlock, pan []byte) ([]byte, error) {
+	pin, err := f.formatter.Decode(pkg.HexEncode(pinBlock), string(pan))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(pin), nil
+}
+
+func isDigits(s []byte) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
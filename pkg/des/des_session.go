@@ -0,0 +1,266 @@
+package des
+
+import (
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// Session bundles key serial number and working keys of one DUKPT transaction
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
+//   - Working keys are derived once when the session is created and wiped on Close (see pkg.KeyHolder)
+//   - Methods have the same signature as methods of aes.Session and are safe for concurrent use
+type Session struct {
+	keys      *pkg.KeyHolder
+	formatter *pinFormatter
+}
+
+// Create session from Initial Key (IK) and Key Serial Number
+//
+// Params:
+//   - ik is 16 bytes initial key
+//   - ksn is 10 bytes key serial number
+//   - format is pinblock format
+//     ("ISO-0", "ISO-1", "ISO-2", "ISO-3", "ISO-4", "ANSI", "ECI1", "ECI2", "ECI3", "ECI4", "VISA1", "VISA2", "VISA3", "VISA4")
+//
+// Return Params:
+//   - result is session of transaction
+//   - err
+func NewSession(ik, ksn []byte, format string) (*Session, error) {
+	pinFormat, err := ParsePinFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	formatter, err := newPinFormatter(pinFormat, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyBuf [keyLen]byte
+	currentKey, err := AppendCurrentTransactionKey(keyBuf[:0], ik, ksn)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(keyBuf[:])
+
+	var keys pkg.SessionKeys
+	derive := func(usage, action string) ([]byte, error) {
+		key, err := workingKey(currentKey, usage, action, nil)
+		defer clear(key[:])
+		return append([]byte(nil), key[:]...), err
+	}
+
+	if keys.Pin, err = derive(pkg.UsagePin, pkg.ActionRequest); err != nil {
+		return nil, err
+	}
+	for i, action := range []string{pkg.ActionRequest, pkg.ActionResponse} {
+		if keys.Mac[i], err = derive(pkg.UsageMac, action); err != nil {
+			keys.Wipe()
+			return nil, err
+		}
+		if keys.Data[i], err = derive(pkg.UsageData, action); err != nil {
+			keys.Wipe()
+			return nil, err
+		}
+	}
+
+	return &Session{keys: pkg.NewKeyHolder(ksn, keys), formatter: formatter}, nil
+}
+
+// Create session from Base Derivative Key and Key Serial Number
+//
+// Params:
+//   - bdk is 16 bytes base derivative key
+//   - ksn is 10 bytes key serial number
+//   - format is pinblock format
+//
+// Return Params:
+//   - result is session of transaction
+//   - err
+func NewSessionFromBDK(bdk, ksn []byte, format string) (*Session, error) {
+	ik, err := DerivationOfInitialKey(bdk, ksn)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(ik)
+
+	return NewSession(ik, ksn, format)
+}
+
+// KSN returns copy of key serial number of session
+func (s *Session) KSN() []byte {
+	return s.keys.KSN()
+}
+
+// Encrypt PIN using PIN working key
+//
+// Params:
+//   - pin is not formatted pin string
+//   - pan is not formatted pan string
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func (s *Session) EncryptPin(pin, pan string) ([]byte, error) {
+	pinBlock, err := s.formatter.encode([]byte(pin), []byte(pan))
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pinBlock)
+
+	var result []byte
+	err = s.keys.Use(func(keys *pkg.SessionKeys) error {
+		block, err := newTripleDesCipher((*[keyLen]byte)(keys.Pin))
+		if err != nil {
+			return err
+		}
+
+		result, err = appendEncryptedBlock(nil, block, pinBlock)
+		return err
+	})
+
+	return result, err
+}
+
+// Decrypt PIN using PIN working key
+//
+// Params:
+//   - cipher text is encrypted pin block
+//   - pan is not formatted pan string
+//
+// Return Params:
+//   - result is pin string (plain text)
+//   - err
+func (s *Session) DecryptPin(ciphertext []byte, pan string) (string, error) {
+	var pinBlock []byte
+	err := s.keys.Use(func(keys *pkg.SessionKeys) error {
+		block, err := newTripleDesCipher((*[keyLen]byte)(keys.Pin))
+		if err != nil {
+			return err
+		}
+
+		pinBlock, err = appendDecryptedBlock(nil, block, ciphertext)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	defer clear(pinBlock)
+
+	pin, err := s.formatter.decode(pinBlock, []byte(pan))
+	if err != nil {
+		return "", err
+	}
+	return string(pin), nil
+}
+
+// Generate MAC using MAC working key
+//
+// NOTE:
+//   - Same result as GenerateMac
+//
+// Params:
+//   - plain text is transaction request data
+//   - action is request or response action
+//
+// Return Params:
+//   - result is 8 bytes mac (use the first 4 bytes of the mac)
+//   - err
+func (s *Session) MAC(plainText []byte, action string) ([]byte, error) {
+	var result []byte
+	err := s.keys.Use(func(keys *pkg.SessionKeys) (err error) {
+		result, err = mac8(keys, plainText, action)
+		return err
+	})
+
+	return result, err
+}
+
+// Verify MAC using MAC working key
+//
+// NOTE:
+//   - Truncated mac is compared with the leftmost bytes of the generated mac in constant time
+//
+// Params:
+//   - plain text is transaction request data
+//   - mac is 4 to 8 bytes received mac
+//   - action is request or response action
+//
+// Return Params:
+//   - result is true when mac matches
+//   - err
+func (s *Session) VerifyMAC(plainText, mac []byte, action string) (bool, error) {
+	return s.keys.VerifyMAC(mac, desBlockLen, func(keys *pkg.SessionKeys) ([]byte, error) {
+		return mac8(keys, plainText, action)
+	})
+}
+
+func mac8(keys *pkg.SessionKeys, plainText []byte, action string) ([]byte, error) {
+	left, right, err := singleDesCiphers((*[keyLen]byte)(keys.Mac[actionIndex(legacyAction(action))]))
+	if err != nil {
+		return nil, err
+	}
+
+	return appendMac(make([]byte, 0, desBlockLen), left, right, plainText), nil
+}
+
+// Encrypt data using data working key
+//
+// NOTE:
+//   - Same result as EncryptData, plain text is padded with zeros to tdes block length [8]
+//
+// Params:
+//   - iv is initial vector
+//   - plain text is transaction request data
+//   - action is request or response action
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func (s *Session) EncryptData(iv, plainText []byte, action string) ([]byte, error) {
+	var result []byte
+	err := s.keys.Use(func(keys *pkg.SessionKeys) error {
+		block, err := newTripleDesCipher((*[keyLen]byte)(keys.Data[actionIndex(legacyAction(action))]))
+		if err != nil {
+			return err
+		}
+
+		result = appendCBCEncrypted(nil, block, iv, plainText)
+		return nil
+	})
+
+	return result, err
+}
+
+// Decrypt data using data working key
+//
+// NOTE:
+//   - Same result as DecryptData
+//
+// Params:
+//   - iv is initial vector
+//   - cipher text is encrypted data
+//   - action is request or response action
+//
+// Return Params:
+//   - result is plain text
+//   - err
+func (s *Session) DecryptData(iv, ciphertext []byte, action string) ([]byte, error) {
+	var result []byte
+	err := s.keys.Use(func(keys *pkg.SessionKeys) error {
+		block, err := newTripleDesCipher((*[keyLen]byte)(keys.Data[actionIndex(legacyAction(action))]))
+		if err != nil {
+			return err
+		}
+
+		result = appendCBCDecrypted(nil, block, iv, ciphertext)
+		return nil
+	})
+
+	return result, err
+}
+
+// Close wipes working keys, any later call of the session returns an error
+func (s *Session) Close() error {
+	return s.keys.Close()
+}
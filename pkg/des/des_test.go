@@ -1,10 +1,14 @@
 package des
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+	"testing/iotest"
 
+	"github.com/moov-io/dukpt/encryption"
 	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/testvectors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -18,53 +22,23 @@ type SequenceItem struct {
 	ResponseMac []byte
 }
 
-// A.4.2 Initial Sequence
-var InitialSequence = []SequenceItem{
-	{
-		Ksn:         pkg.HexDecode("FFFF9876543210E00001"),
-		CurrentKey:  pkg.HexDecode("042666B49184CFA368DE9628D0397BC9"),
-		PinEnc:      pkg.HexDecode("1B9C1845EB993A7A"),
-		DataReqEnc:  pkg.HexDecode("FC0D53B7EA1FDA9EE68AAF2E70D9B9506229BE2AA993F04F"),
-		DataResEnc:  pkg.HexDecode("1FCC89AF66222F27B903898BB2BC8589CDBFDE5EC6AFCC25"),
-		RequestMac:  pkg.HexDecode("9CCC78173FC4FB64"),
-		ResponseMac: pkg.HexDecode("20364223C1FF00FA"),
-	},
-	{
-		Ksn:         pkg.HexDecode("FFFF9876543210E00002"),
-		CurrentKey:  pkg.HexDecode("C46551CEF9FD24B0AA9AD834130D3BC7"),
-		PinEnc:      pkg.HexDecode("10A01C8D02C69107"),
-		DataReqEnc:  pkg.HexDecode("A2B4E70F846E63D68775B7215EB4563DFD3037244C61CC13"),
-		DataResEnc:  pkg.HexDecode("5B692A6B1FDD5E25B0DEFAFDE1672E402F8011360CFF3508"),
-		RequestMac:  pkg.HexDecode("F608A9BCA6FFC311"),
-		ResponseMac: pkg.HexDecode("D1FCA6BEF05D24D2"),
-	},
-	{
-		Ksn:         pkg.HexDecode("FFFF9876543210E00003"),
-		CurrentKey:  pkg.HexDecode("0DF3D9422ACA56E547676D07AD6BADFA"),
-		PinEnc:      pkg.HexDecode("18DC07B94797B466"),
-		DataReqEnc:  pkg.HexDecode("BD751E65F10E75B6C1D5B1D283496A36C2DE21D993C387A7"),
-		DataResEnc:  pkg.HexDecode("345992D4163E4926C927BFD8ABD5D76F087A9CE81D5A27B8"),
-		RequestMac:  pkg.HexDecode("20B59A4FEAC937E3"),
-		ResponseMac: pkg.HexDecode("BAD4CC9CC2AE326C"),
-	},
-	{
-		Ksn:         pkg.HexDecode("FFFF9876543210E00004"),
-		CurrentKey:  pkg.HexDecode("279C0F6AEED0BE652B2C733E1383AE91"),
-		PinEnc:      pkg.HexDecode("0BC79509D5645DF7"),
-		DataReqEnc:  pkg.HexDecode("1118F50947441BBDA3C8C70220021A12EC31CC473F7215F4"),
-		DataResEnc:  pkg.HexDecode("418C7413576C0D1819E785D3807AF32334231FDEC23414DB"),
-		RequestMac:  pkg.HexDecode("C7BFA6CC44161828"),
-		ResponseMac: pkg.HexDecode("1EB08AEECE6FF0C2"),
-	},
-	{
-		Ksn:         pkg.HexDecode("FFFF9876543210E00005"),
-		CurrentKey:  pkg.HexDecode("5F8DC6D2C845C125508DDC048093B83F"),
-		PinEnc:      pkg.HexDecode("5BC0AF22AD87B327"),
-		DataReqEnc:  pkg.HexDecode("9FD7BD1EC28845ACA93367A9DA9317BD555C6B33AE22D365"),
-		DataResEnc:  pkg.HexDecode("7D4C109E49E83355A556AE949EED359F4404E7A2F0167C00"),
-		RequestMac:  pkg.HexDecode("0202B96339022058"),
-		ResponseMac: pkg.HexDecode("5CBE3E81D1D2A0FB"),
-	},
+// A.4.2 Initial Sequence, shared with downstream conformance tests by package testvectors
+var InitialSequence = sequenceOf(testvectors.X9241InitialSequence)
+
+func sequenceOf(suite testvectors.DesSuite) []SequenceItem {
+	items := make([]SequenceItem, len(suite.Vectors))
+	for i, v := range suite.Vectors {
+		items[i] = SequenceItem{
+			Ksn:         v.KSN,
+			CurrentKey:  v.TransactionKey,
+			PinEnc:      v.PinBlock,
+			DataReqEnc:  v.DataRequest,
+			DataResEnc:  v.DataResponse,
+			RequestMac:  v.MacRequest,
+			ResponseMac: v.MacResponse,
+		}
+	}
+	return items
 }
 
 func TestDerivationOfInitialKey(t *testing.T) {
@@ -134,3 +108,456 @@ func TestDeriveCurrentTransactionKey(t *testing.T) {
 		})
 	}
 }
+
+func TestTranslatePinFormat(t *testing.T) {
+	bdk := pkg.HexDecode("0123456789ABCDEFFEDCBA9876543210")
+	pin := "1234"
+	pan := "4012345678909"
+
+	first := InitialSequence[0]
+	second := InitialSequence[1]
+
+	ik, err := DerivationOfInitialKey(bdk, second.Ksn)
+	require.NoError(t, err)
+	outputKey, err := DeriveCurrentTransactionKey(ik, second.Ksn)
+	require.NoError(t, err)
+
+	t.Run("ISO-0 to ISO-3 under same key", func(t *testing.T) {
+		translated, err := TranslatePinFormat(first.CurrentKey, first.PinEnc, pan, "ISO-0", "ISO-3", nil)
+		require.NoError(t, err)
+		require.Len(t, translated, 8)
+
+		decrypted, err := DecryptPin(first.CurrentKey, translated, pan, "ISO-3")
+		require.NoError(t, err)
+		require.Equal(t, pin, decrypted)
+	})
+
+	t.Run("ISO-0 to ISO-0 under output key", func(t *testing.T) {
+		translated, err := TranslatePinFormat(first.CurrentKey, first.PinEnc, pan, "ISO-0", "ISO-0", outputKey)
+		require.NoError(t, err)
+		require.Equal(t, second.PinEnc, translated)
+	})
+
+	t.Run("ISO-1 to ISO-0", func(t *testing.T) {
+		iso1, err := EncryptPin(first.CurrentKey, pin, pan, "ISO-1")
+		require.NoError(t, err)
+
+		translated, err := TranslatePinFormat(first.CurrentKey, iso1, pan, "ISO-1", "ISO-0", nil)
+		require.NoError(t, err)
+		require.Equal(t, first.PinEnc, translated)
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		_, err := TranslatePinFormat(first.CurrentKey, first.PinEnc, pan, "ISO-0", "ISO-9", nil)
+		require.Error(t, err)
+	})
+}
+
+func TestKeySchedule(t *testing.T) {
+	bdk := pkg.HexDecode("0123456789ABCDEFFEDCBA9876543210")
+	pinblock := pkg.HexDecode("041274EDCBA9876F")
+	data := []byte("4012345678909D987")
+
+	for index, item := range InitialSequence {
+		t.Run(fmt.Sprintf("Sequence #%d KSN: %s", index+1, pkg.HexEncode(item.Ksn)), func(t *testing.T) {
+			ik, err := DerivationOfInitialKey(bdk, item.Ksn)
+			require.NoError(t, err)
+
+			prefix := []byte{0xAA}
+			ck, err := AppendCurrentTransactionKey(prefix, ik, item.Ksn)
+			require.NoError(t, err)
+			require.Equal(t, append([]byte{0xAA}, item.CurrentKey...), ck)
+			ck = ck[1:]
+
+			ks, err := NewKeySchedule(ck)
+			require.NoError(t, err)
+
+			encrypted, err := ks.AppendEncryptedPinblock(nil, pinblock)
+			require.NoError(t, err)
+			require.Equal(t, item.PinEnc, encrypted)
+
+			decrypted, err := ks.AppendDecryptedPinblock(nil, encrypted)
+			require.NoError(t, err)
+			require.Equal(t, pinblock, decrypted)
+
+			require.Equal(t, item.RequestMac, ks.AppendMac(nil, data, pkg.Request))
+			require.Equal(t, item.ResponseMac, ks.AppendMac(nil, data, pkg.Response))
+
+			encReqData := ks.AppendEncryptedData(nil, nil, data, pkg.Request)
+			require.Equal(t, item.DataReqEnc, encReqData)
+			require.Equal(t, data, ks.AppendDecryptedData(nil, nil, encReqData, pkg.Request)[:len(data)])
+
+			encResData := ks.AppendEncryptedData(nil, nil, data, pkg.Response)
+			require.Equal(t, item.DataResEnc, encResData)
+			require.Equal(t, data, ks.AppendDecryptedData(nil, nil, encResData, pkg.Response)[:len(data)])
+		})
+	}
+
+	_, err := NewKeySchedule(pkg.HexDecode("0123456789ABCDEF"))
+	require.EqualError(t, err, "current key length must be 16 bytes")
+}
+
+func TestSession(t *testing.T) {
+	bdk := pkg.HexDecode("0123456789ABCDEFFEDCBA9876543210")
+	data := []byte("4012345678909D987")
+
+	for index, item := range InitialSequence {
+		t.Run(fmt.Sprintf("Sequence #%d KSN: %s", index+1, pkg.HexEncode(item.Ksn)), func(t *testing.T) {
+			s, err := NewSessionFromBDK(bdk, item.Ksn, "ANSI")
+			require.NoError(t, err)
+			require.Equal(t, item.Ksn, s.KSN())
+
+			encrypted, err := s.EncryptPin("1234", "4012345678909")
+			require.NoError(t, err)
+			require.Equal(t, item.PinEnc, encrypted)
+
+			pin, err := s.DecryptPin(encrypted, "4012345678909")
+			require.NoError(t, err)
+			require.Equal(t, "1234", pin)
+
+			mac, err := s.MAC(data, pkg.ActionRequest)
+			require.NoError(t, err)
+			require.Equal(t, item.RequestMac, mac)
+
+			mac, err = s.MAC(data, pkg.ActionResponse)
+			require.NoError(t, err)
+			require.Equal(t, item.ResponseMac, mac)
+
+			verified, err := s.VerifyMAC(data, item.ResponseMac[:4], pkg.ActionResponse)
+			require.NoError(t, err)
+			require.True(t, verified)
+
+			verified, err = s.VerifyMAC(data, item.RequestMac, pkg.ActionResponse)
+			require.NoError(t, err)
+			require.False(t, verified)
+
+			encData, err := s.EncryptData(nil, data, pkg.ActionRequest)
+			require.NoError(t, err)
+			require.Equal(t, item.DataReqEnc, encData)
+
+			decData, err := s.DecryptData(nil, encData, pkg.ActionRequest)
+			require.NoError(t, err)
+			require.Equal(t, data, decData[:len(data)])
+
+			encData, err = s.EncryptData(nil, data, pkg.ActionResponse)
+			require.NoError(t, err)
+			require.Equal(t, item.DataResEnc, encData)
+
+			var keys pkg.SessionKeys
+			require.NoError(t, s.keys.Use(func(k *pkg.SessionKeys) error {
+				keys = *k
+				return nil
+			}))
+			require.NoError(t, s.Close())
+			require.Equal(t, make([]byte, keyLen), keys.Pin)
+			require.Equal(t, [2][]byte{make([]byte, keyLen), make([]byte, keyLen)}, keys.Mac)
+			require.Equal(t, [2][]byte{make([]byte, keyLen), make([]byte, keyLen)}, keys.Data)
+
+			_, err = s.EncryptPin("1234", "4012345678909")
+			require.EqualError(t, err, "session is closed")
+			_, err = s.MAC(data, pkg.ActionRequest)
+			require.EqualError(t, err, "session is closed")
+		})
+	}
+
+	s, err := NewSessionFromBDK(bdk, InitialSequence[0].Ksn, "ISO-0")
+	require.NoError(t, err)
+	_, err = s.VerifyMAC(data, []byte{0x01, 0x02}, pkg.ActionRequest)
+	require.EqualError(t, err, "mac length must be between 4 and 8 bytes")
+
+	_, err = NewSessionFromBDK(bdk, InitialSequence[0].Ksn, "ISO-9")
+	require.Error(t, err)
+}
+
+func TestV2(t *testing.T) {
+	item := InitialSequence[0]
+	data := []byte("4012345678909D987")
+
+	encrypted, err := EncryptPinV2(item.CurrentKey, []byte("1234"), []byte("4012345678909"), ISO0)
+	require.NoError(t, err)
+	require.Equal(t, item.PinEnc, encrypted)
+
+	pin, err := DecryptPinV2(item.CurrentKey, encrypted, []byte("4012345678909"), ISO0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("1234"), pin)
+
+	mac, err := GenerateMacV2(item.CurrentKey, data, pkg.Response)
+	require.NoError(t, err)
+	require.Equal(t, item.ResponseMac, mac)
+
+	encData, err := EncryptDataV2(item.CurrentKey, nil, data, pkg.Request)
+	require.NoError(t, err)
+	require.Equal(t, item.DataReqEnc, encData)
+
+	decData, err := DecryptDataV2(item.CurrentKey, nil, encData, pkg.Request)
+	require.NoError(t, err)
+	require.Equal(t, data, decData[:len(data)])
+
+	format, err := ParsePinFormat("iso-3")
+	require.NoError(t, err)
+	require.Equal(t, ISO3, format)
+	require.Equal(t, "ISO-3", format.String())
+
+	_, err = ParsePinFormat("ISO-9")
+	require.EqualError(t, err, `invalid pin block format "ISO-9"`)
+
+	_, err = EncryptPinV2(item.CurrentKey, []byte("1234"), []byte("4012345678909"), PinFormat(0))
+	require.EqualError(t, err, "invalid pin block format 0")
+
+	_, err = GenerateMacV2(item.CurrentKey, data, pkg.Action(9))
+	require.EqualError(t, err, "invalid action")
+
+	_, err = EncryptDataV2(item.CurrentKey[:8], nil, data, pkg.Request)
+	require.EqualError(t, err, "current key length must be 16 bytes")
+
+	// v1 keeps falling back to request on unknown action
+	mac, err = GenerateMac(item.CurrentKey, string(data), "unknown")
+	require.NoError(t, err)
+	require.Equal(t, item.RequestMac, mac)
+}
+
+func TestDataKeyMode(t *testing.T) {
+	item := InitialSequence[0]
+	data := []byte("4012345678909D987")
+	// data variant key is 042666B4917BCFA368DE9628D0C67BC9 (cross checked with openssl des-ede-cbc)
+	variantEnc := pkg.HexDecode("E93B3B0570ECBCDF94453F22D7046D0F05169055540ABE86")
+
+	encrypted, err := EncryptDataWithMode(item.CurrentKey, nil, data, pkg.Request, DataKeyOWF)
+	require.NoError(t, err)
+	require.Equal(t, item.DataReqEnc, encrypted)
+
+	encrypted, err = EncryptDataWithMode(item.CurrentKey, nil, data, pkg.Request, DataKeyVariant)
+	require.NoError(t, err)
+	require.Equal(t, variantEnc, encrypted)
+
+	decrypted, err := DecryptDataWithMode(item.CurrentKey, nil, encrypted, pkg.Request, DataKeyVariant)
+	require.NoError(t, err)
+	require.Equal(t, data, decrypted[:len(data)])
+
+	key, err := DeriveDataKey(item.CurrentKey, pkg.Request, DataKeyVariant, nil)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("042666B4917BCFA368DE9628D0C67BC9"), key)
+
+	mode, err := ParseDataKeyMode("")
+	require.NoError(t, err)
+	require.Equal(t, DataKeyOWF, mode)
+
+	mode, err = ParseDataKeyMode("Variant")
+	require.NoError(t, err)
+	require.Equal(t, DataKeyVariant, mode)
+	require.Equal(t, "variant", mode.String())
+
+	_, err = ParseDataKeyMode("xor")
+	require.EqualError(t, err, `invalid data key mode "xor"`)
+
+	_, err = EncryptDataWithMode(item.CurrentKey, nil, data, pkg.Request, DataKeyMode(7))
+	require.EqualError(t, err, "invalid data key mode 7")
+}
+
+func BenchmarkDeriveCurrentTransactionKey(b *testing.B) {
+	ik := pkg.HexDecode("6AC292FAA1315B4D858AB3A3D7D5933A")
+	ksn := pkg.HexDecode("FFFF9876543210E00005")
+
+	b.Run("allocating", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := DeriveCurrentTransactionKey(ik, ksn); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("append", func(b *testing.B) {
+		b.ReportAllocs()
+		dst := make([]byte, 0, keyLen)
+		for i := 0; i < b.N; i++ {
+			if _, err := AppendCurrentTransactionKey(dst[:0], ik, ksn); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkTranslatePinblock(b *testing.B) {
+	inKey := pkg.HexDecode("042666B49184CFA368DE9628D0397BC9")
+	outKey := pkg.HexDecode("C46551CEF9FD24B0AA9AD834130D3BC7")
+	ciphertext := pkg.HexDecode("1B9C1845EB993A7A")
+
+	b.Run("one-shot", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := TranslatePinFormat(inKey, ciphertext, "4012345678909", "ISO-0", "ISO-0", outKey); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("key-schedule", func(b *testing.B) {
+		in, err := NewKeySchedule(inKey)
+		require.NoError(b, err)
+		out, err := NewKeySchedule(outKey)
+		require.NoError(b, err)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		buf := make([]byte, 0, 2*desBlockLen)
+		for i := 0; i < b.N; i++ {
+			clear, err := in.AppendDecryptedPinblock(buf[:0], ciphertext)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err = out.AppendEncryptedPinblock(clear, clear); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkEncryptData(b *testing.B) {
+	ck := pkg.HexDecode("042666B49184CFA368DE9628D0397BC9")
+	data := "4012345678909D987"
+
+	b.Run("one-shot", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := EncryptData(ck, nil, data, pkg.ActionRequest); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("key-schedule", func(b *testing.B) {
+		ks, err := NewKeySchedule(ck)
+		require.NoError(b, err)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		buf := make([]byte, 0, 3*desBlockLen)
+		for i := 0; i < b.N; i++ {
+			buf = ks.AppendEncryptedData(buf[:0], nil, []byte(data), pkg.Request)
+		}
+	})
+}
+
+func TestDeriveCurrentTransactionKeyWithTracer(t *testing.T) {
+	ik := pkg.HexDecode("6AC292FAA1315B4D858AB3A3D7D5933A")
+	item := InitialSequence[2]
+
+	var steps []pkg.TraceStep
+	tracer := pkg.TracerFunc(func(step pkg.TraceStep) {
+		steps = append(steps, step)
+	})
+
+	ck, err := DeriveCurrentTransactionKeyWithTracer(ik, item.Ksn, tracer)
+	require.NoError(t, err)
+	require.Equal(t, item.CurrentKey, ck)
+
+	// counter 3 sets two shift bits, each one reports shift register, ksn register and intermediate key
+	require.Len(t, steps, 2+2*3+1)
+	require.Equal(t, "initial key", steps[0].Name)
+	require.True(t, steps[0].Secret)
+	require.Equal(t, pkg.HexDecode("9876543210E00000"), steps[1].Value[2:])
+	require.Equal(t, "shift register", steps[2].Name)
+	require.Equal(t, "bit 2", steps[2].Detail)
+	require.Equal(t, []byte{0x00, 0x00, 0x02}, steps[2].Value)
+	require.Equal(t, "bit 1", steps[5].Detail)
+	require.Equal(t, "intermediate key", steps[7].Name)
+	require.Equal(t, item.CurrentKey, steps[7].Value)
+	require.Equal(t, "transaction key", steps[8].Name)
+	require.Equal(t, item.CurrentKey, steps[8].Value)
+
+	steps = nil
+	pinKey, err := DeriveWorkingKey(ck, pkg.UsagePin, pkg.ActionRequest, tracer)
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+	require.Equal(t, "variant mask", steps[0].Name)
+	require.Equal(t, pkg.HexDecode("00000000000000FF00000000000000FF"), steps[0].Value)
+	require.Equal(t, pinKey, steps[1].Value)
+
+	steps = nil
+	dataKey, err := DeriveWorkingKey(ck, pkg.UsageData, pkg.ActionResponse, tracer)
+	require.NoError(t, err)
+	require.Len(t, steps, 3)
+	require.Equal(t, pkg.HexDecode("000000FF00000000000000FF00000000"), steps[0].Value)
+	require.Equal(t, "data variant", steps[1].Name)
+	require.Equal(t, dataKey, steps[2].Value)
+
+	_, err = DeriveWorkingKey(ck, "kek", pkg.ActionRequest, nil)
+	require.EqualError(t, err, "invalid working key usage")
+}
+
+func TestExportKey(t *testing.T) {
+	kek := pkg.HexDecode("000102030405060708090A0B0C0D0E0F")
+	ksn := pkg.HexDecode("FFFF9876543210E00001")
+	ik := pkg.HexDecode("6AC292FAA1315B4D858AB3A3D7D5933A")
+	currentKey := pkg.HexDecode("042666B49184CFA368DE9628D0397BC9")
+
+	for _, mode := range []encryption.KeyWrapMode{encryption.KeyWrapRFC3394, encryption.KeyWrapRFC5649} {
+		wrapped, err := ExportInitialKey(kek, pkg.HexDecode("0123456789ABCDEFFEDCBA9876543210"), ksn, mode)
+		require.NoError(t, err)
+		key, err := encryption.UnwrapKey(kek, wrapped, mode)
+		require.NoError(t, err)
+		require.Equal(t, ik, key)
+
+		wrapped, err = ExportTransactionKey(kek, ik, ksn, mode)
+		require.NoError(t, err)
+		key, err = encryption.UnwrapKey(kek, wrapped, mode)
+		require.NoError(t, err)
+		require.Equal(t, currentKey, key)
+
+		wrapped, err = ExportWorkingKey(kek, currentKey, pkg.UsagePin, pkg.Request, mode)
+		require.NoError(t, err)
+		key, err = encryption.UnwrapKey(kek, wrapped, mode)
+		require.NoError(t, err)
+		expected, err := DeriveWorkingKey(currentKey, pkg.UsagePin, pkg.ActionRequest, nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, key)
+
+		wrapped, err = ExportDataKey(kek, currentKey, pkg.Request, DataKeyVariant, mode)
+		require.NoError(t, err)
+		key, err = encryption.UnwrapKey(kek, wrapped, mode)
+		require.NoError(t, err)
+		require.Equal(t, pkg.HexDecode("042666B4917BCFA368DE9628D0C67BC9"), key)
+	}
+
+	_, err := ExportTransactionKey(pkg.HexDecode("0001020304"), ik, ksn, encryption.KeyWrapRFC3394)
+	require.Error(t, err)
+}
+
+func TestEncryptPinWithRand(t *testing.T) {
+	item := InitialSequence[0]
+	pan := []byte("4012345678909")
+
+	// fill digits EDDDCAAEBE of seed 7
+	encrypted, err := EncryptPinWithRand(item.CurrentKey, []byte("1234"), pan, ISO3, pkg.NewSeededReader(7))
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("A73793448849CB3F"), encrypted)
+
+	pinBlock, err := DecryptPinBlock(item.CurrentKey, encrypted)
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexDecode("341274FFE99CD62E"), pinBlock)
+
+	for _, format := range []PinFormat{ISO0, ISO1, ISO2, ISO3} {
+		first, err := EncryptPinWithRand(item.CurrentKey, []byte("1234"), pan, format, pkg.NewSeededReader(1))
+		require.NoError(t, err)
+		second, err := EncryptPinWithRand(item.CurrentKey, []byte("1234"), pan, format, pkg.NewSeededReader(1))
+		require.NoError(t, err)
+		require.Equal(t, first, second)
+
+		pin, err := DecryptPinV2(item.CurrentKey, first, pan, format)
+		require.NoError(t, err)
+		require.Equal(t, []byte("1234"), pin)
+	}
+
+	_, err = EncryptPinWithRand(item.CurrentKey, []byte("1234"), pan, ISO1, iotest.ErrReader(errors.New("no entropy")))
+	require.EqualError(t, err, "generating fill digits: no entropy")
+
+	_, err = EncryptPinWithRand(item.CurrentKey, []byte("12a4"), pan, ISO1, nil)
+	require.EqualError(t, err, "pin must be 4 to 12 digits")
+
+	_, err = EncryptPinWithRand(item.CurrentKey, []byte("1234"), []byte("401234"), ISO3, nil)
+	require.EqualError(t, err, "pan must be at least 13 digits")
+
+	_, err = EncryptPinWithRand(item.CurrentKey, []byte("1234"), pan, ECI4, pkg.NewSeededReader(1))
+	require.EqualError(t, err, "ECI4 pin block format doesn't support random source")
+}
@@ -0,0 +1,434 @@
+package des
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// PinFormat is pin block format of ISO 9564-1 and proprietary variants
+type PinFormat uint8
+
+const (
+	ISO0 PinFormat = iota + 1
+	ISO1
+	ISO2
+	ISO3
+	ISO4
+	ANSI
+	ECI1
+	ECI2
+	ECI3
+	ECI4
+	VISA1
+	VISA2
+	VISA3
+	VISA4
+)
+
+var pinFormatNames = [...]string{
+	ISO0:  "ISO-0",
+	ISO1:  "ISO-1",
+	ISO2:  "ISO-2",
+	ISO3:  "ISO-3",
+	ISO4:  "ISO-4",
+	ANSI:  "ANSI",
+	ECI1:  "ECI1",
+	ECI2:  "ECI2",
+	ECI3:  "ECI3",
+	ECI4:  "ECI4",
+	VISA1: "VISA1",
+	VISA2: "VISA2",
+	VISA3: "VISA3",
+	VISA4: "VISA4",
+}
+
+// Parse pin block format from name ("ISO-0", "ANSI", "VISA1", ...), the name is case insensitive
+func ParsePinFormat(name string) (PinFormat, error) {
+	upper := strings.ToUpper(name)
+	for format, formatName := range pinFormatNames {
+		if formatName != "" && formatName == upper {
+			return PinFormat(format), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid pin block format %q", name)
+}
+
+func (f PinFormat) String() string {
+	if int(f) < len(pinFormatNames) {
+		return pinFormatNames[f]
+	}
+	return ""
+}
+
+// Validate returns error when pin block format is unknown
+func (f PinFormat) Validate() error {
+	if f.String() == "" {
+		return fmt.Errorf("invalid pin block format %d", f)
+	}
+	return nil
+}
+
+// DataKeyMode selects derivation of data encryption key from data variant of transaction key
+type DataKeyMode uint8
+
+const (
+	// DataKeyOWF encrypts data variant with itself (ANSI X9.24-1:2009 A.4.1 figure A-2), it is the default mode
+	DataKeyOWF DataKeyMode = iota
+	// DataKeyVariant uses data variant (xor mask only) as data key, used by legacy pin pads
+	DataKeyVariant
+)
+
+const (
+	dataKeyModeOWF     = "owf"
+	dataKeyModeVariant = "variant"
+)
+
+// Parse data key mode from name ("owf" or "variant"), empty name is owf
+func ParseDataKeyMode(name string) (DataKeyMode, error) {
+	switch strings.ToLower(name) {
+	case "", dataKeyModeOWF:
+		return DataKeyOWF, nil
+	case dataKeyModeVariant:
+		return DataKeyVariant, nil
+	}
+	return 0, fmt.Errorf("invalid data key mode %q", name)
+}
+
+func (m DataKeyMode) String() string {
+	switch m {
+	case DataKeyOWF:
+		return dataKeyModeOWF
+	case DataKeyVariant:
+		return dataKeyModeVariant
+	}
+	return ""
+}
+
+// Validate returns error when data key mode is unknown
+func (m DataKeyMode) Validate() error {
+	if m != DataKeyOWF && m != DataKeyVariant {
+		return fmt.Errorf("invalid data key mode %d", m)
+	}
+	return nil
+}
+
+func legacyAction(action string) pkg.Action {
+	if action == pkg.ActionResponse {
+		return pkg.Response
+	}
+	return pkg.Request
+}
+
+func checkCurrentKey(currentKey []byte) error {
+	if len(currentKey) != keyLen {
+		return fmt.Errorf("current key length must be %d bytes", keyLen)
+	}
+	return nil
+}
+
+// Encrypt PIN block using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
+//   - ANSI X9.24-1:2009 A.2 Processing Algorithms ("Request PIN Entry 2")
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - pin is not formatted pin
+//   - pan is not formatted pan
+//   - format is pinblock format
+//
+// Return Params:
+//   - result is 8 bytes cipher text
+//   - err
+func EncryptPinV2(currentKey, pin, pan []byte, format PinFormat) ([]byte, error) {
+	return EncryptPinWithRand(currentKey, pin, pan, format, nil)
+}
+
+// Encrypt PIN block using DUKPT transaction key and fill digits of random source
+//
+// NOTE:
+//   - Same as EncryptPinV2, random fill digits of ISO-1 and ISO-3 pin blocks are read from random source
+//   - ECI4 and VISA4 pin blocks are filled by pinblock formats, random source must be nil for them
+//   - A seeded random source (see pkg.NewSeededReader) makes the cipher text reproducible in tests
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - pin is not formatted pin
+//   - pan is not formatted pan
+//   - format is pinblock format
+//   - random is random source (crypto/rand.Reader when nil)
+//
+// Return Params:
+//   - result is 8 bytes cipher text
+//   - err
+func EncryptPinWithRand(currentKey, pin, pan []byte, format PinFormat, random io.Reader) ([]byte, error) {
+	formatter, err := newPinFormatter(format, random)
+	if err != nil {
+		return nil, err
+	}
+
+	pinBlock, err := formatter.encode(pin, pan)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pinBlock)
+
+	return encryptPinblock(currentKey, pinBlock)
+}
+
+// Decrypt PIN block using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
+//   - ANSI X9.24-1:2009 A.2 Processing Algorithms ("Request PIN Entry 2")
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - cipher text is 8 bytes encrypted pin block
+//   - pan is not formatted pan
+//   - format is pinblock format
+//
+// Return Params:
+//   - result is pin (plain text)
+//   - err
+func DecryptPinV2(currentKey, ciphertext, pan []byte, format PinFormat) ([]byte, error) {
+	formatter, err := newPinFormatter(format, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pinBlock, err := decryptPinblock(currentKey, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	return formatter.decode(pinBlock, pan)
+}
+
+// Translate PIN block from one format to another using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
+//   - The clear PIN never leaves this function, only the new cipher text is returned
+//   - PIN block of AES DUKPT key is moved to another transaction key with aes.ReencryptPinV2
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - cipher text is encrypted pin block
+//   - pan is not formatted pan
+//   - in format is pinblock format of cipher text
+//   - out format is pinblock format of result
+//   - output key is 16 bytes transaction key used to encrypt result (current key is used when output key is nil)
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func TranslatePinFormatV2(currentKey, ciphertext, pan []byte, inFormat, outFormat PinFormat, outputKey []byte) ([]byte, error) {
+	if err := outFormat.Validate(); err != nil {
+		return nil, err
+	}
+
+	pin, err := DecryptPinV2(currentKey, ciphertext, pan, inFormat)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pin)
+
+	if outputKey == nil {
+		outputKey = currentKey
+	}
+
+	return EncryptPinV2(outputKey, pin, pan, outFormat)
+}
+
+// Generate MAC using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
+//   - ANSI X9.24-1:2009 A.4 DUKPT Test Data Examples (CBC procedure described in ISO 16609 section C.4)
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - plain text is transaction request data
+//   - action is pkg.Request or pkg.Response
+//
+// Return Params:
+//   - result is 8 bytes mac (use the first 4 bytes of this result)
+//   - err
+func GenerateMacV2(currentKey, plainText []byte, action pkg.Action) ([]byte, error) {
+	if err := checkCurrentKey(currentKey); err != nil {
+		return nil, err
+	}
+	if err := action.Validate(); err != nil {
+		return nil, err
+	}
+
+	left, right, err := macCiphers(currentKey, action.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return appendMac(make([]byte, 0, desBlockLen), left, right, plainText), nil
+}
+
+// Encrypt data using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key (figure A-2)
+//   - Encryption of the data uses T-DEA in CBC mode, plain text is padded with zeros to tdes block length [8]
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - iv is initial vector
+//   - plain text is transaction request data
+//   - action is pkg.Request or pkg.Response
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func EncryptDataV2(currentKey, iv, plainText []byte, action pkg.Action) ([]byte, error) {
+	return EncryptDataWithMode(currentKey, iv, plainText, action, DataKeyOWF)
+}
+
+// Decrypt data using DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key (figure A-2)
+//   - Encryption of the data uses T-DEA in CBC mode
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - iv is initial vector
+//   - cipher text is encrypted data
+//   - action is pkg.Request or pkg.Response
+//
+// Return Params:
+//   - result is plain text (a multiple of tdes block length [8])
+//   - err
+func DecryptDataV2(currentKey, iv, ciphertext []byte, action pkg.Action) ([]byte, error) {
+	return DecryptDataWithMode(currentKey, iv, ciphertext, action, DataKeyOWF)
+}
+
+// Encrypt data using data key of DUKPT transaction key derived in data key mode
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key (figure A-2 applies to owf mode only)
+//   - Encryption of the data uses T-DEA in CBC mode, plain text is padded with zeros to tdes block length [8]
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - iv is initial vector
+//   - plain text is transaction request data
+//   - action is pkg.Request or pkg.Response
+//   - mode is DataKeyOWF or DataKeyVariant
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func EncryptDataWithMode(currentKey, iv, plainText []byte, action pkg.Action, mode DataKeyMode) ([]byte, error) {
+	block, err := dataCipherOf(currentKey, action, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendCBCEncrypted(nil, block, iv, plainText), nil
+}
+
+// Decrypt data using data key of DUKPT transaction key derived in data key mode
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key (figure A-2 applies to owf mode only)
+//   - Encryption of the data uses T-DEA in CBC mode
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - iv is initial vector
+//   - cipher text is encrypted data
+//   - action is pkg.Request or pkg.Response
+//   - mode is DataKeyOWF or DataKeyVariant
+//
+// Return Params:
+//   - result is plain text (a multiple of tdes block length [8])
+//   - err
+func DecryptDataWithMode(currentKey, iv, ciphertext []byte, action pkg.Action, mode DataKeyMode) ([]byte, error) {
+	block, err := dataCipherOf(currentKey, action, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendCBCDecrypted(nil, block, iv, ciphertext), nil
+}
+
+// Decrypt data using pin variant of DUKPT transaction key
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key
+//   - Some card readers encrypt track data with pin variant instead of data key
+//   - Encryption of the data uses T-DEA in CBC mode
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - iv is initial vector
+//   - cipher text is encrypted data
+//
+// Return Params:
+//   - result is plain text (a multiple of tdes block length [8])
+//   - err
+func DecryptDataWithPinKey(currentKey, iv, ciphertext []byte) ([]byte, error) {
+	block, err := pinCipher(currentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendCBCDecrypted(nil, block, iv, ciphertext), nil
+}
+
+// Derive data key of transaction key and report every derivation step to tracer
+//
+// NOTE:
+//   - ANSI X9.24-1:2009 A.4.1 Variants of the Current Key (figure A-2 applies to owf mode only)
+//
+// Params:
+//   - current key is 16 bytes transaction key
+//   - action is pkg.Request or pkg.Response
+//   - mode is DataKeyOWF or DataKeyVariant
+//   - tracer observes derivation steps, nil tracer is allowed
+//
+// Return Params:
+//   - result is 16 bytes data key
+//   - err
+func DeriveDataKey(currentKey []byte, action pkg.Action, mode DataKeyMode, tracer pkg.Tracer) ([]byte, error) {
+	if err := checkDataKeyParams(currentKey, action, mode); err != nil {
+		return nil, err
+	}
+
+	key, err := workingKeyOfMode(currentKey, pkg.UsageData, action.String(), mode, tracer)
+	if err != nil {
+		return nil, err
+	}
+
+	return key[:], nil
+}
+
+func dataCipherOf(currentKey []byte, action pkg.Action, mode DataKeyMode) (cipher.Block, error) {
+	if err := checkDataKeyParams(currentKey, action, mode); err != nil {
+		return nil, err
+	}
+
+	return dataCipherOfMode(currentKey, action.String(), mode)
+}
+
+func checkDataKeyParams(currentKey []byte, action pkg.Action, mode DataKeyMode) error {
+	if err := checkCurrentKey(currentKey); err != nil {
+		return err
+	}
+	if err := action.Validate(); err != nil {
+		return err
+	}
+	return mode.Validate()
+}
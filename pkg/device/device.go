@@ -0,0 +1,338 @@
+package device
+
+/*
+Parsers of encrypted card reader output: vendor frames carry key serial number, encrypted and masked tracks
+*/
+
+import (
+	"crypto/sha1" //nolint:gosec
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/moov-io/dukpt/pkg"
+	dukptaes "github.com/moov-io/dukpt/pkg/aes"
+	dukptdes "github.com/moov-io/dukpt/pkg/des"
+	"github.com/moov-io/dukpt/pkg/emv"
+)
+
+const (
+	tracks = 3
+
+	desBlockLength = 8
+	aesBlockLength = 16
+)
+
+// KeyUsage selects working key of transaction key used by reader to encrypt tracks
+type KeyUsage uint8
+
+const (
+	// KeyUsageDefault uses default key usage of frame format
+	KeyUsageDefault KeyUsage = iota
+	// KeyUsageData is data key (data variant encrypted with itself for des)
+	KeyUsageData
+	// KeyUsageDataVariant is data variant of des transaction key without one way function
+	KeyUsageDataVariant
+	// KeyUsagePin is pin variant of des transaction key
+	KeyUsagePin
+)
+
+// ParseKeyUsage returns key usage of name (data, data_variant or pin), empty name is KeyUsageDefault
+func ParseKeyUsage(name string) (KeyUsage, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return KeyUsageDefault, nil
+	case "data":
+		return KeyUsageData, nil
+	case "data_variant":
+		return KeyUsageDataVariant, nil
+	case "pin":
+		return KeyUsagePin, nil
+	}
+	return KeyUsageDefault, fmt.Errorf("invalid key usage %q", name)
+}
+
+var ErrHashMismatch = errors.New("track hash mismatch")
+
+// Frame is parsed output of encrypted card reader
+type Frame struct {
+	// Format is name of vendor format
+	Format string
+	// Algorithm is des or aes
+	Algorithm string
+	// KSN is key serial number of transaction key
+	KSN []byte
+	// KeyUsage is default working key of format
+	KeyUsage KeyUsage
+	// Encrypted are encrypted track 1, 2 and 3 (nil when track is not sent)
+	Encrypted [tracks][]byte
+	// Lengths are clear lengths of track 1, 2 and 3
+	Lengths [tracks]int
+	// Masked are masked track 1, 2 and 3
+	Masked [tracks]string
+	// Hashes are SHA-1 hashes of clear track 1, 2 and 3 (nil when hash is not sent)
+	Hashes [tracks][]byte
+	// EMV is encrypted BER-TLV data of chip transaction, zero padded to cipher block length (nil when not sent)
+	EMV []byte
+	// SessionID is encrypted session id (nil when not sent)
+	SessionID []byte
+	// SerialNumber is device serial number (empty when not sent)
+	SerialNumber string
+}
+
+// Format parses frames of one vendor layout
+type Format interface {
+	// Name returns unique name of format
+	Name() string
+	// Parse returns frame of data, error when data doesn't follow the layout
+	Parse(data []byte) (*Frame, error)
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = make(map[string]Format)
+)
+
+// Register makes format available by its name, registering a name twice replaces the format
+func Register(format Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[format.Name()] = format
+}
+
+// Formats returns names of registered formats
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse frame of reader output with named format, empty name detects the first format accepting data
+//
+// Params:
+//   - name is format name (idtech-enhanced, magtek-magnesafe, ...) or empty
+//   - data is raw reader output
+//
+// Return Params:
+//   - result is frame
+//   - err
+func Parse(name string, data []byte) (*Frame, error) {
+	if name != "" {
+		formatsMu.RLock()
+		format, ok := formats[name]
+		formatsMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown device format %q", name)
+		}
+		return format.Parse(data)
+	}
+
+	for _, name := range Formats() {
+		if frame, err := Parse(name, data); err == nil {
+			return frame, nil
+		}
+	}
+	return nil, errors.New("no device format accepts data")
+}
+
+// Tracks are decrypted track data and EMV tags of frame
+type Tracks struct {
+	// Raw are clear track 1, 2 and 3
+	Raw [tracks]string
+	// Track1 is parsed track 1 (nil when track 1 is not sent)
+	Track1 *Track1
+	// Track2 is parsed track 2 (nil when track 2 is not sent)
+	Track2 *Track2
+	// EMV are primitive data objects of decrypted EMV data (nil when EMV data is not sent)
+	EMV []emv.TLV
+}
+
+// Decrypt encrypted tracks and EMV data of frame and verify track hashes
+//
+// NOTE:
+//   - Tracks and EMV data are encrypted in CBC mode with zero iv
+//   - des working key follows key usage, aes working key is data key of current key length
+//
+// Params:
+//   - current key is transaction key of frame's ksn
+//   - usage is working key of transaction key (KeyUsageDefault follows frame format)
+//
+// Return Params:
+//   - result is clear and parsed tracks
+//   - err
+func (f *Frame) Decrypt(currentKey []byte, usage KeyUsage) (*Tracks, error) {
+	if usage == KeyUsageDefault {
+		usage = f.KeyUsage
+	}
+
+	var err error
+	result := &Tracks{}
+	for i, encrypted := range f.Encrypted {
+		if encrypted == nil {
+			continue
+		}
+		if len(encrypted)%f.blockSize() != 0 || f.Lengths[i] > len(encrypted) {
+			return nil, fmt.Errorf("invalid encrypted track %d length", i+1)
+		}
+
+		clear, err := f.decryptTrack(currentKey, usage, encrypted)
+		if err != nil {
+			return nil, err
+		}
+		clear = clear[:f.Lengths[i]]
+
+		if f.Hashes[i] != nil {
+			// codeql[go/weak-cryptographic-algorithm] SHA-1 track hashes are sent by card readers
+			sum := sha1.Sum(clear) //nolint:gosec
+			if subtle.ConstantTimeCompare(sum[:], f.Hashes[i]) != 1 {
+				return nil, ErrHashMismatch
+			}
+		}
+
+		result.Raw[i] = string(clear)
+	}
+
+	if f.EMV != nil {
+		if result.EMV, err = f.decryptEMV(currentKey, usage); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.Raw[0] != "" {
+		if result.Track1, err = ParseTrack1(result.Raw[0]); err != nil {
+			return nil, err
+		}
+	}
+	if result.Raw[1] != "" {
+		if result.Track2, err = ParseTrack2(result.Raw[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (f *Frame) blockSize() int {
+	if f.Algorithm == pkg.AlgorithmAes {
+		return aesBlockLength
+	}
+	return desBlockLength
+}
+
+// decryptEMV decrypts EMV data with working key of usage and parses its data objects
+func (f *Frame) decryptEMV(currentKey []byte, usage KeyUsage) ([]emv.TLV, error) {
+	if len(f.EMV)%f.blockSize() != 0 {
+		return nil, errors.New("invalid encrypted emv data length")
+	}
+
+	clear, err := f.decryptTrack(currentKey, usage, f.EMV)
+	if err != nil {
+		return nil, err
+	}
+	return emv.ParseTLV(clear)
+}
+
+// decryptTrack decrypts track with working key of usage, iv is zero
+func (f *Frame) decryptTrack(currentKey []byte, usage KeyUsage, encrypted []byte) ([]byte, error) {
+	if f.Algorithm == pkg.AlgorithmAes {
+		if usage != KeyUsageData {
+			return nil, errors.New("aes readers support only data key usage")
+		}
+
+		keyType, err := dukptaes.KeyTypeOfLength(len(currentKey))
+		if err != nil {
+			return nil, err
+		}
+		return dukptaes.DecryptDataV2(currentKey, f.KSN, nil, encrypted, keyType, pkg.Request)
+	}
+
+	switch usage {
+	case KeyUsageData:
+		return dukptdes.DecryptDataWithMode(currentKey, nil, encrypted, pkg.Request, dukptdes.DataKeyOWF)
+	case KeyUsageDataVariant:
+		return dukptdes.DecryptDataWithMode(currentKey, nil, encrypted, pkg.Request, dukptdes.DataKeyVariant)
+	case KeyUsagePin:
+		return dukptdes.DecryptDataWithPinKey(currentKey, nil, encrypted)
+	}
+	return nil, errors.New("invalid key usage")
+}
+
+// MaskPAN keeps first 6 and last 4 digits of PAN, short PAN keeps only last 4 digits
+func MaskPAN(pan string) string {
+	if len(pan) <= 4 {
+		return strings.Repeat("*", len(pan))
+	}
+
+	first := 6
+	if len(pan) < 13 {
+		first = 0
+	}
+	return pan[:first] + strings.Repeat("*", len(pan)-first-4) + pan[len(pan)-4:]
+}
+
+// Track1 is ISO/IEC 7813 track 1 (format B)
+type Track1 struct {
+	PAN           string
+	Name          string
+	Expiry        string
+	ServiceCode   string
+	Discretionary string
+}
+
+// Track2 is ISO/IEC 7813 track 2
+type Track2 struct {
+	PAN           string
+	Expiry        string
+	ServiceCode   string
+	Discretionary string
+}
+
+// Parse ISO/IEC 7813 track 1 ("%B" PAN "^" NAME "^" YYMM SERVICE DISCRETIONARY "?"), sentinels are optional
+func ParseTrack1(track string) (*Track1, error) {
+	track = strings.TrimPrefix(track, "%")
+	if i := strings.IndexByte(track, '?'); i >= 0 {
+		track = track[:i]
+	}
+
+	fields := strings.SplitN(track, "^", 3)
+	if len(fields) != 3 || !strings.HasPrefix(fields[0], "B") || len(fields[2]) < 7 {
+		return nil, errors.New("invalid track 1 data")
+	}
+
+	return &Track1{
+		PAN:           fields[0][1:],
+		Name:          strings.TrimSpace(fields[1]),
+		Expiry:        fields[2][:4],
+		ServiceCode:   fields[2][4:7],
+		Discretionary: fields[2][7:],
+	}, nil
+}
+
+// Parse ISO/IEC 7813 track 2 (";" PAN "=" YYMM SERVICE DISCRETIONARY "?"), sentinels are optional
+func ParseTrack2(track string) (*Track2, error) {
+	track = strings.TrimPrefix(track, ";")
+	if i := strings.IndexByte(track, '?'); i >= 0 {
+		track = track[:i]
+	}
+
+	fields := strings.SplitN(track, "=", 2)
+	if len(fields) != 2 || fields[0] == "" || len(fields[1]) < 7 {
+		return nil, errors.New("invalid track 2 data")
+	}
+
+	return &Track2{
+		PAN:           fields[0],
+		Expiry:        fields[1][:4],
+		ServiceCode:   fields[1][4:7],
+		Discretionary: fields[1][7:],
+	}, nil
+}
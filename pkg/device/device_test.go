@@ -0,0 +1,124 @@
+package device
+
+import (
+	"crypto/cipher"
+	"crypto/des" //nolint:gosec
+	"testing"
+
+	"github.com/moov-io/dukpt/pkg"
+	dukptdes "github.com/moov-io/dukpt/pkg/des"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTrack1 = "%B4012345678909^DOE/JOHN^2512101123456789?"
+	testTrack2 = ";4012345678909=25121011234567890?"
+)
+
+// A.4.2 Initial Sequence, first transaction key
+var (
+	testKsn        = pkg.HexDecode("FFFF9876543210E00001")
+	testCurrentKey = pkg.HexDecode("042666B49184CFA368DE9628D0397BC9")
+)
+
+// encryptTrack encrypts zero padded track with des working key in CBC mode with zero iv
+func encryptTrack(t *testing.T, track string, usage KeyUsage) []byte {
+	t.Helper()
+
+	var key []byte
+	var err error
+	switch usage {
+	case KeyUsageData:
+		key, err = dukptdes.DeriveDataKey(testCurrentKey, pkg.Request, dukptdes.DataKeyOWF, nil)
+	case KeyUsageDataVariant:
+		key, err = dukptdes.DeriveDataKey(testCurrentKey, pkg.Request, dukptdes.DataKeyVariant, nil)
+	case KeyUsagePin:
+		key, err = dukptdes.DeriveWorkingKey(testCurrentKey, pkg.UsagePin, pkg.ActionRequest, nil)
+	}
+	require.NoError(t, err)
+
+	// two key triple des (K1, K2, K1)
+	block, err := des.NewTripleDESCipher(append(key[:16:16], key[:8]...)) //nolint:gosec
+	require.NoError(t, err)
+
+	padded := make([]byte, (len(track)+des.BlockSize-1)/des.BlockSize*des.BlockSize)
+	copy(padded, track)
+	cipher.NewCBCEncrypter(block, make([]byte, des.BlockSize)).CryptBlocks(padded, padded)
+	return padded
+}
+
+func TestDecryptTrack(t *testing.T) {
+	frame := Frame{Algorithm: pkg.AlgorithmDes}
+	for _, usage := range []KeyUsage{KeyUsageData, KeyUsageDataVariant, KeyUsagePin} {
+		clear, err := frame.decryptTrack(testCurrentKey, usage, encryptTrack(t, testTrack2, usage))
+		require.NoError(t, err)
+		require.Equal(t, testTrack2, string(clear[:len(testTrack2)]))
+	}
+
+	_, err := frame.decryptTrack(testCurrentKey, KeyUsageDefault, make([]byte, des.BlockSize))
+	require.EqualError(t, err, "invalid key usage")
+}
+
+func TestParseTracks(t *testing.T) {
+	track1, err := ParseTrack1(testTrack1)
+	require.NoError(t, err)
+	require.Equal(t, &Track1{
+		PAN:           "4012345678909",
+		Name:          "DOE/JOHN",
+		Expiry:        "2512",
+		ServiceCode:   "101",
+		Discretionary: "123456789",
+	}, track1)
+
+	track2, err := ParseTrack2(testTrack2)
+	require.NoError(t, err)
+	require.Equal(t, &Track2{
+		PAN:           "4012345678909",
+		Expiry:        "2512",
+		ServiceCode:   "101",
+		Discretionary: "1234567890",
+	}, track2)
+
+	_, err = ParseTrack1("%4012345678909^DOE/JOHN?")
+	require.EqualError(t, err, "invalid track 1 data")
+
+	_, err = ParseTrack2(";4012345678909?")
+	require.EqualError(t, err, "invalid track 2 data")
+}
+
+func TestMaskPAN(t *testing.T) {
+	require.Equal(t, "401234***8909", MaskPAN("4012345678909"))
+	require.Equal(t, "411111******1111", MaskPAN("4111111111111111"))
+	require.Equal(t, "*****6789", MaskPAN("123456789"))
+	require.Equal(t, "****", MaskPAN("1234"))
+	require.Equal(t, "", MaskPAN(""))
+}
+
+func TestParseKeyUsage(t *testing.T) {
+	for name, expected := range map[string]KeyUsage{"": KeyUsageDefault, "data": KeyUsageData, "DATA_VARIANT": KeyUsageDataVariant, "pin": KeyUsagePin} {
+		usage, err := ParseKeyUsage(name)
+		require.NoError(t, err)
+		require.Equal(t, expected, usage)
+	}
+
+	_, err := ParseKeyUsage("mac")
+	require.EqualError(t, err, `invalid key usage "mac"`)
+}
+
+func TestParse(t *testing.T) {
+	require.Equal(t, []string{FormatIDTechEMV, FormatIDTechEnhanced, FormatMagTekMagneSafe}, Formats())
+
+	frame, err := Parse("", buildMagTekReport(t, KeyUsagePin))
+	require.NoError(t, err)
+	require.Equal(t, FormatMagTekMagneSafe, frame.Format)
+
+	frame, err = Parse("", buildIDTechFrame(t, true))
+	require.NoError(t, err)
+	require.Equal(t, FormatIDTechEnhanced, frame.Format)
+
+	_, err = Parse("", []byte("not a frame"))
+	require.EqualError(t, err, "no device format accepts data")
+
+	_, err = Parse("acme", nil)
+	require.EqualError(t, err, `unknown device format "acme"`)
+}
@@ -0,0 +1,208 @@
+package device
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/emv"
+)
+
+const (
+	FormatIDTechEnhanced = "idtech-enhanced"
+	FormatIDTechEMV      = "idtech-emv"
+
+	// default tags of idtech emv output
+	IDTechKsnTag       = "DFEE12"
+	IDTechEncryptedTag = "DFEF4D"
+
+	idtechSTX        = 0x02
+	idtechETX        = 0x03
+	idtechHeaderLen  = 10
+	idtechTrailerLen = 3
+
+	idtechSessionIDLen = 8
+	idtechHashLen      = 20
+	idtechDesKsnLen    = 10
+	idtechAesKsnLen    = 12
+
+	// byte 8, bits 4 and 5
+	idtechEncryptionMask = 0x30
+	idtechEncryptionTdes = 0x00
+	idtechEncryptionAes  = 0x10
+
+	// byte 9
+	idtechSessionIDSent = 0x40
+	idtechKsnSent       = 0x80
+)
+
+func init() {
+	Register(IDTechEnhanced{})
+	Register(IDTechEMV{})
+}
+
+// IDTechEnhanced is IDTech enhanced encrypted MSR layout
+//
+// NOTE:
+//   - STX (1) | length of bytes 3 .. LRC exclusive, little endian (2) | card encode type (1) | track status (1) |
+//     clear length of track 1, 2, 3 (3) | masked data status (1) | encrypted and hash data status (1) |
+//     masked tracks | encrypted tracks | session id (8) | hashes (20 each) | ksn | LRC (1) | checksum (1) | ETX (1)
+//   - Masked data status bits 0-2 flag masked tracks, bits 4-5 are encryption type (0 TDES, 1 AES)
+//   - Encrypted and hash data status bits 0-2 flag encrypted tracks, bits 3-5 flag hashes,
+//     bit 6 flags session id and bit 7 flags ksn (10 bytes for TDES, 12 bytes for AES)
+//   - Encrypted track is clear length rounded up to cipher block length, tracks are encrypted with data key
+//   - LRC is xor and checksum is sum of bytes 3 .. LRC exclusive
+//   - The layout carries no EMV data, chip transactions are parsed by IDTechEMV
+type IDTechEnhanced struct{}
+
+func (IDTechEnhanced) Name() string {
+	return FormatIDTechEnhanced
+}
+
+func (IDTechEnhanced) Parse(data []byte) (*Frame, error) {
+	if len(data) < idtechHeaderLen+idtechTrailerLen || data[0] != idtechSTX || data[len(data)-1] != idtechETX {
+		return nil, errors.New("invalid idtech frame")
+	}
+
+	body := data[3 : len(data)-idtechTrailerLen]
+	if int(binary.LittleEndian.Uint16(data[1:3])) != len(body) {
+		return nil, errors.New("invalid idtech frame length")
+	}
+
+	var lrc, checksum byte
+	for _, b := range body {
+		lrc ^= b
+		checksum += b
+	}
+	if lrc != data[len(data)-3] || checksum != data[len(data)-2] {
+		return nil, errors.New("invalid idtech frame checksum")
+	}
+
+	frame := &Frame{
+		Format:    FormatIDTechEnhanced,
+		Algorithm: pkg.AlgorithmDes,
+		KeyUsage:  KeyUsageData,
+	}
+
+	blockLen, ksnLen := 8, idtechDesKsnLen
+	switch data[8] & idtechEncryptionMask {
+	case idtechEncryptionTdes:
+	case idtechEncryptionAes:
+		frame.Algorithm = pkg.AlgorithmAes
+		blockLen, ksnLen = 16, idtechAesKsnLen
+	default:
+		return nil, fmt.Errorf("unsupported idtech encryption type %X", data[8]&idtechEncryptionMask)
+	}
+
+	r := reader{data: data[idtechHeaderLen : len(data)-idtechTrailerLen]}
+	masked, encrypted := data[8], data[9]
+
+	for i := 0; i < tracks; i++ {
+		frame.Lengths[i] = int(data[5+i])
+		if masked&(1<<i) != 0 {
+			frame.Masked[i] = string(r.next(frame.Lengths[i]))
+		}
+	}
+	for i := 0; i < tracks; i++ {
+		if encrypted&(1<<i) != 0 {
+			frame.Encrypted[i] = r.next((frame.Lengths[i] + blockLen - 1) / blockLen * blockLen)
+		}
+	}
+	if encrypted&idtechSessionIDSent != 0 {
+		frame.SessionID = r.next(idtechSessionIDLen)
+	}
+	for i := 0; i < tracks; i++ {
+		if encrypted&(1<<(3+i)) != 0 {
+			frame.Hashes[i] = r.next(idtechHashLen)
+		}
+	}
+	if encrypted&idtechKsnSent == 0 {
+		return nil, errors.New("idtech frame doesn't carry key serial number")
+	}
+	frame.KSN = r.next(ksnLen)
+
+	if r.err || len(r.data) != 0 {
+		return nil, errors.New("invalid idtech frame length")
+	}
+
+	return frame, nil
+}
+
+// IDTechEMV is BER-TLV output of IDTech chip transaction
+//
+// NOTE:
+//   - Tag of ksn (10 bytes for TDES, 12 bytes for AES) and tag of encrypted EMV data are read from any level of the data,
+//     other data objects (masked and clear tags) are ignored
+//   - Encrypted EMV data is BER-TLV data of sensitive tags (57, 5A, 5F24, ...) zero padded and encrypted with data key
+//   - Tag numbers differ between firmware lines, empty tags are IDTechKsnTag and IDTechEncryptedTag,
+//     register IDTechEMV with tags of the reader's documentation when they differ
+type IDTechEMV struct {
+	// KsnTag is hex encoded tag of key serial number (default IDTechKsnTag)
+	KsnTag string
+	// EncryptedTag is hex encoded tag of encrypted EMV data (default IDTechEncryptedTag)
+	EncryptedTag string
+}
+
+func (IDTechEMV) Name() string {
+	return FormatIDTechEMV
+}
+
+func (f IDTechEMV) Parse(data []byte) (*Frame, error) {
+	tlvs, err := emv.ParseTLV(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid idtech emv data: %w", err)
+	}
+
+	ksnTag, encryptedTag := IDTechKsnTag, IDTechEncryptedTag
+	if f.KsnTag != "" {
+		ksnTag = strings.ToUpper(f.KsnTag)
+	}
+	if f.EncryptedTag != "" {
+		encryptedTag = strings.ToUpper(f.EncryptedTag)
+	}
+
+	frame := &Frame{
+		Format:   FormatIDTechEMV,
+		KeyUsage: KeyUsageData,
+	}
+	for _, tlv := range tlvs {
+		switch tlv.Tag {
+		case ksnTag:
+			frame.KSN = tlv.Value
+		case encryptedTag:
+			frame.EMV = tlv.Value
+		}
+	}
+
+	switch len(frame.KSN) {
+	case idtechDesKsnLen:
+		frame.Algorithm = pkg.AlgorithmDes
+	case idtechAesKsnLen:
+		frame.Algorithm = pkg.AlgorithmAes
+	default:
+		return nil, errors.New("idtech emv data doesn't carry key serial number")
+	}
+	if len(frame.EMV) == 0 || len(frame.EMV)%frame.blockSize() != 0 {
+		return nil, errors.New("invalid idtech encrypted emv data")
+	}
+
+	return frame, nil
+}
+
+// reader reads fields of fixed layout, err is set when data is too short
+type reader struct {
+	data []byte
+	err  bool
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err || len(r.data) < n {
+		r.err = true
+		return nil
+	}
+	out := append([]byte(nil), r.data[:n]...)
+	r.data = r.data[n:]
+	return out
+}
@@ -0,0 +1,163 @@
+package device
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"testing"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/stretchr/testify/require"
+)
+
+func maskTrack(track string) string {
+	return track[:8] + "********" + track[16:]
+}
+
+func buildIDTechFrame(t *testing.T, withHashes bool) []byte {
+	t.Helper()
+
+	encrypt := func(track string) []byte {
+		return encryptTrack(t, track, KeyUsageData)
+	}
+	return buildIDTechFrameOf(t, idtechEncryptionTdes, encrypt, testKsn, withHashes)
+}
+
+func buildIDTechFrameOf(t *testing.T, encryption byte, encrypt func(track string) []byte, ksn []byte, withHashes bool) []byte {
+	t.Helper()
+
+	body := []byte{0x80, 0x03, byte(len(testTrack1)), byte(len(testTrack2)), 0, 0x03 | encryption, 0x83 | 0x40}
+	body = append(body, maskTrack(testTrack1)...)
+	body = append(body, maskTrack(testTrack2)...)
+	body = append(body, encrypt(testTrack1)...)
+	body = append(body, encrypt(testTrack2)...)
+	body = append(body, pkg.HexDecode("0102030405060708")...)
+	if withHashes {
+		body[6] |= 0x18
+		for _, track := range []string{testTrack1, testTrack2} {
+			sum := sha1.Sum([]byte(track))
+			body = append(body, sum[:]...)
+		}
+	}
+	body = append(body, ksn...)
+
+	var lrc, checksum byte
+	for _, b := range body {
+		lrc ^= b
+		checksum += b
+	}
+
+	frame := []byte{idtechSTX, 0, 0}
+	binary.LittleEndian.PutUint16(frame[1:], uint16(len(body)))
+	frame = append(frame, body...)
+	return append(frame, lrc, checksum, idtechETX)
+}
+
+func TestIDTechEnhanced(t *testing.T) {
+	frame, err := Parse(FormatIDTechEnhanced, buildIDTechFrame(t, true))
+	require.NoError(t, err)
+	require.Equal(t, pkg.AlgorithmDes, frame.Algorithm)
+	require.Equal(t, testKsn, frame.KSN)
+	require.Equal(t, maskTrack(testTrack2), frame.Masked[1])
+	require.Equal(t, pkg.HexDecode("0102030405060708"), frame.SessionID)
+	require.Nil(t, frame.Encrypted[2])
+
+	tracks, err := frame.Decrypt(testCurrentKey, KeyUsageDefault)
+	require.NoError(t, err)
+	require.Equal(t, testTrack1, tracks.Raw[0])
+	require.Equal(t, testTrack2, tracks.Raw[1])
+	require.Equal(t, "DOE/JOHN", tracks.Track1.Name)
+	require.Equal(t, "4012345678909", tracks.Track2.PAN)
+
+	// wrong working key is detected by track hashes
+	_, err = frame.Decrypt(testCurrentKey, KeyUsagePin)
+	require.ErrorIs(t, err, ErrHashMismatch)
+
+	frame, err = Parse(FormatIDTechEnhanced, buildIDTechFrame(t, false))
+	require.NoError(t, err)
+	require.Nil(t, frame.Hashes[0])
+
+	// aes dukpt reader
+	aesKey := pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44")
+	aesKsn := pkg.HexDecode("123456789012345600000001")
+	encrypt := func(track string) []byte {
+		encrypted, err := aes.EncryptDataV2(aesKey, aesKsn, nil, []byte(track), aes.AES128, pkg.Request)
+		require.NoError(t, err)
+		return encrypted
+	}
+
+	frame, err = Parse("", buildIDTechFrameOf(t, idtechEncryptionAes, encrypt, aesKsn, true))
+	require.NoError(t, err)
+	require.Equal(t, pkg.AlgorithmAes, frame.Algorithm)
+	require.Equal(t, aesKsn, frame.KSN)
+
+	tracks, err = frame.Decrypt(aesKey, KeyUsageDefault)
+	require.NoError(t, err)
+	require.Equal(t, testTrack2, tracks.Raw[1])
+
+	data := buildIDTechFrame(t, true)
+	data[20] ^= 0x01
+	_, err = Parse(FormatIDTechEnhanced, data)
+	require.EqualError(t, err, "invalid idtech frame checksum")
+}
+
+func buildIDTechEMV(ksn, encrypted []byte) []byte {
+	data := pkg.HexDecode("DFEE1202")
+	data[3] = byte(len(ksn))
+	data = append(data, ksn...)
+	// masked pan is ignored
+	data = append(data, pkg.HexDecode("5A08401234FFFFFF8909")...)
+	data = append(data, pkg.HexDecode("DFEF4D81")...)
+	data = append(data, byte(len(encrypted)))
+	return append(data, encrypted...)
+}
+
+func TestIDTechEMV(t *testing.T) {
+	emvData := pkg.HexDecode("57104012345678909D25121011234567890F5A074012345678909F5F24032512319F0206000000001000")
+	tags := []string{"57", "5A", "5F24", "9F02"}
+
+	frame, err := Parse(FormatIDTechEMV, buildIDTechEMV(testKsn, encryptTrack(t, string(emvData), KeyUsageData)))
+	require.NoError(t, err)
+	require.Equal(t, pkg.AlgorithmDes, frame.Algorithm)
+	require.Equal(t, testKsn, frame.KSN)
+
+	decrypted, err := frame.Decrypt(testCurrentKey, KeyUsageDefault)
+	require.NoError(t, err)
+	require.Nil(t, decrypted.Track2)
+	require.Len(t, decrypted.EMV, len(tags))
+	for i, tag := range tags {
+		require.Equal(t, tag, decrypted.EMV[i].Tag)
+	}
+	require.Equal(t, pkg.HexDecode("4012345678909F"), decrypted.EMV[1].Value)
+
+	// aes dukpt reader
+	aesKey := pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44")
+	aesKsn := pkg.HexDecode("123456789012345600000001")
+	encrypted, err := aes.EncryptDataV2(aesKey, aesKsn, nil, emvData, aes.AES128, pkg.Request)
+	require.NoError(t, err)
+
+	frame, err = Parse("", buildIDTechEMV(aesKsn, encrypted))
+	require.NoError(t, err)
+	require.Equal(t, FormatIDTechEMV, frame.Format)
+	require.Equal(t, pkg.AlgorithmAes, frame.Algorithm)
+
+	decrypted, err = frame.Decrypt(aesKey, KeyUsageDefault)
+	require.NoError(t, err)
+	require.Equal(t, "9F02", decrypted.EMV[3].Tag)
+
+	// reader with other tags
+	Register(IDTechEMV{KsnTag: "dfee26", EncryptedTag: "DFEF4D"})
+	defer Register(IDTechEMV{})
+
+	data := buildIDTechEMV(aesKsn, encrypted)
+	data[2] = 0x26
+	frame, err = Parse(FormatIDTechEMV, data)
+	require.NoError(t, err)
+	require.Equal(t, aesKsn, frame.KSN)
+
+	_, err = IDTechEMV{}.Parse(data)
+	require.EqualError(t, err, "idtech emv data doesn't carry key serial number")
+
+	_, err = IDTechEMV{}.Parse(buildIDTechEMV(aesKsn, encrypted[:8]))
+	require.EqualError(t, err, "invalid idtech encrypted emv data")
+}
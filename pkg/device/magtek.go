@@ -0,0 +1,82 @@
+package device
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/moov-io/dukpt/pkg"
+)
+
+const (
+	FormatMagTekMagneSafe = "magtek-magnesafe"
+
+	magtekReportLen   = 887
+	magtekTrackLen    = 112
+	magtekSerialLen   = 16
+	magtekKsnLen      = 10
+	magtekSessionLen  = 8
+	magtekBlockLen    = 8
+	magtekEncLenAt    = 3
+	magtekEncTrackAt  = 7
+	magtekSerialAt    = 476
+	magtekKsnAt       = 494
+	magtekMaskLenAt   = 504
+	magtekMaskTrackAt = 507
+	magtekSessionAt   = 843
+	magtekAbsLenAt    = 851
+)
+
+func init() {
+	Register(MagTekMagneSafe{})
+}
+
+// MagTekMagneSafe is MagTek MagneSafe fixed layout input report (887 bytes)
+//
+// NOTE:
+//   - Track decode status (3) | encrypted length of track 1, 2, 3 (3) | card encode type (1) |
+//     encrypted track 1, 2, 3 (112 each) at 7 | MagnePrint status, length and data (133) |
+//     device serial number (16) at 476 | reader encryption status (2) | ksn (10) at 494 |
+//     masked length of track 1, 2, 3 (3) at 504 | masked track 1, 2, 3 (112 each) at 507 |
+//     encrypted session id (8) at 843 | clear length of track 1, 2, 3 (3) at 851
+//   - Tracks are encrypted with TDES DUKPT, default working key is pin variant of transaction key
+//     (readers configured with data variant are decrypted with KeyUsageDataVariant)
+//   - The report carries no EMV data
+type MagTekMagneSafe struct{}
+
+func (MagTekMagneSafe) Name() string {
+	return FormatMagTekMagneSafe
+}
+
+func (MagTekMagneSafe) Parse(data []byte) (*Frame, error) {
+	if len(data) != magtekReportLen {
+		return nil, errors.New("invalid magtek report length")
+	}
+
+	frame := &Frame{
+		Format:       FormatMagTekMagneSafe,
+		Algorithm:    pkg.AlgorithmDes,
+		KeyUsage:     KeyUsagePin,
+		KSN:          append([]byte(nil), data[magtekKsnAt:magtekKsnAt+magtekKsnLen]...),
+		SessionID:    append([]byte(nil), data[magtekSessionAt:magtekSessionAt+magtekSessionLen]...),
+		SerialNumber: strings.TrimRight(string(data[magtekSerialAt:magtekSerialAt+magtekSerialLen]), "\x00 "),
+	}
+
+	for i := 0; i < tracks; i++ {
+		encLen := int(data[magtekEncLenAt+i])
+		maskLen := int(data[magtekMaskLenAt+i])
+		if encLen > magtekTrackLen || maskLen > magtekTrackLen || encLen%magtekBlockLen != 0 {
+			return nil, errors.New("invalid magtek track length")
+		}
+
+		frame.Lengths[i] = int(data[magtekAbsLenAt+i])
+		if encLen > 0 {
+			at := magtekEncTrackAt + i*magtekTrackLen
+			frame.Encrypted[i] = append([]byte(nil), data[at:at+encLen]...)
+		}
+
+		at := magtekMaskTrackAt + i*magtekTrackLen
+		frame.Masked[i] = string(data[at : at+maskLen])
+	}
+
+	return frame, nil
+}
@@ -0,0 +1,53 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func buildMagTekReport(t *testing.T, usage KeyUsage) []byte {
+	t.Helper()
+
+	report := make([]byte, magtekReportLen)
+	for i, track := range []string{testTrack1, testTrack2} {
+		encrypted := encryptTrack(t, track, usage)
+		report[magtekEncLenAt+i] = byte(len(encrypted))
+		copy(report[magtekEncTrackAt+i*magtekTrackLen:], encrypted)
+
+		masked := maskTrack(track)
+		report[magtekMaskLenAt+i] = byte(len(masked))
+		copy(report[magtekMaskTrackAt+i*magtekTrackLen:], masked)
+
+		report[magtekAbsLenAt+i] = byte(len(track))
+	}
+	copy(report[magtekSerialAt:], "B1234567")
+	copy(report[magtekKsnAt:], testKsn)
+
+	return report
+}
+
+func TestMagTekMagneSafe(t *testing.T) {
+	frame, err := Parse(FormatMagTekMagneSafe, buildMagTekReport(t, KeyUsagePin))
+	require.NoError(t, err)
+	require.Equal(t, testKsn, frame.KSN)
+	require.Equal(t, "B1234567", frame.SerialNumber)
+	require.Equal(t, maskTrack(testTrack1), frame.Masked[0])
+	require.Equal(t, "", frame.Masked[2])
+
+	tracks, err := frame.Decrypt(testCurrentKey, KeyUsageDefault)
+	require.NoError(t, err)
+	require.Equal(t, testTrack2, tracks.Raw[1])
+	require.Equal(t, "2512", tracks.Track1.Expiry)
+
+	// reader configured with data variant
+	frame, err = Parse(FormatMagTekMagneSafe, buildMagTekReport(t, KeyUsageDataVariant))
+	require.NoError(t, err)
+
+	tracks, err = frame.Decrypt(testCurrentKey, KeyUsageDataVariant)
+	require.NoError(t, err)
+	require.Equal(t, testTrack1, tracks.Raw[0])
+
+	_, err = Parse(FormatMagTekMagneSafe, make([]byte, 10))
+	require.EqualError(t, err, "invalid magtek report length")
+}
@@ -0,0 +1,254 @@
+package emv
+
+/*
+Selective encryption of sensitive EMV tags inside BER-TLV data using DUKPT data keys
+*/
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+)
+
+// Padding conventions of tag values before encryption
+const (
+	// PaddingZero fills with 0x00, decrypted values are cut to their clear length carried by Options.Lengths
+	PaddingZero = "zero"
+	// PaddingISO9797M2 appends 0x80 then fills with 0x00 (ISO/IEC 9797-1 padding method 2)
+	PaddingISO9797M2 = "iso9797-m2"
+	// PaddingPKCS7 fills with bytes of the padding length (RFC 5652 6.3)
+	PaddingPKCS7 = "pkcs7"
+)
+
+// DefaultTags are encrypted when no tag is specified (Track 2 equivalent data, PAN and expiration date)
+var DefaultTags = []string{"57", "5A", "5F24"}
+
+// Cipher encrypts and decrypts tag values
+type Cipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+	BlockSize() int
+}
+
+// Options select tags and padding convention
+type Options struct {
+	// Tags are hex encoded tags (for example "57", "5A", "5F24")
+	Tags []string
+	// Padding is one of zero, iso9797-m2, pkcs7 (default zero)
+	Padding string
+	// Lengths are clear value lengths of zero padded tags: EncryptTags records them when the map is set
+	// and DecryptTags cuts decrypted values to them (values of unknown length keep their padding)
+	Lengths map[string]int
+}
+
+type desCipher struct {
+	currentKey []byte
+	iv         []byte
+	action     string
+}
+
+// NewDesCipher creates tag cipher with TDES DUKPT data key (see des.EncryptData)
+func NewDesCipher(currentKey, iv []byte, action string) Cipher {
+	return &desCipher{currentKey: currentKey, iv: iv, action: action}
+}
+
+func (c *desCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	return des.EncryptData(c.currentKey, c.iv, string(plaintext), c.action)
+}
+
+func (c *desCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	plaintext, err := des.DecryptData(c.currentKey, ciphertext, c.iv, c.action)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(plaintext), nil
+}
+
+func (c *desCipher) BlockSize() int {
+	return 8
+}
+
+type aesCipher struct {
+	currentKey []byte
+	ksn        []byte
+	iv         []byte
+	keyType    string
+	action     string
+}
+
+// NewAesCipher creates tag cipher with AES DUKPT data key (see aes.EncryptData)
+func NewAesCipher(currentKey, ksn, iv []byte, keyType, action string) Cipher {
+	return &aesCipher{currentKey: currentKey, ksn: ksn, iv: iv, keyType: keyType, action: action}
+}
+
+func (c *aesCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	return aes.EncryptData(c.currentKey, c.ksn, c.iv, string(plaintext), c.keyType, c.action)
+}
+
+func (c *aesCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	plaintext, err := aes.DecryptData(c.currentKey, c.ksn, ciphertext, c.iv, c.keyType, c.action)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(plaintext), nil
+}
+
+func (c *aesCipher) BlockSize() int {
+	return 16
+}
+
+// Encrypt values of selected tags in BER-TLV data
+//
+// Params:
+//   - data is BER-TLV encoded data
+//   - cipher is des or aes tag cipher
+//   - opts selects tags and padding convention
+//
+// Return Params:
+//   - result is BER-TLV data with encrypted values and re-encoded lengths
+//   - touched is list of encrypted tags
+//   - err
+func EncryptTags(data []byte, cipher Cipher, opts Options) ([]byte, []string, error) {
+	padding, err := opts.padding()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	touched := []string{}
+	result, err := transformTLV(data, opts.selected(), func(tag string, value []byte) ([]byte, error) {
+		if padding == PaddingZero && opts.Lengths != nil {
+			if length, ok := opts.Lengths[tag]; ok && length != len(value) {
+				return nil, fmt.Errorf("tag %s is repeated with another length", tag)
+			}
+			opts.Lengths[tag] = len(value)
+		}
+		return cipher.Encrypt(pad(value, cipher.BlockSize(), padding))
+	}, &touched)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, touched, nil
+}
+
+// Decrypt values of selected tags in BER-TLV data
+//
+// Params:
+//   - data is BER-TLV encoded data with encrypted values
+//   - cipher is des or aes tag cipher
+//   - opts selects tags and padding convention
+//
+// Return Params:
+//   - result is BER-TLV data with decrypted values and re-encoded lengths
+//   - touched is list of decrypted tags
+//   - err
+func DecryptTags(data []byte, cipher Cipher, opts Options) ([]byte, []string, error) {
+	padding, err := opts.padding()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	touched := []string{}
+	result, err := transformTLV(data, opts.selected(), func(tag string, value []byte) ([]byte, error) {
+		if len(value)%cipher.BlockSize() != 0 {
+			return nil, errors.New("encrypted tag value must be a multiple of cipher block length")
+		}
+
+		plaintext, err := cipher.Decrypt(value)
+		if err != nil {
+			return nil, err
+		}
+		if padding == PaddingZero {
+			return cutZeroPadding(plaintext, opts.Lengths, tag)
+		}
+		return unpad(plaintext, padding)
+	}, &touched)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, touched, nil
+}
+
+func (o Options) selected() map[string]bool {
+	tags := o.Tags
+	if len(tags) == 0 {
+		tags = DefaultTags
+	}
+
+	selected := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		selected[strings.ToUpper(tag)] = true
+	}
+	return selected
+}
+
+func (o Options) padding() (string, error) {
+	switch o.Padding {
+	case "":
+		return PaddingZero, nil
+	case PaddingZero, PaddingISO9797M2, PaddingPKCS7:
+		return o.Padding, nil
+	}
+	return "", errors.New("unsupported padding")
+}
+
+func pad(value []byte, blockSize int, padding string) []byte {
+	padded := make([]byte, len(value), len(value)+blockSize)
+	copy(padded, value)
+
+	switch padding {
+	case PaddingISO9797M2:
+		padded = append(padded, 0x80)
+		for len(padded)%blockSize != 0 {
+			padded = append(padded, 0x00)
+		}
+	case PaddingPKCS7:
+		n := blockSize - len(padded)%blockSize
+		padded = append(padded, bytes.Repeat([]byte{byte(n)}, n)...)
+	default:
+		for len(padded)%blockSize != 0 {
+			padded = append(padded, 0x00)
+		}
+	}
+
+	return padded
+}
+
+func unpad(value []byte, padding string) ([]byte, error) {
+	switch padding {
+	case PaddingISO9797M2:
+		idx := bytes.LastIndexByte(value, 0x80)
+		if idx < 0 || len(bytes.Trim(value[idx+1:], "\x00")) != 0 {
+			return nil, errors.New("invalid iso9797-m2 padding")
+		}
+		return value[:idx], nil
+	case PaddingPKCS7:
+		if len(value) == 0 {
+			return nil, errors.New("invalid pkcs7 padding")
+		}
+		n := int(value[len(value)-1])
+		if n == 0 || n > len(value) || !bytes.Equal(value[len(value)-n:], bytes.Repeat([]byte{byte(n)}, n)) {
+			return nil, errors.New("invalid pkcs7 padding")
+		}
+		return value[:len(value)-n], nil
+	}
+	return nil, errors.New("unsupported padding")
+}
+
+// cutZeroPadding cuts zero padded value to its clear length, trailing 0x00 bytes may belong to the value,
+// so they are kept when the length is unknown
+func cutZeroPadding(value []byte, lengths map[string]int, tag string) ([]byte, error) {
+	length, ok := lengths[tag]
+	if !ok {
+		return value, nil
+	}
+	if length > len(value) || len(bytes.Trim(value[length:], "\x00")) != 0 {
+		return nil, fmt.Errorf("invalid zero padding of tag %s", tag)
+	}
+	return value[:length], nil
+}
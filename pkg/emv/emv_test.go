@@ -0,0 +1,160 @@
+package emv
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	desCurrentKey = pkg.HexDecode("042666B49184CFA368DE9628D0397BC9")
+	aesCurrentKey = pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44")
+	aesKsn        = pkg.HexDecode("123456789012345600000001")
+
+	// amount, track 2 equivalent data, pan, expiration date inside a constructed template
+	tlvData = pkg.HexDecode(strings.Join([]string{
+		"9F0206000000001000",
+		"BF0C",
+		"27",
+		"5710", "4761739001010010D25122011234567F",
+		"5A08", "4761739001010010",
+		"5F2403", "251231",
+		"9F3602", "0001",
+	}, ""))
+)
+
+func TestEncryptTags(t *testing.T) {
+	ciphers := map[string]Cipher{
+		"des": NewDesCipher(desCurrentKey, nil, pkg.ActionRequest),
+		"aes": NewAesCipher(aesCurrentKey, aesKsn, nil, aes.KeyAES128Type, pkg.ActionRequest),
+	}
+
+	for name, cipher := range ciphers {
+		for _, padding := range []string{PaddingZero, PaddingISO9797M2, PaddingPKCS7} {
+			t.Run(name+" "+padding, func(t *testing.T) {
+				opts := Options{Padding: padding, Lengths: map[string]int{}}
+
+				encrypted, touched, err := EncryptTags(tlvData, cipher, opts)
+				require.NoError(t, err)
+				require.Equal(t, []string{"57", "5A", "5F24"}, touched)
+				require.NotEqual(t, tlvData, encrypted)
+
+				// untouched tags are kept as they are
+				require.Equal(t, tlvData[:9], encrypted[:9])
+
+				decrypted, touched, err := DecryptTags(encrypted, cipher, opts)
+				require.NoError(t, err)
+				require.Equal(t, []string{"57", "5A", "5F24"}, touched)
+				require.Equal(t, tlvData, decrypted)
+			})
+		}
+	}
+}
+
+func TestEncryptTags_Selection(t *testing.T) {
+	cipher := NewDesCipher(desCurrentKey, nil, pkg.ActionRequest)
+
+	// encrypt whole template
+	lengths := map[string]int{}
+	encrypted, touched, err := EncryptTags(tlvData, cipher, Options{Tags: []string{"bf0c"}, Lengths: lengths})
+	require.NoError(t, err)
+	require.Equal(t, []string{"BF0C"}, touched)
+	require.Equal(t, map[string]int{"BF0C": 39}, lengths)
+
+	// template value is 39 bytes, 40 bytes after zero padding
+	require.Equal(t, "BF0C28", strings.ToUpper(pkg.HexEncode(encrypted[9:12])))
+
+	decrypted, _, err := DecryptTags(encrypted, cipher, Options{Tags: []string{"BF0C"}, Lengths: lengths})
+	require.NoError(t, err)
+	require.Equal(t, tlvData, decrypted)
+}
+
+func TestEncryptTags_LongLength(t *testing.T) {
+	cipher := NewDesCipher(desCurrentKey, nil, pkg.ActionRequest)
+
+	value := strings.Repeat("AB", 126)
+	data := pkg.HexDecode("DF017E" + value)
+
+	encrypted, _, err := EncryptTags(data, cipher, Options{Tags: []string{"DF01"}, Padding: PaddingPKCS7})
+	require.NoError(t, err)
+	require.Equal(t, "DF018180", strings.ToUpper(pkg.HexEncode(encrypted[:4])))
+
+	decrypted, _, err := DecryptTags(encrypted, cipher, Options{Tags: []string{"DF01"}, Padding: PaddingPKCS7})
+	require.NoError(t, err)
+	require.Equal(t, data, decrypted)
+}
+
+func TestEncryptTags_Filler(t *testing.T) {
+	cipher := NewDesCipher(desCurrentKey, nil, pkg.ActionRequest)
+
+	// filler bytes of erased data objects (EMV Book 3 Annex B1) are kept as they are
+	data := pkg.HexDecode("00FF" + "5A084761739001010010" + "FFFF00" + "9F360200010000")
+
+	encrypted, touched, err := EncryptTags(data, cipher, Options{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"5A"}, touched)
+	require.Equal(t, "00FF5A08", strings.ToUpper(pkg.HexEncode(encrypted[:4])))
+	require.Equal(t, "FFFF009F360200010000", strings.ToUpper(pkg.HexEncode(encrypted[12:])))
+}
+
+func TestDecryptTags_ZeroPadding(t *testing.T) {
+	cipher := NewDesCipher(desCurrentKey, nil, pkg.ActionRequest)
+
+	// trailing 0x00 bytes are part of the value
+	data := pkg.HexDecode("DF0103010000")
+
+	lengths := map[string]int{}
+	encrypted, _, err := EncryptTags(data, cipher, Options{Tags: []string{"DF01"}, Lengths: lengths})
+	require.NoError(t, err)
+
+	decrypted, _, err := DecryptTags(encrypted, cipher, Options{Tags: []string{"DF01"}, Lengths: lengths})
+	require.NoError(t, err)
+	require.Equal(t, data, decrypted)
+
+	// unknown length keeps padding of the value
+	decrypted, _, err = DecryptTags(encrypted, cipher, Options{Tags: []string{"DF01"}})
+	require.NoError(t, err)
+	require.Equal(t, "DF01080100000000000000", strings.ToUpper(pkg.HexEncode(decrypted)))
+
+	_, _, err = DecryptTags(encrypted, cipher, Options{Tags: []string{"DF01"}, Lengths: map[string]int{"DF01": 9}})
+	require.EqualError(t, err, "invalid zero padding of tag DF01")
+
+	// repeated tag of another length
+	_, _, err = EncryptTags(pkg.HexDecode("DF010101DF01020101"), cipher, Options{Tags: []string{"DF01"}, Lengths: map[string]int{}})
+	require.EqualError(t, err, "tag DF01 is repeated with another length")
+}
+
+func TestEncryptTags_Errors(t *testing.T) {
+	cipher := NewDesCipher(desCurrentKey, nil, pkg.ActionRequest)
+
+	_, _, err := EncryptTags(tlvData, cipher, Options{Padding: "unknown"})
+	require.EqualError(t, err, "unsupported padding")
+
+	_, _, err = EncryptTags(pkg.HexDecode("5A08476173"), cipher, Options{})
+	require.EqualError(t, err, "tlv value exceeds data length")
+
+	_, _, err = EncryptTags(pkg.HexDecode("9F"), cipher, Options{})
+	require.EqualError(t, err, "truncated tlv tag")
+
+	_, _, err = DecryptTags(pkg.HexDecode("5A03010203"), cipher, Options{})
+	require.Error(t, err)
+}
+
+func TestParseTLV(t *testing.T) {
+	// zero padding of decrypted data is skipped
+	tlvs, err := ParseTLV(append(append([]byte(nil), tlvData...), 0x00, 0x00))
+	require.NoError(t, err)
+	require.Equal(t, []TLV{
+		{Tag: "9F02", Value: pkg.HexDecode("000000001000")},
+		{Tag: "57", Value: pkg.HexDecode("4761739001010010D25122011234567F")},
+		{Tag: "5A", Value: pkg.HexDecode("4761739001010010")},
+		{Tag: "5F24", Value: pkg.HexDecode("251231")},
+		{Tag: "9F36", Value: pkg.HexDecode("0001")},
+	}, tlvs)
+
+	_, err = ParseTLV(pkg.HexDecode("5A08476173"))
+	require.EqualError(t, err, "tlv value exceeds data length")
+}
@@ -0,0 +1,199 @@
+package emv
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// transformFunc converts value of a selected tag
+type transformFunc func(tag string, value []byte) ([]byte, error)
+
+// Walk BER-TLV data, transform values of selected tags and re-encode lengths
+//
+// NOTE:
+//   - EMV Book 3 Annex B (Rules for BER-TLV Data Objects)
+//   - filler bytes (0x00 or 0xFF) before, between and after data objects are skipped and kept as they are,
+//     they can't start a tag (ISO/IEC 7816-4 5.2.2)
+//   - selected constructed tags are transformed as a whole, other constructed tags are walked recursively
+func transformTLV(data []byte, selected map[string]bool, fn transformFunc, touched *[]string) ([]byte, error) {
+	var result []byte
+
+	for offset := 0; offset < len(data); {
+		if isFiller(data[offset]) {
+			result = append(result, data[offset])
+			offset++
+			continue
+		}
+
+		tag, next, err := readTag(data, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		length, next, err := readLength(data, next)
+		if err != nil {
+			return nil, err
+		}
+		if next+length > len(data) {
+			return nil, errors.New("tlv value exceeds data length")
+		}
+
+		value := data[next : next+length]
+		offset = next + length
+
+		tagName := strings.ToUpper(pkg.HexEncode(tag))
+		switch {
+		case selected[tagName]:
+			value, err = fn(tagName, value)
+			if err != nil {
+				return nil, err
+			}
+			*touched = append(*touched, tagName)
+		case isConstructed(tag):
+			value, err = transformTLV(value, selected, fn, touched)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		result = append(result, tag...)
+		result = appendLength(result, len(value))
+		result = append(result, value...)
+	}
+
+	return result, nil
+}
+
+func isFiller(b byte) bool {
+	return b == 0x00 || b == 0xFF
+}
+
+func isConstructed(tag []byte) bool {
+	return tag[0]&0x20 != 0
+}
+
+func readTag(data []byte, offset int) ([]byte, int, error) {
+	start := offset
+	offset++
+
+	// subsequent bytes follow when the 5 least significant bits are set
+	if data[start]&0x1F == 0x1F {
+		for {
+			if offset >= len(data) {
+				return nil, 0, errors.New("truncated tlv tag")
+			}
+			b := data[offset]
+			offset++
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+
+	return data[start:offset], offset, nil
+}
+
+func readLength(data []byte, offset int) (int, int, error) {
+	if offset >= len(data) {
+		return 0, 0, errors.New("truncated tlv length")
+	}
+
+	first := data[offset]
+	offset++
+	if first&0x80 == 0 {
+		return int(first), offset, nil
+	}
+
+	count := int(first & 0x7F)
+	if count == 0 || count > 3 {
+		return 0, 0, errors.New("unsupported tlv length")
+	}
+	if offset+count > len(data) {
+		return 0, 0, errors.New("truncated tlv length")
+	}
+
+	length := 0
+	for _, b := range data[offset : offset+count] {
+		length = length<<8 | int(b)
+	}
+
+	return length, offset + count, nil
+}
+
+func appendLength(dst []byte, length int) []byte {
+	switch {
+	case length < 0x80:
+		return append(dst, byte(length))
+	case length <= 0xFF:
+		return append(dst, 0x81, byte(length))
+	case length <= 0xFFFF:
+		return append(dst, 0x82, byte(length>>8), byte(length&0xFF))
+	default:
+		return append(dst, 0x83, byte((length>>16)&0xFF), byte((length>>8)&0xFF), byte(length&0xFF))
+	}
+}
+
+// TLV is primitive data object of BER-TLV data
+type TLV struct {
+	// Tag is hex encoded tag (for example "5A")
+	Tag string
+	// Value is value of data object
+	Value []byte
+}
+
+// Parse BER-TLV data into primitive data objects
+//
+// NOTE:
+//   - Constructed data objects are walked recursively, filler bytes (0x00 or 0xFF) are skipped
+//     (zero padding of decrypted data is skipped as well)
+//
+// Params:
+//   - data is BER-TLV encoded data
+//
+// Return Params:
+//   - result is primitive data objects in order of data
+//   - err
+func ParseTLV(data []byte) ([]TLV, error) {
+	var result []TLV
+
+	for offset := 0; offset < len(data); {
+		if isFiller(data[offset]) {
+			offset++
+			continue
+		}
+
+		tag, next, err := readTag(data, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		length, next, err := readLength(data, next)
+		if err != nil {
+			return nil, err
+		}
+		if next+length > len(data) {
+			return nil, errors.New("tlv value exceeds data length")
+		}
+
+		value := data[next : next+length]
+		offset = next + length
+
+		if isConstructed(tag) {
+			inner, err := ParseTLV(value)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, inner...)
+			continue
+		}
+
+		result = append(result, TLV{
+			Tag:   strings.ToUpper(pkg.HexEncode(tag)),
+			Value: append([]byte(nil), value...),
+		})
+	}
+
+	return result, nil
+}
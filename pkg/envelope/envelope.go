@@ -0,0 +1,415 @@
+package envelope
+
+/*
+Authenticated DUKPT message envelope: payload encrypted with data key and MAC of header and cipher text (encrypt-then-MAC)
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+)
+
+// Version is version of envelope layout
+const Version = 1
+
+const (
+	algorithmDes byte = 1
+	algorithmAes byte = 2
+
+	desBlockLen = 8
+	aesBlockLen = 16
+
+	// ISO/IEC 9797-1 padding method 2
+	paddingMark = 0x80
+)
+
+var (
+	ErrAuthentication = errors.New("envelope authentication failed")
+	errMalformed      = errors.New("malformed envelope")
+)
+
+// Key describes DUKPT transaction key sealing or opening envelopes
+type Key struct {
+	// Algorithm is des or aes
+	Algorithm string
+	// CurrentKey is transaction key of KSN
+	CurrentKey []byte
+	// KSN is key serial number of transaction key (Open rejects envelopes of another key serial number)
+	KSN []byte
+	// KeyType is AES128, AES192 or AES256 working key type of aes (default follows current key length)
+	KeyType string
+	// DataKeyMode is data key derivation mode of des (default owf)
+	DataKeyMode des.DataKeyMode
+	// Action selects request (default) or response working keys
+	Action pkg.Action
+}
+
+// Envelope is key serial number, iv, cipher text and mac of one protected payload
+//
+// NOTE:
+//   - Binary layout is version (1) | algorithm (1) | key type (1) | ksn length (1) | ksn |
+//     iv length (1) | iv | cipher text length (4) | cipher text | mac length (1) | mac
+//   - MAC covers the binary layout up to and including cipher text, for both JSON and binary forms
+type Envelope struct {
+	Version    int
+	Algorithm  string
+	KeyType    string
+	KSN        []byte
+	IV         []byte
+	Ciphertext []byte
+	MAC        []byte
+}
+
+// Seal payload into authenticated envelope
+//
+// NOTE:
+//   - IV is random, payload is padded with ISO/IEC 9797-1 padding method 2 and encrypted with data key
+//   - MAC is des retail mac (8 bytes) or aes cmac (16 bytes) of mac key over header and cipher text
+//
+// Params:
+//   - key is transaction key and key serial number
+//   - plain text is payload
+//
+// Return Params:
+//   - result is envelope
+//   - err
+func Seal(key Key, plainText []byte) (*Envelope, error) {
+	return SealWithRand(key, plainText, nil)
+}
+
+// Seal payload into authenticated envelope with IV of random source
+//
+// NOTE:
+//   - Same as Seal, IV is read from random source
+//   - A seeded random source (see pkg.NewSeededReader) makes the envelope reproducible in tests
+//
+// Params:
+//   - key is transaction key and key serial number
+//   - plain text is payload
+//   - random is random source (crypto/rand.Reader when nil)
+//
+// Return Params:
+//   - result is envelope
+//   - err
+func SealWithRand(key Key, plainText []byte, random io.Reader) (*Envelope, error) {
+	keyType, err := key.keyType()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Envelope{
+		Version:   Version,
+		Algorithm: key.Algorithm,
+		KeyType:   keyType.String(),
+		KSN:       append([]byte(nil), key.KSN...),
+		IV:        make([]byte, key.blockLen()),
+	}
+	if _, err := io.ReadFull(pkg.RandReader(random), e.IV); err != nil {
+		return nil, fmt.Errorf("generating iv: %w", err)
+	}
+
+	e.Ciphertext, err = key.encrypt(keyType, e.IV, pad(plainText, len(e.IV)))
+	if err != nil {
+		return nil, err
+	}
+
+	e.MAC, err = key.mac(keyType, e.authenticatedData())
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Open authenticated envelope and return its payload
+//
+// NOTE:
+//   - MAC is verified in constant time before the cipher text is decrypted
+//
+// Params:
+//   - key is transaction key and key serial number of envelope
+//   - e is envelope
+//
+// Return Params:
+//   - result is payload
+//   - err
+func Open(key Key, e *Envelope) ([]byte, error) {
+	if e == nil {
+		return nil, errMalformed
+	}
+
+	keyType, err := key.keyType()
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Version != Version {
+		return nil, fmt.Errorf("unsupported envelope version %d", e.Version)
+	}
+	if e.Algorithm != key.Algorithm || e.KeyType != keyType.String() {
+		return nil, errors.New("envelope algorithm mismatch")
+	}
+	if !bytes.Equal(e.KSN, key.KSN) {
+		return nil, errors.New("envelope key serial number mismatch")
+	}
+	if len(e.IV) != key.blockLen() || len(e.Ciphertext) == 0 || len(e.Ciphertext)%key.blockLen() != 0 {
+		return nil, errMalformed
+	}
+
+	mac, err := key.mac(keyType, e.authenticatedData())
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, e.MAC) {
+		return nil, ErrAuthentication
+	}
+
+	padded, err := key.decrypt(keyType, e.IV, e.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	return unpad(padded)
+}
+
+// MarshalBinary encodes envelope into compact binary layout
+func (e *Envelope) MarshalBinary() ([]byte, error) {
+	if len(e.MAC) > 0xFF {
+		return nil, errMalformed
+	}
+
+	data := e.authenticatedData()
+	if data == nil {
+		return nil, errMalformed
+	}
+
+	data = append(data, byte(len(e.MAC)))
+	return append(data, e.MAC...), nil
+}
+
+// UnmarshalBinary decodes envelope from compact binary layout
+func (e *Envelope) UnmarshalBinary(data []byte) error {
+	r := reader{data: data}
+
+	version := r.byte()
+	algorithm := r.byte()
+	keyType := r.byte()
+	ksn := r.bytes(int(r.byte()))
+	iv := r.bytes(int(r.byte()))
+	ciphertext := r.bytes(int(r.uint32()))
+	mac := r.bytes(int(r.byte()))
+
+	if r.err || len(r.data) != 0 {
+		return errMalformed
+	}
+
+	*e = Envelope{
+		Version:    int(version),
+		KSN:        ksn,
+		IV:         iv,
+		Ciphertext: ciphertext,
+		MAC:        mac,
+	}
+
+	switch algorithm {
+	case algorithmDes:
+		e.Algorithm = pkg.AlgorithmDes
+	case algorithmAes:
+		e.Algorithm = pkg.AlgorithmAes
+		e.KeyType = aes.KeyType(keyType).String()
+	default:
+		return errMalformed
+	}
+
+	return nil
+}
+
+type envelopeJSON struct {
+	Version    int    `json:"version"`
+	Algorithm  string `json:"algorithm"`
+	KeyType    string `json:"key_type,omitempty"`
+	KSN        string `json:"ksn"`
+	IV         string `json:"iv"`
+	Ciphertext string `json:"ciphertext"`
+	MAC        string `json:"mac"`
+}
+
+// MarshalJSON encodes envelope into JSON with hex encoded fields
+func (e Envelope) MarshalJSON() ([]byte, error) {
+	return json.Marshal(envelopeJSON{
+		Version:    e.Version,
+		Algorithm:  e.Algorithm,
+		KeyType:    e.KeyType,
+		KSN:        strings.ToUpper(pkg.HexEncode(e.KSN)),
+		IV:         strings.ToUpper(pkg.HexEncode(e.IV)),
+		Ciphertext: strings.ToUpper(pkg.HexEncode(e.Ciphertext)),
+		MAC:        strings.ToUpper(pkg.HexEncode(e.MAC)),
+	})
+}
+
+// UnmarshalJSON decodes envelope from JSON with hex encoded fields
+func (e *Envelope) UnmarshalJSON(data []byte) error {
+	var v envelopeJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*e = Envelope{
+		Version:    v.Version,
+		Algorithm:  v.Algorithm,
+		KeyType:    v.KeyType,
+		KSN:        pkg.HexDecode(v.KSN),
+		IV:         pkg.HexDecode(v.IV),
+		Ciphertext: pkg.HexDecode(v.Ciphertext),
+		MAC:        pkg.HexDecode(v.MAC),
+	}
+	if e.KSN == nil || e.IV == nil || e.Ciphertext == nil || e.MAC == nil {
+		return errMalformed
+	}
+
+	return nil
+}
+
+// authenticatedData returns binary layout of envelope without mac, nil when a field doesn't fit its length
+func (e *Envelope) authenticatedData() []byte {
+	if e.Version < 0 || e.Version > 0xFF || len(e.KSN) > 0xFF || len(e.IV) > 0xFF || uint64(len(e.Ciphertext)) > 0xFFFFFFFF {
+		return nil
+	}
+
+	var algorithm, keyType byte
+	switch e.Algorithm {
+	case pkg.AlgorithmDes:
+		algorithm = algorithmDes
+	case pkg.AlgorithmAes:
+		algorithm = algorithmAes
+		parsed, err := aes.ParseKeyType(e.KeyType)
+		if err != nil {
+			return nil
+		}
+		keyType = byte(parsed)
+	default:
+		return nil
+	}
+
+	data := make([]byte, 0, 9+len(e.KSN)+len(e.IV)+len(e.Ciphertext))
+	data = append(data, byte(e.Version), algorithm, keyType, byte(len(e.KSN)))
+	data = append(data, e.KSN...)
+	data = append(data, byte(len(e.IV)))
+	data = append(data, e.IV...)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(e.Ciphertext)))
+	return append(data, e.Ciphertext...)
+}
+
+func (k Key) action() pkg.Action {
+	if k.Action == 0 {
+		return pkg.Request
+	}
+	return k.Action
+}
+
+func (k Key) blockLen() int {
+	if k.Algorithm == pkg.AlgorithmAes {
+		return aesBlockLen
+	}
+	return desBlockLen
+}
+
+// keyType returns working key type of aes (zero for des)
+func (k Key) keyType() (aes.KeyType, error) {
+	switch k.Algorithm {
+	case pkg.AlgorithmDes:
+		return 0, nil
+	case pkg.AlgorithmAes:
+	default:
+		return 0, errors.New("invalid encrypt/decrypt algorithm")
+	}
+
+	if k.KeyType != "" {
+		return aes.ParseKeyType(strings.ToUpper(k.KeyType))
+	}
+
+	return aes.KeyTypeOfLength(len(k.CurrentKey))
+}
+
+func (k Key) encrypt(keyType aes.KeyType, iv, plainText []byte) ([]byte, error) {
+	if k.Algorithm == pkg.AlgorithmAes {
+		return aes.EncryptDataV2(k.CurrentKey, k.KSN, iv, plainText, keyType, k.action())
+	}
+	return des.EncryptDataWithMode(k.CurrentKey, iv, plainText, k.action(), k.DataKeyMode)
+}
+
+func (k Key) decrypt(keyType aes.KeyType, iv, ciphertext []byte) ([]byte, error) {
+	if k.Algorithm == pkg.AlgorithmAes {
+		return aes.DecryptDataV2(k.CurrentKey, k.KSN, iv, ciphertext, keyType, k.action())
+	}
+	return des.DecryptDataWithMode(k.CurrentKey, iv, ciphertext, k.action(), k.DataKeyMode)
+}
+
+func (k Key) mac(keyType aes.KeyType, data []byte) ([]byte, error) {
+	if data == nil {
+		return nil, errMalformed
+	}
+	if k.Algorithm == pkg.AlgorithmAes {
+		return aes.GenerateMacV2(k.CurrentKey, k.KSN, data, keyType, pkg.CMAC, k.action())
+	}
+	return des.GenerateMacV2(k.CurrentKey, data, k.action())
+}
+
+// pad appends ISO/IEC 9797-1 padding method 2 (0x80 followed by zeros to block length)
+func pad(data []byte, blockLen int) []byte {
+	padded := make([]byte, len(data)+blockLen-len(data)%blockLen)
+	copy(padded, data)
+	padded[len(data)] = paddingMark
+	return padded
+}
+
+func unpad(data []byte) ([]byte, error) {
+	i := len(data) - 1
+	for i >= 0 && data[i] == 0 {
+		i--
+	}
+	if i < 0 || data[i] != paddingMark {
+		return nil, errMalformed
+	}
+	return data[:i], nil
+}
+
+// reader reads fields of binary layout, err is set when data is too short
+type reader struct {
+	data []byte
+	err  bool
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err || len(r.data) < n {
+		r.err = true
+		return nil
+	}
+	out := append([]byte(nil), r.data[:n]...)
+	r.data = r.data[n:]
+	return out
+}
+
+func (r *reader) byte() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
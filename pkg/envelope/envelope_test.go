@@ -0,0 +1,122 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/des"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+	keys := map[string]Key{
+		"des": {
+			Algorithm:  pkg.AlgorithmDes,
+			CurrentKey: pkg.HexDecode("042666B49184CFA368DE9628D0397BC9"),
+			KSN:        pkg.HexDecode("FFFF9876543210E00001"),
+		},
+		"des variant": {
+			Algorithm:   pkg.AlgorithmDes,
+			CurrentKey:  pkg.HexDecode("042666B49184CFA368DE9628D0397BC9"),
+			KSN:         pkg.HexDecode("FFFF9876543210E00001"),
+			DataKeyMode: des.DataKeyVariant,
+			Action:      pkg.Response,
+		},
+		"aes": {
+			Algorithm:  pkg.AlgorithmAes,
+			CurrentKey: pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44"),
+			KSN:        pkg.HexDecode("123456789012345600000001"),
+		},
+	}
+
+	for name, key := range keys {
+		t.Run(name, func(t *testing.T) {
+			for _, payload := range [][]byte{nil, []byte("4012345678909D987"), []byte("0123456789ABCDEF")} {
+				e, err := Seal(key, payload)
+				require.NoError(t, err)
+				require.Equal(t, key.KSN, e.KSN)
+				require.Len(t, e.Ciphertext, (len(payload)/len(e.IV)+1)*len(e.IV))
+
+				opened, err := Open(key, e)
+				require.NoError(t, err)
+				require.Equal(t, string(payload), string(opened))
+
+				// binary form
+				data, err := e.MarshalBinary()
+				require.NoError(t, err)
+
+				var fromBinary Envelope
+				require.NoError(t, fromBinary.UnmarshalBinary(data))
+				require.Equal(t, *e, fromBinary)
+
+				// JSON form
+				data, err = json.Marshal(e)
+				require.NoError(t, err)
+
+				var fromJSON Envelope
+				require.NoError(t, json.Unmarshal(data, &fromJSON))
+				require.Equal(t, *e, fromJSON)
+
+				opened, err = Open(key, &fromJSON)
+				require.NoError(t, err)
+				require.Equal(t, string(payload), string(opened))
+			}
+		})
+	}
+
+	key := keys["aes"]
+	e, err := Seal(key, []byte("4012345678909D987"))
+	require.NoError(t, err)
+
+	// header and cipher text are authenticated
+	tampered := *e
+	tampered.IV = append([]byte(nil), e.IV...)
+	tampered.IV[0] ^= 0x01
+	_, err = Open(key, &tampered)
+	require.ErrorIs(t, err, ErrAuthentication)
+
+	tampered = *e
+	tampered.Ciphertext = append([]byte(nil), e.Ciphertext...)
+	tampered.Ciphertext[len(tampered.Ciphertext)-1] ^= 0x01
+	_, err = Open(key, &tampered)
+	require.ErrorIs(t, err, ErrAuthentication)
+
+	other := key
+	other.KSN = pkg.HexDecode("123456789012345600000002")
+	_, err = Open(other, e)
+	require.EqualError(t, err, "envelope key serial number mismatch")
+
+	_, err = Open(keys["des"], e)
+	require.EqualError(t, err, "envelope algorithm mismatch")
+
+	data, err := e.MarshalBinary()
+	require.NoError(t, err)
+	var truncated Envelope
+	require.EqualError(t, truncated.UnmarshalBinary(data[:len(data)-1]), "malformed envelope")
+
+	_, err = Seal(Key{Algorithm: "rsa"}, nil)
+	require.EqualError(t, err, "invalid encrypt/decrypt algorithm")
+}
+
+func TestSealWithRand(t *testing.T) {
+	key := Key{
+		Algorithm:  pkg.AlgorithmAes,
+		CurrentKey: pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44"),
+		KSN:        pkg.HexDecode("123456789012345600000001"),
+	}
+
+	first, err := SealWithRand(key, []byte("4012345678909D987"), pkg.NewSeededReader(1))
+	require.NoError(t, err)
+	second, err := SealWithRand(key, []byte("4012345678909D987"), pkg.NewSeededReader(1))
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+
+	opened, err := Open(key, first)
+	require.NoError(t, err)
+	require.Equal(t, "4012345678909D987", string(opened))
+
+	_, err = SealWithRand(key, []byte("4012345678909D987"), bytes.NewReader(nil))
+	require.EqualError(t, err, "generating iv: EOF")
+}
@@ -0,0 +1,279 @@
+package host
+
+/*
+Host side DUKPT processing: resolve base derivative key, derive keys and decrypt / verify in one call
+*/
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+	"github.com/moov-io/dukpt/pkg/device"
+)
+
+const (
+	desKsnLength = 10
+	aesKsnLength = 12
+
+	// key set identifier is left-most 5 bytes of TDES key serial number
+	desKeySetIDLength = 5
+	// bdk id is left-most 4 bytes of AES key serial number
+	aesBdkIDLength = 4
+	// initial key id is left-most 8 bytes of AES key serial number
+	aesInitialKeyIDLength = 8
+
+	minMacLength = 4
+)
+
+var ErrMacMismatch = errors.New("mac mismatch")
+
+// BDKResolver resolves base derivative key
+type BDKResolver interface {
+	// ResolveBDK returns base derivative key of algorithm (des or aes) and id
+	// (5 bytes key set identifier for des, 4 bytes bdk id for aes)
+	ResolveBDK(algorithm string, id []byte) ([]byte, error)
+}
+
+// BDKResolverFunc is a function implementing BDKResolver
+type BDKResolverFunc func(algorithm string, id []byte) ([]byte, error)
+
+func (f BDKResolverFunc) ResolveBDK(algorithm string, id []byte) ([]byte, error) {
+	return f(algorithm, id)
+}
+
+// IKCache stores derived initial keys by initial key id
+type IKCache interface {
+	Get(id string) ([]byte, bool)
+	Put(id string, ik []byte)
+}
+
+type ikCacheInMemory struct {
+	mtx  sync.RWMutex
+	keys map[string][]byte
+}
+
+// NewIKCacheInMemory is an in memory storage of initial keys
+func NewIKCacheInMemory() IKCache {
+	return &ikCacheInMemory{
+		keys: make(map[string][]byte),
+	}
+}
+
+func (c *ikCacheInMemory) Get(id string) ([]byte, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	ik, ok := c.keys[id]
+	return ik, ok
+}
+
+func (c *ikCacheInMemory) Put(id string, ik []byte) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.keys[id] = ik
+}
+
+// Host decrypts and verifies terminal data, the algorithm is chosen by key serial number length
+// (10 bytes for TDES DUKPT, 12 bytes for AES DUKPT)
+type Host struct {
+	// PinFormat is TDES pin block format (default ISO-0)
+	PinFormat string
+	// MacType is AES mac type, cmac or hmac (default cmac)
+	MacType string
+
+	resolver BDKResolver
+	cache    IKCache
+}
+
+// NewHost creates host with bdk resolver and optional initial key cache (nil disables caching)
+func NewHost(resolver BDKResolver, cache IKCache) *Host {
+	return &Host{
+		PinFormat: "ISO-0",
+		MacType:   pkg.MaxTypeCmac,
+		resolver:  resolver,
+		cache:     cache,
+	}
+}
+
+// DecryptPin decrypts terminal PIN block
+func (h *Host) DecryptPin(ksn, block []byte, pan string) (string, error) {
+	algorithm, tk, err := h.transactionKey(ksn)
+	if err != nil {
+		return "", err
+	}
+
+	if algorithm == pkg.AlgorithmAes {
+		keyType, err := aes.KeyTypeOfLength(len(tk))
+		if err != nil {
+			return "", err
+		}
+		return aes.DecryptPin(tk, ksn, block, pan, keyType.String())
+	}
+	return des.DecryptPin(tk, block, pan, h.PinFormat)
+}
+
+// VerifyMac verifies terminal request MAC, mac may be truncated to its left-most bytes (at least 4 bytes)
+func (h *Host) VerifyMac(ksn, msg, mac []byte) error {
+	algorithm, tk, err := h.transactionKey(ksn)
+	if err != nil {
+		return err
+	}
+
+	var expected []byte
+	if algorithm == pkg.AlgorithmAes {
+		keyType, err := aes.KeyTypeOfLength(len(tk))
+		if err != nil {
+			return err
+		}
+
+		switch h.MacType {
+		case pkg.MaxTypeCmac:
+			expected, err = aes.GenerateCMAC(tk, ksn, string(msg), keyType.String(), pkg.ActionRequest)
+		case pkg.MaxTypeHmac:
+			expected, err = aes.GenerateHMAC(tk, ksn, string(msg), keyType.HMAC().String(), pkg.ActionRequest)
+		default:
+			return errors.New("invalid mac type")
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		expected, err = des.GenerateMac(tk, string(msg), pkg.ActionRequest)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(mac) < minMacLength || len(mac) > len(expected) {
+		return fmt.Errorf("mac length must be between %d and %d bytes", minMacLength, len(expected))
+	}
+
+	if subtle.ConstantTimeCompare(expected[:len(mac)], mac) != 1 {
+		return ErrMacMismatch
+	}
+
+	return nil
+}
+
+// DecryptData decrypts terminal request data
+func (h *Host) DecryptData(ksn, ciphertext, iv []byte) ([]byte, error) {
+	algorithm, tk, err := h.transactionKey(ksn)
+	if err != nil {
+		return nil, err
+	}
+
+	var plaintext string
+	if algorithm == pkg.AlgorithmAes {
+		keyType, err := aes.KeyTypeOfLength(len(tk))
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err = aes.DecryptData(tk, ksn, ciphertext, iv, keyType.String(), pkg.ActionRequest)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		plaintext, err = des.DecryptData(tk, ciphertext, iv, pkg.ActionRequest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []byte(plaintext), nil
+}
+
+// DecryptFrame decrypts tracks of card reader frame with transaction key of frame's key serial number
+func (h *Host) DecryptFrame(frame *device.Frame, usage device.KeyUsage) (*device.Tracks, error) {
+	if frame == nil {
+		return nil, errors.New("nil device frame")
+	}
+
+	algorithm, tk, err := h.transactionKey(frame.KSN)
+	if err != nil {
+		return nil, err
+	}
+	if algorithm != frame.Algorithm {
+		return nil, errors.New("key serial number doesn't match frame algorithm")
+	}
+
+	return frame.Decrypt(tk, usage)
+}
+
+func (h *Host) transactionKey(ksn []byte) (string, []byte, error) {
+	var algorithm string
+	var bdkID, ikID []byte
+
+	switch len(ksn) {
+	case desKsnLength:
+		algorithm = pkg.AlgorithmDes
+		bdkID = ksn[:desKeySetIDLength]
+
+		// initial key id is key serial number without transaction counter (21 bits)
+		ikID = make([]byte, desKsnLength)
+		copy(ikID, ksn)
+		ikID[7] &= 0xE0
+		ikID[8] = 0
+		ikID[9] = 0
+	case aesKsnLength:
+		algorithm = pkg.AlgorithmAes
+		bdkID = ksn[:aesBdkIDLength]
+		ikID = ksn[:aesInitialKeyIDLength]
+	default:
+		return "", nil, fmt.Errorf("key serial number length must be %d or %d bytes", desKsnLength, aesKsnLength)
+	}
+
+	ik, err := h.initialKey(algorithm, bdkID, ikID, ksn)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var tk []byte
+	if algorithm == pkg.AlgorithmAes {
+		tk, err = aes.DeriveCurrentTransactionKey(ik, ksn)
+	} else {
+		tk, err = des.DeriveCurrentTransactionKey(ik, ksn)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	return algorithm, tk, nil
+}
+
+func (h *Host) initialKey(algorithm string, bdkID, ikID, ksn []byte) ([]byte, error) {
+	cacheID := algorithm + ":" + pkg.HexEncode(ikID)
+	if h.cache != nil {
+		if ik, ok := h.cache.Get(cacheID); ok {
+			return ik, nil
+		}
+	}
+
+	if h.resolver == nil {
+		return nil, errors.New("nil bdk resolver")
+	}
+
+	bdk, err := h.resolver.ResolveBDK(algorithm, bdkID)
+	if err != nil {
+		return nil, fmt.Errorf("resolving bdk: %w", err)
+	}
+
+	var ik []byte
+	if algorithm == pkg.AlgorithmAes {
+		ik, err = aes.DerivationOfInitialKey(bdk, ksn)
+	} else {
+		ik, err = des.DerivationOfInitialKey(bdk, ksn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if h.cache != nil {
+		h.cache.Put(cacheID, ik)
+	}
+
+	return ik, nil
+}
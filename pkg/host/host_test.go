@@ -0,0 +1,137 @@
+package host
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/des"
+	"github.com/moov-io/dukpt/pkg/device"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	desBdk = pkg.HexDecode("0123456789ABCDEFFEDCBA9876543210")
+	aesBdk = pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1")
+)
+
+type countingResolver struct {
+	calls int
+}
+
+func (r *countingResolver) ResolveBDK(algorithm string, id []byte) ([]byte, error) {
+	r.calls++
+	switch {
+	case algorithm == pkg.AlgorithmDes && bytes.Equal(id, pkg.HexDecode("FFFF987654")):
+		return desBdk, nil
+	case algorithm == pkg.AlgorithmAes && bytes.Equal(id, pkg.HexDecode("12345678")):
+		return aesBdk, nil
+	}
+	return nil, errors.New("unknown bdk")
+}
+
+func TestHost_DecryptPin(t *testing.T) {
+	h := NewHost(&countingResolver{}, nil)
+
+	pin, err := h.DecryptPin(pkg.HexDecode("FFFF9876543210E00001"), pkg.HexDecode("1B9C1845EB993A7A"), "4012345678909")
+	require.NoError(t, err)
+	require.Equal(t, "1234", pin)
+
+	pin, err = h.DecryptPin(pkg.HexDecode("FFFF9876543210E00002"), pkg.HexDecode("10A01C8D02C69107"), "4012345678909")
+	require.NoError(t, err)
+	require.Equal(t, "1234", pin)
+
+	pin, err = h.DecryptPin(pkg.HexDecode("123456789012345600000001"), pkg.HexDecode("1dd48e0fc64d89836fa3b71cf4aa3783"), "4111111111111111")
+	require.NoError(t, err)
+	require.Equal(t, "1234", pin)
+}
+
+func TestHost_VerifyMac(t *testing.T) {
+	h := NewHost(&countingResolver{}, nil)
+	data := []byte("4012345678909D987")
+
+	desKsn := pkg.HexDecode("FFFF9876543210E00001")
+	require.NoError(t, h.VerifyMac(desKsn, data, pkg.HexDecode("9CCC78173FC4FB64")))
+	require.NoError(t, h.VerifyMac(desKsn, data, pkg.HexDecode("9CCC7817")))
+	require.ErrorIs(t, h.VerifyMac(desKsn, data, pkg.HexDecode("9CCC7818")), ErrMacMismatch)
+	require.Error(t, h.VerifyMac(desKsn, data, pkg.HexDecode("9CCC")))
+
+	aesKsn := pkg.HexDecode("123456789012345600000001")
+	require.NoError(t, h.VerifyMac(aesKsn, data, pkg.HexDecode("A2EB5C1C35809E58404E873C3C411E31")))
+	require.ErrorIs(t, h.VerifyMac(aesKsn, []byte("tampered"), pkg.HexDecode("A2EB5C1C35809E58")), ErrMacMismatch)
+
+	h.MacType = pkg.MaxTypeHmac
+	require.NoError(t, h.VerifyMac(aesKsn, data, pkg.HexDecode("B6F8B3159CD4E140159DA87A68C0FB7AF2F123D222662E98988C76386E8E8A02")))
+}
+
+func TestHost_DecryptData(t *testing.T) {
+	h := NewHost(&countingResolver{}, nil)
+
+	data, err := h.DecryptData(pkg.HexDecode("FFFF9876543210E00001"), pkg.HexDecode("FC0D53B7EA1FDA9EE68AAF2E70D9B9506229BE2AA993F04F"), nil)
+	require.NoError(t, err)
+	require.Equal(t, "4012345678909D987", string(bytes.TrimRight(data, "\x00")))
+
+	data, err = h.DecryptData(pkg.HexDecode("123456789012345600000001"), pkg.HexDecode("E5AFA5B408A3310E3D779C8A9A2AE29448BD5B4232582090DB703AF647205A79"), nil)
+	require.NoError(t, err)
+	require.Equal(t, "4012345678909D987", string(bytes.TrimRight(data, "\x00")))
+}
+
+func TestHost_DecryptFrame(t *testing.T) {
+	h := NewHost(&countingResolver{}, nil)
+
+	track := ";4012345678909=25121011234567890?"
+	tk := pkg.HexDecode("042666B49184CFA368DE9628D0397BC9")
+	encrypted, err := des.EncryptDataWithMode(tk, nil, []byte(track), pkg.Request, des.DataKeyOWF)
+	require.NoError(t, err)
+
+	frame := &device.Frame{
+		Algorithm: pkg.AlgorithmDes,
+		KSN:       pkg.HexDecode("FFFF9876543210E00001"),
+		KeyUsage:  device.KeyUsageData,
+	}
+	frame.Encrypted[1] = encrypted
+	frame.Lengths[1] = len(track)
+
+	tracks, err := h.DecryptFrame(frame, device.KeyUsageDefault)
+	require.NoError(t, err)
+	require.Equal(t, "4012345678909", tracks.Track2.PAN)
+
+	frame.Algorithm = pkg.AlgorithmAes
+	_, err = h.DecryptFrame(frame, device.KeyUsageDefault)
+	require.EqualError(t, err, "key serial number doesn't match frame algorithm")
+}
+
+func TestHost_IKCache(t *testing.T) {
+	resolver := &countingResolver{}
+	h := NewHost(resolver, NewIKCacheInMemory())
+
+	_, err := h.DecryptPin(pkg.HexDecode("FFFF9876543210E00001"), pkg.HexDecode("1B9C1845EB993A7A"), "4012345678909")
+	require.NoError(t, err)
+	_, err = h.DecryptPin(pkg.HexDecode("FFFF9876543210E00002"), pkg.HexDecode("10A01C8D02C69107"), "4012345678909")
+	require.NoError(t, err)
+	require.Equal(t, 1, resolver.calls)
+
+	// without cache the bdk is resolved for every call
+	resolver = &countingResolver{}
+	h = NewHost(resolver, nil)
+	_, err = h.DecryptPin(pkg.HexDecode("FFFF9876543210E00001"), pkg.HexDecode("1B9C1845EB993A7A"), "4012345678909")
+	require.NoError(t, err)
+	_, err = h.DecryptPin(pkg.HexDecode("FFFF9876543210E00002"), pkg.HexDecode("10A01C8D02C69107"), "4012345678909")
+	require.NoError(t, err)
+	require.Equal(t, 2, resolver.calls)
+}
+
+func TestHost_Errors(t *testing.T) {
+	h := NewHost(&countingResolver{}, nil)
+
+	_, err := h.DecryptPin(pkg.HexDecode("FFFF9876543210E0"), pkg.HexDecode("1B9C1845EB993A7A"), "4012345678909")
+	require.EqualError(t, err, "key serial number length must be 10 or 12 bytes")
+
+	_, err = h.DecryptPin(pkg.HexDecode("AAAA9876543210E00001"), pkg.HexDecode("1B9C1845EB993A7A"), "4012345678909")
+	require.EqualError(t, err, "resolving bdk: unknown bdk")
+
+	h.MacType = "unknown"
+	err = h.VerifyMac(pkg.HexDecode("123456789012345600000001"), []byte("data"), pkg.HexDecode("A2EB5C1C"))
+	require.EqualError(t, err, "invalid mac type")
+}
@@ -0,0 +1,445 @@
+package pkcs11
+
+/*
+PKCS#11 key provider: AES DUKPT keys are derived and used inside of a PKCS#11 token (e.g. SoftHSM or a network HSM)
+*/
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/moov-io/dukpt"
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/pin"
+)
+
+const (
+	aesBlockLen = 16
+
+	handleSeparator  = "/"
+	handleInitialKey = "ik:"
+	handleTransKey   = "tk:"
+)
+
+var (
+	errUnsupportedAlgorithm = errors.New("pkcs11 provider supports only aes dukpt")
+	errUnsupportedKeyType   = errors.New("pkcs11 provider supports only aes and hmac working keys")
+	errInvalidHandle        = errors.New("invalid key handle")
+)
+
+// object is key of token
+type object uint
+
+// token is the PKCS#11 operations used by the provider, keys never leave the token
+type token interface {
+	// findKey returns secret key of label and its length in bytes
+	findKey(label string) (object, int, error)
+	// deriveKey encrypts data with base key in ECB mode (CKM_AES_ECB_ENCRYPT_DATA), derived key is leftmost length bytes
+	// of the result, it is an aes key or a generic secret of hmac
+	deriveKey(base object, data []byte, length int, hmac bool) (object, error)
+	// destroy removes derived key
+	destroy(key object) error
+	encryptECB(key object, data []byte) ([]byte, error)
+	decryptECB(key object, data []byte) ([]byte, error)
+	encryptCBC(key object, iv, data []byte) ([]byte, error)
+	decryptCBC(key object, iv, data []byte) ([]byte, error)
+	cmac(key object, data []byte) ([]byte, error)
+	hmacSHA256(key object, data []byte) ([]byte, error)
+}
+
+// Provider is dukpt.KeyProvider of keys held by a PKCS#11 token
+//
+// NOTE:
+//   - Only AES DUKPT is supported (TDES DUKPT variants need xor derivation, which tokens rarely offer)
+//   - Working keys are AES or HMAC keys, X9.24-3 TDES2 and TDES3 working keys aren't supported (token derives AES keys)
+//   - Handle of a token key (base derivative key or initial key) is its label, handles of derived keys
+//     append derivation steps to it, e.g. "bdk/ik:1234567890123456/tk:123456789012345600000001"
+//   - Derived keys are session objects, they are derived again for every operation and destroyed after it
+type Provider struct {
+	mu    sync.Mutex
+	token token
+	close func() error
+}
+
+func newProvider(t token, close func() error) *Provider {
+	return &Provider{token: t, close: close}
+}
+
+// Close logs out and closes the token session
+func (p *Provider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.close == nil {
+		return nil
+	}
+	err := p.close()
+	p.close = nil
+	return err
+}
+
+// handle is parsed key handle
+type handle struct {
+	label string
+	ikID  []byte
+	ksn   []byte
+}
+
+func parseHandle(h dukpt.KeyHandle) (handle, error) {
+	parts := strings.Split(string(h), handleSeparator)
+
+	parsed := handle{label: parts[0]}
+	if parsed.label == "" || len(parts) > 3 {
+		return parsed, errInvalidHandle
+	}
+
+	for i, part := range parts[1:] {
+		switch {
+		case i == 0 && strings.HasPrefix(part, handleInitialKey):
+			parsed.ikID = pkg.HexDecode(strings.TrimPrefix(part, handleInitialKey))
+			if parsed.ikID == nil {
+				return parsed, errInvalidHandle
+			}
+		case parsed.ksn == nil && strings.HasPrefix(part, handleTransKey):
+			parsed.ksn = pkg.HexDecode(strings.TrimPrefix(part, handleTransKey))
+			if parsed.ksn == nil {
+				return parsed, errInvalidHandle
+			}
+		default:
+			return parsed, errInvalidHandle
+		}
+	}
+	return parsed, nil
+}
+
+// resolve derives key of handle from token key, release destroys derived keys
+func (p *Provider) resolve(h handle) (key object, keyLen int, release func(), err error) {
+	key, keyLen, err = p.token.findKey(h.label)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	var derived []object
+	release = func() {
+		for _, o := range derived {
+			p.token.destroy(o)
+		}
+	}
+
+	var steps [][]byte
+	if h.ikID != nil {
+		data, err := aes.InitialKeyDerivationData(keyLen, h.ikID)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		steps = append(steps, data)
+	}
+	if h.ksn != nil {
+		data, err := aes.TransactionKeyDerivationData(keyLen, h.ksn)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		steps = append(steps, data...)
+	}
+
+	for _, data := range steps {
+		next, err := p.token.deriveKey(key, data, keyLen, false)
+		if err != nil {
+			release()
+			return 0, 0, nil, fmt.Errorf("deriving key: %w", err)
+		}
+
+		// only the latest intermediate key is kept
+		release()
+		derived = []object{next}
+		key = next
+	}
+
+	return key, keyLen, release, nil
+}
+
+// workingKey derives working key of usage from transaction key
+func (p *Provider) workingKey(tk dukpt.KeyHandle, ksn []byte, usage string, o *dukpt.Options) (object, func(), error) {
+	h, err := parseHandle(tk)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	key, keyLen, release, err := p.resolve(h)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer release()
+
+	keyTypeOf := o.AESKeyType
+	if usage == pkg.UsageMac {
+		keyTypeOf = o.AESMacKeyType
+	}
+	keyType, err := keyTypeOf(keyLen)
+	if err != nil {
+		return 0, nil, err
+	}
+	if keyType == aes.TDES2 || keyType == aes.TDES3 {
+		return 0, nil, errUnsupportedKeyType
+	}
+
+	data, err := aes.WorkingKeyDerivationData(keyLen, ksn, usage, keyType, o.Action)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	workingKey, err := p.token.deriveKey(key, data, keyTypeLength(keyType), usage == pkg.UsageMac && o.MacType == pkg.HMAC)
+	if err != nil {
+		return 0, nil, fmt.Errorf("deriving working key: %w", err)
+	}
+	return workingKey, func() { p.token.destroy(workingKey) }, nil
+}
+
+func keyTypeLength(keyType aes.KeyType) int {
+	switch keyType {
+	case aes.AES192, aes.HMAC192:
+		return 24
+	case aes.AES256, aes.HMAC256:
+		return 32
+	}
+	return 16
+}
+
+func options(ksn []byte, opts []dukpt.Option) (*dukpt.Options, error) {
+	o, err := dukpt.ResolveOptions(ksn, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if o.Algorithm != pkg.AlgorithmAes {
+		return nil, errUnsupportedAlgorithm
+	}
+	return o, nil
+}
+
+// DeriveIK returns handle of initial key derived from base derivative key in token
+func (p *Provider) DeriveIK(bdk dukpt.KeyHandle, ksn []byte, opts ...dukpt.Option) (dukpt.KeyHandle, error) {
+	if _, err := options(ksn, opts); err != nil {
+		return "", err
+	}
+
+	h, err := parseHandle(bdk)
+	if err != nil {
+		return "", err
+	}
+	if h.ikID != nil || h.ksn != nil {
+		return "", errors.New("base derivative key handle must be label of token key")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, keyLen, err := p.token.findKey(h.label)
+	if err != nil {
+		return "", err
+	}
+
+	// initial key id is validated before the handle is returned
+	if _, err := aes.InitialKeyDerivationData(keyLen, ksn); err != nil {
+		return "", err
+	}
+
+	return dukpt.KeyHandle(h.label + handleSeparator + handleInitialKey + pkg.HexEncode(ksn[:8])), nil
+}
+
+// DeriveTransactionKey returns handle of transaction key derived from initial key (token key or derived initial key)
+func (p *Provider) DeriveTransactionKey(ik dukpt.KeyHandle, ksn []byte, opts ...dukpt.Option) (dukpt.KeyHandle, error) {
+	if _, err := options(ksn, opts); err != nil {
+		return "", err
+	}
+
+	h, err := parseHandle(ik)
+	if err != nil {
+		return "", err
+	}
+	if h.ksn != nil {
+		return "", errors.New("initial key handle must not be transaction key")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// initial key is derived once, so a missing token key is reported here
+	_, _, release, err := p.resolve(h)
+	if err != nil {
+		return "", err
+	}
+	release()
+
+	return dukpt.KeyHandle(string(ik) + handleSeparator + handleTransKey + pkg.HexEncode(ksn)), nil
+}
+
+// EncryptPin encrypts ISO 9564-1 format 4 PIN block with pin key of transaction key
+func (p *Provider) EncryptPin(tk dukpt.KeyHandle, ksn, pinDigits, pan []byte, opts ...dukpt.Option) ([]byte, error) {
+	o, err := options(ksn, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pinField, err := pin.ISO4PinField(string(pinDigits), o.Rand)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pinField)
+
+	panField, err := pin.ISO4PanField(string(pan))
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, release, err := p.workingKey(tk, ksn, pkg.UsagePin, o)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	block, err := p.token.encryptECB(key, pinField)
+	if err != nil {
+		return nil, err
+	}
+	for i := range block {
+		block[i] ^= panField[i]
+	}
+	return p.token.encryptECB(key, block)
+}
+
+// DecryptPin decrypts ISO 9564-1 format 4 PIN block with pin key of transaction key
+func (p *Provider) DecryptPin(tk dukpt.KeyHandle, ksn, ciphertext, pan []byte, opts ...dukpt.Option) ([]byte, error) {
+	o, err := options(ksn, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) != aesBlockLen {
+		return nil, fmt.Errorf("pin block length must be %d bytes", aesBlockLen)
+	}
+
+	panField, err := pin.ISO4PanField(string(pan))
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, release, err := p.workingKey(tk, ksn, pkg.UsagePin, o)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	block, err := p.token.decryptECB(key, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	for i := range block {
+		block[i] ^= panField[i]
+	}
+
+	pinField, err := p.token.decryptECB(key, block)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pinField)
+
+	fields, err := pin.DecodeBlock(pinField, "")
+	if err != nil {
+		return nil, err
+	}
+	if fields.Format != "ISO-4" {
+		return nil, errors.New("invalid pin block format")
+	}
+	return []byte(fields.Pin), nil
+}
+
+// MAC generates CMAC or HMAC with mac key of transaction key
+func (p *Provider) MAC(tk dukpt.KeyHandle, ksn, msg []byte, opts ...dukpt.Option) ([]byte, error) {
+	o, err := options(ksn, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.MacType.Validate(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, release, err := p.workingKey(tk, ksn, pkg.UsageMac, o)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if o.MacType == pkg.HMAC {
+		return p.token.hmacSHA256(key, msg)
+	}
+	return p.token.cmac(key, msg)
+}
+
+// EncryptData encrypts data in CBC mode with data key of transaction key, data is padded with zeros
+func (p *Provider) EncryptData(tk dukpt.KeyHandle, ksn, iv, plaintext []byte, opts ...dukpt.Option) ([]byte, error) {
+	o, err := options(ksn, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, release, err := p.workingKey(tk, ksn, pkg.UsageData, o)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if len(plaintext) == 0 {
+		return nil, nil
+	}
+	return p.token.encryptCBC(key, ivBlock(iv), zeroPad(plaintext))
+}
+
+// DecryptData decrypts data in CBC mode with data key of transaction key
+func (p *Provider) DecryptData(tk dukpt.KeyHandle, ksn, iv, ciphertext []byte, opts ...dukpt.Option) ([]byte, error) {
+	o, err := options(ksn, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, release, err := p.workingKey(tk, ksn, pkg.UsageData, o)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if len(ciphertext) == 0 {
+		return nil, nil
+	}
+	return p.token.decryptCBC(key, ivBlock(iv), zeroPad(ciphertext))
+}
+
+// ivBlock is iv padded with zeros or truncated to aes block length, nil iv is zero iv
+func ivBlock(iv []byte) []byte {
+	block := make([]byte, aesBlockLen)
+	copy(block, iv)
+	return block
+}
+
+func zeroPad(data []byte) []byte {
+	size := (len(data) + aesBlockLen - 1) / aesBlockLen * aesBlockLen
+	padded := make([]byte, size)
+	copy(padded, data)
+	return padded
+}
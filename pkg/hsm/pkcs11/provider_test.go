@@ -0,0 +1,225 @@
+package pkcs11
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/chmike/cmac-go"
+	"github.com/moov-io/dukpt"
+	"github.com/moov-io/dukpt/pkg"
+	dukptaes "github.com/moov-io/dukpt/pkg/aes"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeToken is in-memory token of clear keys
+type fakeToken struct {
+	keys   map[object][]byte
+	labels map[string]object
+	next   object
+}
+
+func newFakeToken() *fakeToken {
+	return &fakeToken{keys: map[object][]byte{}, labels: map[string]object{}}
+}
+
+func (t *fakeToken) add(key []byte) object {
+	t.next++
+	t.keys[t.next] = key
+	return t.next
+}
+
+func (t *fakeToken) findKey(label string) (object, int, error) {
+	o, ok := t.labels[label]
+	if !ok {
+		return 0, 0, fmt.Errorf("key %s not found", label)
+	}
+	return o, len(t.keys[o]), nil
+}
+
+func (t *fakeToken) deriveKey(base object, data []byte, length int, hmac bool) (object, error) {
+	derived, err := t.encryptECB(base, data)
+	if err != nil {
+		return 0, err
+	}
+	return t.add(derived[:length]), nil
+}
+
+func (t *fakeToken) destroy(key object) error {
+	delete(t.keys, key)
+	return nil
+}
+
+func (t *fakeToken) block(key object) (cipher.Block, error) {
+	k, ok := t.keys[key]
+	if !ok {
+		return nil, fmt.Errorf("object %d not found", key)
+	}
+	return aes.NewCipher(k)
+}
+
+func (t *fakeToken) encryptECB(key object, data []byte) ([]byte, error) {
+	b, err := t.block(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	for i := 0; i < len(data); i += aes.BlockSize {
+		b.Encrypt(out[i:], data[i:])
+	}
+	return out, nil
+}
+
+func (t *fakeToken) decryptECB(key object, data []byte) ([]byte, error) {
+	b, err := t.block(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	for i := 0; i < len(data); i += aes.BlockSize {
+		b.Decrypt(out[i:], data[i:])
+	}
+	return out, nil
+}
+
+func (t *fakeToken) encryptCBC(key object, iv, data []byte) ([]byte, error) {
+	b, err := t.block(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(b, iv).CryptBlocks(out, data)
+	return out, nil
+}
+
+func (t *fakeToken) decryptCBC(key object, iv, data []byte) ([]byte, error) {
+	b, err := t.block(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(b, iv).CryptBlocks(out, data)
+	return out, nil
+}
+
+func (t *fakeToken) cmac(key object, data []byte) ([]byte, error) {
+	cm, err := cmac.New(aes.NewCipher, t.keys[key])
+	if err != nil {
+		return nil, err
+	}
+	cm.Write(data)
+	return cm.Sum(nil), nil
+}
+
+func (t *fakeToken) hmacSHA256(key object, data []byte) ([]byte, error) {
+	h := hmac.New(sha256.New, t.keys[key])
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+func TestProvider(t *testing.T) {
+	bdk := pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1")
+	ksn := pkg.HexDecode("123456789012345600000001")
+	pan := []byte("4111111111111111")
+
+	token := newFakeToken()
+	token.labels["bdk"] = token.add(bdk)
+	provider := newProvider(token, nil)
+
+	ikHandle, err := provider.DeriveIK("bdk", ksn)
+	require.NoError(t, err)
+	require.Equal(t, dukpt.KeyHandle("bdk/ik:1234567890123456"), ikHandle)
+
+	tkHandle, err := provider.DeriveTransactionKey(ikHandle, ksn)
+	require.NoError(t, err)
+	require.Equal(t, dukpt.KeyHandle("bdk/ik:1234567890123456/tk:123456789012345600000001"), tkHandle)
+
+	ik, err := dukpt.DeriveIK(bdk, ksn)
+	require.NoError(t, err)
+	tk, err := dukpt.DeriveTransactionKey(ik, ksn)
+	require.NoError(t, err)
+	require.Equal(t, "4f21b565bad9835e112b6465635eae44", pkg.HexEncode(tk))
+
+	t.Run("pin", func(t *testing.T) {
+		encrypted, err := provider.EncryptPin(tkHandle, ksn, []byte("1234"), pan, dukpt.WithRand(pkg.NewSeededReader(7)))
+		require.NoError(t, err)
+
+		expected, err := dukpt.EncryptPin(tk, ksn, []byte("1234"), pan, dukpt.WithRand(pkg.NewSeededReader(7)))
+		require.NoError(t, err)
+		require.Equal(t, expected, encrypted)
+
+		decrypted, err := provider.DecryptPin(tkHandle, ksn, encrypted, pan)
+		require.NoError(t, err)
+		require.Equal(t, "1234", string(decrypted))
+
+		_, err = provider.EncryptPin(tkHandle, ksn, []byte("12"), pan)
+		require.Error(t, err)
+	})
+
+	t.Run("mac", func(t *testing.T) {
+		msg := []byte("4012345678909D987")
+		for _, macType := range []pkg.MacType{pkg.CMAC, pkg.HMAC} {
+			mac, err := provider.MAC(tkHandle, ksn, msg, dukpt.WithMacType(macType))
+			require.NoError(t, err)
+
+			expected, err := dukpt.MAC(tk, ksn, msg, dukpt.WithMacType(macType))
+			require.NoError(t, err)
+			require.Equal(t, expected, mac)
+		}
+	})
+
+	t.Run("data", func(t *testing.T) {
+		plaintext := []byte("4012345678909D987")
+		for _, action := range []pkg.Action{pkg.Request, pkg.Response} {
+			encrypted, err := provider.EncryptData(tkHandle, ksn, nil, plaintext, dukpt.WithAction(action))
+			require.NoError(t, err)
+
+			expected, err := dukpt.EncryptData(tk, ksn, nil, plaintext, dukpt.WithAction(action))
+			require.NoError(t, err)
+			require.Equal(t, expected, encrypted)
+
+			decrypted, err := provider.DecryptData(tkHandle, ksn, nil, encrypted, dukpt.WithAction(action))
+			require.NoError(t, err)
+
+			expected, err = dukpt.DecryptData(tk, ksn, nil, encrypted, dukpt.WithAction(action))
+			require.NoError(t, err)
+			require.Equal(t, expected, decrypted)
+		}
+	})
+
+	t.Run("derived keys are destroyed", func(t *testing.T) {
+		require.Len(t, token.keys, 1)
+	})
+
+	t.Run("invalid handles", func(t *testing.T) {
+		_, err := provider.DeriveIK("unknown", ksn)
+		require.EqualError(t, err, "key unknown not found")
+
+		_, err = provider.DeriveIK(ikHandle, ksn)
+		require.Error(t, err)
+
+		_, err = provider.DeriveTransactionKey(tkHandle, ksn)
+		require.Error(t, err)
+
+		_, err = provider.MAC("bdk/tk:XYZ", ksn, []byte("msg"))
+		require.ErrorIs(t, err, errInvalidHandle)
+	})
+
+	t.Run("des dukpt", func(t *testing.T) {
+		_, err := provider.DeriveIK("bdk", pkg.HexDecode("FFFF9876543210E00001"))
+		require.ErrorIs(t, err, errUnsupportedAlgorithm)
+	})
+
+	t.Run("tdes working keys", func(t *testing.T) {
+		_, err := provider.MAC(tkHandle, ksn, []byte("msg"), dukpt.WithKeyType(dukptaes.TDES2))
+		require.ErrorIs(t, err, errUnsupportedKeyType)
+
+		_, err = provider.EncryptData(tkHandle, ksn, nil, []byte("msg"), dukpt.WithKeyType(dukptaes.TDES3))
+		require.ErrorIs(t, err, errUnsupportedKeyType)
+	})
+
+	require.NoError(t, provider.Close())
+}
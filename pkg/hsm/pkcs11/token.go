@@ -0,0 +1,222 @@
+//go:build cgo
+
+package pkcs11
+
+/*
+#include <stdlib.h>
+
+// CK_KEY_DERIVATION_STRING_DATA of PKCS#11 (mechanism parameter of CKM_AES_ECB_ENCRYPT_DATA)
+typedef struct {
+	unsigned char *pData;
+	unsigned long ulLen;
+} derivation_string_data;
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/miekg/pkcs11"
+)
+
+// session is token of PKCS#11 session
+type session struct {
+	ctx *pkcs11.Ctx
+	sh  pkcs11.SessionHandle
+}
+
+// New opens session of PKCS#11 token and creates provider of its keys
+//
+// NOTE:
+//   - Base derivative keys (or initial keys) are AES secret keys of the token, found by CKA_LABEL
+//   - The keys must allow CKA_DERIVE, derived keys are session objects
+//
+// Params:
+//   - module is path of PKCS#11 library (e.g. /usr/lib/softhsm/libsofthsm2.so)
+//   - token label is label of token
+//   - pin is user pin of token
+//
+// Return Params:
+//   - result is key provider, it should be closed
+//   - err
+func New(module, tokenLabel, pin string) (*Provider, error) {
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return nil, fmt.Errorf("loading pkcs11 module %s", module)
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("initializing pkcs11 module: %w", err)
+	}
+
+	s, err := openSession(ctx, tokenLabel, pin)
+	if err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+
+	return newProvider(s, func() error {
+		ctx.Logout(s.sh)
+		err := ctx.CloseSession(s.sh)
+		ctx.Finalize()
+		ctx.Destroy()
+		return err
+	}), nil
+}
+
+func openSession(ctx *pkcs11.Ctx, tokenLabel, pin string) (*session, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return nil, fmt.Errorf("listing slots: %w", err)
+	}
+
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || info.Label != tokenLabel {
+			continue
+		}
+
+		sh, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			return nil, fmt.Errorf("opening session: %w", err)
+		}
+		if err := ctx.Login(sh, pkcs11.CKU_USER, pin); err != nil {
+			ctx.CloseSession(sh)
+			return nil, fmt.Errorf("logging in: %w", err)
+		}
+		return &session{ctx: ctx, sh: sh}, nil
+	}
+
+	return nil, fmt.Errorf("token %s not found", tokenLabel)
+}
+
+func (s *session) findKey(label string) (object, int, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := s.ctx.FindObjectsInit(s.sh, template); err != nil {
+		return 0, 0, err
+	}
+
+	objects, _, err := s.ctx.FindObjects(s.sh, 2)
+	s.ctx.FindObjectsFinal(s.sh)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch len(objects) {
+	case 0:
+		return 0, 0, fmt.Errorf("key %s not found", label)
+	case 1:
+	default:
+		return 0, 0, fmt.Errorf("key label %s is not unique", label)
+	}
+
+	attrs, err := s.ctx.GetAttributeValue(s.sh, objects[0], []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, nil),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(attrs) != 1 {
+		return 0, 0, errors.New("reading key length")
+	}
+
+	var keyLen uint64
+	for i, b := range attrs[0].Value {
+		// CK_ULONG in native (little endian) byte order
+		keyLen |= uint64(b) << (8 * i)
+	}
+	return object(objects[0]), int(keyLen), nil
+}
+
+func (s *session) deriveKey(base object, data []byte, length int, hmac bool) (object, error) {
+	cData := C.CBytes(data)
+	defer C.free(cData)
+
+	param := C.derivation_string_data{
+		pData: (*C.uchar)(cData),
+		ulLen: C.ulong(len(data)),
+	}
+	paramBytes := C.GoBytes(unsafe.Pointer(&param), C.sizeof_derivation_string_data)
+
+	keyType, usage := uint(pkcs11.CKK_AES), uint(pkcs11.CKA_ENCRYPT)
+	if hmac {
+		keyType, usage = pkcs11.CKK_GENERIC_SECRET, pkcs11.CKA_SIGN
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, length),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+		pkcs11.NewAttribute(usage, true),
+	}
+	if !hmac {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true))
+	}
+
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_ECB_ENCRYPT_DATA, paramBytes)}
+	key, err := s.ctx.DeriveKey(s.sh, mechanism, pkcs11.ObjectHandle(base), template)
+	if err != nil {
+		return 0, err
+	}
+	return object(key), nil
+}
+
+func (s *session) destroy(key object) error {
+	return s.ctx.DestroyObject(s.sh, pkcs11.ObjectHandle(key))
+}
+
+func (s *session) encrypt(mechanism *pkcs11.Mechanism, key object, data []byte) ([]byte, error) {
+	if err := s.ctx.EncryptInit(s.sh, []*pkcs11.Mechanism{mechanism}, pkcs11.ObjectHandle(key)); err != nil {
+		return nil, err
+	}
+	return s.ctx.Encrypt(s.sh, data)
+}
+
+func (s *session) decrypt(mechanism *pkcs11.Mechanism, key object, data []byte) ([]byte, error) {
+	if err := s.ctx.DecryptInit(s.sh, []*pkcs11.Mechanism{mechanism}, pkcs11.ObjectHandle(key)); err != nil {
+		return nil, err
+	}
+	return s.ctx.Decrypt(s.sh, data)
+}
+
+func (s *session) sign(mechanism *pkcs11.Mechanism, key object, data []byte) ([]byte, error) {
+	if err := s.ctx.SignInit(s.sh, []*pkcs11.Mechanism{mechanism}, pkcs11.ObjectHandle(key)); err != nil {
+		return nil, err
+	}
+	return s.ctx.Sign(s.sh, data)
+}
+
+func (s *session) encryptECB(key object, data []byte) ([]byte, error) {
+	return s.encrypt(pkcs11.NewMechanism(pkcs11.CKM_AES_ECB, nil), key, data)
+}
+
+func (s *session) decryptECB(key object, data []byte) ([]byte, error) {
+	return s.decrypt(pkcs11.NewMechanism(pkcs11.CKM_AES_ECB, nil), key, data)
+}
+
+func (s *session) encryptCBC(key object, iv, data []byte) ([]byte, error) {
+	return s.encrypt(pkcs11.NewMechanism(pkcs11.CKM_AES_CBC, iv), key, data)
+}
+
+func (s *session) decryptCBC(key object, iv, data []byte) ([]byte, error) {
+	return s.decrypt(pkcs11.NewMechanism(pkcs11.CKM_AES_CBC, iv), key, data)
+}
+
+func (s *session) cmac(key object, data []byte) ([]byte, error) {
+	return s.sign(pkcs11.NewMechanism(pkcs11.CKM_AES_CMAC, nil), key, data)
+}
+
+func (s *session) hmacSHA256(key object, data []byte) ([]byte, error) {
+	return s.sign(pkcs11.NewMechanism(pkcs11.CKM_SHA256_HMAC, nil), key, data)
+}
@@ -0,0 +1,76 @@
+//go:build cgo
+
+package pkcs11
+
+import (
+	"os"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/moov-io/dukpt"
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+// TestToken runs against a PKCS#11 token, e.g. SoftHSM:
+//
+//	softhsm2-util --init-token --free --label dukpt --so-pin 1234 --pin 1234
+//	PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so PKCS11_TOKEN=dukpt PKCS11_PIN=1234 go test ./pkg/hsm/pkcs11/...
+func TestToken(t *testing.T) {
+	module, tokenLabel, pin := os.Getenv("PKCS11_MODULE"), os.Getenv("PKCS11_TOKEN"), os.Getenv("PKCS11_PIN")
+	if module == "" || tokenLabel == "" {
+		t.Skip("PKCS11_MODULE and PKCS11_TOKEN are not set")
+	}
+
+	provider, err := New(module, tokenLabel, pin)
+	require.NoError(t, err)
+	defer provider.Close()
+
+	bdk := pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1")
+	ksn := pkg.HexDecode("123456789012345600000001")
+	pan := []byte("4111111111111111")
+
+	// bdk is imported as session object, so it is removed with the session
+	s := provider.token.(*session)
+	_, err = s.ctx.CreateObject(s.sh, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "dukpt-test-bdk"),
+		pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, bdk),
+	})
+	require.NoError(t, err)
+
+	ikHandle, err := provider.DeriveIK("dukpt-test-bdk", ksn)
+	require.NoError(t, err)
+	tkHandle, err := provider.DeriveTransactionKey(ikHandle, ksn)
+	require.NoError(t, err)
+
+	ik, err := dukpt.DeriveIK(bdk, ksn)
+	require.NoError(t, err)
+	tk, err := dukpt.DeriveTransactionKey(ik, ksn)
+	require.NoError(t, err)
+
+	encrypted, err := provider.EncryptPin(tkHandle, ksn, []byte("1234"), pan, dukpt.WithRand(pkg.NewSeededReader(7)))
+	require.NoError(t, err)
+	expected, err := dukpt.EncryptPin(tk, ksn, []byte("1234"), pan, dukpt.WithRand(pkg.NewSeededReader(7)))
+	require.NoError(t, err)
+	require.Equal(t, expected, encrypted)
+
+	decrypted, err := provider.DecryptPin(tkHandle, ksn, encrypted, pan)
+	require.NoError(t, err)
+	require.Equal(t, "1234", string(decrypted))
+
+	mac, err := provider.MAC(tkHandle, ksn, []byte("4012345678909D987"))
+	require.NoError(t, err)
+	expected, err = dukpt.MAC(tk, ksn, []byte("4012345678909D987"))
+	require.NoError(t, err)
+	require.Equal(t, expected, mac)
+
+	data, err := provider.EncryptData(tkHandle, ksn, nil, []byte("4012345678909D987"))
+	require.NoError(t, err)
+	expected, err = dukpt.EncryptData(tk, ksn, nil, []byte("4012345678909D987"))
+	require.NoError(t, err)
+	require.Equal(t, expected, data)
+}
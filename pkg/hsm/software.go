@@ -0,0 +1,154 @@
+package hsm
+
+/*
+In-process software HSM: DUKPT keys are kept encrypted under a local master key and decrypted only for one operation
+*/
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/moov-io/dukpt"
+	"github.com/moov-io/dukpt/encryption"
+	"github.com/moov-io/dukpt/pkg"
+)
+
+var errInvalidHandle = errors.New("invalid key handle")
+
+// Software is dukpt.KeyProvider of keys wrapped under an aes master key
+//
+// NOTE:
+//   - Key handle is hex encoded RFC 5649 wrapped key, so handles carry keys and the provider keeps no state
+//   - Handles of the same key are equal, they may be stored and used as ids (e.g. server.Machine)
+//   - Clear keys are wiped after every operation
+type Software struct {
+	masterKey []byte
+}
+
+// NewSoftware creates software key provider
+//
+// Params:
+//   - master key is 16, 24 or 32 bytes aes key encryption key
+//
+// Return Params:
+//   - result is key provider
+//   - err
+func NewSoftware(masterKey []byte) (*Software, error) {
+	switch len(masterKey) {
+	case 16, 24, 32:
+	default:
+		return nil, errors.New("master key length must be 16, 24 or 32 bytes")
+	}
+
+	return &Software{masterKey: append([]byte(nil), masterKey...)}, nil
+}
+
+// ImportKey wraps clear key (e.g. base derivative key) under master key
+//
+// NOTE:
+//   - The handle is the same as openssl id-aes*-wrap-pad of key under master key,
+//     so keys may be wrapped outside of the dukpt process
+//
+// Params:
+//   - key is clear key
+//
+// Return Params:
+//   - result is key handle
+//   - err
+func (s *Software) ImportKey(key []byte) (dukpt.KeyHandle, error) {
+	wrapped, err := encryption.WrapKey(s.masterKey, key, encryption.KeyWrapRFC5649)
+	if err != nil {
+		return "", err
+	}
+	return dukpt.KeyHandle(pkg.HexEncode(wrapped)), nil
+}
+
+func (s *Software) key(handle dukpt.KeyHandle) ([]byte, error) {
+	wrapped := pkg.HexDecode(string(handle))
+	if wrapped == nil {
+		return nil, errInvalidHandle
+	}
+
+	key, err := encryption.UnwrapKey(s.masterKey, wrapped, encryption.KeyWrapRFC5649)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidHandle, err)
+	}
+	return key, nil
+}
+
+// derive applies derivation to the key of handle and imports derived key
+func (s *Software) derive(handle dukpt.KeyHandle, derivation func(key []byte) ([]byte, error)) (dukpt.KeyHandle, error) {
+	key, err := s.key(handle)
+	if err != nil {
+		return "", err
+	}
+	defer clear(key)
+
+	derived, err := derivation(key)
+	if err != nil {
+		return "", err
+	}
+	defer clear(derived)
+
+	return s.ImportKey(derived)
+}
+
+// use runs operation with the key of handle
+func (s *Software) use(handle dukpt.KeyHandle, operation func(key []byte) ([]byte, error)) ([]byte, error) {
+	key, err := s.key(handle)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+
+	return operation(key)
+}
+
+// DeriveIK derives initial key of base derivative key, see dukpt.DeriveIK
+func (s *Software) DeriveIK(bdk dukpt.KeyHandle, ksn []byte, opts ...dukpt.Option) (dukpt.KeyHandle, error) {
+	return s.derive(bdk, func(key []byte) ([]byte, error) {
+		return dukpt.DeriveIK(key, ksn, opts...)
+	})
+}
+
+// DeriveTransactionKey derives current transaction key of initial key, see dukpt.DeriveTransactionKey
+func (s *Software) DeriveTransactionKey(ik dukpt.KeyHandle, ksn []byte, opts ...dukpt.Option) (dukpt.KeyHandle, error) {
+	return s.derive(ik, func(key []byte) ([]byte, error) {
+		return dukpt.DeriveTransactionKey(key, ksn, opts...)
+	})
+}
+
+// EncryptPin encrypts PIN block with pin key of transaction key, see dukpt.EncryptPin
+func (s *Software) EncryptPin(tk dukpt.KeyHandle, ksn, pin, pan []byte, opts ...dukpt.Option) ([]byte, error) {
+	return s.use(tk, func(key []byte) ([]byte, error) {
+		return dukpt.EncryptPin(key, ksn, pin, pan, opts...)
+	})
+}
+
+// DecryptPin decrypts PIN block with pin key of transaction key, see dukpt.DecryptPin
+func (s *Software) DecryptPin(tk dukpt.KeyHandle, ksn, ciphertext, pan []byte, opts ...dukpt.Option) ([]byte, error) {
+	return s.use(tk, func(key []byte) ([]byte, error) {
+		return dukpt.DecryptPin(key, ksn, ciphertext, pan, opts...)
+	})
+}
+
+// MAC generates message authentication code with mac key of transaction key, see dukpt.MAC
+func (s *Software) MAC(tk dukpt.KeyHandle, ksn, msg []byte, opts ...dukpt.Option) ([]byte, error) {
+	return s.use(tk, func(key []byte) ([]byte, error) {
+		return dukpt.MAC(key, ksn, msg, opts...)
+	})
+}
+
+// EncryptData encrypts data in CBC mode with data key of transaction key, see dukpt.EncryptData
+func (s *Software) EncryptData(tk dukpt.KeyHandle, ksn, iv, plaintext []byte, opts ...dukpt.Option) ([]byte, error) {
+	return s.use(tk, func(key []byte) ([]byte, error) {
+		return dukpt.EncryptData(key, ksn, iv, plaintext, opts...)
+	})
+}
+
+// DecryptData decrypts data in CBC mode with data key of transaction key, see dukpt.DecryptData
+func (s *Software) DecryptData(tk dukpt.KeyHandle, ksn, iv, ciphertext []byte, opts ...dukpt.Option) ([]byte, error) {
+	return s.use(tk, func(key []byte) ([]byte, error) {
+		return dukpt.DecryptData(key, ksn, iv, ciphertext, opts...)
+	})
+}
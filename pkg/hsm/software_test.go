@@ -0,0 +1,82 @@
+package hsm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/moov-io/dukpt"
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSoftware(t *testing.T) {
+	_, err := NewSoftware([]byte("short"))
+	require.EqualError(t, err, "master key length must be 16, 24 or 32 bytes")
+
+	provider, err := NewSoftware(pkg.HexDecode("000102030405060708090A0B0C0D0E0F"))
+	require.NoError(t, err)
+
+	t.Run("aes", func(t *testing.T) {
+		bdk := pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1")
+		ksn := pkg.HexDecode("123456789012345600000001")
+		pan := []byte("4111111111111111")
+
+		bdkHandle, err := provider.ImportKey(bdk)
+		require.NoError(t, err)
+		require.NotContains(t, strings.ToLower(string(bdkHandle)), pkg.HexEncode(bdk))
+
+		ikHandle, err := provider.DeriveIK(bdkHandle, ksn)
+		require.NoError(t, err)
+		tkHandle, err := provider.DeriveTransactionKey(ikHandle, ksn)
+		require.NoError(t, err)
+
+		// handles are stable, so the expected transaction key handle is the imported clear key
+		expectedHandle, err := provider.ImportKey(pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44"))
+		require.NoError(t, err)
+		require.Equal(t, expectedHandle, tkHandle)
+
+		encrypted, err := provider.EncryptPin(tkHandle, ksn, []byte("1234"), pan, dukpt.WithRand(pkg.NewSeededReader(7)))
+		require.NoError(t, err)
+		require.Equal(t, "488414CE467A5CF1411AAD8FD4A5346A", strings.ToUpper(pkg.HexEncode(encrypted)))
+
+		decrypted, err := provider.DecryptPin(tkHandle, ksn, encrypted, pan)
+		require.NoError(t, err)
+		require.Equal(t, "1234", string(decrypted))
+
+		mac, err := provider.MAC(tkHandle, ksn, []byte("4012345678909D987"))
+		require.NoError(t, err)
+		require.NotEmpty(t, mac)
+
+		ciphertext, err := provider.EncryptData(tkHandle, ksn, nil, []byte("4012345678909D987"))
+		require.NoError(t, err)
+		plaintext, err := provider.DecryptData(tkHandle, ksn, nil, ciphertext)
+		require.NoError(t, err)
+		require.Equal(t, "4012345678909D987", string(plaintext[:17]))
+	})
+
+	t.Run("des", func(t *testing.T) {
+		ksn := pkg.HexDecode("FFFF9876543210E00001")
+
+		tkHandle, err := provider.ImportKey(pkg.HexDecode("042666B49184CFA368DE9628D0397BC9"))
+		require.NoError(t, err)
+
+		encrypted, err := provider.EncryptPin(tkHandle, ksn, []byte("1234"), []byte("4012345678909"))
+		require.NoError(t, err)
+		require.Equal(t, "1B9C1845EB993A7A", strings.ToUpper(pkg.HexEncode(encrypted)))
+	})
+
+	t.Run("invalid handles", func(t *testing.T) {
+		ksn := pkg.HexDecode("123456789012345600000001")
+
+		_, err := provider.DeriveIK("XYZ", ksn)
+		require.ErrorIs(t, err, errInvalidHandle)
+
+		other, err := NewSoftware(pkg.HexDecode("0F0E0D0C0B0A09080706050403020100"))
+		require.NoError(t, err)
+		handle, err := other.ImportKey(pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1"))
+		require.NoError(t, err)
+
+		_, err = provider.DeriveIK(handle, ksn)
+		require.ErrorIs(t, err, errInvalidHandle)
+	})
+}
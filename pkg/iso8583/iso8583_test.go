@@ -0,0 +1,177 @@
+package iso8583
+
+import (
+	"testing"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	desCurrentKey = pkg.HexDecode("042666B49184CFA368DE9628D0397BC9")
+	desKsn        = pkg.HexDecode("FFFF9876543210E00001")
+	aesCurrentKey = pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44")
+	aesKsn        = pkg.HexDecode("123456789012345600000001")
+)
+
+func mockMessage() Fields {
+	return Fields{
+		FieldMTI:    []byte("0200"),
+		FieldBitmap: pkg.HexDecode("7000000000000001"),
+		2:           []byte("134012345678909"),
+		3:           []byte("000000"),
+		4:           []byte("000000001000"),
+	}
+}
+
+func TestMacData(t *testing.T) {
+	msg := mockMessage()
+
+	data, err := MacData(msg, MacConfig{})
+	require.NoError(t, err)
+	require.Equal(t, "0200"+string(pkg.HexDecode("7000000000000001"))+"134012345678909000000000000001000", string(data))
+
+	data, err = MacData(msg, MacConfig{Fields: []int{4, 2}})
+	require.NoError(t, err)
+	require.Equal(t, "000000001000134012345678909", string(data))
+
+	_, err = MacData(msg, MacConfig{Fields: []int{5}})
+	require.EqualError(t, err, "field 5 is not present")
+
+	_, err = MacData(msg, MacConfig{Fields: []int{64}})
+	require.EqualError(t, err, "mac field can't be covered by mac")
+
+	_, err = MacData(msg, MacConfig{MacField: 65})
+	require.EqualError(t, err, "mac field must be 64 or 128")
+}
+
+func TestGenerateMac(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  MacConfig
+		fn   MacFunc
+	}{
+		{"des de64", MacConfig{}, DesMac(desCurrentKey, pkg.ActionRequest)},
+		{"des de128", MacConfig{MacField: FieldSecondaryMac}, DesMac(desCurrentKey, pkg.ActionRequest)},
+		{"aes de64", MacConfig{}, AesCmac(aesCurrentKey, aesKsn, aes.KeyAES128Type, pkg.ActionRequest)},
+		{"aes de128 full cmac", MacConfig{MacField: FieldSecondaryMac, Length: 16}, AesCmac(aesCurrentKey, aesKsn, aes.KeyAES128Type, pkg.ActionRequest)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := mockMessage()
+
+			mac, err := GenerateMac(msg, tt.cfg, tt.fn)
+			require.NoError(t, err)
+			require.Len(t, mac, tt.cfg.length())
+
+			field, _ := tt.cfg.macField()
+			msg[field] = mac
+			require.NoError(t, VerifyMac(msg, tt.cfg, tt.fn))
+
+			// tampered amount
+			msg[4] = []byte("000000009999")
+			require.ErrorIs(t, VerifyMac(msg, tt.cfg, tt.fn), ErrMacMismatch)
+		})
+	}
+
+	t.Run("des mac matches GenerateMac", func(t *testing.T) {
+		msg := Fields{2: []byte("4012345678909D987")}
+		mac, err := GenerateMac(msg, MacConfig{}, DesMac(desCurrentKey, pkg.ActionRequest))
+		require.NoError(t, err)
+		require.Equal(t, pkg.HexDecode("9CCC78173FC4FB64"), mac)
+	})
+
+	t.Run("missing mac field", func(t *testing.T) {
+		err := VerifyMac(mockMessage(), MacConfig{}, DesMac(desCurrentKey, pkg.ActionRequest))
+		require.EqualError(t, err, "field 64 is not present")
+	})
+}
+
+func TestPinData(t *testing.T) {
+	pinBlock := pkg.HexDecode("1B9C1845EB993A7A")
+
+	de52, de53, err := BuildPinData(PinData{
+		SecurityControl: SecurityControl{Scheme: SchemeDukptDes, PinBlockFormat: 0, KSN: desKsn},
+		PinBlock:        pinBlock,
+	}, ProprietaryLayout)
+	require.NoError(t, err)
+	require.Equal(t, pinBlock, de52)
+	require.Equal(t, "01000affff9876543210e00001", pkg.HexEncode(de53))
+
+	msg := Fields{FieldPinData: de52, FieldSecurityControl: de53}
+	data, err := ReadPinData(msg, ProprietaryLayout)
+	require.NoError(t, err)
+	require.Equal(t, pkg.AlgorithmDes, data.Algorithm())
+	require.Equal(t, desKsn, data.KSN)
+
+	pin, err := des.DecryptPin(desCurrentKey, data.PinBlock, "4012345678909", "ISO-0")
+	require.NoError(t, err)
+	require.Equal(t, "1234", pin)
+
+	// aes
+	aesPinBlock, err := aes.EncryptPin(aesCurrentKey, aesKsn, "1234", "4111111111111111", aes.KeyAES128Type)
+	require.NoError(t, err)
+
+	de52, de53, err = BuildPinData(PinData{
+		SecurityControl: SecurityControl{Scheme: SchemeDukptAes, PinBlockFormat: 4, KSN: aesKsn},
+		PinBlock:        aesPinBlock,
+	}, ProprietaryLayout)
+	require.NoError(t, err)
+
+	data, err = ParsePinData(de52, de53, ProprietaryLayout)
+	require.NoError(t, err)
+	require.Equal(t, pkg.AlgorithmAes, data.Algorithm())
+
+	pin, err = aes.DecryptPin(aesCurrentKey, data.KSN, data.PinBlock, "4111111111111111", aes.KeyAES128Type)
+	require.NoError(t, err)
+	require.Equal(t, "1234", pin)
+
+	// ksn only layout
+	layout := KSNLayout{PinBlockFormat: 4}
+	de52, de53, err = BuildPinData(PinData{
+		SecurityControl: SecurityControl{Scheme: SchemeDukptAes, PinBlockFormat: 4, KSN: aesKsn},
+		PinBlock:        aesPinBlock,
+	}, layout)
+	require.NoError(t, err)
+	require.Equal(t, aesKsn, de53)
+
+	data, err = ParsePinData(de52, de53, layout)
+	require.NoError(t, err)
+	require.Equal(t, pkg.AlgorithmAes, data.Algorithm())
+	require.Equal(t, byte(4), data.PinBlockFormat)
+}
+
+func TestPinData_Errors(t *testing.T) {
+	_, _, err := BuildPinData(PinData{
+		SecurityControl: SecurityControl{Scheme: SchemeDukptAes, KSN: aesKsn},
+		PinBlock:        pkg.HexDecode("1B9C1845EB993A7A"),
+	}, ProprietaryLayout)
+	require.EqualError(t, err, "pin block length must be 16 bytes")
+
+	_, _, err = BuildPinData(PinData{
+		SecurityControl: SecurityControl{Scheme: SchemeDukptDes, KSN: aesKsn},
+		PinBlock:        pkg.HexDecode("1B9C1845EB993A7A"),
+	}, ProprietaryLayout)
+	require.EqualError(t, err, "tdes key serial number length must be 10 bytes")
+
+	_, err = ProprietaryLayout.Decode(pkg.HexDecode("03000affff9876543210e00001"))
+	require.EqualError(t, err, "invalid key management scheme")
+
+	_, err = ProprietaryLayout.Decode(pkg.HexDecode("01000bffff9876543210e00001"))
+	require.EqualError(t, err, "invalid security control length")
+
+	_, err = KSNLayout{}.Decode(pkg.HexDecode("ffff9876543210e000"))
+	require.EqualError(t, err, "invalid security control length")
+
+	_, _, err = BuildPinData(PinData{
+		SecurityControl: SecurityControl{Scheme: SchemeDukptDes, KSN: desKsn},
+		PinBlock:        pkg.HexDecode("1B9C1845EB993A7A"),
+	}, KSNLayout{PinBlockFormat: 4})
+	require.EqualError(t, err, "pin block format doesn't match layout")
+
+	_, err = ReadPinData(Fields{}, ProprietaryLayout)
+	require.EqualError(t, err, "field 52 is not present")
+}
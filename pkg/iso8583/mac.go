@@ -0,0 +1,182 @@
+package iso8583
+
+/*
+ISO 8583 integration helpers for DUKPT MAC (DE 64 / DE 128) and security control fields (DE 52 / DE 53)
+
+The helpers don't pack or unpack messages, any ISO 8583 encoder can provide raw field bytes through FieldSource.
+*/
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+)
+
+const (
+	// FieldMTI is used as field id of message type indicator
+	FieldMTI = 0
+	// FieldBitmap is used as field id of primary (and secondary) bitmap
+	FieldBitmap = 1
+
+	FieldPinData         = 52
+	FieldSecurityControl = 53
+	FieldPrimaryMac      = 64
+	FieldSecondaryMac    = 128
+
+	defaultMacLength = 8
+)
+
+var ErrMacMismatch = errors.New("mac mismatch")
+
+// FieldSource provides raw (packed) bytes of message fields
+type FieldSource interface {
+	// FieldBytes returns packed field bytes and whether the field is present
+	FieldBytes(id int) ([]byte, bool)
+}
+
+// Fields is a FieldSource backed by a map of field id to packed bytes
+type Fields map[int][]byte
+
+func (f Fields) FieldBytes(id int) ([]byte, bool) {
+	value, ok := f[id]
+	return value, ok
+}
+
+// MacFunc generates a MAC of message data with a DUKPT key
+type MacFunc func(data []byte) ([]byte, error)
+
+// DesMac creates MacFunc with des.GenerateMac
+func DesMac(currentKey []byte, action string) MacFunc {
+	return func(data []byte) ([]byte, error) {
+		return des.GenerateMac(currentKey, string(data), action)
+	}
+}
+
+// AesCmac creates MacFunc with aes.GenerateCMAC
+func AesCmac(currentKey, ksn []byte, keyType, action string) MacFunc {
+	return func(data []byte) ([]byte, error) {
+		return aes.GenerateCMAC(currentKey, ksn, string(data), keyType, action)
+	}
+}
+
+// MacConfig selects MAC placement and fields covered by MAC
+type MacConfig struct {
+	// MacField is 64 or 128 (default 64)
+	MacField int
+	// Fields are covered by MAC in the given order
+	// (default all present fields from MTI to the field before MAC field, in ascending order)
+	Fields []int
+	// Length is MAC field length in bytes (default 8)
+	Length int
+}
+
+func (c MacConfig) macField() (int, error) {
+	switch c.MacField {
+	case 0:
+		return FieldPrimaryMac, nil
+	case FieldPrimaryMac, FieldSecondaryMac:
+		return c.MacField, nil
+	}
+	return 0, errors.New("mac field must be 64 or 128")
+}
+
+func (c MacConfig) length() int {
+	if c.Length <= 0 {
+		return defaultMacLength
+	}
+	return c.Length
+}
+
+// MacData concatenates fields covered by MAC
+func MacData(msg FieldSource, cfg MacConfig) ([]byte, error) {
+	macField, err := cfg.macField()
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if len(cfg.Fields) > 0 {
+		for _, id := range cfg.Fields {
+			if id == macField {
+				return nil, errors.New("mac field can't be covered by mac")
+			}
+			value, ok := msg.FieldBytes(id)
+			if !ok {
+				return nil, fmt.Errorf("field %d is not present", id)
+			}
+			data = append(data, value...)
+		}
+		return data, nil
+	}
+
+	for id := FieldMTI; id < macField; id++ {
+		if value, ok := msg.FieldBytes(id); ok {
+			data = append(data, value...)
+		}
+	}
+
+	return data, nil
+}
+
+// Generate MAC field value of message
+//
+// Params:
+//   - msg provides packed fields
+//   - cfg selects mac field and covered fields
+//   - fn is DesMac or AesCmac
+//
+// Return Params:
+//   - result is DE 64 or DE 128 value (left-most bytes of generated mac)
+//   - err
+func GenerateMac(msg FieldSource, cfg MacConfig, fn MacFunc) ([]byte, error) {
+	data, err := MacData(msg, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := fn(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mac) < cfg.length() {
+		return nil, fmt.Errorf("generated mac is shorter than %d bytes", cfg.length())
+	}
+
+	return mac[:cfg.length()], nil
+}
+
+// Verify MAC field value of message
+//
+// Params:
+//   - msg provides packed fields including mac field
+//   - cfg selects mac field and covered fields
+//   - fn is DesMac or AesCmac
+//
+// Return Params:
+//   - err is ErrMacMismatch when mac field doesn't match
+func VerifyMac(msg FieldSource, cfg MacConfig, fn MacFunc) error {
+	macField, err := cfg.macField()
+	if err != nil {
+		return err
+	}
+
+	received, ok := msg.FieldBytes(macField)
+	if !ok {
+		return fmt.Errorf("field %d is not present", macField)
+	}
+
+	expected, err := GenerateMac(msg, cfg, fn)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(expected, received) != 1 {
+		return ErrMacMismatch
+	}
+
+	return nil
+}
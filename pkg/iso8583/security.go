@@ -0,0 +1,234 @@
+package iso8583
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// Key management schemes of security control information
+const (
+	SchemeDukptDes byte = 0x01
+	SchemeDukptAes byte = 0x02
+)
+
+const (
+	desKsnLength      = 10
+	aesKsnLength      = 12
+	desPinBlockLength = 8
+	aesPinBlockLength = 16
+
+	securityControlHeaderLength = 3
+)
+
+// SecurityControl is DUKPT security control information of DE 53 Security Related Control Information
+//
+// NOTE:
+//   - Layout of DE 53 differs between networks, SecurityControlLayout encodes it to field value
+type SecurityControl struct {
+	Scheme         byte
+	PinBlockFormat byte
+	KSN            []byte
+}
+
+// Algorithm returns des or aes
+func (s SecurityControl) Algorithm() string {
+	if s.Scheme == SchemeDukptAes {
+		return pkg.AlgorithmAes
+	}
+	return pkg.AlgorithmDes
+}
+
+func (s SecurityControl) validate() error {
+	switch s.Scheme {
+	case SchemeDukptDes:
+		if len(s.KSN) != desKsnLength {
+			return fmt.Errorf("tdes key serial number length must be %d bytes", desKsnLength)
+		}
+	case SchemeDukptAes:
+		if len(s.KSN) != aesKsnLength {
+			return fmt.Errorf("aes key serial number length must be %d bytes", aesKsnLength)
+		}
+	default:
+		return errors.New("invalid key management scheme")
+	}
+
+	if s.PinBlockFormat > 4 {
+		return errors.New("invalid pin block format")
+	}
+
+	return nil
+}
+
+// SecurityControlLayout encodes security control information to DE 53 field value of a network
+type SecurityControlLayout interface {
+	// Encode returns DE 53 field value (length prefix is added by message encoder)
+	Encode(s SecurityControl) ([]byte, error)
+	// Decode reads DE 53 field value
+	Decode(value []byte) (*SecurityControl, error)
+}
+
+// ProprietaryLayout is a layout defined by this package, it doesn't match DE 53 of any card network
+//
+// NOTE:
+//   - Both sides of the link must use this package (or implement the layout), e.g. host to host links
+//
+// Layout of field value:
+//   - byte 0 is key management scheme (0x01 TDES DUKPT, 0x02 AES DUKPT)
+//   - byte 1 is ISO 9564-1 pin block format number (0 to 4)
+//   - byte 2 is key serial number length
+//   - remaining bytes are key serial number (10 bytes TDES, 12 bytes AES)
+var ProprietaryLayout SecurityControlLayout = proprietaryLayout{}
+
+type proprietaryLayout struct{}
+
+func (proprietaryLayout) Encode(s SecurityControl) ([]byte, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	result := []byte{s.Scheme, s.PinBlockFormat, byte(len(s.KSN))}
+	result = append(result, s.KSN...)
+
+	return result, nil
+}
+
+func (proprietaryLayout) Decode(value []byte) (*SecurityControl, error) {
+	if len(value) < securityControlHeaderLength {
+		return nil, errors.New("invalid security control length")
+	}
+
+	ksnLength := int(value[2])
+	if len(value) != securityControlHeaderLength+ksnLength {
+		return nil, errors.New("invalid security control length")
+	}
+
+	s := &SecurityControl{
+		Scheme:         value[0],
+		PinBlockFormat: value[1],
+		KSN:            make([]byte, ksnLength),
+	}
+	copy(s.KSN, value[securityControlHeaderLength:])
+
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// KSNLayout is a layout carrying only the key serial number in DE 53
+//
+// NOTE:
+//   - Key management scheme is taken from key serial number length (10 bytes TDES, 12 bytes AES)
+//   - Pin block format isn't carried, PinBlockFormat is agreed with the network
+type KSNLayout struct {
+	PinBlockFormat byte
+}
+
+func (l KSNLayout) Encode(s SecurityControl) ([]byte, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	if s.PinBlockFormat != l.PinBlockFormat {
+		return nil, errors.New("pin block format doesn't match layout")
+	}
+
+	result := make([]byte, len(s.KSN))
+	copy(result, s.KSN)
+
+	return result, nil
+}
+
+func (l KSNLayout) Decode(value []byte) (*SecurityControl, error) {
+	s := &SecurityControl{
+		PinBlockFormat: l.PinBlockFormat,
+		KSN:            make([]byte, len(value)),
+	}
+	copy(s.KSN, value)
+
+	switch len(value) {
+	case desKsnLength:
+		s.Scheme = SchemeDukptDes
+	case aesKsnLength:
+		s.Scheme = SchemeDukptAes
+	default:
+		return nil, errors.New("invalid security control length")
+	}
+
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// PinData is encrypted PIN block (DE 52) with its security control information (DE 53)
+type PinData struct {
+	SecurityControl
+	PinBlock []byte
+}
+
+// BuildPinData returns DE 52 and DE 53 field values, DE 53 is encoded with layout
+func BuildPinData(data PinData, layout SecurityControlLayout) ([]byte, []byte, error) {
+	if err := checkPinBlockLength(data.Scheme, data.PinBlock); err != nil {
+		return nil, nil, err
+	}
+
+	de53, err := layout.Encode(data.SecurityControl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	de52 := make([]byte, len(data.PinBlock))
+	copy(de52, data.PinBlock)
+
+	return de52, de53, nil
+}
+
+// ParsePinData reads DE 52 and DE 53 field values, DE 53 is decoded with layout
+func ParsePinData(de52, de53 []byte, layout SecurityControlLayout) (*PinData, error) {
+	security, err := layout.Decode(de53)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkPinBlockLength(security.Scheme, de52); err != nil {
+		return nil, err
+	}
+
+	data := &PinData{
+		SecurityControl: *security,
+		PinBlock:        make([]byte, len(de52)),
+	}
+	copy(data.PinBlock, de52)
+
+	return data, nil
+}
+
+// ReadPinData reads DE 52 and DE 53 from message, DE 53 is decoded with layout
+func ReadPinData(msg FieldSource, layout SecurityControlLayout) (*PinData, error) {
+	de52, ok := msg.FieldBytes(FieldPinData)
+	if !ok {
+		return nil, fmt.Errorf("field %d is not present", FieldPinData)
+	}
+	de53, ok := msg.FieldBytes(FieldSecurityControl)
+	if !ok {
+		return nil, fmt.Errorf("field %d is not present", FieldSecurityControl)
+	}
+
+	return ParsePinData(de52, de53, layout)
+}
+
+func checkPinBlockLength(scheme byte, pinBlock []byte) error {
+	expected := desPinBlockLength
+	if scheme == SchemeDukptAes {
+		expected = aesPinBlockLength
+	}
+
+	if len(pinBlock) != expected {
+		return fmt.Errorf("pin block length must be %d bytes", expected)
+	}
+	return nil
+}
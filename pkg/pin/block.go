@@ -0,0 +1,166 @@
+package pin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/moov-io/dukpt/pkg"
+)
+
+const (
+	tdesPinBlockLen = 8
+	aesPinBlockLen  = 16
+	minPinLen       = 4
+	maxPinLen       = 12
+)
+
+// BlockFields are fields of clear ISO 9564-1 PIN block
+type BlockFields struct {
+	// Format is ISO-0, ISO-1, ISO-2, ISO-3 or ISO-4 (detected from control field)
+	Format string
+	// PinLength is number of PIN digits
+	PinLength int
+	// Pin is clear PIN
+	Pin string
+	// Fill is fill digits following the PIN (hex nibbles)
+	Fill string
+	// Random is random field of ISO-4 pin field (hex)
+	Random string
+}
+
+// Decode clear PIN block and detect its ISO 9564-1 format
+//
+// NOTE:
+//   - ISO 9564-1:2017 9.3 (formats 0, 1, 2, 3 as 8 bytes blocks and format 4 as 16 bytes pin field)
+//   - Formats 0 and 3 are combined with pan field, so pan is required to read their PIN
+//   - Format 4 pin field is the block deciphered with pan field (see aes.DecryptPinBlock)
+//
+// Params:
+//   - block is 8 bytes clear pin block or 16 bytes clear pin field
+//   - pan is not formatted pan string (only used by formats 0 and 3)
+//
+// Return Params:
+//   - result is fields of pin block
+//   - err
+func DecodeBlock(block []byte, pan string) (BlockFields, error) {
+	var fields BlockFields
+
+	switch len(block) {
+	case tdesPinBlockLen, aesPinBlockLen:
+	default:
+		return fields, fmt.Errorf("pin block length must be %d or %d bytes", tdesPinBlockLen, aesPinBlockLen)
+	}
+
+	control := block[0] >> 4
+	field := append([]byte(nil), block...)
+
+	switch {
+	case len(block) == aesPinBlockLen && control == 4:
+	case len(block) == tdesPinBlockLen && (control == 1 || control == 2):
+	case len(block) == tdesPinBlockLen && (control == 0 || control == 3):
+		if pan == "" {
+			return fields, fmt.Errorf("pan is required to decode ISO-%d pin block", control)
+		}
+		panField, err := iso0PanField(pan)
+		if err != nil {
+			return fields, err
+		}
+		for i := range field {
+			field[i] ^= panField[i]
+		}
+	default:
+		return fields, fmt.Errorf("unknown pin block format %X of %d bytes block", control, len(block))
+	}
+
+	fields.Format = fmt.Sprintf("ISO-%d", control)
+	fields.PinLength = int(field[0] & 0x0F)
+	if fields.PinLength < minPinLen || fields.PinLength > maxPinLen {
+		return fields, fmt.Errorf("invalid pin length %d", fields.PinLength)
+	}
+
+	nibbles := strings.ToUpper(pkg.HexEncode(field[:tdesPinBlockLen]))
+	fields.Pin = nibbles[2 : 2+fields.PinLength]
+	fields.Fill = nibbles[2+fields.PinLength:]
+	if len(block) == aesPinBlockLen {
+		fields.Random = strings.ToUpper(pkg.HexEncode(field[tdesPinBlockLen:]))
+	}
+
+	if !isDigits(fields.Pin) {
+		return fields, errors.New("invalid pin digits")
+	}
+
+	var validFill string
+	switch control {
+	case 0, 2:
+		validFill = "F"
+	case 3:
+		validFill = "ABCDEF"
+	case 4:
+		validFill = "A"
+	}
+	if validFill != "" && strings.Trim(fields.Fill, validFill) != "" {
+		return fields, fmt.Errorf("invalid fill of %s pin block", fields.Format)
+	}
+
+	return fields, nil
+}
+
+// Build plain text pin field of ISO 9564-1:2017 PIN block format 4
+//
+// NOTE:
+//   - Control field 4, PIN length, PIN, fill digits A and 8 bytes random field
+//   - The pin field is encrypted with pan field by aes.EncryptPinBlock
+//
+// Params:
+//   - pin is not formatted pin string (4 to 12 digits)
+//   - random is random source of random field (crypto/rand.Reader when nil)
+//
+// Return Params:
+//   - result is 16 bytes pin field
+//   - err
+func ISO4PinField(pin string, random io.Reader) ([]byte, error) {
+	if len(pin) < minPinLen || len(pin) > maxPinLen || !isDigits(pin) {
+		return nil, fmt.Errorf("pin must be %d to %d digits", minPinLen, maxPinLen)
+	}
+
+	nibbles := fmt.Sprintf("4%X%s", len(pin), pin)
+	nibbles += strings.Repeat("A", 2*tdesPinBlockLen-len(nibbles))
+
+	field := make([]byte, aesPinBlockLen)
+	copy(field, pkg.HexDecode(nibbles))
+	if _, err := io.ReadFull(pkg.RandReader(random), field[tdesPinBlockLen:]); err != nil {
+		return nil, fmt.Errorf("generating random field: %w", err)
+	}
+
+	return field, nil
+}
+
+// Build plain text pan field of ISO 9564-1:2017 PIN block format 4
+//
+// Params:
+//   - pan is not formatted pan string (12 to 19 digits)
+//
+// Return Params:
+//   - result is 16 bytes pan field
+//   - err
+func ISO4PanField(pan string) ([]byte, error) {
+	if len(pan) < 12 || len(pan) > 19 || !isDigits(pan) {
+		return nil, errors.New("pan must be 12 to 19 digits")
+	}
+
+	nibbles := fmt.Sprintf("%d%s", len(pan)-12, pan)
+	nibbles += strings.Repeat("0", 2*aesPinBlockLen-len(nibbles))
+
+	return pkg.HexDecode(nibbles), nil
+}
+
+// pan field of ISO 9564-1 formats 0 and 3 (rightmost 12 digits excluding check digit)
+func iso0PanField(pan string) ([]byte, error) {
+	if len(pan) < 13 || !isDigits(pan) {
+		return nil, errors.New("pan must be at least 13 digits")
+	}
+
+	return pkg.HexDecode("0000" + pan[len(pan)-13:len(pan)-1]), nil
+}
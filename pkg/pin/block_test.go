@@ -0,0 +1,99 @@
+package pin
+
+import (
+	"testing"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeBlock(t *testing.T) {
+	t.Run("dukpt-des ISO-0", func(t *testing.T) {
+		ik, err := des.DerivationOfInitialKey(desBdk, desKsn)
+		require.NoError(t, err)
+		tk, err := des.DeriveCurrentTransactionKey(ik, desKsn)
+		require.NoError(t, err)
+
+		clearBlock, err := des.DecryptPinBlock(tk, pkg.HexDecode("1B9C1845EB993A7A"))
+		require.NoError(t, err)
+		require.Equal(t, pkg.HexDecode("041274EDCBA9876F"), clearBlock)
+
+		fields, err := DecodeBlock(clearBlock, pan)
+		require.NoError(t, err)
+		require.Equal(t, BlockFields{Format: "ISO-0", PinLength: 4, Pin: "1234", Fill: "FFFFFFFFFF"}, fields)
+
+		encrypted, err := des.EncryptPinBlock(tk, clearBlock)
+		require.NoError(t, err)
+		require.Equal(t, pkg.HexDecode("1B9C1845EB993A7A"), encrypted)
+
+		_, err = DecodeBlock(clearBlock, "")
+		require.EqualError(t, err, "pan is required to decode ISO-0 pin block")
+	})
+
+	t.Run("dukpt-aes ISO-4", func(t *testing.T) {
+		ik, err := aes.DerivationOfInitialKey(aesBdk, aesKsn)
+		require.NoError(t, err)
+		tk, err := aes.DeriveCurrentTransactionKey(ik, aesKsn)
+		require.NoError(t, err)
+
+		encrypted, err := aes.EncryptPinV2(tk, aesKsn, []byte("123456"), []byte(pan), aes.AES128)
+		require.NoError(t, err)
+
+		panField, err := ISO4PanField(pan)
+		require.NoError(t, err)
+		require.Equal(t, pkg.HexDecode("14012345678909000000000000000000"), panField)
+
+		pinField, err := aes.DecryptPinBlock(tk, aesKsn, encrypted, panField, aes.AES128)
+		require.NoError(t, err)
+
+		fields, err := DecodeBlock(pinField, "")
+		require.NoError(t, err)
+		require.Equal(t, "ISO-4", fields.Format)
+		require.Equal(t, "123456", fields.Pin)
+		require.Equal(t, "AAAAAAAA", fields.Fill)
+		require.Len(t, fields.Random, 16)
+
+		reencrypted, err := aes.EncryptPinBlock(tk, aesKsn, pinField, panField, aes.AES128)
+		require.NoError(t, err)
+		require.Equal(t, encrypted, reencrypted)
+
+		_, err = aes.DecryptPinBlock(tk, aesKsn, encrypted[:8], panField, aes.AES128)
+		require.EqualError(t, err, "pin block length must be 16 bytes")
+
+		// pin field of seeded random source matches aes.EncryptPinWithRand
+		pinField, err = ISO4PinField("123456", pkg.NewSeededReader(7))
+		require.NoError(t, err)
+		encrypted, err = aes.EncryptPinBlock(tk, aesKsn, pinField, panField, aes.AES128)
+		require.NoError(t, err)
+		expected, err := aes.EncryptPinWithRand(tk, aesKsn, []byte("123456"), []byte(pan), aes.AES128, pkg.NewSeededReader(7))
+		require.NoError(t, err)
+		require.Equal(t, expected, encrypted)
+
+		_, err = ISO4PinField("123", nil)
+		require.EqualError(t, err, "pin must be 4 to 12 digits")
+	})
+
+	t.Run("clear blocks", func(t *testing.T) {
+		fields, err := DecodeBlock(pkg.HexDecode("241234FFFFFFFFFF"), "")
+		require.NoError(t, err)
+		require.Equal(t, BlockFields{Format: "ISO-2", PinLength: 4, Pin: "1234", Fill: "FFFFFFFFFF"}, fields)
+
+		fields, err = DecodeBlock(pkg.HexDecode("1612345612345678"), "")
+		require.NoError(t, err)
+		require.Equal(t, BlockFields{Format: "ISO-1", PinLength: 6, Pin: "123456", Fill: "12345678"}, fields)
+
+		_, err = DecodeBlock(pkg.HexDecode("2412345612345678"), "")
+		require.EqualError(t, err, "invalid fill of ISO-2 pin block")
+
+		_, err = DecodeBlock(pkg.HexDecode("5412345612345678"), "")
+		require.EqualError(t, err, "unknown pin block format 5 of 8 bytes block")
+
+		_, err = DecodeBlock(pkg.HexDecode("2312345612345678"), "")
+		require.EqualError(t, err, "invalid pin length 3")
+
+		_, err = DecodeBlock(pkg.HexDecode("2412"), "")
+		require.EqualError(t, err, "pin block length must be 8 or 16 bytes")
+	})
+}
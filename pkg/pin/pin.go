@@ -0,0 +1,236 @@
+package pin
+
+/*
+PIN block translation between DUKPT transaction keys and static zone PIN keys (ZPK)
+*/
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/moov-io/dukpt/encryption"
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+	"github.com/moov-io/pinblock/formats"
+)
+
+const (
+	SchemeDukptDes = "dukpt-des"
+	SchemeDukptAes = "dukpt-aes"
+	SchemeZpkTdes  = "zpk-tdes"
+	SchemeZpkAes   = "zpk-aes"
+)
+
+const (
+	formatISO0 = "ISO-0"
+	formatISO4 = "ISO-4"
+)
+
+// Key describes the key protecting a PIN block
+type Key struct {
+	// Scheme is one of dukpt-des, dukpt-aes, zpk-tdes, zpk-aes
+	Scheme string
+	// BDK is base derivative key of dukpt schemes (used when IK is empty)
+	BDK []byte
+	// IK is initial key of dukpt schemes
+	IK []byte
+	// KSN is key serial number of dukpt schemes
+	KSN []byte
+	// ZPK is static zone pin key of zpk schemes
+	ZPK []byte
+	// KeyType is AES128, AES192, AES256, TDES2 or TDES3 working key type of dukpt-aes scheme (default follows key length)
+	KeyType string
+	// Format is pin block format (default ISO-0 for tdes keys and tdes working keys, ISO-4 for aes keys)
+	Format string
+}
+
+// Translate PIN block from one key and format to another
+//
+// NOTE:
+//   - The clear PIN never leaves this function, only the new cipher text is returned
+//   - AES keys support only ISO 9564-1:2017 PIN block format 4, TDES2 and TDES3 working keys of dukpt-aes only format 0
+//
+// Params:
+//   - in is key and format protecting cipher text
+//   - cipher text is encrypted pin block
+//   - pan is not formatted pan string
+//   - out is key and format protecting result
+//
+// Return Params:
+//   - result is cipher text
+//   - err
+func Translate(in Key, ciphertext []byte, pan string, out Key) ([]byte, error) {
+	pin, err := in.decryptPin(ciphertext, pan)
+	if err != nil {
+		return nil, err
+	}
+
+	return out.encryptPin(pin, pan)
+}
+
+func (k Key) format() (string, error) {
+	format := strings.ToUpper(k.Format)
+
+	switch k.Scheme {
+	case SchemeDukptDes, SchemeZpkTdes:
+		if format == "" {
+			format = formatISO0
+		}
+	case SchemeDukptAes, SchemeZpkAes:
+		if k.tdesWorkingKey() {
+			if format == "" {
+				format = formatISO0
+			}
+			if format != formatISO0 {
+				return "", errors.New("tdes working keys support only ISO-0 pin block format")
+			}
+			break
+		}
+		if format == "" {
+			format = formatISO4
+		}
+		if format != formatISO4 {
+			return "", errors.New("aes pin keys support only ISO-4 pin block format")
+		}
+	default:
+		return "", errors.New("invalid pin key scheme")
+	}
+
+	return format, nil
+}
+
+func (k Key) transactionKey() ([]byte, error) {
+	ik := k.IK
+	if len(ik) == 0 {
+		if len(k.BDK) == 0 {
+			return nil, errors.New("base derivative key or initial key is required")
+		}
+
+		var err error
+		if k.Scheme == SchemeDukptAes {
+			ik, err = aes.DerivationOfInitialKey(k.BDK, k.KSN)
+		} else {
+			ik, err = des.DerivationOfInitialKey(k.BDK, k.KSN)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if k.Scheme == SchemeDukptAes {
+		return aes.DeriveCurrentTransactionKey(ik, k.KSN)
+	}
+	return des.DeriveCurrentTransactionKey(ik, k.KSN)
+}
+
+// tdesWorkingKey is true for TDES2 and TDES3 working keys of dukpt-aes scheme
+func (k Key) tdesWorkingKey() bool {
+	keyType, _ := aes.ParseKeyType(strings.ToUpper(k.KeyType))
+	return k.Scheme == SchemeDukptAes && (keyType == aes.TDES2 || keyType == aes.TDES3)
+}
+
+func (k Key) aesKeyType(transactionKey []byte) (string, error) {
+	if k.KeyType != "" {
+		return strings.ToUpper(k.KeyType), nil
+	}
+
+	keyType, err := aes.KeyTypeOfLength(len(transactionKey))
+	return keyType.String(), err
+}
+
+func (k Key) decryptPin(ciphertext []byte, pan string) (string, error) {
+	format, err := k.format()
+	if err != nil {
+		return "", err
+	}
+
+	switch k.Scheme {
+	case SchemeDukptDes:
+		tk, err := k.transactionKey()
+		if err != nil {
+			return "", err
+		}
+		return des.DecryptPin(tk, ciphertext, pan, format)
+	case SchemeDukptAes:
+		tk, err := k.transactionKey()
+		if err != nil {
+			return "", err
+		}
+		keyType, err := k.aesKeyType(tk)
+		if err != nil {
+			return "", err
+		}
+		return aes.DecryptPin(tk, k.KSN, ciphertext, pan, keyType)
+	case SchemeZpkTdes:
+		cipher, err := encryption.NewTripleDesECB(k.ZPK)
+		if err != nil {
+			return "", err
+		}
+		pinBlock, err := cipher.Decrypt(ciphertext)
+		if err != nil {
+			return "", err
+		}
+		formatter, err := formats.NewFormatter(format)
+		if err != nil {
+			return "", err
+		}
+		return formatter.Decode(pkg.HexEncode(pinBlock), pan)
+	default:
+		cipher, err := encryption.NewAesECB(k.ZPK)
+		if err != nil {
+			return "", err
+		}
+		return formats.NewISO4(cipher).Decode(pkg.HexEncode(ciphertext), pan)
+	}
+}
+
+func (k Key) encryptPin(pin, pan string) ([]byte, error) {
+	format, err := k.format()
+	if err != nil {
+		return nil, err
+	}
+
+	switch k.Scheme {
+	case SchemeDukptDes:
+		tk, err := k.transactionKey()
+		if err != nil {
+			return nil, err
+		}
+		return des.EncryptPin(tk, pin, pan, format)
+	case SchemeDukptAes:
+		tk, err := k.transactionKey()
+		if err != nil {
+			return nil, err
+		}
+		keyType, err := k.aesKeyType(tk)
+		if err != nil {
+			return nil, err
+		}
+		return aes.EncryptPin(tk, k.KSN, pin, pan, keyType)
+	case SchemeZpkTdes:
+		cipher, err := encryption.NewTripleDesECB(k.ZPK)
+		if err != nil {
+			return nil, err
+		}
+		formatter, err := formats.NewFormatter(format)
+		if err != nil {
+			return nil, err
+		}
+		blockstr, err := formatter.Encode(pin, pan)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.Encrypt(pkg.HexDecode(blockstr))
+	default:
+		cipher, err := encryption.NewAesECB(k.ZPK)
+		if err != nil {
+			return nil, err
+		}
+		blockstr, err := formats.NewISO4(cipher).Encode(pin, pan)
+		if err != nil {
+			return nil, err
+		}
+		return pkg.HexDecode(blockstr), nil
+	}
+}
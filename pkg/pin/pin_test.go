@@ -0,0 +1,81 @@
+package pin
+
+import (
+	"testing"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	desBdk = pkg.HexDecode("0123456789ABCDEFFEDCBA9876543210")
+	desKsn = pkg.HexDecode("FFFF9876543210E00001")
+	aesBdk = pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1")
+	aesKsn = pkg.HexDecode("123456789012345600000001")
+	zpk    = pkg.HexDecode("89B07B35A1B3F47E89B07B35A1B3F47E")
+
+	pan = "4012345678909"
+)
+
+func TestTranslate(t *testing.T) {
+	desIn := Key{Scheme: SchemeDukptDes, BDK: desBdk, KSN: desKsn, Format: "ISO-0"}
+	encrypted := pkg.HexDecode("1B9C1845EB993A7A")
+
+	tests := []struct {
+		name string
+		out  Key
+	}{
+		{"dukpt-des to zpk-tdes", Key{Scheme: SchemeZpkTdes, ZPK: zpk, Format: "ISO-3"}},
+		{"dukpt-des to zpk-aes", Key{Scheme: SchemeZpkAes, ZPK: zpk}},
+		{"dukpt-des to dukpt-aes", Key{Scheme: SchemeDukptAes, BDK: aesBdk, KSN: aesKsn, KeyType: aes.KeyAES128Type}},
+		{"dukpt-des to dukpt-aes tdes working key", Key{Scheme: SchemeDukptAes, BDK: aesBdk, KSN: aesKsn, KeyType: aes.KeyTDES2Type}},
+		{"dukpt-des to dukpt-des", Key{Scheme: SchemeDukptDes, BDK: desBdk, KSN: pkg.HexDecode("FFFF9876543210E00002"), Format: "ISO-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			translated, err := Translate(desIn, encrypted, pan, tt.out)
+			require.NoError(t, err)
+
+			// translate back to the original key to check the pin is unchanged
+			back, err := Translate(tt.out, translated, pan, desIn)
+			require.NoError(t, err)
+			require.Equal(t, encrypted, back)
+		})
+	}
+
+	t.Run("dukpt-aes with initial key", func(t *testing.T) {
+		ik, err := aes.DerivationOfInitialKey(aesBdk, aesKsn)
+		require.NoError(t, err)
+
+		aesIn := Key{Scheme: SchemeDukptAes, IK: ik, KSN: aesKsn}
+		translated, err := Translate(desIn, encrypted, pan, aesIn)
+		require.NoError(t, err)
+		require.Len(t, translated, 16)
+
+		back, err := Translate(aesIn, translated, pan, desIn)
+		require.NoError(t, err)
+		require.Equal(t, encrypted, back)
+	})
+}
+
+func TestTranslate_Errors(t *testing.T) {
+	desIn := Key{Scheme: SchemeDukptDes, BDK: desBdk, KSN: desKsn}
+	encrypted := pkg.HexDecode("1B9C1845EB993A7A")
+
+	_, err := Translate(desIn, encrypted, pan, Key{Scheme: "unknown", ZPK: zpk})
+	require.EqualError(t, err, "invalid pin key scheme")
+
+	_, err = Translate(desIn, encrypted, pan, Key{Scheme: SchemeZpkAes, ZPK: zpk, Format: "ISO-0"})
+	require.EqualError(t, err, "aes pin keys support only ISO-4 pin block format")
+
+	_, err = Translate(desIn, encrypted, pan, Key{Scheme: SchemeDukptAes, BDK: aesBdk, KSN: aesKsn, KeyType: aes.KeyTDES3Type, Format: "ISO-4"})
+	require.EqualError(t, err, "tdes working keys support only ISO-0 pin block format")
+
+	_, err = Translate(Key{Scheme: SchemeDukptDes, KSN: desKsn}, encrypted, pan, desIn)
+	require.EqualError(t, err, "base derivative key or initial key is required")
+
+	_, err = Translate(desIn, encrypted, pan, Key{Scheme: SchemeZpkTdes, ZPK: []byte{0x01}})
+	require.Error(t, err)
+}
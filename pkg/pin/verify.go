@@ -0,0 +1,207 @@
+package pin
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/moov-io/dukpt/encryption"
+)
+
+const (
+	MethodIBM3624 = "ibm3624"
+	MethodVisaPVV = "visa-pvv"
+)
+
+const defaultDecimalizationTable = "0123456789012345"
+
+// Verification describes PIN verification data stored by the issuer
+type Verification struct {
+	// Method is one of ibm3624, visa-pvv
+	Method string
+	// PVK is pin verification key (8, 16 or 24 bytes for ibm3624, 16 or 24 bytes for visa-pvv)
+	PVK []byte
+	// DecimalizationTable is 16 decimal digits of ibm3624 (default 0123456789012345)
+	DecimalizationTable string
+	// ValidationData is 16 hexadecimal characters of ibm3624 (usually taken from the PAN)
+	ValidationData string
+	// Offset is ibm3624 pin offset, its length is the pin length
+	Offset string
+	// PVKI is pin verification key index (0 to 9) of visa-pvv
+	PVKI int
+	// PVV is 4 digits visa pin verification value
+	PVV string
+}
+
+// Verify PIN block against stored PIN verification data
+//
+// NOTE:
+//   - The PIN block is decrypted internally, the clear PIN never leaves this function
+//   - IBM 3624 PIN offset or Visa PIN verification value (PVV)
+//
+// Params:
+//   - key is key and format protecting cipher text
+//   - cipher text is encrypted pin block
+//   - pan is not formatted pan string
+//   - v is pin verification data with offset or pvv
+//
+// Return Params:
+//   - result is true when the pin matches verification data
+//   - err
+func Verify(key Key, ciphertext []byte, pan string, v Verification) (bool, error) {
+	pin, err := key.decryptPin(ciphertext, pan)
+	if err != nil {
+		return false, err
+	}
+
+	var expected, stored string
+	switch v.Method {
+	case MethodIBM3624:
+		expected, err = v.ibm3624Offset(pin)
+		stored = v.Offset
+	case MethodVisaPVV:
+		expected, err = v.visaPVV(pin, pan)
+		stored = v.PVV
+	default:
+		return false, errors.New("invalid pin verification method")
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(stored)) == 1, nil
+}
+
+// Generate PIN verification data of PIN block for PIN issuance
+//
+// NOTE:
+//   - The PIN block is decrypted internally, the clear PIN never leaves this function
+//   - Offset and PVV fields of verification data are ignored
+//
+// Params:
+//   - key is key and format protecting cipher text
+//   - cipher text is encrypted pin block
+//   - pan is not formatted pan string
+//   - v is pin verification data without offset or pvv
+//
+// Return Params:
+//   - result is ibm3624 offset or visa pvv
+//   - err
+func GenerateVerification(key Key, ciphertext []byte, pan string, v Verification) (string, error) {
+	pin, err := key.decryptPin(ciphertext, pan)
+	if err != nil {
+		return "", err
+	}
+
+	switch v.Method {
+	case MethodIBM3624:
+		return v.ibm3624Offset(pin)
+	case MethodVisaPVV:
+		return v.visaPVV(pin, pan)
+	}
+	return "", errors.New("invalid pin verification method")
+}
+
+// IBM 3624 offset is the digit-wise (modulo 10) difference of the pin and the natural pin
+func (v Verification) ibm3624Offset(pin string) (string, error) {
+	table := v.DecimalizationTable
+	if table == "" {
+		table = defaultDecimalizationTable
+	}
+	if len(table) != 16 || !isDigits(table) {
+		return "", errors.New("decimalization table must be 16 decimal digits")
+	}
+
+	validationData, err := hex.DecodeString(v.ValidationData)
+	if err != nil || len(validationData) != 8 {
+		return "", errors.New("validation data must be 16 hexadecimal characters")
+	}
+
+	var cipher *encryption.DesECB
+	if len(v.PVK) == 8 {
+		cipher, err = encryption.NewDesECB(v.PVK)
+	} else {
+		cipher, err = encryption.NewTripleDesECB(v.PVK)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := cipher.Encrypt(validationData)
+	if err != nil {
+		return "", err
+	}
+
+	// natural pin is the decimalized leftmost digits of encrypted validation data
+	intermediate := hex.EncodeToString(encrypted)
+	offset := make([]byte, len(pin))
+	for i := range pin {
+		natural := table[hexValue(intermediate[i])] - '0'
+		offset[i] = '0' + (pin[i]-'0'+10-natural)%10
+	}
+
+	return string(offset), nil
+}
+
+// Visa PVV is decimalized encryption of transformed security parameter (TSP)
+func (v Verification) visaPVV(pin, pan string) (string, error) {
+	if len(pin) < 4 {
+		return "", errors.New("pin length must be at least 4 digits")
+	}
+	if len(pan) < 12 || !isDigits(pan) {
+		return "", errors.New("pan must be at least 12 decimal digits")
+	}
+	if v.PVKI < 0 || v.PVKI > 9 {
+		return "", errors.New("pin verification key index must be 0 to 9")
+	}
+	if len(v.PVK) != 16 && len(v.PVK) != 24 {
+		return "", errors.New("pin verification key length must be 16 or 24 bytes")
+	}
+
+	// rightmost 11 pan digits excluding check digit, pvki and leftmost 4 pin digits
+	tsp := pan[len(pan)-12:len(pan)-1] + string(rune('0'+v.PVKI)) + pin[:4]
+
+	cipher, err := encryption.NewTripleDesECB(v.PVK)
+	if err != nil {
+		return "", err
+	}
+
+	tspBytes, _ := hex.DecodeString(tsp)
+	encrypted, err := cipher.Encrypt(tspBytes)
+	if err != nil {
+		return "", err
+	}
+
+	// decimal digits first, then hexadecimal letters converted to digits (A to 0, ..., F to 5)
+	result := strings.ToUpper(hex.EncodeToString(encrypted))
+	pvv := make([]byte, 0, 4)
+	for i := 0; i < len(result) && len(pvv) < 4; i++ {
+		if result[i] <= '9' {
+			pvv = append(pvv, result[i])
+		}
+	}
+	for i := 0; i < len(result) && len(pvv) < 4; i++ {
+		if result[i] >= 'A' {
+			pvv = append(pvv, result[i]-'A'+'0')
+		}
+	}
+
+	return string(pvv), nil
+}
+
+func isDigits(s string) bool {
+	for i := range s {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func hexValue(c byte) byte {
+	if c >= 'a' {
+		return c - 'a' + 10
+	}
+	return c - '0'
+}
@@ -0,0 +1,82 @@
+package pin
+
+import (
+	"testing"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	// pin 1234 of ANSI X9.24-1:2009 A.4.2 initial sequence
+	key := Key{Scheme: SchemeDukptDes, BDK: desBdk, KSN: desKsn, Format: "ISO-0"}
+	encrypted := pkg.HexDecode("1B9C1845EB993A7A")
+	pvk := pkg.HexDecode("0123456789ABCDEFFEDCBA9876543210")
+
+	// Expected values are reproduced with the openssl 3.0 command line, not taken from this package:
+	//   - ibm3624 (IBM 3624 PIN offset, IBM CCA Clear_PIN_Generate_Alternate): natural pin is the decimalized leftmost
+	//     4 digits of openssl enc -des-ede-ecb -nopad -K <pvk> (-des-ecb -provider legacy for single length pvk)
+	//     over validation data 4012345678909000, offset is pin minus natural pin digit by digit (modulo 10)
+	//     double length: 223DF755FBD8A3C8 -> natural 2233 -> offset 9001
+	//     single length: 88A93260160972AF -> natural 8809 -> offset 3435
+	//   - visa pvv (Visa PIN Verification Value): TSP is 11 rightmost pan digits excluding check digit, pvki and
+	//     4 leftmost pin digits (0123456789011234), openssl enc -des-ede-ecb -nopad -K <pvk> gives 51AF11771990A73B,
+	//     its first 4 decimal digits are pvv 5111
+	tests := []struct {
+		name     string
+		v        Verification
+		expected string
+	}{
+		{
+			name:     "ibm3624 double length pvk",
+			v:        Verification{Method: MethodIBM3624, PVK: pvk, ValidationData: "4012345678909000"},
+			expected: "9001",
+		},
+		{
+			name:     "ibm3624 single length pvk",
+			v:        Verification{Method: MethodIBM3624, PVK: pvk[:8], ValidationData: "4012345678909000", DecimalizationTable: "0123456789012345"},
+			expected: "3435",
+		},
+		{
+			name:     "visa pvv",
+			v:        Verification{Method: MethodVisaPVV, PVK: pvk, PVKI: 1},
+			expected: "5111",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generated, err := GenerateVerification(key, encrypted, pan, tt.v)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, generated)
+
+			v := tt.v
+			v.Offset, v.PVV = generated, generated
+			verified, err := Verify(key, encrypted, pan, v)
+			require.NoError(t, err)
+			require.True(t, verified)
+
+			// a different pin under the same key doesn't verify
+			other, err := key.encryptPin("4321", pan)
+			require.NoError(t, err)
+			verified, err = Verify(key, other, pan, v)
+			require.NoError(t, err)
+			require.False(t, verified)
+		})
+	}
+
+	_, err := Verify(key, encrypted, pan, Verification{Method: "unknown"})
+	require.EqualError(t, err, "invalid pin verification method")
+
+	_, err = GenerateVerification(key, encrypted, pan, Verification{Method: MethodIBM3624, PVK: pvk, ValidationData: "40123456"})
+	require.EqualError(t, err, "validation data must be 16 hexadecimal characters")
+
+	_, err = GenerateVerification(key, encrypted, pan, Verification{Method: MethodIBM3624, PVK: pvk, ValidationData: "4012345678909000", DecimalizationTable: "0123456789ABCDEF"})
+	require.EqualError(t, err, "decimalization table must be 16 decimal digits")
+
+	_, err = GenerateVerification(key, encrypted, pan, Verification{Method: MethodVisaPVV, PVK: pvk, PVKI: 10})
+	require.EqualError(t, err, "pin verification key index must be 0 to 9")
+
+	_, err = GenerateVerification(key, encrypted, pan, Verification{Method: MethodVisaPVV, PVK: pvk[:8]})
+	require.EqualError(t, err, "pin verification key length must be 16 or 24 bytes")
+}
@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// RandReader returns random source r, crypto/rand.Reader is returned when r is nil
+func RandReader(r io.Reader) io.Reader {
+	if r == nil {
+		return rand.Reader
+	}
+	return r
+}
+
+// seededReader is SHA-256 of seed and block counter in counter mode
+type seededReader struct {
+	mu      sync.Mutex
+	seed    [8]byte
+	counter uint64
+	buf     []byte
+}
+
+// NewSeededReader returns deterministic random source for golden tests
+//
+// NOTE:
+//   - The same seed always produces the same byte stream (SHA-256 of seed and block counter)
+//   - It is safe for concurrent use, concurrent readers share one stream
+//   - It isn't random, never use it to protect production PINs or data
+//
+// Params:
+//   - seed is any number
+//
+// Return Params:
+//   - result is random source
+func NewSeededReader(seed int64) io.Reader {
+	r := &seededReader{}
+	binary.BigEndian.PutUint64(r.seed[:], uint64(seed))
+	return r
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			var block [16]byte
+			copy(block[:8], r.seed[:])
+			binary.BigEndian.PutUint64(block[8:], r.counter)
+			r.counter++
+
+			sum := sha256.Sum256(block[:])
+			r.buf = sum[:]
+		}
+
+		copied := copy(p[n:], r.buf)
+		r.buf = r.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// RandomNibbles reads random hex digits between min and F from random source
+//
+// NOTE:
+//   - Nibbles below min are discarded, so the digits are uniformly distributed
+//
+// Params:
+//   - r is random source (crypto/rand.Reader when nil)
+//   - n is number of digits
+//   - min is the smallest digit (0x0 for 0-F, 0xA for A-F)
+//
+// Return Params:
+//   - result is upper case hex digits
+//   - err
+func RandomNibbles(r io.Reader, n int, min byte) (string, error) {
+	const digits = "0123456789ABCDEF"
+	if min > 0x0F {
+		return "", errors.New("invalid minimum nibble")
+	}
+
+	r = RandReader(r)
+
+	var sb strings.Builder
+	buf := make([]byte, 1)
+	for sb.Len() < n {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		for _, nibble := range []byte{buf[0] >> 4, buf[0] & 0x0F} {
+			if nibble >= min && sb.Len() < n {
+				sb.WriteByte(digits[nibble])
+			}
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+)
+
+var errInvalidAlgorithm = errors.New("invalid encrypt/decrypt algorithm")
+
+// Algorithm implements DUKPT derivation, key serial number stepping, pin, mac and data operations of one algorithm
+//
+// NOTE:
+//   - Keys, key serial numbers and binary data of params are hex encoded
+type Algorithm interface {
+	// Name returns unique name of algorithm (value of UnifiedParams.Algorithm)
+	Name() string
+	// ValidateParams returns error when params carry options the algorithm doesn't support
+	ValidateParams(params UnifiedParams) error
+	// NextKSN returns key serial number following ksn
+	NextKSN(ksn string) (string, error)
+	InitialKey(params UnifiedParams) (string, error)
+	TransactionKey(params UnifiedParams) (string, error)
+	EncryptPin(params UnifiedParams) (string, error)
+	DecryptPin(params UnifiedParams) (string, error)
+	GenerateMac(params UnifiedParams) (string, error)
+	EncryptData(params UnifiedParams) (string, error)
+	DecryptData(params UnifiedParams) (string, error)
+	// ExportKey returns key selected by params.ExportKey (ik, tk, pin, mac or data) wrapped under params.KEK
+	ExportKey(params UnifiedParams) (string, error)
+}
+
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = make(map[string]Algorithm)
+)
+
+func init() {
+	RegisterAlgorithm(desAlgorithm{})
+	RegisterAlgorithm(aesAlgorithm{})
+}
+
+// RegisterAlgorithm makes algorithm available by its name, registering a name twice replaces the algorithm
+func RegisterAlgorithm(algorithm Algorithm) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+	algorithms[algorithm.Name()] = algorithm
+}
+
+// Algorithms returns names of registered algorithms
+func Algorithms() []string {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupAlgorithm returns registered algorithm of name
+func LookupAlgorithm(name string) (Algorithm, error) {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	algorithm, ok := algorithms[name]
+	if !ok {
+		return nil, errInvalidAlgorithm
+	}
+	return algorithm, nil
+}
+
+// desAlgorithm is ANSI X9.24-1 TDES DUKPT
+type desAlgorithm struct{}
+
+func (desAlgorithm) Name() string {
+	return pkg.AlgorithmDes
+}
+
+func (desAlgorithm) ValidateParams(params UnifiedParams) error {
+	if params.DataKeyMode == "" {
+		return nil
+	}
+	_, err := des.ParseDataKeyMode(params.DataKeyMode)
+	return err
+}
+
+func (desAlgorithm) NextKSN(ksn string) (string, error) {
+	buf, err := pkg.GenerateNextDesKsn(pkg.HexDecode(ksn))
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (desAlgorithm) InitialKey(params UnifiedParams) (string, error) {
+	buf, err := des.DerivationOfInitialKey(pkg.HexDecode(params.BKD), pkg.HexDecode(params.KSN))
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (desAlgorithm) TransactionKey(params UnifiedParams) (string, error) {
+	buf, err := des.DeriveCurrentTransactionKey(pkg.HexDecode(params.IK), pkg.HexDecode(params.KSN))
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (desAlgorithm) EncryptPin(params UnifiedParams) (string, error) {
+	format, err := des.ParsePinFormat(params.Format)
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := des.EncryptPinWithRand(pkg.HexDecode(params.TK), []byte(params.PIN), []byte(params.PAN), format, params.Rand)
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (desAlgorithm) DecryptPin(params UnifiedParams) (string, error) {
+	return des.DecryptPin(pkg.HexDecode(params.TK), pkg.HexDecode(params.PIN), params.PAN, params.Format)
+}
+
+func (desAlgorithm) GenerateMac(params UnifiedParams) (string, error) {
+	buf, err := des.GenerateMac(pkg.HexDecode(params.TK), params.Plaintext, params.Action)
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (desAlgorithm) EncryptData(params UnifiedParams) (string, error) {
+	mode, err := des.ParseDataKeyMode(params.DataKeyMode)
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := des.EncryptDataWithMode(pkg.HexDecode(params.TK), pkg.HexDecode(params.IV), []byte(params.Plaintext), params.action(), mode)
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (desAlgorithm) DecryptData(params UnifiedParams) (string, error) {
+	mode, err := des.ParseDataKeyMode(params.DataKeyMode)
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := des.DecryptDataWithMode(pkg.HexDecode(params.TK), pkg.HexDecode(params.IV), pkg.HexDecode(params.Ciphertext), params.action(), mode)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (desAlgorithm) ExportKey(params UnifiedParams) (string, error) {
+	mode, err := params.keyWrapMode()
+	if err != nil {
+		return "", err
+	}
+
+	kek := pkg.HexDecode(params.KEK)
+	var buf []byte
+	switch params.ExportKey {
+	case ExportKeyInitial:
+		buf, err = des.ExportInitialKey(kek, pkg.HexDecode(params.BKD), pkg.HexDecode(params.KSN), mode)
+	case ExportKeyTransaction:
+		buf, err = des.ExportTransactionKey(kek, pkg.HexDecode(params.IK), pkg.HexDecode(params.KSN), mode)
+	case pkg.UsageData:
+		var dataKeyMode des.DataKeyMode
+		if dataKeyMode, err = des.ParseDataKeyMode(params.DataKeyMode); err != nil {
+			return "", err
+		}
+		buf, err = des.ExportDataKey(kek, pkg.HexDecode(params.TK), params.action(), dataKeyMode, mode)
+	default:
+		buf, err = des.ExportWorkingKey(kek, pkg.HexDecode(params.TK), params.ExportKey, params.action(), mode)
+	}
+
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+// aesAlgorithm is ANSI X9.24-3 AES DUKPT
+type aesAlgorithm struct{}
+
+func (aesAlgorithm) Name() string {
+	return pkg.AlgorithmAes
+}
+
+func (aesAlgorithm) ValidateParams(params UnifiedParams) error {
+	if params.DataKeyMode != "" {
+		return errors.New("data key mode is only supported by des algorithm")
+	}
+	return nil
+}
+
+func (aesAlgorithm) NextKSN(ksn string) (string, error) {
+	buf, err := pkg.GenerateNextAesKsn(pkg.HexDecode(ksn))
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (aesAlgorithm) InitialKey(params UnifiedParams) (string, error) {
+	buf, err := aes.DerivationOfInitialKey(pkg.HexDecode(params.BKD), pkg.HexDecode(params.KSN))
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (aesAlgorithm) TransactionKey(params UnifiedParams) (string, error) {
+	buf, err := aes.DeriveCurrentTransactionKey(pkg.HexDecode(params.IK), pkg.HexDecode(params.KSN))
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (aesAlgorithm) EncryptPin(params UnifiedParams) (string, error) {
+	// unknown key type is rejected after the transaction key length, as aes.EncryptPin does
+	keyType, _ := aes.ParseKeyType(params.AlgorithmKey)
+	buf, err := aes.EncryptPinWithRand(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), []byte(params.PIN), []byte(params.PAN), keyType, params.Rand)
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (aesAlgorithm) DecryptPin(params UnifiedParams) (string, error) {
+	return aes.DecryptPin(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), pkg.HexDecode(params.PIN), params.PAN, params.AlgorithmKey)
+}
+
+func (aesAlgorithm) GenerateMac(params UnifiedParams) (string, error) {
+	var buf []byte
+	var err error
+
+	switch params.MacType {
+	case pkg.MaxTypeCmac:
+		buf, err = aes.GenerateCMAC(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), params.Plaintext, params.AlgorithmKey, params.Action)
+	case pkg.MaxTypeHmac:
+		buf, err = aes.GenerateHMAC(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), params.Plaintext, params.AlgorithmKey, params.Action)
+	default:
+		return "", errors.New("invalid mac type")
+	}
+
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (aesAlgorithm) EncryptData(params UnifiedParams) (string, error) {
+	buf, err := aes.EncryptData(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), pkg.HexDecode(params.IV), params.Plaintext, params.AlgorithmKey, params.Action)
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (aesAlgorithm) DecryptData(params UnifiedParams) (string, error) {
+	return aes.DecryptData(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), pkg.HexDecode(params.Ciphertext), pkg.HexDecode(params.IV), params.AlgorithmKey, params.Action)
+}
+
+func (aesAlgorithm) ExportKey(params UnifiedParams) (string, error) {
+	mode, err := params.keyWrapMode()
+	if err != nil {
+		return "", err
+	}
+
+	kek := pkg.HexDecode(params.KEK)
+	var buf []byte
+	switch params.ExportKey {
+	case ExportKeyInitial:
+		buf, err = aes.ExportInitialKey(kek, pkg.HexDecode(params.BKD), pkg.HexDecode(params.KSN), mode)
+	case ExportKeyTransaction:
+		buf, err = aes.ExportTransactionKey(kek, pkg.HexDecode(params.IK), pkg.HexDecode(params.KSN), mode)
+	default:
+		var keyType aes.KeyType
+		if keyType, err = aes.ParseKeyType(params.AlgorithmKey); err != nil {
+			return "", err
+		}
+		buf, err = aes.ExportWorkingKey(kek, pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), params.ExportKey, keyType, params.action(), mode)
+	}
+
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"crypto/subtle"
+)
+
+const (
+	// PermissionFullPAN allows caller to read unmasked PAN of decrypted device data
+	PermissionFullPAN = "pan:full"
+	// PermissionExportKey allows caller to export machine keys wrapped under key encryption key
+	PermissionExportKey = "key:export"
+)
+
+// Authorizer grants permissions to caller's token
+type Authorizer interface {
+	Authorize(token, permission string) bool
+}
+
+type tokenAuthorizer map[string][]string
+
+// NewTokenAuthorizer grants permissions of each token (token -> permissions)
+func NewTokenAuthorizer(tokens map[string][]string) Authorizer {
+	return tokenAuthorizer(tokens)
+}
+
+func (a tokenAuthorizer) Authorize(token, permission string) bool {
+	if token == "" {
+		return false
+	}
+
+	granted := false
+	for t, permissions := range a {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) != 1 {
+			continue
+		}
+		for _, p := range permissions {
+			if p == permission {
+				granted = true
+			}
+		}
+	}
+	return granted
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/device"
+	"github.com/moov-io/dukpt/pkg/emv"
+	"github.com/moov-io/dukpt/pkg/host"
+)
+
+const (
+	DeviceEncodingHex    = "hex"
+	DeviceEncodingBase64 = "base64"
+)
+
+// DeviceRequest is raw card reader output
+type DeviceRequest struct {
+	// Format is device format name, empty format detects it
+	Format string
+	// Data is encoded frame
+	Data string
+	// Encoding is hex (default) or base64
+	Encoding string
+	// KeyUsage is data, data_variant or pin, empty key usage follows device format
+	KeyUsage string
+}
+
+// DeviceTrack is parsed card track, PAN is masked unless caller has PermissionFullPAN
+type DeviceTrack struct {
+	PAN         string
+	Name        string `json:",omitempty"`
+	Expiry      string
+	ServiceCode string
+}
+
+// DeviceTag is decrypted EMV data object, PAN of PAN bearing tags (5A, 56, 57, 9F6B) is masked
+// unless caller has PermissionFullPAN
+type DeviceTag struct {
+	Tag string
+	// Value is hex encoded value, PAN bearing tags are PAN digits (5A) or track data without discretionary data
+	// (56 track 1, 57 and 9F6B track 2 equivalent data)
+	Value string
+}
+
+// DeviceData is decrypted card reader output
+type DeviceData struct {
+	Format       string
+	Algorithm    string
+	KSN          string
+	SerialNumber string       `json:",omitempty"`
+	Track1       *DeviceTrack `json:",omitempty"`
+	Track2       *DeviceTrack `json:",omitempty"`
+	EMV          []DeviceTag  `json:",omitempty"`
+}
+
+// DecryptDevice parses and decrypts card reader output with host's base derivative keys
+//
+// Params:
+//   - h is host resolving base derivative key of frame's ksn
+//   - req is raw reader output
+//   - fullPan returns unmasked PAN
+//
+// Return Params:
+//   - result is decrypted tracks and EMV tags
+//   - err
+func DecryptDevice(h *host.Host, req DeviceRequest, fullPan bool) (*DeviceData, error) {
+	if h == nil {
+		return nil, errors.New("device decryption is not configured")
+	}
+
+	var data []byte
+	var err error
+	switch strings.ToLower(req.Encoding) {
+	case "", DeviceEncodingHex:
+		data, err = hex.DecodeString(req.Data)
+	case DeviceEncodingBase64:
+		data, err = base64.StdEncoding.DecodeString(req.Data)
+	default:
+		return nil, errors.New("invalid device data encoding")
+	}
+	if err != nil {
+		return nil, errors.New("invalid device data")
+	}
+
+	usage, err := device.ParseKeyUsage(req.KeyUsage)
+	if err != nil {
+		return nil, err
+	}
+
+	frame, err := device.Parse(req.Format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks, err := h.DecryptFrame(frame, usage)
+	if err != nil {
+		return nil, err
+	}
+
+	maskPan := device.MaskPAN
+	if fullPan {
+		maskPan = func(pan string) string { return pan }
+	}
+
+	result := &DeviceData{
+		Format:       frame.Format,
+		Algorithm:    frame.Algorithm,
+		KSN:          pkg.HexEncode(frame.KSN),
+		SerialNumber: frame.SerialNumber,
+	}
+	if t := tracks.Track1; t != nil {
+		result.Track1 = &DeviceTrack{PAN: maskPan(t.PAN), Name: t.Name, Expiry: t.Expiry, ServiceCode: t.ServiceCode}
+	}
+	if t := tracks.Track2; t != nil {
+		result.Track2 = &DeviceTrack{PAN: maskPan(t.PAN), Expiry: t.Expiry, ServiceCode: t.ServiceCode}
+	}
+	for _, tlv := range tracks.EMV {
+		result.EMV = append(result.EMV, deviceTag(tlv, maskPan))
+	}
+
+	return result, nil
+}
+
+// deviceTag encodes value of EMV data object, PAN bearing tags keep PAN (masked by maskPan) and no discretionary data
+func deviceTag(tlv emv.TLV, maskPan func(pan string) string) DeviceTag {
+	value := strings.ToUpper(pkg.HexEncode(tlv.Value))
+
+	switch tlv.Tag {
+	case "5A":
+		// compressed numeric, padded with F
+		value = maskPan(strings.TrimRight(value, "F"))
+	case "56":
+		if t, err := device.ParseTrack1(string(tlv.Value)); err == nil {
+			value = "B" + maskPan(t.PAN) + "^" + t.Name + "^" + t.Expiry + t.ServiceCode
+		} else {
+			value = maskPan(value)
+		}
+	case "57", "9F6B":
+		// PAN, separator D, expiry YYMM, service code, discretionary data, padded with F
+		if pan, rest, found := strings.Cut(value, "D"); found && len(rest) >= 7 {
+			value = maskPan(pan) + "D" + rest[:7]
+		} else {
+			value = maskPan(strings.TrimRight(value, "F"))
+		}
+	}
+
+	return DeviceTag{Tag: tlv.Tag, Value: value}
+}
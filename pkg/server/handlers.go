@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/gorilla/mux"
@@ -455,3 +456,326 @@ func decryptDataEndpoint(s Service) endpoint.Endpoint {
 		return resp, nil
 	}
 }
+
+type envelopeRequest struct {
+	requestID string
+	ik        string
+	action    string
+	data      string
+	format    string
+}
+
+type sealEnvelopeResponse struct {
+	Envelope string `json:"envelope"`
+	Err      error  `json:"error"`
+}
+
+type openEnvelopeResponse struct {
+	Data string `json:"data"`
+	Err  error  `json:"error"`
+}
+
+func decodeEnvelopeRequest(_ context.Context, request *http.Request) (interface{}, error) {
+	req := envelopeRequest{
+		requestID: moovhttp.GetRequestID(request),
+	}
+
+	req.ik = mux.Vars(request)["ik"]
+
+	type requestParam struct {
+		Action string
+		Data   string
+		Format string
+	}
+
+	reqParams := requestParam{}
+	if err := bindJSON(request, &reqParams); err != nil {
+		return nil, err
+	}
+
+	req.action = reqParams.Action
+	req.data = reqParams.Data
+	req.format = reqParams.Format
+
+	return req, nil
+}
+
+func sealEnvelopeEndpoint(s Service) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(envelopeRequest)
+		if !ok {
+			return sealEnvelopeResponse{Err: ErrFoundABug}, ErrFoundABug
+		}
+
+		resp := sealEnvelopeResponse{}
+		sealed, err := s.SealEnvelope(req.ik, req.data, req.action, req.format)
+		if err != nil {
+			resp.Err = err
+			return resp, nil
+		}
+
+		resp.Envelope = sealed
+		return resp, nil
+	}
+}
+
+func openEnvelopeEndpoint(s Service) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(envelopeRequest)
+		if !ok {
+			return openEnvelopeResponse{Err: ErrFoundABug}, ErrFoundABug
+		}
+
+		resp := openEnvelopeResponse{}
+		opened, err := s.OpenEnvelope(req.ik, req.data, req.action, req.format)
+		if err != nil {
+			resp.Err = err
+			return resp, nil
+		}
+
+		resp.Data = opened
+		return resp, nil
+	}
+}
+
+type decryptDeviceRequest struct {
+	requestID string
+	token     string
+	device    DeviceRequest
+}
+
+type decryptDeviceResponse struct {
+	Data *DeviceData `json:"data"`
+	Err  error       `json:"error"`
+}
+
+func decodeDecryptDeviceRequest(_ context.Context, request *http.Request) (interface{}, error) {
+	req := decryptDeviceRequest{
+		requestID: moovhttp.GetRequestID(request),
+	}
+
+	// callers authenticate with bearer token to read unmasked PAN
+	req.token = bearerToken(request)
+
+	if err := bindJSON(request, &req.device); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func decryptDeviceEndpoint(s Service) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(decryptDeviceRequest)
+		if !ok {
+			return decryptDeviceResponse{Err: ErrFoundABug}, ErrFoundABug
+		}
+
+		resp := decryptDeviceResponse{}
+		data, err := s.DecryptDevice(req.token, req.device)
+		if err != nil {
+			resp.Err = err
+			return resp, nil
+		}
+
+		resp.Data = data
+		return resp, nil
+	}
+}
+
+// bearerToken returns token of Authorization header, empty when caller doesn't authenticate
+func bearerToken(request *http.Request) string {
+	if auth := request.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+type exportKeyRequest struct {
+	requestID string
+	token     string
+	ik        string
+	export    KeyExportRequest
+}
+
+type exportKeyResponse struct {
+	WrappedKey string `json:"wrappedKey"`
+	Err        error  `json:"error"`
+}
+
+// error makes failed exports respond with error status (forbidden callers get 403)
+func (r exportKeyResponse) error() error {
+	return r.Err
+}
+
+func decodeExportKeyRequest(_ context.Context, request *http.Request) (interface{}, error) {
+	req := exportKeyRequest{
+		requestID: moovhttp.GetRequestID(request),
+		token:     bearerToken(request),
+	}
+
+	req.ik = mux.Vars(request)["ik"]
+
+	if err := bindJSON(request, &req.export); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func exportKeyEndpoint(s Service) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(exportKeyRequest)
+		if !ok {
+			return exportKeyResponse{Err: ErrFoundABug}, ErrFoundABug
+		}
+
+		resp := exportKeyResponse{}
+		wrapped, err := s.ExportKey(req.token, req.ik, req.export)
+		if err != nil {
+			resp.Err = err
+			return resp, nil
+		}
+
+		resp.WrappedKey = wrapped
+		return resp, nil
+	}
+}
+
+type translatePinRequest struct {
+	requestID string
+	input     PinKey
+	output    PinKey
+	encrypted string
+	pan       string
+}
+
+type translatePinResponse struct {
+	Encrypted string `json:"encrypted"`
+	Err       error  `json:"error"`
+}
+
+func decodeTranslatePinRequest(_ context.Context, request *http.Request) (interface{}, error) {
+	req := translatePinRequest{
+		requestID: moovhttp.GetRequestID(request),
+	}
+
+	type requestParam struct {
+		Input     PinKey
+		Output    PinKey
+		Encrypted string
+		Pan       string
+	}
+
+	reqParams := requestParam{}
+	if err := bindJSON(request, &reqParams); err != nil {
+		return nil, err
+	}
+
+	req.input = reqParams.Input
+	req.output = reqParams.Output
+	req.encrypted = reqParams.Encrypted
+	req.pan = reqParams.Pan
+
+	return req, nil
+}
+
+func translatePinEndpoint(s Service) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(translatePinRequest)
+		if !ok {
+			return translatePinResponse{Err: ErrFoundABug}, ErrFoundABug
+		}
+
+		resp := translatePinResponse{}
+		encrypted, err := s.TranslatePin(req.input, req.output, req.encrypted, req.pan)
+		if err != nil {
+			resp.Err = err
+			return resp, nil
+		}
+
+		resp.Encrypted = encrypted
+		return resp, nil
+	}
+}
+
+type verifyPinRequest struct {
+	requestID    string
+	key          PinKey
+	encrypted    string
+	pan          string
+	verification PinVerification
+}
+
+type verifyPinResponse struct {
+	Verified bool  `json:"verified"`
+	Err      error `json:"error"`
+}
+
+func decodeVerifyPinRequest(_ context.Context, request *http.Request) (interface{}, error) {
+	req := verifyPinRequest{
+		requestID: moovhttp.GetRequestID(request),
+	}
+
+	type requestParam struct {
+		Key          PinKey
+		Encrypted    string
+		Pan          string
+		Verification PinVerification
+	}
+
+	reqParams := requestParam{}
+	if err := bindJSON(request, &reqParams); err != nil {
+		return nil, err
+	}
+
+	req.key = reqParams.Key
+	req.encrypted = reqParams.Encrypted
+	req.pan = reqParams.Pan
+	req.verification = reqParams.Verification
+
+	return req, nil
+}
+
+func verifyPinEndpoint(s Service) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(verifyPinRequest)
+		if !ok {
+			return verifyPinResponse{Err: ErrFoundABug}, ErrFoundABug
+		}
+
+		resp := verifyPinResponse{}
+		verified, err := s.VerifyPin(req.key, req.encrypted, req.pan, req.verification)
+		if err != nil {
+			resp.Err = err
+			return resp, nil
+		}
+
+		resp.Verified = verified
+		return resp, nil
+	}
+}
+
+type generatePinVerificationResponse struct {
+	Value string `json:"value"`
+	Err   error  `json:"error"`
+}
+
+func generatePinVerificationEndpoint(s Service) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(verifyPinRequest)
+		if !ok {
+			return generatePinVerificationResponse{Err: ErrFoundABug}, ErrFoundABug
+		}
+
+		resp := generatePinVerificationResponse{}
+		value, err := s.GeneratePinVerification(req.key, req.encrypted, req.pan, req.verification)
+		if err != nil {
+			resp.Err = err
+			return resp, nil
+		}
+
+		resp.Value = value
+		return resp, nil
+	}
+}
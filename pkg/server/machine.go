@@ -1,7 +1,10 @@
 package server
 
 import (
+	"errors"
 	"time"
+
+	"github.com/moov-io/dukpt/pkg"
 )
 
 type BaseKey struct {
@@ -9,6 +12,14 @@ type BaseKey struct {
 	AlgorithmKey      string
 	BaseDerivativeKey string
 	KeySerialNumber   string
+	// DataKeyMode is des data key derivation mode ("owf" or "variant", default owf)
+	DataKeyMode string
+	// WorkingKeyType is aes pin, mac and data key type (AES128, AES192, AES256, TDES2, TDES3),
+	// it may be shorter than the key derived from BaseDerivativeKey (default AlgorithmKey),
+	// TDES2 and TDES3 working keys use ISO-0 pin blocks, TDES CMAC and TDES CBC data encryption
+	WorkingKeyType string
+	// LegacyKsn maps 10 bytes key serial number of aes machine onto aes key serial number (compatibility mode)
+	LegacyKsn bool
 }
 
 type Machine struct {
@@ -25,3 +36,27 @@ func NewMachine(b BaseKey) *Machine {
 		CurrentKSN: b.KeySerialNumber,
 	}
 }
+
+// workingKeyType returns key type of aes working keys
+func (b BaseKey) workingKeyType() string {
+	if b.WorkingKeyType != "" {
+		return b.WorkingKeyType
+	}
+	return b.AlgorithmKey
+}
+
+// derivationKsn returns key serial number used by aes derivation, legacy key serial number is mapped in compatibility mode
+func (b BaseKey) derivationKsn(ksn string) (string, error) {
+	if !b.LegacyKsn {
+		return ksn, nil
+	}
+	if b.Algorithm != pkg.AlgorithmAes {
+		return "", errors.New("legacy key serial number is only supported by aes algorithm")
+	}
+
+	aesKsn, err := pkg.LegacyKsnToAesKsn(pkg.HexDecode(ksn))
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(aesKsn), nil
+}
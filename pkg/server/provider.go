@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/moov-io/dukpt"
+	"github.com/moov-io/dukpt/pkg"
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+)
+
+var errProviderKeyExport = errors.New("key export is not supported with key provider")
+
+// algorithm returns registered algorithm of params, its keys are handles of params.Provider when it is set
+func (p UnifiedParams) algorithm() (Algorithm, error) {
+	algorithm, err := LookupAlgorithm(p.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if p.Provider == nil {
+		return algorithm, nil
+	}
+	return providerAlgorithm{Algorithm: algorithm, provider: p.Provider}, nil
+}
+
+// providerAlgorithm runs operations of algorithm with dukpt.KeyProvider
+//
+// NOTE:
+//   - BDK (params.BKD), IK and TK of params are key handles instead of hex encoded keys
+//   - Keys never leave the provider, so keys can't be exported
+type providerAlgorithm struct {
+	Algorithm
+	provider dukpt.KeyProvider
+}
+
+// options maps params to options of dukpt.KeyProvider
+func (a providerAlgorithm) options(params UnifiedParams) ([]dukpt.Option, error) {
+	opts := []dukpt.Option{
+		dukpt.WithAlgorithm(a.Name()),
+		dukpt.WithAction(params.action()),
+		dukpt.WithRand(params.Rand),
+	}
+
+	if params.MacType != "" {
+		macType, err := pkg.ParseMacType(params.MacType)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, dukpt.WithMacType(macType))
+	}
+
+	switch a.Name() {
+	case pkg.AlgorithmAes:
+		if params.AlgorithmKey != "" {
+			keyType, err := aes.ParseKeyType(params.AlgorithmKey)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, dukpt.WithKeyType(keyType))
+		}
+	case pkg.AlgorithmDes:
+		if params.Format != "" {
+			format, err := des.ParsePinFormat(params.Format)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, dukpt.WithPinFormat(format))
+		}
+
+		mode, err := des.ParseDataKeyMode(params.DataKeyMode)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, dukpt.WithDataKeyMode(mode))
+	}
+
+	return opts, nil
+}
+
+func (a providerAlgorithm) InitialKey(params UnifiedParams) (string, error) {
+	opts, err := a.options(params)
+	if err != nil {
+		return "", err
+	}
+
+	handle, err := a.provider.DeriveIK(dukpt.KeyHandle(params.BKD), pkg.HexDecode(params.KSN), opts...)
+	return string(handle), err
+}
+
+func (a providerAlgorithm) TransactionKey(params UnifiedParams) (string, error) {
+	opts, err := a.options(params)
+	if err != nil {
+		return "", err
+	}
+
+	handle, err := a.provider.DeriveTransactionKey(dukpt.KeyHandle(params.IK), pkg.HexDecode(params.KSN), opts...)
+	return string(handle), err
+}
+
+func (a providerAlgorithm) EncryptPin(params UnifiedParams) (string, error) {
+	opts, err := a.options(params)
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := a.provider.EncryptPin(dukpt.KeyHandle(params.TK), pkg.HexDecode(params.KSN), []byte(params.PIN), []byte(params.PAN), opts...)
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (a providerAlgorithm) DecryptPin(params UnifiedParams) (string, error) {
+	opts, err := a.options(params)
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := a.provider.DecryptPin(dukpt.KeyHandle(params.TK), pkg.HexDecode(params.KSN), pkg.HexDecode(params.PIN), []byte(params.PAN), opts...)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (a providerAlgorithm) GenerateMac(params UnifiedParams) (string, error) {
+	opts, err := a.options(params)
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := a.provider.MAC(dukpt.KeyHandle(params.TK), pkg.HexDecode(params.KSN), []byte(params.Plaintext), opts...)
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (a providerAlgorithm) EncryptData(params UnifiedParams) (string, error) {
+	opts, err := a.options(params)
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := a.provider.EncryptData(dukpt.KeyHandle(params.TK), pkg.HexDecode(params.KSN), pkg.HexDecode(params.IV), []byte(params.Plaintext), opts...)
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func (a providerAlgorithm) DecryptData(params UnifiedParams) (string, error) {
+	opts, err := a.options(params)
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := a.provider.DecryptData(dukpt.KeyHandle(params.TK), pkg.HexDecode(params.KSN), pkg.HexDecode(params.IV), pkg.HexDecode(params.Ciphertext), opts...)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (a providerAlgorithm) ExportKey(params UnifiedParams) (string, error) {
+	return "", errProviderKeyExport
+}
@@ -156,6 +156,55 @@ func MakeHTTPHandler(s Service) http.Handler {
 		options...,
 	))
 
+	r.Methods("POST").Path("/seal_envelope/{ik}").Handler(httptransport.NewServer(
+		sealEnvelopeEndpoint(s),
+		decodeEnvelopeRequest,
+		encodeResponse,
+		options...,
+	))
+
+	r.Methods("POST").Path("/open_envelope/{ik}").Handler(httptransport.NewServer(
+		openEnvelopeEndpoint(s),
+		decodeEnvelopeRequest,
+		encodeResponse,
+		options...,
+	))
+
+	r.Methods("POST").Path("/decrypt_device").Handler(httptransport.NewServer(
+		decryptDeviceEndpoint(s),
+		decodeDecryptDeviceRequest,
+		encodeResponse,
+		options...,
+	))
+
+	r.Methods("POST").Path("/export_key/{ik}").Handler(httptransport.NewServer(
+		exportKeyEndpoint(s),
+		decodeExportKeyRequest,
+		encodeResponse,
+		options...,
+	))
+
+	r.Methods("POST").Path("/translate_pin").Handler(httptransport.NewServer(
+		translatePinEndpoint(s),
+		decodeTranslatePinRequest,
+		encodeResponse,
+		options...,
+	))
+
+	r.Methods("POST").Path("/verify_pin").Handler(httptransport.NewServer(
+		verifyPinEndpoint(s),
+		decodeVerifyPinRequest,
+		encodeResponse,
+		options...,
+	))
+
+	r.Methods("POST").Path("/generate_pin_verification").Handler(httptransport.NewServer(
+		generatePinVerificationEndpoint(s),
+		decodeVerifyPinRequest,
+		encodeResponse,
+		options...,
+	))
+
 	return r
 }
 
@@ -257,6 +306,8 @@ func codeFrom(err error) int {
 		return http.StatusNotFound
 	case ErrAlreadyExists:
 		return http.StatusBadRequest
+	case ErrForbidden:
+		return http.StatusForbidden
 	default:
 		return http.StatusInternalServerError
 	}
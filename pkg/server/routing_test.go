@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	moovhttp "github.com/moov-io/base/http"
+	"github.com/moov-io/dukpt/pkg"
 	"github.com/stretchr/testify/require"
 )
 
@@ -113,3 +114,175 @@ func TestRouting_machine_mgmt(t *testing.T) {
 	require.NotNil(t, response3.Machine)
 	require.Equal(t, "6ac292faa1315b4d858ab3a3d7d5933a", response3.Machine.InitialKey)
 }
+
+func TestRouting_envelope(t *testing.T) {
+	router := mockHttpHandler()
+
+	requestBody, err := json.Marshal(mockBaseAesKey())
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/machine", bytes.NewReader(requestBody))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	w.Flush()
+	require.Equal(t, http.StatusOK, w.Code)
+
+	machine := createMachineResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &machine))
+
+	requestBody, err = json.Marshal(map[string]string{"Data": "4012345678909D987", "Format": "binary"})
+	require.NoError(t, err)
+
+	req = httptest.NewRequest("POST", "/seal_envelope/"+machine.IK, bytes.NewReader(requestBody))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	w.Flush()
+	require.Equal(t, http.StatusOK, w.Code)
+
+	sealed := sealEnvelopeResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sealed))
+	require.NotEmpty(t, sealed.Envelope)
+
+	requestBody, err = json.Marshal(map[string]string{"Data": sealed.Envelope, "Format": "binary"})
+	require.NoError(t, err)
+
+	req = httptest.NewRequest("POST", "/open_envelope/"+machine.IK, bytes.NewReader(requestBody))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	w.Flush()
+	require.Equal(t, http.StatusOK, w.Code)
+
+	opened := openEnvelopeResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &opened))
+	require.Equal(t, "4012345678909D987", opened.Data)
+}
+
+func TestRouting_decrypt_device(t *testing.T) {
+	router := MakeHTTPHandler(mockDeviceService())
+
+	requestBody, err := json.Marshal(map[string]string{"Format": "idtech-enhanced", "Data": pkg.HexEncode(mockDeviceFrame(t))})
+	require.NoError(t, err)
+
+	for token, pan := range map[string]string{"": "401234***8909", "full-pan-token": "4012345678909"} {
+		req := httptest.NewRequest("POST", "/decrypt_device", bytes.NewReader(requestBody))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := decryptDeviceResponse{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Equal(t, pan, resp.Data.Track2.PAN)
+		require.Equal(t, "2512", resp.Data.Track2.Expiry)
+	}
+}
+
+func TestRouting_export_key(t *testing.T) {
+	s := NewService(NewRepositoryInMemory(nil), WithAuthorizer(NewTokenAuthorizer(map[string][]string{"export-token": {PermissionExportKey}})))
+	router := MakeHTTPHandler(s)
+
+	m := NewMachine(mockBaseDesKey())
+	require.NoError(t, s.CreateMachine(m))
+
+	requestBody, err := json.Marshal(map[string]string{"Key": "tk", "KEK": "000102030405060708090A0B0C0D0E0F"})
+	require.NoError(t, err)
+
+	for token, code := range map[string]int{"": http.StatusForbidden, "other-token": http.StatusForbidden, "export-token": http.StatusOK} {
+		req := httptest.NewRequest("POST", "/export_key/"+m.InitialKey, bytes.NewReader(requestBody))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		require.Equal(t, code, w.Code)
+
+		if code == http.StatusOK {
+			resp := exportKeyResponse{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			require.NotEmpty(t, resp.WrappedKey)
+		}
+	}
+}
+
+func TestRouting_translate_pin(t *testing.T) {
+	router := mockHttpHandler()
+
+	body := map[string]interface{}{
+		"Input": PinKey{
+			Scheme: "dukpt-des",
+			BDK:    "0123456789ABCDEFFEDCBA9876543210",
+			KSN:    "FFFF9876543210E00001",
+		},
+		"Output": PinKey{
+			Scheme: "dukpt-des",
+			BDK:    "0123456789ABCDEFFEDCBA9876543210",
+			KSN:    "FFFF9876543210E00002",
+		},
+		"Encrypted": "1B9C1845EB993A7A",
+		"Pan":       "4012345678909",
+	}
+	requestBody, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/translate_pin", bytes.NewReader(requestBody))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	w.Flush()
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	response := translatePinResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	require.Equal(t, "10a01c8d02c69107", response.Encrypted)
+}
+
+func TestRouting_verify_pin(t *testing.T) {
+	router := mockHttpHandler()
+
+	body := map[string]interface{}{
+		"Key": PinKey{
+			Scheme: "dukpt-des",
+			BDK:    "0123456789ABCDEFFEDCBA9876543210",
+			KSN:    "FFFF9876543210E00001",
+		},
+		"Encrypted": "1B9C1845EB993A7A",
+		"Pan":       "4012345678909",
+		"Verification": PinVerification{
+			Method:         "ibm3624",
+			PVK:            "0123456789ABCDEFFEDCBA9876543210",
+			ValidationData: "4012345678909000",
+			Offset:         "9001",
+		},
+	}
+	requestBody, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/generate_pin_verification", bytes.NewReader(requestBody))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	w.Flush()
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	generated := generatePinVerificationResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), &generated)
+	require.NoError(t, err)
+	require.Equal(t, "9001", generated.Value)
+
+	req = httptest.NewRequest("POST", "/verify_pin", bytes.NewReader(requestBody))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	w.Flush()
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	response := verifyPinResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	require.True(t, response.Verified)
+}
@@ -3,14 +3,18 @@ package server
 import (
 	"errors"
 	"fmt"
+	"io"
 
+	"github.com/moov-io/dukpt"
 	"github.com/moov-io/dukpt/pkg"
 	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/host"
 )
 
 var (
 	ErrNotFound      = errors.New("not found")
 	ErrAlreadyExists = errors.New("already exists")
+	ErrForbidden     = errors.New("forbidden")
 )
 
 // Service is a REST interface for interacting with machine structures
@@ -25,18 +29,65 @@ type Service interface {
 	GenerateMac(ik, data, action, macType string) (string, error)
 	EncryptData(ik, data, action, iv string) (string, error)
 	DecryptData(ik, ciphertext, action, iv string) (string, error)
+	SealEnvelope(ik, data, action, format string) (string, error)
+	OpenEnvelope(ik, envelope, action, format string) (string, error)
+	TranslatePin(in, out PinKey, ciphertext, pan string) (string, error)
+	VerifyPin(key PinKey, ciphertext, pan string, verification PinVerification) (bool, error)
+	GeneratePinVerification(key PinKey, ciphertext, pan string, verification PinVerification) (string, error)
+	DecryptDevice(token string, req DeviceRequest) (*DeviceData, error)
+	ExportKey(token, ik string, req KeyExportRequest) (string, error)
 }
 
 // service a concrete implementation of the service.
 type service struct {
-	store Repository
+	store      Repository
+	host       *host.Host
+	authorizer Authorizer
+	rand       io.Reader
+	provider   dukpt.KeyProvider
+}
+
+// ServiceOption configures optional features of the service
+type ServiceOption func(*service)
+
+// WithHost enables device decryption with base derivative keys of host
+func WithHost(h *host.Host) ServiceOption {
+	return func(s *service) {
+		s.host = h
+	}
+}
+
+// WithAuthorizer grants permissions (e.g. PermissionFullPAN) to caller tokens
+func WithAuthorizer(a Authorizer) ServiceOption {
+	return func(s *service) {
+		s.authorizer = a
+	}
+}
+
+// WithRand replaces crypto/rand as random source of pin block fill and envelope iv (e.g. pkg.NewSeededReader in golden tests)
+func WithRand(r io.Reader) ServiceOption {
+	return func(s *service) {
+		s.rand = r
+	}
+}
+
+// WithKeyProvider keeps machine keys in key provider, base derivative keys of machines are key handles of the provider
+// (e.g. hsm.Software or a PKCS#11 token), so the clear keys never enter the service
+func WithKeyProvider(p dukpt.KeyProvider) ServiceOption {
+	return func(s *service) {
+		s.provider = p
+	}
 }
 
 // NewService creates a new concrete service
-func NewService(r Repository) Service {
-	return &service{
+func NewService(r Repository, opts ...ServiceOption) Service {
+	s := &service{
 		store: r,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // CreateMachine add a machine to storage
@@ -45,16 +96,26 @@ func (s *service) CreateMachine(m *Machine) error {
 		return ErrNotFound
 	}
 
+	ksn, err := m.derivationKsn(m.KeySerialNumber)
+	if err != nil {
+		return err
+	}
+
 	params := UnifiedParams{
 		Algorithm:    m.Algorithm,
 		AlgorithmKey: m.AlgorithmKey,
-		KSN:          m.KeySerialNumber,
+		KSN:          ksn,
 		BKD:          m.BaseDerivativeKey,
+		DataKeyMode:  m.DataKeyMode,
+		Provider:     s.provider,
 	}
 
 	if err := params.ValidateAlgorithm(); err != nil {
 		return err
 	}
+	if err := params.ValidateDataKeyMode(); err != nil {
+		return err
+	}
 
 	ik, err := InitialKey(params)
 	if err != nil {
@@ -70,6 +131,15 @@ func (s *service) CreateMachine(m *Machine) error {
 		return err
 	}
 
+	// transaction key of key provider is a handle, working key type is checked on first use
+	if m.Algorithm == pkg.AlgorithmAes && m.WorkingKeyType != "" && s.provider == nil {
+		// working key type must not be stronger than transaction key
+		_, err = aes.DeriveWorkingKey(pkg.HexDecode(m.TransactionKey), pkg.HexDecode(ksn), pkg.UsagePin, m.WorkingKeyType, "", nil)
+		if err != nil {
+			return err
+		}
+	}
+
 	if err = s.store.StoreMachine(m); err != nil {
 		return err
 	}
@@ -97,27 +167,35 @@ func (s *service) MakeNextKSN(ik string) (*Machine, error) {
 		return nil, fmt.Errorf("make next ksn: %v(%s)", err, ik)
 	}
 
-	var nextKsn []byte
-	if m.Algorithm == pkg.AlgorithmAes {
-		nextKsn, err = pkg.GenerateNextAesKsn(pkg.HexDecode(m.CurrentKSN))
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		nextKsn, err = pkg.GenerateNextDesKsn(pkg.HexDecode(m.CurrentKSN))
-		if err != nil {
-			return nil, err
-		}
+	// legacy key serial number follows des layout
+	name := m.Algorithm
+	if m.LegacyKsn {
+		name = pkg.AlgorithmDes
+	}
+	algorithm, err := LookupAlgorithm(name)
+	if err != nil {
+		return nil, err
+	}
+
+	nextKsn, err := algorithm.NextKSN(m.CurrentKSN)
+	if err != nil {
+		return nil, err
 	}
 
 	// update machine
-	m.CurrentKSN = pkg.HexEncode(nextKsn)
+	m.CurrentKSN = nextKsn
+
+	ksn, err := m.derivationKsn(m.CurrentKSN)
+	if err != nil {
+		return nil, err
+	}
 
 	params := UnifiedParams{
 		Algorithm:    m.Algorithm,
 		AlgorithmKey: m.AlgorithmKey,
-		KSN:          m.CurrentKSN,
+		KSN:          ksn,
 		IK:           ik,
+		Provider:     s.provider,
 	}
 	m.TransactionKey, err = TransactionKey(params)
 	if err != nil {
@@ -137,15 +215,22 @@ func (s *service) EncryptPin(ik, pin, pan, format string) (string, error) {
 		return "", fmt.Errorf("make next ksn: %v(%s)", err, ik)
 	}
 
+	ksn, err := m.derivationKsn(m.CurrentKSN)
+	if err != nil {
+		return "", err
+	}
+
 	params := UnifiedParams{
 		Algorithm:    m.Algorithm,
-		AlgorithmKey: m.AlgorithmKey,
+		AlgorithmKey: m.workingKeyType(),
 		TK:           m.TransactionKey,
-		KSN:          m.CurrentKSN,
+		KSN:          ksn,
 		IK:           ik,
 		Format:       format,
 		PIN:          pin,
 		PAN:          pan,
+		Rand:         s.rand,
+		Provider:     s.provider,
 	}
 
 	if params.Format == "" {
@@ -161,15 +246,21 @@ func (s *service) DecryptPin(ik, ciphertext, pan, format string) (string, error)
 		return "", fmt.Errorf("make next ksn: %v(%s)", err, ik)
 	}
 
+	ksn, err := m.derivationKsn(m.CurrentKSN)
+	if err != nil {
+		return "", err
+	}
+
 	params := UnifiedParams{
 		Algorithm:    m.Algorithm,
-		AlgorithmKey: m.AlgorithmKey,
+		AlgorithmKey: m.workingKeyType(),
 		TK:           m.TransactionKey,
-		KSN:          m.CurrentKSN,
+		KSN:          ksn,
 		IK:           ik,
 		Format:       format,
 		PIN:          ciphertext,
 		PAN:          pan,
+		Provider:     s.provider,
 	}
 
 	if params.Format == "" {
@@ -185,28 +276,26 @@ func (s *service) GenerateMac(ik, data, action, macType string) (string, error)
 		return "", fmt.Errorf("make next ksn: %v(%s)", err, ik)
 	}
 
+	ksn, err := m.derivationKsn(m.CurrentKSN)
+	if err != nil {
+		return "", err
+	}
+
 	params := UnifiedParams{
 		Algorithm:    m.Algorithm,
-		AlgorithmKey: m.AlgorithmKey,
+		AlgorithmKey: m.workingKeyType(),
 		TK:           m.TransactionKey,
-		KSN:          m.CurrentKSN,
+		KSN:          ksn,
 		IK:           ik,
 		Plaintext:    data,
 		Action:       action,
 		MacType:      macType,
+		Provider:     s.provider,
 	}
 
 	// workaround for hmac
 	if macType == pkg.MaxTypeHmac && m.Algorithm == pkg.AlgorithmAes {
-		if m.AlgorithmKey == aes.KeyAES128Type {
-			params.AlgorithmKey = aes.KeyHMAC128Type
-		}
-		if m.AlgorithmKey == aes.KeyAES192Type {
-			params.AlgorithmKey = aes.KeyHMAC192Type
-		}
-		if m.AlgorithmKey == aes.KeyAES256Type {
-			params.AlgorithmKey = aes.KeyHMAC256Type
-		}
+		params.AlgorithmKey = hmacKeyType(params.AlgorithmKey)
 	}
 
 	return GenerateMac(params)
@@ -218,15 +307,22 @@ func (s *service) EncryptData(ik, data, action, iv string) (string, error) {
 		return "", fmt.Errorf("make next ksn: %v(%s)", err, ik)
 	}
 
+	ksn, err := m.derivationKsn(m.CurrentKSN)
+	if err != nil {
+		return "", err
+	}
+
 	params := UnifiedParams{
 		Algorithm:    m.Algorithm,
-		AlgorithmKey: m.AlgorithmKey,
+		AlgorithmKey: m.workingKeyType(),
 		TK:           m.TransactionKey,
-		KSN:          m.CurrentKSN,
+		KSN:          ksn,
 		IK:           ik,
 		Plaintext:    data,
 		Action:       action,
 		IV:           iv,
+		DataKeyMode:  m.DataKeyMode,
+		Provider:     s.provider,
 	}
 
 	return EncryptData(params)
@@ -238,16 +334,154 @@ func (s *service) DecryptData(ik, ciphertext, action, iv string) (string, error)
 		return "", fmt.Errorf("make next ksn: %v(%s)", err, ik)
 	}
 
+	ksn, err := m.derivationKsn(m.CurrentKSN)
+	if err != nil {
+		return "", err
+	}
+
 	params := UnifiedParams{
 		Algorithm:    m.Algorithm,
-		AlgorithmKey: m.AlgorithmKey,
+		AlgorithmKey: m.workingKeyType(),
 		TK:           m.TransactionKey,
-		KSN:          m.CurrentKSN,
+		KSN:          ksn,
 		IK:           ik,
 		Ciphertext:   ciphertext,
 		Action:       action,
 		IV:           iv,
+		DataKeyMode:  m.DataKeyMode,
+		Provider:     s.provider,
 	}
 
 	return DecryptData(params)
 }
+
+// SealEnvelope encrypts and authenticates data with transaction key of machine's current ksn
+func (s *service) SealEnvelope(ik, data, action, format string) (string, error) {
+	params, err := s.envelopeParams(ik, action, format)
+	if err != nil {
+		return "", err
+	}
+
+	params.Plaintext = data
+	return SealEnvelope(params)
+}
+
+// OpenEnvelope verifies and decrypts envelope sealed with transaction key of machine's current ksn
+func (s *service) OpenEnvelope(ik, envelope, action, format string) (string, error) {
+	params, err := s.envelopeParams(ik, action, format)
+	if err != nil {
+		return "", err
+	}
+
+	params.Ciphertext = envelope
+	return OpenEnvelope(params)
+}
+
+func (s *service) envelopeParams(ik, action, format string) (UnifiedParams, error) {
+	m, err := s.GetMachine(ik)
+	if err != nil {
+		return UnifiedParams{}, fmt.Errorf("envelope: %v(%s)", err, ik)
+	}
+
+	ksn, err := m.derivationKsn(m.CurrentKSN)
+	if err != nil {
+		return UnifiedParams{}, err
+	}
+
+	params := UnifiedParams{
+		Algorithm:      m.Algorithm,
+		AlgorithmKey:   m.workingKeyType(),
+		TK:             m.TransactionKey,
+		KSN:            ksn,
+		IK:             ik,
+		Action:         action,
+		DataKeyMode:    m.DataKeyMode,
+		EnvelopeFormat: format,
+		Rand:           s.rand,
+		Provider:       s.provider,
+	}
+
+	return params, nil
+}
+
+// TranslatePin re-encrypts a PIN block from input key to output key without returning the clear PIN
+func (s *service) TranslatePin(in, out PinKey, ciphertext, pan string) (string, error) {
+	return TranslatePin(in, out, ciphertext, pan)
+}
+
+// VerifyPin checks a PIN block against IBM 3624 offset or Visa PVV without returning the clear PIN
+func (s *service) VerifyPin(key PinKey, ciphertext, pan string, verification PinVerification) (bool, error) {
+	return VerifyPin(key, ciphertext, pan, verification)
+}
+
+// GeneratePinVerification makes IBM 3624 offset or Visa PVV of a PIN block for PIN issuance
+func (s *service) GeneratePinVerification(key PinKey, ciphertext, pan string, verification PinVerification) (string, error) {
+	return GeneratePinVerification(key, ciphertext, pan, verification)
+}
+
+// DecryptDevice decrypts card reader output, PAN is masked unless caller's token has PermissionFullPAN
+func (s *service) DecryptDevice(token string, req DeviceRequest) (*DeviceData, error) {
+	fullPan := s.authorizer != nil && s.authorizer.Authorize(token, PermissionFullPAN)
+	return DecryptDevice(s.host, req, fullPan)
+}
+
+// KeyExportRequest selects machine key exported under key encryption key (hex encoded)
+type KeyExportRequest struct {
+	// Key is ik, tk or working key usage (pin, mac, data)
+	Key string
+	// KEK is 16, 24 or 32 bytes AES key encryption key
+	KEK string
+	// Mode is rfc3394 or rfc5649 (default rfc5649)
+	Mode string
+	// Action selects request or response working key
+	Action string
+	// MacType selects hmac working key of aes machine (default cmac)
+	MacType string
+}
+
+// ExportKey returns machine key wrapped under key encryption key, caller's token needs PermissionExportKey
+func (s *service) ExportKey(token, ik string, req KeyExportRequest) (string, error) {
+	if s.authorizer == nil || !s.authorizer.Authorize(token, PermissionExportKey) {
+		return "", ErrForbidden
+	}
+
+	m, err := s.GetMachine(ik)
+	if err != nil {
+		return "", fmt.Errorf("export key: %v(%s)", err, ik)
+	}
+
+	ksn, err := m.derivationKsn(m.CurrentKSN)
+	if err != nil {
+		return "", err
+	}
+
+	params := UnifiedParams{
+		Algorithm:    m.Algorithm,
+		AlgorithmKey: m.workingKeyType(),
+		BKD:          m.BaseDerivativeKey,
+		IK:           ik,
+		TK:           m.TransactionKey,
+		KSN:          ksn,
+		Action:       req.Action,
+		DataKeyMode:  m.DataKeyMode,
+		KEK:          req.KEK,
+		KeyWrapMode:  req.Mode,
+		ExportKey:    req.Key,
+		Provider:     s.provider,
+	}
+
+	if req.Key == pkg.UsageMac && req.MacType == pkg.MaxTypeHmac && m.Algorithm == pkg.AlgorithmAes {
+		params.AlgorithmKey = hmacKeyType(params.AlgorithmKey)
+	}
+
+	return ExportKey(params)
+}
+
+// hmacKeyType returns hmac key type of aes key type name, unknown names are returned unchanged
+func hmacKeyType(keyType string) string {
+	k, err := aes.ParseKeyType(keyType)
+	if err != nil {
+		return keyType
+	}
+	return k.HMAC().String()
+}
@@ -1,11 +1,17 @@
 package server
 
 import (
+	"encoding/base64"
 	"strings"
 	"testing"
 
+	"github.com/moov-io/dukpt/encryption"
 	"github.com/moov-io/dukpt/pkg"
 	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+	"github.com/moov-io/dukpt/pkg/host"
+	"github.com/moov-io/dukpt/pkg/hsm"
+	"github.com/moov-io/dukpt/pkg/pin"
 	"github.com/stretchr/testify/require"
 )
 
@@ -112,6 +118,83 @@ func TestService__EncryptPin(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestService__Rand(t *testing.T) {
+	s := NewService(NewRepositoryInMemory(nil), WithRand(pkg.NewSeededReader(7)))
+
+	m := NewMachine(mockBaseDesKey())
+	require.NoError(t, s.CreateMachine(m))
+
+	encrypted, err := s.EncryptPin(m.InitialKey, "1234", "4012345678909", "ISO-3")
+	require.NoError(t, err)
+	require.Equal(t, "A73793448849CB3F", strings.ToUpper(encrypted))
+
+	// services of the same seed seal the same envelope
+	var sealed []string
+	for i := 0; i < 2; i++ {
+		s := NewService(NewRepositoryInMemory(nil), WithRand(pkg.NewSeededReader(1)))
+		m := NewMachine(mockBaseAesKey())
+		require.NoError(t, s.CreateMachine(m))
+
+		data, err := s.SealEnvelope(m.InitialKey, "4012345678909D987", pkg.ActionRequest, EnvelopeFormatBinary)
+		require.NoError(t, err)
+		sealed = append(sealed, data)
+	}
+	require.Equal(t, sealed[0], sealed[1])
+}
+
+func TestService__KeyProvider(t *testing.T) {
+	provider, err := hsm.NewSoftware(pkg.HexDecode("000102030405060708090A0B0C0D0E0F"))
+	require.NoError(t, err)
+
+	plain := mockServiceInMemory()
+	s := NewService(NewRepositoryInMemory(nil), WithKeyProvider(provider), WithAuthorizer(NewTokenAuthorizer(map[string][]string{"export-token": {PermissionExportKey}})))
+
+	for _, base := range []BaseKey{mockBaseDesKey(), mockBaseAesKey()} {
+		m := NewMachine(base)
+		require.NoError(t, plain.CreateMachine(m))
+
+		// machine of provider has handle of base derivative key, its keys are handles too
+		bdk, err := provider.ImportKey(pkg.HexDecode(base.BaseDerivativeKey))
+		require.NoError(t, err)
+		base.BaseDerivativeKey = string(bdk)
+
+		hm := NewMachine(base)
+		require.NoError(t, s.CreateMachine(hm))
+
+		tk, err := provider.ImportKey(pkg.HexDecode(m.TransactionKey))
+		require.NoError(t, err)
+		require.Equal(t, string(tk), hm.TransactionKey)
+
+		encrypted, err := s.EncryptPin(hm.InitialKey, "1234", "4111111111111111", "")
+		require.NoError(t, err)
+		pin, err := plain.DecryptPin(m.InitialKey, encrypted, "4111111111111111", "")
+		require.NoError(t, err)
+		require.Equal(t, "1234", pin)
+
+		expected, err := plain.GenerateMac(m.InitialKey, "4012345678909D987", pkg.ActionRequest, pkg.MaxTypeCmac)
+		require.NoError(t, err)
+		mac, err := s.GenerateMac(hm.InitialKey, "4012345678909D987", pkg.ActionRequest, pkg.MaxTypeCmac)
+		require.NoError(t, err)
+		require.Equal(t, expected, mac)
+
+		expected, err = plain.EncryptData(m.InitialKey, "4012345678909D987", pkg.ActionRequest, "")
+		require.NoError(t, err)
+		data, err := s.EncryptData(hm.InitialKey, "4012345678909D987", pkg.ActionRequest, "")
+		require.NoError(t, err)
+		require.Equal(t, expected, data)
+
+		_, err = s.SealEnvelope(hm.InitialKey, "4012345678909D987", pkg.ActionRequest, EnvelopeFormatJSON)
+		require.EqualError(t, err, "envelope is not supported with key provider")
+
+		_, err = s.ExportKey("export-token", hm.InitialKey, KeyExportRequest{Key: ExportKeyTransaction, KEK: "000102030405060708090A0B0C0D0E0F"})
+		require.ErrorIs(t, err, errProviderKeyExport)
+
+		next, err := s.MakeNextKSN(hm.InitialKey)
+		require.NoError(t, err)
+		require.NotEqual(t, string(tk), next.TransactionKey)
+	}
+}
+
 func TestService__DecryptPin(t *testing.T) {
 	s := mockServiceInMemory()
 
@@ -166,6 +249,155 @@ func TestService__DecryptData(t *testing.T) {
 	require.Equal(t, 0, strings.Index(strings.ToUpper(data), "4012345678909D987"))
 }
 
+func TestService__DataKeyMode(t *testing.T) {
+	s := mockServiceInMemory()
+
+	base := mockBaseDesKey()
+	base.DataKeyMode = "variant"
+	m := NewMachine(base)
+	require.NoError(t, s.CreateMachine(m))
+
+	encrypted, err := s.EncryptData(m.InitialKey, "4012345678909D987", pkg.ActionRequest, "")
+	require.NoError(t, err)
+	require.Equal(t, "E93B3B0570ECBCDF94453F22D7046D0F05169055540ABE86", strings.ToUpper(encrypted))
+
+	data, err := s.DecryptData(m.InitialKey, encrypted, pkg.ActionRequest, "")
+	require.NoError(t, err)
+	require.Equal(t, 0, strings.Index(strings.ToUpper(data), "4012345678909D987"))
+
+	base.DataKeyMode = "xor"
+	err = s.CreateMachine(NewMachine(base))
+	require.EqualError(t, err, `invalid data key mode "xor"`)
+
+	aesBase := mockBaseAesKey()
+	aesBase.DataKeyMode = "variant"
+	err = s.CreateMachine(NewMachine(aesBase))
+	require.EqualError(t, err, "data key mode is only supported by des algorithm")
+}
+
+func TestService__WorkingKeyType(t *testing.T) {
+	s := mockServiceInMemory()
+
+	base := BaseKey{
+		Algorithm:         pkg.AlgorithmAes,
+		BaseDerivativeKey: "FEDCBA9876543210F1F1F1F1F1F1F1F1FEDCBA9876543210F1F1F1F1F1F1F1F1",
+		KeySerialNumber:   "123456789012345600000001",
+		AlgorithmKey:      aes.KeyAES256Type,
+		WorkingKeyType:    aes.KeyAES128Type,
+	}
+	m := NewMachine(base)
+	require.NoError(t, s.CreateMachine(m))
+
+	encrypted, err := s.EncryptData(m.InitialKey, "4012345678909D987", pkg.ActionRequest, "")
+	require.NoError(t, err)
+	require.Equal(t, "90D417E83B22D858ACB8D98D4F2F66D9331FFCA232E65B16D6398D52394EA20D", strings.ToUpper(encrypted))
+
+	mac, err := s.GenerateMac(m.InitialKey, "4012345678909D987", pkg.ActionRequest, pkg.MaxTypeCmac)
+	require.NoError(t, err)
+	require.Equal(t, "C94CF5495EEC750788C26C29E3BF2E3E", strings.ToUpper(mac))
+
+	encrypted, err = s.EncryptPin(m.InitialKey, "1234", "4111111111111111", "")
+	require.NoError(t, err)
+	require.Len(t, encrypted, 32)
+
+	base = mockBaseAesKey()
+	base.WorkingKeyType = aes.KeyAES256Type
+	err = s.CreateMachine(NewMachine(base))
+	require.EqualError(t, err, "mismatched key length and key type")
+
+	// 2TDEA working keys of aes transaction key
+	base = mockBaseAesKey()
+	base.WorkingKeyType = aes.KeyTDES2Type
+	m = NewMachine(base)
+	require.NoError(t, s.CreateMachine(m))
+
+	encrypted, err = s.EncryptData(m.InitialKey, "4012345678909D987", pkg.ActionRequest, "")
+	require.NoError(t, err)
+	require.Equal(t, "AC8B2166615E553BAF8717272E2250E8DB9D1EADE4063F19", strings.ToUpper(encrypted))
+
+	mac, err = s.GenerateMac(m.InitialKey, "4012345678909D987", pkg.ActionRequest, pkg.MaxTypeCmac)
+	require.NoError(t, err)
+	require.Equal(t, "44992DECE189AEDB", strings.ToUpper(mac))
+
+	encrypted, err = s.EncryptPin(m.InitialKey, "1234", "4111111111111111", "")
+	require.NoError(t, err)
+	require.Len(t, encrypted, 16)
+
+	pin, err := s.DecryptPin(m.InitialKey, encrypted, "4111111111111111", "")
+	require.NoError(t, err)
+	require.Equal(t, "1234", pin)
+}
+
+func TestService__LegacyKsn(t *testing.T) {
+	s := mockServiceInMemory()
+
+	base := mockBaseAesKey()
+	base.KeySerialNumber = "FFFF9876543210E00001"
+	base.LegacyKsn = true
+	legacy := NewMachine(base)
+	require.NoError(t, s.CreateMachine(legacy))
+
+	// the same device with mapped aes key serial number
+	base = mockBaseAesKey()
+	base.KeySerialNumber = "FFFF9876543210E000000001"
+	mapped := NewMachine(base)
+	require.NoError(t, mockServiceInMemory().CreateMachine(mapped))
+
+	require.Equal(t, mapped.InitialKey, legacy.InitialKey)
+	require.Equal(t, mapped.TransactionKey, legacy.TransactionKey)
+
+	m, err := s.MakeNextKSN(legacy.InitialKey)
+	require.NoError(t, err)
+	require.Equal(t, "ffff9876543210e00002", m.CurrentKSN)
+
+	expected, err := aes.EncryptData(pkg.HexDecode(m.TransactionKey), pkg.HexDecode("FFFF9876543210E000000002"), nil, "4012345678909D987", aes.KeyAES128Type, pkg.ActionRequest)
+	require.NoError(t, err)
+
+	encrypted, err := s.EncryptData(m.InitialKey, "4012345678909D987", pkg.ActionRequest, "")
+	require.NoError(t, err)
+	require.Equal(t, pkg.HexEncode(expected), encrypted)
+
+	base = mockBaseDesKey()
+	base.LegacyKsn = true
+	err = s.CreateMachine(NewMachine(base))
+	require.EqualError(t, err, "legacy key serial number is only supported by aes algorithm")
+
+	base = mockBaseAesKey()
+	base.LegacyKsn = true
+	err = s.CreateMachine(NewMachine(base))
+	require.EqualError(t, err, "legacy key serial number length must be 10 bytes")
+}
+
+func TestService__Envelope(t *testing.T) {
+	s := mockServiceInMemory()
+
+	for _, base := range []BaseKey{mockBaseDesKey(), mockBaseAesKey()} {
+		m := NewMachine(base)
+		require.NoError(t, s.CreateMachine(m))
+
+		for _, format := range []string{"", EnvelopeFormatBinary} {
+			sealed, err := s.SealEnvelope(m.InitialKey, "4012345678909D987", pkg.ActionRequest, format)
+			require.NoError(t, err)
+
+			data, err := s.OpenEnvelope(m.InitialKey, sealed, pkg.ActionRequest, format)
+			require.NoError(t, err)
+			require.Equal(t, "4012345678909D987", data)
+		}
+
+		sealed, err := s.SealEnvelope(m.InitialKey, "4012345678909D987", pkg.ActionRequest, "")
+		require.NoError(t, err)
+
+		// envelope is bound to current ksn of machine
+		_, err = s.MakeNextKSN(m.InitialKey)
+		require.NoError(t, err)
+		_, err = s.OpenEnvelope(m.InitialKey, sealed, pkg.ActionRequest, "")
+		require.EqualError(t, err, "envelope key serial number mismatch")
+
+		_, err = s.SealEnvelope(m.InitialKey, "4012345678909D987", pkg.ActionRequest, "xml")
+		require.EqualError(t, err, "invalid envelope format")
+	}
+}
+
 func TestService__GenerateMac(t *testing.T) {
 	s := mockServiceInMemory()
 
@@ -187,3 +419,243 @@ func TestService__GenerateMac(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "B6F8B3159CD4E140159DA87A68C0FB7AF2F123D222662E98988C76386E8E8A02", strings.ToUpper(encrypted))
 }
+
+func TestService__TranslatePin(t *testing.T) {
+	s := mockServiceInMemory()
+
+	in := PinKey{
+		Scheme: pin.SchemeDukptDes,
+		BDK:    "0123456789ABCDEFFEDCBA9876543210",
+		KSN:    "FFFF9876543210E00001",
+		Format: "ISO-0",
+	}
+	out := PinKey{
+		Scheme: pin.SchemeZpkTdes,
+		ZPK:    "89B07B35A1B3F47E89B07B35A1B3F47E",
+		Format: "ISO-3",
+	}
+
+	translated, err := s.TranslatePin(in, out, "1B9C1845EB993A7A", "4012345678909")
+	require.NoError(t, err)
+	require.Len(t, translated, 16)
+
+	encrypted, err := s.TranslatePin(out, in, translated, "4012345678909")
+	require.NoError(t, err)
+	require.Equal(t, "1B9C1845EB993A7A", strings.ToUpper(encrypted))
+
+	_, err = s.TranslatePin(in, PinKey{Scheme: "unknown"}, "1B9C1845EB993A7A", "4012345678909")
+	require.Error(t, err)
+}
+
+func TestService__VerifyPin(t *testing.T) {
+	s := mockServiceInMemory()
+
+	key := PinKey{
+		Scheme: pin.SchemeDukptDes,
+		BDK:    "0123456789ABCDEFFEDCBA9876543210",
+		KSN:    "FFFF9876543210E00001",
+		Format: "ISO-0",
+	}
+	verification := PinVerification{
+		Method: pin.MethodVisaPVV,
+		PVK:    "0123456789ABCDEFFEDCBA9876543210",
+		PVKI:   1,
+	}
+
+	pvv, err := s.GeneratePinVerification(key, "1B9C1845EB993A7A", "4012345678909", verification)
+	require.NoError(t, err)
+	require.Equal(t, "5111", pvv)
+
+	verification.PVV = pvv
+	verified, err := s.VerifyPin(key, "1B9C1845EB993A7A", "4012345678909", verification)
+	require.NoError(t, err)
+	require.True(t, verified)
+
+	verification.PVV = "5112"
+	verified, err = s.VerifyPin(key, "1B9C1845EB993A7A", "4012345678909", verification)
+	require.NoError(t, err)
+	require.False(t, verified)
+}
+
+// mockDeviceFrame is IDTech enhanced frame with tracks encrypted by TDES data key of KSN FFFF9876543210E00001
+func mockDeviceFrame(t *testing.T) []byte {
+	t.Helper()
+
+	tracks := []string{"%B4012345678909^DOE/JOHN^2512101123456789?", ";4012345678909=25121011234567890?"}
+	tk := pkg.HexDecode("042666B49184CFA368DE9628D0397BC9")
+
+	body := []byte{0x80, 0x03, byte(len(tracks[0])), byte(len(tracks[1])), 0, 0x03, 0x83}
+	for _, track := range tracks {
+		body = append(body, strings.Repeat("*", len(track))...)
+	}
+	for _, track := range tracks {
+		encrypted, err := des.EncryptDataWithMode(tk, nil, []byte(track), pkg.Request, des.DataKeyOWF)
+		require.NoError(t, err)
+		body = append(body, encrypted...)
+	}
+	body = append(body, pkg.HexDecode("FFFF9876543210E00001")...)
+
+	var lrc, checksum byte
+	for _, b := range body {
+		lrc ^= b
+		checksum += b
+	}
+
+	frame := append([]byte{0x02, byte(len(body)), byte(len(body) >> 8)}, body...)
+	return append(frame, lrc, checksum, 0x03)
+}
+
+// mockDeviceEMV is IDTech chip output with EMV data encrypted by TDES data key of KSN FFFF9876543210E00001
+func mockDeviceEMV(t *testing.T) []byte {
+	t.Helper()
+
+	// track 2 equivalent data, pan, track 1, track 2 data, expiration date
+	emvData := pkg.HexDecode(strings.Join([]string{
+		"5710", "4012345678909D25121011234567890F",
+		"5A07", "4012345678909F",
+		"5625", pkg.HexEncode([]byte("B4012345678909^DOE/JOHN^2512101123456")),
+		"9F6B10", "4012345678909D25121011234567890F",
+		"5F2403", "251231",
+	}, ""))
+	tk := pkg.HexDecode("042666B49184CFA368DE9628D0397BC9")
+	encrypted, err := des.EncryptDataWithMode(tk, nil, emvData, pkg.Request, des.DataKeyOWF)
+	require.NoError(t, err)
+
+	data := pkg.HexDecode("DFEE120AFFFF9876543210E00001DFEF4D81")
+	data = append(data, byte(len(encrypted)))
+	return append(data, encrypted...)
+}
+
+func mockDeviceService() Service {
+	resolver := host.BDKResolverFunc(func(algorithm string, id []byte) ([]byte, error) {
+		return pkg.HexDecode("0123456789ABCDEFFEDCBA9876543210"), nil
+	})
+	authorizer := NewTokenAuthorizer(map[string][]string{"full-pan-token": {PermissionFullPAN}, "other-token": nil})
+
+	return NewService(NewRepositoryInMemory(nil), WithHost(host.NewHost(resolver, nil)), WithAuthorizer(authorizer))
+}
+
+func TestService__DecryptDevice(t *testing.T) {
+	s := mockDeviceService()
+	frame := mockDeviceFrame(t)
+
+	req := DeviceRequest{Format: "idtech-enhanced", Data: pkg.HexEncode(frame)}
+	data, err := s.DecryptDevice("", req)
+	require.NoError(t, err)
+	require.Equal(t, "idtech-enhanced", data.Format)
+	require.Equal(t, "FFFF9876543210E00001", strings.ToUpper(data.KSN))
+	require.Equal(t, &DeviceTrack{PAN: "401234***8909", Name: "DOE/JOHN", Expiry: "2512", ServiceCode: "101"}, data.Track1)
+	require.Equal(t, &DeviceTrack{PAN: "401234***8909", Expiry: "2512", ServiceCode: "101"}, data.Track2)
+
+	// token without permission still gets masked PAN
+	data, err = s.DecryptDevice("other-token", req)
+	require.NoError(t, err)
+	require.Equal(t, "401234***8909", data.Track2.PAN)
+
+	// base64 data with detected format
+	req = DeviceRequest{Data: base64.StdEncoding.EncodeToString(frame), Encoding: DeviceEncodingBase64}
+	data, err = s.DecryptDevice("full-pan-token", req)
+	require.NoError(t, err)
+	require.Equal(t, "4012345678909", data.Track1.PAN)
+	require.Equal(t, "4012345678909", data.Track2.PAN)
+
+	// emv tags of chip output
+	req = DeviceRequest{Format: "idtech-emv", Data: pkg.HexEncode(mockDeviceEMV(t))}
+	data, err = s.DecryptDevice("other-token", req)
+	require.NoError(t, err)
+	require.Nil(t, data.Track2)
+	require.Equal(t, []DeviceTag{
+		{Tag: "57", Value: "401234***8909D2512101"},
+		{Tag: "5A", Value: "401234***8909"},
+		{Tag: "56", Value: "B401234***8909^DOE/JOHN^2512101"},
+		{Tag: "9F6B", Value: "401234***8909D2512101"},
+		{Tag: "5F24", Value: "251231"},
+	}, data.EMV)
+
+	data, err = s.DecryptDevice("full-pan-token", req)
+	require.NoError(t, err)
+	require.Equal(t, DeviceTag{Tag: "57", Value: "4012345678909D2512101"}, data.EMV[0])
+	require.Equal(t, DeviceTag{Tag: "5A", Value: "4012345678909"}, data.EMV[1])
+
+	_, err = s.DecryptDevice("", DeviceRequest{Data: "zz"})
+	require.EqualError(t, err, "invalid device data")
+
+	_, err = mockServiceInMemory().DecryptDevice("", DeviceRequest{Data: pkg.HexEncode(frame)})
+	require.EqualError(t, err, "device decryption is not configured")
+}
+
+// testAlgorithm is des registered with another name
+type testAlgorithm struct {
+	desAlgorithm
+}
+
+func (testAlgorithm) Name() string {
+	return "des-test"
+}
+
+func TestService__AlgorithmRegistry(t *testing.T) {
+	require.Equal(t, []string{pkg.AlgorithmAes, pkg.AlgorithmDes}, Algorithms())
+
+	RegisterAlgorithm(testAlgorithm{})
+	t.Cleanup(func() {
+		algorithmsMu.Lock()
+		delete(algorithms, "des-test")
+		algorithmsMu.Unlock()
+	})
+
+	s := mockServiceInMemory()
+
+	base := mockBaseDesKey()
+	base.Algorithm = "des-test"
+	m := NewMachine(base)
+	require.NoError(t, s.CreateMachine(m))
+	require.Equal(t, "042666B49184CFA368DE9628D0397BC9", strings.ToUpper(m.TransactionKey))
+
+	m, err := s.MakeNextKSN(m.InitialKey)
+	require.NoError(t, err)
+	require.Equal(t, "FFFF9876543210E00002", strings.ToUpper(m.CurrentKSN))
+
+	encrypted, err := s.EncryptPin(m.InitialKey, "1234", "4012345678909", "ISO-0")
+	require.NoError(t, err)
+	require.Equal(t, "10A01C8D02C69107", strings.ToUpper(encrypted))
+
+	base.Algorithm = "rsa"
+	require.EqualError(t, s.CreateMachine(NewMachine(base)), "invalid encrypt/decrypt algorithm")
+}
+
+func TestService__ExportKey(t *testing.T) {
+	s := NewService(NewRepositoryInMemory(nil), WithAuthorizer(NewTokenAuthorizer(map[string][]string{"export-token": {PermissionExportKey}})))
+	kek := "000102030405060708090A0B0C0D0E0F"
+
+	m := NewMachine(mockBaseDesKey())
+	require.NoError(t, s.CreateMachine(m))
+
+	_, err := s.ExportKey("", m.InitialKey, KeyExportRequest{Key: ExportKeyTransaction, KEK: kek})
+	require.ErrorIs(t, err, ErrForbidden)
+
+	for key, expected := range map[string]string{ExportKeyInitial: m.InitialKey, ExportKeyTransaction: m.TransactionKey} {
+		for _, mode := range []encryption.KeyWrapMode{encryption.KeyWrapRFC3394, encryption.KeyWrapRFC5649} {
+			wrapped, err := s.ExportKey("export-token", m.InitialKey, KeyExportRequest{Key: key, KEK: kek, Mode: mode.String()})
+			require.NoError(t, err)
+
+			unwrapped, err := encryption.UnwrapKey(pkg.HexDecode(kek), pkg.HexDecode(wrapped), mode)
+			require.NoError(t, err)
+			require.Equal(t, strings.ToUpper(expected), strings.ToUpper(pkg.HexEncode(unwrapped)))
+		}
+	}
+
+	// working key of aes machine, default mode is rfc5649
+	m = NewMachine(mockBaseAesKey())
+	require.NoError(t, s.CreateMachine(m))
+
+	wrapped, err := s.ExportKey("export-token", m.InitialKey, KeyExportRequest{Key: pkg.UsageMac, KEK: kek, Action: pkg.ActionRequest, MacType: pkg.MaxTypeHmac})
+	require.NoError(t, err)
+	unwrapped, err := encryption.UnwrapKey(pkg.HexDecode(kek), pkg.HexDecode(wrapped), encryption.KeyWrapRFC5649)
+	require.NoError(t, err)
+	expected, err := aes.DeriveWorkingKey(pkg.HexDecode(m.TransactionKey), pkg.HexDecode(m.CurrentKSN), pkg.UsageMac, aes.KeyHMAC128Type, pkg.ActionRequest, nil)
+	require.NoError(t, err)
+	require.Equal(t, expected, unwrapped)
+
+	_, err = s.ExportKey("export-token", m.InitialKey, KeyExportRequest{Key: pkg.UsagePin, KEK: kek, Mode: "tr-31"})
+	require.EqualError(t, err, `invalid key wrap mode "tr-31"`)
+}
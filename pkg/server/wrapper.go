@@ -2,153 +2,280 @@ package server
 
 import (
 	"errors"
+	"io"
+
+	"github.com/moov-io/dukpt"
+	"github.com/moov-io/dukpt/encryption"
 	"github.com/moov-io/dukpt/pkg"
-	"github.com/moov-io/dukpt/pkg/aes"
 	"github.com/moov-io/dukpt/pkg/des"
+	"github.com/moov-io/dukpt/pkg/envelope"
+	"github.com/moov-io/dukpt/pkg/pin"
 )
 
 type UnifiedParams struct {
-	Algorithm    string
-	AlgorithmKey string
-	BKD          string
-	KSN          string
-	IK           string
-	TK           string
-	PIN          string
-	PAN          string
-	Format       string
-	MacType      string
-	Plaintext    string
-	Ciphertext   string
-	Action       string
-	IV           string
+	Algorithm      string
+	AlgorithmKey   string
+	BKD            string
+	KSN            string
+	IK             string
+	TK             string
+	PIN            string
+	PAN            string
+	Format         string
+	MacType        string
+	Plaintext      string
+	Ciphertext     string
+	Action         string
+	IV             string
+	DataKeyMode    string
+	EnvelopeFormat string
+	KEK            string
+	KeyWrapMode    string
+	ExportKey      string
+	// Rand is random source of pin block fill and envelope iv (crypto/rand when nil)
+	Rand io.Reader
+	// Provider holds keys of BKD, IK and TK, they are key handles of the provider when it is set
+	Provider dukpt.KeyProvider
 }
 
+// ValidateAlgorithm returns error when algorithm isn't registered
 func (p UnifiedParams) ValidateAlgorithm() error {
-	if p.Algorithm != pkg.AlgorithmDes && p.Algorithm != pkg.AlgorithmAes {
-		return errors.New("invalid encrypt/decrypt algorithm")
+	_, err := LookupAlgorithm(p.Algorithm)
+	return err
+}
+
+// ValidateDataKeyMode returns error when data key mode is unknown or not supported by algorithm
+func (p UnifiedParams) ValidateDataKeyMode() error {
+	algorithm, err := LookupAlgorithm(p.Algorithm)
+	if err != nil {
+		return err
 	}
-	return nil
+	return algorithm.ValidateParams(p)
 }
 
-type WrapperCall func(params UnifiedParams) (string, error)
+// action of request, unknown actions fall back to request
+func (p UnifiedParams) action() pkg.Action {
+	if p.Action == pkg.ActionResponse {
+		return pkg.Response
+	}
+	return pkg.Request
+}
 
-func InitialKey(params UnifiedParams) (string, error) {
-	var buf []byte
-	var err error
+// keyWrapMode of key export, empty mode is RFC 5649 (key wrap with padding)
+func (p UnifiedParams) keyWrapMode() (encryption.KeyWrapMode, error) {
+	if p.KeyWrapMode == "" {
+		return encryption.KeyWrapRFC5649, nil
+	}
+	return encryption.ParseKeyWrapMode(p.KeyWrapMode)
+}
 
-	if params.Algorithm == pkg.AlgorithmAes {
-		buf, err = aes.DerivationOfInitialKey(pkg.HexDecode(params.BKD), pkg.HexDecode(params.KSN))
+// PinKey describes the key protecting a PIN block for translation (hex encoded keys)
+type PinKey struct {
+	Scheme  string
+	BDK     string
+	IK      string
+	KSN     string
+	ZPK     string
+	KeyType string
+	Format  string
+}
 
-	} else {
-		buf, err = des.DerivationOfInitialKey(pkg.HexDecode(params.BKD), pkg.HexDecode(params.KSN))
+func (k PinKey) pinKey() pin.Key {
+	return pin.Key{
+		Scheme:  k.Scheme,
+		BDK:     pkg.HexDecode(k.BDK),
+		IK:      pkg.HexDecode(k.IK),
+		KSN:     pkg.HexDecode(k.KSN),
+		ZPK:     pkg.HexDecode(k.ZPK),
+		KeyType: k.KeyType,
+		Format:  k.Format,
 	}
+}
 
-	if err != nil {
-		return "", err
+// PinVerification describes stored PIN verification data (hex encoded pin verification key)
+type PinVerification struct {
+	Method              string
+	PVK                 string
+	DecimalizationTable string
+	ValidationData      string
+	Offset              string
+	PVKI                int
+	PVV                 string
+}
+
+func (v PinVerification) verification() pin.Verification {
+	return pin.Verification{
+		Method:              v.Method,
+		PVK:                 pkg.HexDecode(v.PVK),
+		DecimalizationTable: v.DecimalizationTable,
+		ValidationData:      v.ValidationData,
+		Offset:              v.Offset,
+		PVKI:                v.PVKI,
+		PVV:                 v.PVV,
 	}
-	return pkg.HexEncode(buf), nil
 }
 
-func TransactionKey(params UnifiedParams) (string, error) {
-	var buf []byte
-	var err error
+type WrapperCall func(params UnifiedParams) (string, error)
 
-	if params.Algorithm == pkg.AlgorithmAes {
-		buf, err = aes.DeriveCurrentTransactionKey(pkg.HexDecode(params.IK), pkg.HexDecode(params.KSN))
-	} else {
-		buf, err = des.DeriveCurrentTransactionKey(pkg.HexDecode(params.IK), pkg.HexDecode(params.KSN))
+func InitialKey(params UnifiedParams) (string, error) {
+	algorithm, err := params.algorithm()
+	if err != nil {
+		return "", err
 	}
+	return algorithm.InitialKey(params)
+}
 
+func TransactionKey(params UnifiedParams) (string, error) {
+	algorithm, err := params.algorithm()
 	if err != nil {
 		return "", err
 	}
-	return pkg.HexEncode(buf), nil
+	return algorithm.TransactionKey(params)
 }
 
 func EncryptPin(params UnifiedParams) (string, error) {
-	var buf []byte
-	var err error
+	algorithm, err := params.algorithm()
+	if err != nil {
+		return "", err
+	}
+	return algorithm.EncryptPin(params)
+}
 
-	if params.Algorithm == pkg.AlgorithmAes {
-		buf, err = aes.EncryptPin(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), params.PIN, params.PAN, params.AlgorithmKey)
-	} else {
-		buf, err = des.EncryptPin(pkg.HexDecode(params.TK), params.PIN, params.PAN, params.Format)
+func DecryptPin(params UnifiedParams) (string, error) {
+	algorithm, err := params.algorithm()
+	if err != nil {
+		return "", err
 	}
+	return algorithm.DecryptPin(params)
+}
 
+func GenerateMac(params UnifiedParams) (string, error) {
+	algorithm, err := params.algorithm()
 	if err != nil {
 		return "", err
 	}
-	return pkg.HexEncode(buf), nil
+	return algorithm.GenerateMac(params)
 }
 
-func DecryptPin(params UnifiedParams) (string, error) {
-	var buf string
-	var err error
+func EncryptData(params UnifiedParams) (string, error) {
+	algorithm, err := params.algorithm()
+	if err != nil {
+		return "", err
+	}
+	return algorithm.EncryptData(params)
+}
 
-	if params.Algorithm == pkg.AlgorithmAes {
-		buf, err = aes.DecryptPin(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), pkg.HexDecode(params.PIN), params.PAN, params.AlgorithmKey)
-	} else {
-		buf, err = des.DecryptPin(pkg.HexDecode(params.TK), pkg.HexDecode(params.PIN), params.PAN, params.Format)
+func DecryptData(params UnifiedParams) (string, error) {
+	algorithm, err := params.algorithm()
+	if err != nil {
+		return "", err
 	}
+	return algorithm.DecryptData(params)
+}
 
+// ExportKey returns initial key, transaction key or working key wrapped under key encryption key (hex encoded)
+func ExportKey(params UnifiedParams) (string, error) {
+	algorithm, err := params.algorithm()
 	if err != nil {
 		return "", err
 	}
-	return buf, nil
+	return algorithm.ExportKey(params)
 }
 
-func GenerateMac(params UnifiedParams) (string, error) {
-	var buf []byte
-	var err error
+const (
+	// ExportKeyInitial exports initial key derived from base derivative key
+	ExportKeyInitial = "ik"
+	// ExportKeyTransaction exports current transaction key derived from initial key
+	ExportKeyTransaction = "tk"
+)
 
-	if params.Algorithm == pkg.AlgorithmAes {
-		if params.MacType != pkg.MaxTypeCmac && params.MacType != pkg.MaxTypeHmac {
-			return "", errors.New("invalid mac type")
-		}
-		if params.MacType == pkg.MaxTypeCmac {
-			buf, err = aes.GenerateCMAC(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), params.Plaintext, params.AlgorithmKey, params.Action)
-		} else {
-			buf, err = aes.GenerateHMAC(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), params.Plaintext, params.AlgorithmKey, params.Action)
-		}
-	} else {
-		buf, err = des.GenerateMac(pkg.HexDecode(params.TK), params.Plaintext, params.Action)
+const (
+	EnvelopeFormatJSON   = "json"
+	EnvelopeFormatBinary = "binary"
+)
+
+func (p UnifiedParams) envelopeKey() (envelope.Key, error) {
+	if p.Provider != nil {
+		return envelope.Key{}, errors.New("envelope is not supported with key provider")
 	}
 
+	mode, err := des.ParseDataKeyMode(p.DataKeyMode)
 	if err != nil {
-		return "", err
+		return envelope.Key{}, err
 	}
-	return pkg.HexEncode(buf), nil
-}
 
-func EncryptData(params UnifiedParams) (string, error) {
-	var buf []byte
-	var err error
+	return envelope.Key{
+		Algorithm:   p.Algorithm,
+		CurrentKey:  pkg.HexDecode(p.TK),
+		KSN:         pkg.HexDecode(p.KSN),
+		KeyType:     p.AlgorithmKey,
+		DataKeyMode: mode,
+		Action:      p.action(),
+	}, nil
+}
 
-	if params.Algorithm == pkg.AlgorithmAes {
-		buf, err = aes.EncryptData(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), pkg.HexDecode(params.IV), params.Plaintext, params.AlgorithmKey, params.Action)
-	} else {
-		buf, err = des.EncryptData(pkg.HexDecode(params.TK), pkg.HexDecode(params.IV), params.Plaintext, params.Action)
+// SealEnvelope encrypts plain text and returns authenticated envelope (JSON or hex encoded binary)
+func SealEnvelope(params UnifiedParams) (string, error) {
+	key, err := params.envelopeKey()
+	if err != nil {
+		return "", err
 	}
 
+	e, err := envelope.SealWithRand(key, []byte(params.Plaintext), params.Rand)
 	if err != nil {
 		return "", err
 	}
-	return pkg.HexEncode(buf), nil
+
+	switch params.EnvelopeFormat {
+	case "", EnvelopeFormatJSON:
+		data, err := e.MarshalJSON()
+		return string(data), err
+	case EnvelopeFormatBinary:
+		data, err := e.MarshalBinary()
+		return pkg.HexEncode(data), err
+	}
+	return "", errors.New("invalid envelope format")
 }
 
-func DecryptData(params UnifiedParams) (string, error) {
-	var buf string
-	var err error
+// OpenEnvelope verifies authenticated envelope (JSON or hex encoded binary) of cipher text and returns plain text
+func OpenEnvelope(params UnifiedParams) (string, error) {
+	key, err := params.envelopeKey()
+	if err != nil {
+		return "", err
+	}
 
-	if params.Algorithm == pkg.AlgorithmAes {
-		buf, err = aes.DecryptData(pkg.HexDecode(params.TK), pkg.HexDecode(params.KSN), pkg.HexDecode(params.Ciphertext), pkg.HexDecode(params.IV), params.AlgorithmKey, params.Action)
-	} else {
-		buf, err = des.DecryptData(pkg.HexDecode(params.TK), pkg.HexDecode(params.Ciphertext), pkg.HexDecode(params.IV), params.Action)
+	var e envelope.Envelope
+	switch params.EnvelopeFormat {
+	case "", EnvelopeFormatJSON:
+		err = e.UnmarshalJSON([]byte(params.Ciphertext))
+	case EnvelopeFormatBinary:
+		err = e.UnmarshalBinary(pkg.HexDecode(params.Ciphertext))
+	default:
+		err = errors.New("invalid envelope format")
+	}
+	if err != nil {
+		return "", err
 	}
 
+	buf, err := envelope.Open(key, &e)
 	if err != nil {
 		return "", err
 	}
-	return buf, nil
+	return string(buf), nil
+}
+
+func TranslatePin(in, out PinKey, ciphertext, pan string) (string, error) {
+	buf, err := pin.Translate(in.pinKey(), pkg.HexDecode(ciphertext), pan, out.pinKey())
+	if err != nil {
+		return "", err
+	}
+	return pkg.HexEncode(buf), nil
+}
+
+func VerifyPin(key PinKey, ciphertext, pan string, verification PinVerification) (bool, error) {
+	return pin.Verify(key.pinKey(), pkg.HexDecode(ciphertext), pan, verification.verification())
+}
+
+func GeneratePinVerification(key PinKey, ciphertext, pan string, verification PinVerification) (string, error) {
+	return pin.GenerateVerification(key.pinKey(), pkg.HexDecode(ciphertext), pan, verification.verification())
 }
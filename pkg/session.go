@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const minSessionMacLength = 4
+
+var ErrSessionClosed = errors.New("session is closed")
+
+// SessionKeys are working keys of one DUKPT transaction, mac and data keys are indexed by action (request, response)
+type SessionKeys struct {
+	Pin  []byte
+	Mac  [2][]byte
+	Data [2][]byte
+}
+
+// Wipe clears working keys
+func (k *SessionKeys) Wipe() {
+	clear(k.Pin)
+	for i := range k.Mac {
+		clear(k.Mac[i])
+		clear(k.Data[i])
+	}
+}
+
+// KeyHolder keeps key serial number and working keys of a session until Close wipes them
+//
+// NOTE:
+//   - Lifecycle of des.Session and aes.Session, methods are safe for concurrent use
+type KeyHolder struct {
+	mu     sync.RWMutex
+	ksn    []byte
+	keys   SessionKeys
+	closed bool
+}
+
+// NewKeyHolder returns holder of keys, the holder owns keys and wipes them on Close
+func NewKeyHolder(ksn []byte, keys SessionKeys) *KeyHolder {
+	return &KeyHolder{
+		ksn:  append([]byte(nil), ksn...),
+		keys: keys,
+	}
+}
+
+// KSN returns copy of key serial number of session
+func (h *KeyHolder) KSN() []byte {
+	return append([]byte(nil), h.ksn...)
+}
+
+// Use runs fn with working keys, ErrSessionClosed after Close
+func (h *KeyHolder) Use(fn func(keys *SessionKeys) error) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		return ErrSessionClosed
+	}
+
+	return fn(&h.keys)
+}
+
+// Verify MAC with MAC generated from working keys
+//
+// NOTE:
+//   - Truncated mac is compared with the leftmost bytes of the generated mac in constant time
+//
+// Params:
+//   - mac is received mac (4 bytes up to max length)
+//   - max length is length of generated mac
+//   - generate returns mac of working keys
+//
+// Return Params:
+//   - result is true when mac matches
+//   - err
+func (h *KeyHolder) VerifyMAC(mac []byte, maxLength int, generate func(keys *SessionKeys) ([]byte, error)) (bool, error) {
+	if len(mac) < minSessionMacLength || len(mac) > maxLength {
+		return false, fmt.Errorf("mac length must be between %d and %d bytes", minSessionMacLength, maxLength)
+	}
+
+	var verified bool
+	err := h.Use(func(keys *SessionKeys) error {
+		expected, err := generate(keys)
+		if err != nil {
+			return err
+		}
+		verified = len(expected) >= len(mac) && subtle.ConstantTimeCompare(expected[:len(mac)], mac) == 1
+		return nil
+	})
+
+	return verified, err
+}
+
+// Close wipes working keys, any later call of the session returns ErrSessionClosed
+func (h *KeyHolder) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.keys.Wipe()
+	h.closed = true
+
+	return nil
+}
@@ -0,0 +1,704 @@
+package testvectors
+
+/*
+ANSI X9.24 DUKPT test vectors shared with downstream conformance tests
+
+Vector sets transcribed from the standards (published values):
+  - ANSI X9.24-1:2009 A.4.2 Initial Sequence (KSN counter 1 to 5)
+  - ANSI X9.24-3:2017 B.1 AES-128 keys from AES-128 BDK (KSN counter 1 to 8)
+
+Computed vector sets, their names end with "(computed)":
+  - TDES initial sequence of A.4.2 at further KSN counters (6 to A, 10, 800 and FFC00)
+  - AES-192 and AES-256 keys from AES-192 and AES-256 BDK (BDK of B.1 extended, KSN counter 1 to 8)
+
+Computed values come from an independent OpenSSL based implementation of the standards that reproduces
+the published sets byte for byte, they aren't transcribed from a copy of the standard. Suites transcribed
+from a copy of the standard can be built with DesSuite and AesSuite and run with their Check method.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/moov-io/dukpt/pkg"
+)
+
+// DesVector is one transaction of TDES DUKPT test sequence
+type DesVector struct {
+	KSN            []byte
+	TransactionKey []byte
+	PinBlock       []byte
+	DataRequest    []byte
+	DataResponse   []byte
+	MacRequest     []byte
+	MacResponse    []byte
+}
+
+// DesSuite is TDES DUKPT test sequence of one initial key
+type DesSuite struct {
+	Name       string
+	BDK        []byte
+	InitialKey []byte
+	Pin        string
+	Pan        string
+	PinFormat  string
+	Data       string
+	Vectors    []DesVector
+}
+
+// AesVector is one transaction of AES DUKPT test sequence
+//
+// NOTE:
+//   - ISO 9564-1 format 4 PIN blocks use random fill, so the PIN is checked by round trip only
+type AesVector struct {
+	KSN            []byte
+	TransactionKey []byte
+	CMACRequest    []byte
+	CMACResponse   []byte
+	HMACRequest    []byte
+	HMACResponse   []byte
+	DataRequest    []byte
+	DataResponse   []byte
+}
+
+// AesSuite is AES DUKPT test sequence of one initial key
+type AesSuite struct {
+	Name         string
+	BDK          []byte
+	InitialKeyID []byte
+	InitialKey   []byte
+	KeyType      string
+	HmacKeyType  string
+	Pin          string
+	Pan          string
+	Data         string
+	Vectors      []AesVector
+}
+
+// DesImplementation is TDES DUKPT implementation checked against DesSuite
+type DesImplementation interface {
+	DerivationOfInitialKey(bdk, ksn []byte) ([]byte, error)
+	DeriveCurrentTransactionKey(ik, ksn []byte) ([]byte, error)
+	EncryptPin(currentKey []byte, pin, pan string, format string) ([]byte, error)
+	GenerateMac(currentKey []byte, plainText, action string) ([]byte, error)
+	EncryptData(currentKey, iv []byte, plainText, action string) ([]byte, error)
+}
+
+// AesImplementation is AES DUKPT implementation checked against AesSuite
+type AesImplementation interface {
+	DerivationOfInitialKey(bdk, initialKeyID []byte) ([]byte, error)
+	DeriveCurrentTransactionKey(ik, ksn []byte) ([]byte, error)
+	EncryptPin(currentKey, ksn []byte, pin, pan string, keyType string) ([]byte, error)
+	DecryptPin(currentKey, ksn, ciphertext []byte, pan string, keyType string) (string, error)
+	GenerateCMAC(currentKey, ksn []byte, plaintext string, keyType string, action string) ([]byte, error)
+	GenerateHMAC(currentKey, ksn []byte, plaintext string, keyType string, action string) ([]byte, error)
+	EncryptData(currentKey, ksn, iv []byte, plaintext, keyType, action string) ([]byte, error)
+}
+
+// ANSI X9.24-1:2009 A.4.2 Initial Sequence
+var X9241InitialSequence = DesSuite{
+	Name:       "ANSI X9.24-1:2009 A.4.2 Initial Sequence",
+	BDK:        pkg.HexDecode("0123456789ABCDEFFEDCBA9876543210"),
+	InitialKey: pkg.HexDecode("6AC292FAA1315B4D858AB3A3D7D5933A"),
+	Pin:        "1234",
+	Pan:        "4012345678909",
+	PinFormat:  "ISO-0",
+	Data:       "4012345678909D987",
+	Vectors: []DesVector{
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210E00001"),
+			TransactionKey: pkg.HexDecode("042666B49184CFA368DE9628D0397BC9"),
+			PinBlock:       pkg.HexDecode("1B9C1845EB993A7A"),
+			DataRequest:    pkg.HexDecode("FC0D53B7EA1FDA9EE68AAF2E70D9B9506229BE2AA993F04F"),
+			DataResponse:   pkg.HexDecode("1FCC89AF66222F27B903898BB2BC8589CDBFDE5EC6AFCC25"),
+			MacRequest:     pkg.HexDecode("9CCC78173FC4FB64"),
+			MacResponse:    pkg.HexDecode("20364223C1FF00FA"),
+		},
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210E00002"),
+			TransactionKey: pkg.HexDecode("C46551CEF9FD24B0AA9AD834130D3BC7"),
+			PinBlock:       pkg.HexDecode("10A01C8D02C69107"),
+			DataRequest:    pkg.HexDecode("A2B4E70F846E63D68775B7215EB4563DFD3037244C61CC13"),
+			DataResponse:   pkg.HexDecode("5B692A6B1FDD5E25B0DEFAFDE1672E402F8011360CFF3508"),
+			MacRequest:     pkg.HexDecode("F608A9BCA6FFC311"),
+			MacResponse:    pkg.HexDecode("D1FCA6BEF05D24D2"),
+		},
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210E00003"),
+			TransactionKey: pkg.HexDecode("0DF3D9422ACA56E547676D07AD6BADFA"),
+			PinBlock:       pkg.HexDecode("18DC07B94797B466"),
+			DataRequest:    pkg.HexDecode("BD751E65F10E75B6C1D5B1D283496A36C2DE21D993C387A7"),
+			DataResponse:   pkg.HexDecode("345992D4163E4926C927BFD8ABD5D76F087A9CE81D5A27B8"),
+			MacRequest:     pkg.HexDecode("20B59A4FEAC937E3"),
+			MacResponse:    pkg.HexDecode("BAD4CC9CC2AE326C"),
+		},
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210E00004"),
+			TransactionKey: pkg.HexDecode("279C0F6AEED0BE652B2C733E1383AE91"),
+			PinBlock:       pkg.HexDecode("0BC79509D5645DF7"),
+			DataRequest:    pkg.HexDecode("1118F50947441BBDA3C8C70220021A12EC31CC473F7215F4"),
+			DataResponse:   pkg.HexDecode("418C7413576C0D1819E785D3807AF32334231FDEC23414DB"),
+			MacRequest:     pkg.HexDecode("C7BFA6CC44161828"),
+			MacResponse:    pkg.HexDecode("1EB08AEECE6FF0C2"),
+		},
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210E00005"),
+			TransactionKey: pkg.HexDecode("5F8DC6D2C845C125508DDC048093B83F"),
+			PinBlock:       pkg.HexDecode("5BC0AF22AD87B327"),
+			DataRequest:    pkg.HexDecode("9FD7BD1EC28845ACA93367A9DA9317BD555C6B33AE22D365"),
+			DataResponse:   pkg.HexDecode("7D4C109E49E83355A556AE949EED359F4404E7A2F0167C00"),
+			MacRequest:     pkg.HexDecode("0202B96339022058"),
+			MacResponse:    pkg.HexDecode("5CBE3E81D1D2A0FB"),
+		},
+	},
+}
+
+// ANSI X9.24-3:2017 B.1 Sample Test Vectors for Generating AES-128 keys from AES-128 BDK
+var X9243AES128 = AesSuite{
+	Name:         "ANSI X9.24-3:2017 B.1 AES-128 keys from AES-128 BDK",
+	BDK:          pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1"),
+	InitialKeyID: pkg.HexDecode("1234567890123456"),
+	InitialKey:   pkg.HexDecode("1273671EA26AC29AFA4D1084127652A1"),
+	KeyType:      "AES128",
+	HmacKeyType:  "HMAC128",
+	Pin:          "1234",
+	Pan:          "4111111111111111",
+	Data:         "4012345678909D987",
+	Vectors: []AesVector{
+		{
+			KSN:            pkg.HexDecode("123456789012345600000001"),
+			TransactionKey: pkg.HexDecode("4F21B565BAD9835E112B6465635EAE44"),
+			CMACRequest:    pkg.HexDecode("A2EB5C1C35809E58404E873C3C411E31"),
+			CMACResponse:   pkg.HexDecode("DD4E1895FD9BF53D8DAF25568ABF551D"),
+			HMACRequest:    pkg.HexDecode("B6F8B3159CD4E140159DA87A68C0FB7AF2F123D222662E98988C76386E8E8A02"),
+			HMACResponse:   pkg.HexDecode("DDE7CB7BDE05AC9A934919B5D94C37954F2376BAF5D45031A56FE74D94C4B64D"),
+			DataRequest:    pkg.HexDecode("E5AFA5B408A3310E3D779C8A9A2AE29448BD5B4232582090DB703AF647205A79"),
+			DataResponse:   pkg.HexDecode("84904DFC6B5201A4F1FE2EAA49E70B8C01838EF53030790FF785D630AB3916B4"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000002"),
+			TransactionKey: pkg.HexDecode("2F34D68DE10F68D38091A73B9E7C437C"),
+			CMACRequest:    pkg.HexDecode("4D104704C53491A60944193DCAF6B411"),
+			CMACResponse:   pkg.HexDecode("008CEAAE2B913BEEE76209EE9406DAC1"),
+			HMACRequest:    pkg.HexDecode("A884FD458692820E8458AD9FF4FCE58D6B809AB3AE617AE1FC6B70E9E9C12B08"),
+			HMACResponse:   pkg.HexDecode("32C4EEBF5FB820C4E90A580C0769E5BF8188007191AFAEF53F7C585B03285314"),
+			DataRequest:    pkg.HexDecode("A17A9658EE0F451D6CA11B65B592EF9C5F90BB175D926F1457B63B3273042476"),
+			DataResponse:   pkg.HexDecode("699ED7BC85994F1C11DE1C40177A629530E85262EA0B02FB80771255DC1F65B6"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000003"),
+			TransactionKey: pkg.HexDecode("031504E530365CF81264238540518318"),
+			CMACRequest:    pkg.HexDecode("6A18904AA8E966A03505F5EEBDAEBD82"),
+			CMACResponse:   pkg.HexDecode("40CB2F0C2E043D060063FAA916A849E1"),
+			HMACRequest:    pkg.HexDecode("5CCB50FB8001C07C51191502BFC7586E165219F59D87938C8A7A84D41AF44F18"),
+			HMACResponse:   pkg.HexDecode("255E08E91BB5AFFD26C695110FAB5167AB36C9A3C61C78BD2401292A8EFE7C0A"),
+			DataRequest:    pkg.HexDecode("E289C32A084A39419A6C147DE40B53A67BABE2BD22DC3D5EE09B002E83B93ACE"),
+			DataResponse:   pkg.HexDecode("0F440D9321C1ABBEF9CA7008B40CF18B3045A84D5435D6CF9A4955E3451C49E9"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000004"),
+			TransactionKey: pkg.HexDecode("0EEFC7ADA628BA68878DA9165A8A1887"),
+			CMACRequest:    pkg.HexDecode("77BBD3FB827158B9B12880B0794D1C74"),
+			CMACResponse:   pkg.HexDecode("51FDE192BC484F769AA0A86F8BC5CB30"),
+			HMACRequest:    pkg.HexDecode("5E3C734C41496A10130BE415CBDB8E4F9027250997803DC742D4988123C2963F"),
+			HMACResponse:   pkg.HexDecode("6493E4DB82E4ACFB3AA3830EBEF7365A31B0D7E3B463304FB3EBBC372A866292"),
+			DataRequest:    pkg.HexDecode("D074C0F81866781B92D008C8EA6A3947319B94361C7ECD3C1736020E0D57E629"),
+			DataResponse:   pkg.HexDecode("08A3D4FB24E9CA4864F63E1AB518C69327D87A6A709B9336E3E60FA7F312759A"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000005"),
+			TransactionKey: pkg.HexDecode("C2A7AC328A5DA2D6002D62465BFC028B"),
+			CMACRequest:    pkg.HexDecode("C0CE6DA1F4434AD16EEEBF486C9616CD"),
+			CMACResponse:   pkg.HexDecode("4B508F51104D184A9190061ED854200E"),
+			HMACRequest:    pkg.HexDecode("E49D95FD38DF4E39371FD6FD0789E1A2E9A663E20764BF935B457ECEEAE46CBD"),
+			HMACResponse:   pkg.HexDecode("401CBB584E48C3E08A212A3117E087520D38E4EC054749747D1435B421C9DCE6"),
+			DataRequest:    pkg.HexDecode("8F222D79AE7F942BF74D3EA9E364C0000D0C94AD7EA0A8CCF23330FCA4B1D0D0"),
+			DataResponse:   pkg.HexDecode("400E67A22795467C145D7A5D2475DFD8041C233C6E2D004CEDBEBA25AE5CE1A7"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000006"),
+			TransactionKey: pkg.HexDecode("D30F7D9351DA58448A2F5E92B4EE3B7D"),
+			CMACRequest:    pkg.HexDecode("05222AE91471C590B3A9471523324C14"),
+			CMACResponse:   pkg.HexDecode("ED90BD8AE4571C75CF08EA3EA195ABF8"),
+			HMACRequest:    pkg.HexDecode("D5B61390352342D2027DFD120F32E77831B32BF34700FBA8232D80780D492766"),
+			HMACResponse:   pkg.HexDecode("6644594E7C867C2BF0FBA7F00CAF2E184823AE0D9C40FCAA6F48A3605737094D"),
+			DataRequest:    pkg.HexDecode("19C254B0498CBD32E597D882B7D993513994EE913836E65711EA41F8D4B34728"),
+			DataResponse:   pkg.HexDecode("1524F027685DCDA7197E0DE3CB87EF83C6D61D2E483D47678C4914DEC2320B08"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000007"),
+			TransactionKey: pkg.HexDecode("A8253CEED9AC042C54F75D35C8352278"),
+			CMACRequest:    pkg.HexDecode("37FA54258AAF805A44AA1273C0DF928C"),
+			CMACResponse:   pkg.HexDecode("52D6C1D3A95B1D21761E20870D9A7E8C"),
+			HMACRequest:    pkg.HexDecode("E0A41D52B44C96D5D730BB0DC2F747E7FE5970CE58C0043C42CD3769F6CA3925"),
+			HMACResponse:   pkg.HexDecode("55F096E89E56A27438F6D01A6DCBF9D396E29EA076C3CC9823B2BB5996ED17EE"),
+			DataRequest:    pkg.HexDecode("0710C8F0EB136629E30ADFB148562F6F80B786EC1074428B7C7511B7301EAAF0"),
+			DataResponse:   pkg.HexDecode("8CA084F21F599C7361C8F2B1565D993779C62EC3496F0113DF17B5C29EA4E404"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000008"),
+			TransactionKey: pkg.HexDecode("718EE6CF0B27E53D5F7AF99C4D8146A2"),
+			CMACRequest:    pkg.HexDecode("85C7CA68F55F51E7DCAC969B8C111130"),
+			CMACResponse:   pkg.HexDecode("B45399DB90C0BA75DB4B86605758695A"),
+			HMACRequest:    pkg.HexDecode("D7CAB17596E6D193B83E0E13CAB35A179B7296DC04A42D8145B9DF6E60A2A47F"),
+			HMACResponse:   pkg.HexDecode("32403C170DD07A7C823247DF37649D49FDE905482D1B1C6A62E03E735FA2E384"),
+			DataRequest:    pkg.HexDecode("304EC254A7B528D9892E47F7C1315D498C0D88EA8CD71CA52D38F362721AEA67"),
+			DataResponse:   pkg.HexDecode("8CD1A54538B9DC79A5DF4544ADE8D1F1A085AC1F89AA59BFF49351A68957DE1B"),
+		},
+	},
+}
+
+// ANSI X9.24-3:2017 Annex B AES-192 keys from AES-192 BDK (computed)
+var X9243AES192 = AesSuite{
+	Name:         "ANSI X9.24-3:2017 Annex B AES-192 keys from AES-192 BDK (computed)",
+	BDK:          pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1FEDCBA9876543210"),
+	InitialKeyID: pkg.HexDecode("1234567890123456"),
+	InitialKey:   pkg.HexDecode("5B6DEE2B5B7FABFFA32591F35BF8F23DD9329AE85131E584"),
+	KeyType:      "AES192",
+	HmacKeyType:  "HMAC192",
+	Pin:          "1234",
+	Pan:          "4111111111111111",
+	Data:         "4012345678909D987",
+	Vectors: []AesVector{
+		{
+			KSN:            pkg.HexDecode("123456789012345600000001"),
+			TransactionKey: pkg.HexDecode("1387E87CF91556E340947CDBB154AF263ECFCFEA3655EBFE"),
+			CMACRequest:    pkg.HexDecode("9797BDA625ED02A7CFAEA84964FAA7E3"),
+			CMACResponse:   pkg.HexDecode("02B8679775A5D5624582E2D2F22AA3E9"),
+			HMACRequest:    pkg.HexDecode("B952689B9A75ED9B7D112A51C961492CF877C613AC6AF5759F918179EF0CB500"),
+			HMACResponse:   pkg.HexDecode("E18E59B03D756DC1FB10189196D1246D788CF381070DFDFB80998F4D472DF5BD"),
+			DataRequest:    pkg.HexDecode("A4B6B9953F27E01F2A2E234688C05F710E7171233D97FA660668CB99D211031A"),
+			DataResponse:   pkg.HexDecode("D149237AB7C301A6FB670F77061890AE80056CDF22C0074F8212E65B4FE28F26"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000002"),
+			TransactionKey: pkg.HexDecode("897806620737396FABCEDE162B49D4EA31E5D2BFAD496290"),
+			CMACRequest:    pkg.HexDecode("93F22D82F22F335B21035F11DCC392D5"),
+			CMACResponse:   pkg.HexDecode("E51659795993AF89FB1AAA979BE9E64C"),
+			HMACRequest:    pkg.HexDecode("0101948AF9539388568CE5E391C5A2BA9492A4038DADE441FBDE460FDB4E4D4A"),
+			HMACResponse:   pkg.HexDecode("223A750AC4286B3DB31705D7EBAF494925C819DBCD9B022D5A427334F6BBC0BD"),
+			DataRequest:    pkg.HexDecode("DF6DE2BC5145D01EB72427FF98C29CE56A7E320E8C4E1BC060B57B3E9FE4FD6C"),
+			DataResponse:   pkg.HexDecode("9C486EECC8557C0B4094AEFE936CC940CA13FACD2D43E46CE6CBB528BCA4EB8C"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000003"),
+			TransactionKey: pkg.HexDecode("3ED3BB63EC1A12708B97F007FFB39CD9DBB9013BDCD95B68"),
+			CMACRequest:    pkg.HexDecode("2AFB4F4A7D8E9284A71AE2C57ADC607C"),
+			CMACResponse:   pkg.HexDecode("61AF88F883B5B3E0CD1BC71DA918FE78"),
+			HMACRequest:    pkg.HexDecode("EE43885FB94D35ECDF0FF01FA87D006FBE263FBE51EFCADD8EE85199134A89FF"),
+			HMACResponse:   pkg.HexDecode("C619E7FE11AC5775A6E5F08748AD1AF663C7B675DC835D54206CCAFC6EDC5CF1"),
+			DataRequest:    pkg.HexDecode("3D7C1C3F5B9954D86DAA9F07F7E929D2907E9BDFA01E7DEB456AA2A18E313EA0"),
+			DataResponse:   pkg.HexDecode("B311409605A8927981ECE90249B34B97771C952BC5B401DF50953E870811E676"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000004"),
+			TransactionKey: pkg.HexDecode("BB347C1B8007185E59FF076B3AA050A71F9FC528C960A775"),
+			CMACRequest:    pkg.HexDecode("DB308F76E75759A86EE4E216CEB8E7B1"),
+			CMACResponse:   pkg.HexDecode("C48CCDF56D13696DEDB1ECCB2075ECEE"),
+			HMACRequest:    pkg.HexDecode("55D2E4F8CB9698EEB23CCF8F29B3C1C3E6392E970A2DAD82E136142758F51C1E"),
+			HMACResponse:   pkg.HexDecode("8653A7382B820F00DB1116FCB8E6A67CE873E02565D40BE7789F365076DEDAFF"),
+			DataRequest:    pkg.HexDecode("64B1ACCFD849CD90BD384425B8F5C8004AB112D705A5224EA9E00DE7EB64D229"),
+			DataResponse:   pkg.HexDecode("7C5E066261E206A7DD2CCD3B6704A0AFC9F25099A06F56235A23AD6AA5695190"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000005"),
+			TransactionKey: pkg.HexDecode("08C64364FA2AFB299E21EA6616F751BF1DC852B5FB76FBFD"),
+			CMACRequest:    pkg.HexDecode("CFD7017A7BE667D44E49FE92253067C3"),
+			CMACResponse:   pkg.HexDecode("5A7C034AE4E364A6DC1194A9790C1927"),
+			HMACRequest:    pkg.HexDecode("A21F6EA017A359AD50EA7AD386D1C4FBC3E0F00378CB7DA40702FFE84565B1DF"),
+			HMACResponse:   pkg.HexDecode("BFF468551AC4C3385DD90E5AFA3C7BD449FDFACCA81FDE7FCC5691FCC9DE5A5A"),
+			DataRequest:    pkg.HexDecode("D8E3CDB9D06A6F5AA062464AD8A30E4C6F35A1E68B2BB5C637455F44B263D777"),
+			DataResponse:   pkg.HexDecode("DD932C3267807F4B8EB700DD1847D4BB5E87BD90F0BE6217900F7676768F43C3"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000006"),
+			TransactionKey: pkg.HexDecode("DE7C6E0449E2426E37FAEB1BF357F6BFEB4BF3E9B368DB92"),
+			CMACRequest:    pkg.HexDecode("BCDD6E5BD84F289A4209E1289A356499"),
+			CMACResponse:   pkg.HexDecode("5EB17C9AECC0BFBE5DE88DD61526B0C4"),
+			HMACRequest:    pkg.HexDecode("5E9679B58FB95A2403E8D374F630F358F8F4C25303E4175BE6EFA49DB015A70A"),
+			HMACResponse:   pkg.HexDecode("789BD988062A5750721C4F302049B688AE17C57F504EE6AC73B902B5CD04AF48"),
+			DataRequest:    pkg.HexDecode("FB7E84EFDE8209C38B16079343DB5CD3C93819B0B90DF9D2240A5AA5BC55CDA1"),
+			DataResponse:   pkg.HexDecode("BE74E4680B2291ECFDBB4A11166D053857814FE25CF1B1F1765863093ED44123"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000007"),
+			TransactionKey: pkg.HexDecode("8C3DB9ACA532BFDDE4E3FE898F22618E8F187FEB5FCF4EC0"),
+			CMACRequest:    pkg.HexDecode("1EFED70AE8F2E7B08311C9F534CB0C5D"),
+			CMACResponse:   pkg.HexDecode("EB05AB8E7553F277247C462E664D9559"),
+			HMACRequest:    pkg.HexDecode("0FA8E89490A7E87F9CCCDB7AB7A40BA41F0D64D3AFE6E1C9876E644D3CE639A7"),
+			HMACResponse:   pkg.HexDecode("F64EAF953767324996E348D34D2B4A2729218CFC32CBE6F3D46B1FE00AD64B1C"),
+			DataRequest:    pkg.HexDecode("5A3387B9C66C2D4117350F6711C6CC0C5AE51EE0E217F1569DA2035A34C99FD6"),
+			DataResponse:   pkg.HexDecode("8DFF201907E5E46BF383245D7C94704F0221C126E1B3D46628FE8AC5E94F9494"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000008"),
+			TransactionKey: pkg.HexDecode("286D636B634C4FD3714E72482605FCAB8A3985A23B0F8D91"),
+			CMACRequest:    pkg.HexDecode("115E437D79687A701BC29C071E7D0E2D"),
+			CMACResponse:   pkg.HexDecode("460901A73A719F4AF05A5397BB9F9C02"),
+			HMACRequest:    pkg.HexDecode("9EBC97112746718B26B0FAE64A98D116C1B40C858EBC6704E2A02B408212F3C8"),
+			HMACResponse:   pkg.HexDecode("5EDD58A2A470D8F5DA5707E8F4B993658387A9FD94EC9466367781B8FCFFA85F"),
+			DataRequest:    pkg.HexDecode("DBF00C17FC8DE2EEA26B04842B035A87BE286D6E19404B0E76B64A2E2EF8831F"),
+			DataResponse:   pkg.HexDecode("11994079CD1B59791874F1CF284FE3F41A3901327FC0E0BEE05447F3BA49E2E2"),
+		},
+	},
+}
+
+// ANSI X9.24-3:2017 Annex B AES-256 keys from AES-256 BDK (computed)
+var X9243AES256 = AesSuite{
+	Name:         "ANSI X9.24-3:2017 Annex B AES-256 keys from AES-256 BDK (computed)",
+	BDK:          pkg.HexDecode("FEDCBA9876543210F1F1F1F1F1F1F1F1FEDCBA9876543210F1F1F1F1F1F1F1F1"),
+	InitialKeyID: pkg.HexDecode("1234567890123456"),
+	InitialKey:   pkg.HexDecode("CE9CE0C101D1138F97FB6CAD4DF045A7083D4EAE2D35A31789D01CCF0949550F"),
+	KeyType:      "AES256",
+	HmacKeyType:  "HMAC256",
+	Pin:          "1234",
+	Pan:          "4111111111111111",
+	Data:         "4012345678909D987",
+	Vectors: []AesVector{
+		{
+			KSN:            pkg.HexDecode("123456789012345600000001"),
+			TransactionKey: pkg.HexDecode("54AC2B32B145EA4A554CB8BC44B17467063A799856B1CCC2A138D36E8DBF78B3"),
+			CMACRequest:    pkg.HexDecode("B2072B93EACB70AF0A7FA3F81F25EC31"),
+			CMACResponse:   pkg.HexDecode("19DE172C16D1817FBEBD5AF77707FF23"),
+			HMACRequest:    pkg.HexDecode("DCA9F4A17503CC60DED639EBABEEB57F56B184E51F42F3C191985796FC17FE73"),
+			HMACResponse:   pkg.HexDecode("0C6BFF0296E3BD1892B0E6A63FC14A0547E184533E0172979F9B0F7E12C7441D"),
+			DataRequest:    pkg.HexDecode("A3F8560CC7E0E0CB9DAE191E0FE182E1C86D658366564448B5DB6499313F7BFF"),
+			DataResponse:   pkg.HexDecode("773535871245AADF8F4DC987D3E1968733D41E93FD58D4962250AF57F0E79364"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000002"),
+			TransactionKey: pkg.HexDecode("5DD5A0253842BBBE1D7C0DA27021412C6F1FAB53FB928DEAE56DA06090A9DE97"),
+			CMACRequest:    pkg.HexDecode("670C002CB90D4BBB1ABC7DB0A9B62B81"),
+			CMACResponse:   pkg.HexDecode("0FCA3E7154DAF21224B331A60DB8BFEB"),
+			HMACRequest:    pkg.HexDecode("6DF4CC7BA63023E64118A8EDDA3AE7097F3F50651CBD995E452538D37162D01B"),
+			HMACResponse:   pkg.HexDecode("12D7A15080A141FBF1C2B42B1C84002161E1C9A87F764C6B88DCBBB006BB16A9"),
+			DataRequest:    pkg.HexDecode("7635BF97555732E82F892DCE8E33BB04A3AF5994C28D2D2CBE1EE09448524651"),
+			DataResponse:   pkg.HexDecode("C4965A9C750E0ADB2B9935F35826C22EFF145B7D0CAE6643722F3BA555C2EA4E"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000003"),
+			TransactionKey: pkg.HexDecode("8EEEF7C464AE415BB1D73FAED21993CD669F7999092A579EC6DD3CC680C65171"),
+			CMACRequest:    pkg.HexDecode("204BAE4260080FDF39D2562BAF3AEFF8"),
+			CMACResponse:   pkg.HexDecode("E403B926792ED765D0CA59A797E9CB43"),
+			HMACRequest:    pkg.HexDecode("D30AA10075B566513C5B495546947BA44E29A86FE8607ED77168022BECDC5284"),
+			HMACResponse:   pkg.HexDecode("2FD8C2FE31C479585B90A6B1244D91DF70D90F7F8322E8DF4084D266CB45E5F0"),
+			DataRequest:    pkg.HexDecode("8099FA909E2091C3C2AAB6986FA82750CE9A88A43D885ABE10798E869F1F2A73"),
+			DataResponse:   pkg.HexDecode("5BF417CAB5409D3438BCB6877F12B0289EA1B39C51D1B6A682780DE4F38CAA38"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000004"),
+			TransactionKey: pkg.HexDecode("C18CBED570B3B89ECADA7CEC9C224CD5A86ECF3DE377D3EFAB720F8C4D76B9D0"),
+			CMACRequest:    pkg.HexDecode("BC9F4A8804BEDEF85D19928AC1D4EFE1"),
+			CMACResponse:   pkg.HexDecode("871D487BC0FAD68C4FB60783939CEED2"),
+			HMACRequest:    pkg.HexDecode("C3765FCAEC59902C206B0CB0AA02EF98C8E043FE962A49F8C43B504BAD160E18"),
+			HMACResponse:   pkg.HexDecode("BD9676F853B7330D9F441BF5D78F2130536B307FEB6978F300066DAD6C026D9B"),
+			DataRequest:    pkg.HexDecode("2DCBE30EA109757ADA6D06CD7F62EB069142854DD7B00930833A997545424E49"),
+			DataResponse:   pkg.HexDecode("849CE611CC3988759CD77F0A297F4C4510F0C58505323F5A3FFB8C18B7AE4DCE"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000005"),
+			TransactionKey: pkg.HexDecode("6B3C3C93307151C25DC2309456F01C5C3108DAD571C321899A9B7E0C009FD2EB"),
+			CMACRequest:    pkg.HexDecode("82B208EE4182FCE92C4C46EBFF6C9575"),
+			CMACResponse:   pkg.HexDecode("F384DB85EF34232D2386731BB10284A0"),
+			HMACRequest:    pkg.HexDecode("9833FC57DB54284FE9D32D990CF4BEAE09F73CD19F23B1D97F6AE1A3A0DB9047"),
+			HMACResponse:   pkg.HexDecode("C948D5901C3EC2AC61D1C21D9DB1F5391548B13DDE6D133C0FE685BC90783FB4"),
+			DataRequest:    pkg.HexDecode("064BCD0B5B0FC307DC5303B6729E7BE97606B5627442CFABDC6ECD800A3F02F9"),
+			DataResponse:   pkg.HexDecode("7904642A9CD07A39CB48EBBD4EF76617D72E76A8EB062826168862FB3F02C795"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000006"),
+			TransactionKey: pkg.HexDecode("B8BB61AE3E2D5CD8C699E0C578FB2C7B89B0C0CF02DBAB60864D8049C986C844"),
+			CMACRequest:    pkg.HexDecode("797AA724A874A195753761D35B0939CA"),
+			CMACResponse:   pkg.HexDecode("BFE09609D1BDABE2A19CD3DC31E9A9DC"),
+			HMACRequest:    pkg.HexDecode("DD40EC91073966640D0F97FAAD8EE0A047656EB75A200A72A7333F7728CC8C58"),
+			HMACResponse:   pkg.HexDecode("97687ACF4FC99B38AD60191CCC3D9F89A0DB2A90EAB55A8D3926AA358DA18C58"),
+			DataRequest:    pkg.HexDecode("596A256497A82654343C631E12EDE6F2AFDC5C6E2363A23DDE53C5E9F8361457"),
+			DataResponse:   pkg.HexDecode("A5931A60C48D42E7AA276861D4FC187B6B144474C0C06BF4AC8DA3D01E4DEC8F"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000007"),
+			TransactionKey: pkg.HexDecode("88D0ACA287B3066F5DE0C93396337D4C2DA8D92C1F720A576D62CEB4C979E01E"),
+			CMACRequest:    pkg.HexDecode("F1AAEB00DEF55D803FAC49BA39DA7521"),
+			CMACResponse:   pkg.HexDecode("279F104FFCAE8D3359A09454281FD238"),
+			HMACRequest:    pkg.HexDecode("C8EB338165C13B21B86FF971FA998559D851077B4CE20923FFD988A99C4F2B59"),
+			HMACResponse:   pkg.HexDecode("22FF4966828E48E8681740322ECAE4E6200D0D6706D954EDC7F78CFC7D3B9947"),
+			DataRequest:    pkg.HexDecode("1B0F99C9BF1463E7A27DC9D70C7D9380A4AE157A06635BE9AAA32A4D4F279954"),
+			DataResponse:   pkg.HexDecode("1FC4EC910BB27B96BD16A0E52385EA18C6104FDEB74F701CB91D235E85586699"),
+		},
+		{
+			KSN:            pkg.HexDecode("123456789012345600000008"),
+			TransactionKey: pkg.HexDecode("20A796D5077D51B8E7613893A4F095B134E02917DC84BD391F3661D0873FF308"),
+			CMACRequest:    pkg.HexDecode("7C3054E0270C8BA8770FAE44FC06A106"),
+			CMACResponse:   pkg.HexDecode("0D2BE33B9CCDE278BE45F211D140C209"),
+			HMACRequest:    pkg.HexDecode("0B33ECB3BEC505773690573834F136F882C54FDB94924CE76D18101CC0827A9F"),
+			HMACResponse:   pkg.HexDecode("5AECD54E751B95F68D6537688B804149190160B33196747670CAA5FF89B97887"),
+			DataRequest:    pkg.HexDecode("66150FBA0661CA814285C5F800D63A1980F0423693938946BA756354CB67A118"),
+			DataResponse:   pkg.HexDecode("CEB8CEF07FF847C89CDDA9F26259D9B72EA757A584769D0F6C3792286A441E6D"),
+		},
+	},
+}
+
+// ANSI X9.24-1:2009 A.4.2 Initial Sequence, further counters (computed)
+var X9241FurtherCounters = DesSuite{
+	Name:       "ANSI X9.24-1:2009 A.4.2 Initial Sequence, further counters (computed)",
+	BDK:        pkg.HexDecode("0123456789ABCDEFFEDCBA9876543210"),
+	InitialKey: pkg.HexDecode("6AC292FAA1315B4D858AB3A3D7D5933A"),
+	Pin:        "1234",
+	Pan:        "4012345678909",
+	PinFormat:  "ISO-0",
+	Data:       "4012345678909D987",
+	Vectors: []DesVector{
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210E00006"),
+			TransactionKey: pkg.HexDecode("5E415CB0BAF9F03CD0C14B63FB62FF43"),
+			PinBlock:       pkg.HexDecode("A16DF70AE36158D8"),
+			DataRequest:    pkg.HexDecode("DC526613AD9095C1CEC60C41B7686ED606AC1AA2F4F54912"),
+			DataResponse:   pkg.HexDecode("1C1B3FBCC424736B9DBD57DC81554B7AD6CE6F5F44865D02"),
+			MacRequest:     pkg.HexDecode("CF6C72E6A49892D5"),
+			MacResponse:    pkg.HexDecode("C60D38E858C936EB"),
+		},
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210E00007"),
+			TransactionKey: pkg.HexDecode("0C8F780B7C8B49D0AE84A9EB2A6CE660"),
+			PinBlock:       pkg.HexDecode("27711C16CB257F8E"),
+			DataRequest:    pkg.HexDecode("24700BD6F17751531F2A16CE2AF7731101E6F87839C67244"),
+			DataResponse:   pkg.HexDecode("D2B6B0FD9B43E0346DE66E43DE8AFEC7274FBF0C57C430BC"),
+			MacRequest:     pkg.HexDecode("B11EB0D97CF167E8"),
+			MacResponse:    pkg.HexDecode("053EFD396A0B1333"),
+		},
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210E00008"),
+			TransactionKey: pkg.HexDecode("27F66D5244FF62E1AA6F6120EDEB4280"),
+			PinBlock:       pkg.HexDecode("50E55547A5027551"),
+			DataRequest:    pkg.HexDecode("5F6584DEAFC51B48A239B51C2DACA97176C01D9CA8EDC33C"),
+			DataResponse:   pkg.HexDecode("E0BDA1641F6E9FA24ED091EE66F9F828115B59FA498D03D5"),
+			MacRequest:     pkg.HexDecode("3679055BCCBE3D6B"),
+			MacResponse:    pkg.HexDecode("7FACA91D970D9187"),
+		},
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210E00009"),
+			TransactionKey: pkg.HexDecode("27E31064FDC565698900E2057F658E7E"),
+			PinBlock:       pkg.HexDecode("536CF7F678ACFC8D"),
+			DataRequest:    pkg.HexDecode("7CB9080923ED4D7D8D1B8A2849331684CC910522023BE537"),
+			DataResponse:   pkg.HexDecode("B2EE8E24487F05135BFA8DD440766F7F3773509F0D660117"),
+			MacRequest:     pkg.HexDecode("26AA23DC169152F8"),
+			MacResponse:    pkg.HexDecode("37F17B8F302EE3A6"),
+		},
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210E0000A"),
+			TransactionKey: pkg.HexDecode("6CF2500A22507C7CC776CEADC1E33014"),
+			PinBlock:       pkg.HexDecode("EDABBA23221833FE"),
+			DataRequest:    pkg.HexDecode("C221F5A8CA5DA909BE04938E0FB8DFCFB30ED0D62B590663"),
+			DataResponse:   pkg.HexDecode("752A73BE721368376788582C1105775F2A3137B9C5F44B78"),
+			MacRequest:     pkg.HexDecode("1632621C039098CE"),
+			MacResponse:    pkg.HexDecode("CE038CC91CB4CBBE"),
+		},
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210E00010"),
+			TransactionKey: pkg.HexDecode("59598DCBD9BD94C094165CE453585F57"),
+			PinBlock:       pkg.HexDecode("D5D9638559EF53D6"),
+			DataRequest:    pkg.HexDecode("53EDA44BDBCA037B2D3FEAEBAF73499336767BD1D0D09679"),
+			DataResponse:   pkg.HexDecode("BDB56714D57B00AC814FAFA1C0B943C1B08EFCF65DAD376D"),
+			MacRequest:     pkg.HexDecode("D39D3A598549B9CD"),
+			MacResponse:    pkg.HexDecode("9E933C88DA838A4F"),
+		},
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210E00800"),
+			TransactionKey: pkg.HexDecode("7E4AB005422BCADC5F65363964EF6504"),
+			PinBlock:       pkg.HexDecode("7D690D85FFA4878E"),
+			DataRequest:    pkg.HexDecode("4F7F1B4E000463A143280C7B7940941DE47C6AE9F82422BD"),
+			DataResponse:   pkg.HexDecode("D37668FECBC631CE21CFDAC5F130E58F4BEF9DA30FE22019"),
+			MacRequest:     pkg.HexDecode("2037F96B347BACD4"),
+			MacResponse:    pkg.HexDecode("2D2E82C4A4ABD834"),
+		},
+		{
+			KSN:            pkg.HexDecode("FFFF9876543210EFFC00"),
+			TransactionKey: pkg.HexDecode("F9430DF975082491C77BE4EF4FDB91EE"),
+			PinBlock:       pkg.HexDecode("DEFC6F09F8927B71"),
+			DataRequest:    pkg.HexDecode("A2C773BC45B277E3F100C37E30A6A6FB1865134815B05EBC"),
+			DataResponse:   pkg.HexDecode("DE52F53012EE441EE5D09A2D679A26F10D197EEF1AE38426"),
+			MacRequest:     pkg.HexDecode("2917C6EFF391D1BF"),
+			MacResponse:    pkg.HexDecode("AA8D2DA65925AE3B"),
+		},
+	},
+}
+
+// DesSuites are the TDES DUKPT vector sets shipped in this package
+var DesSuites = []DesSuite{X9241InitialSequence, X9241FurtherCounters}
+
+// AesSuites are the AES DUKPT vector sets shipped in this package
+var AesSuites = []AesSuite{X9243AES128, X9243AES192, X9243AES256}
+
+// CheckAll runs implementations against every suite of DesSuites and AesSuites
+//
+// NOTE:
+//   - nil implementation skips suites of its algorithm
+//
+// Params:
+//   - desImpl is TDES DUKPT implementation under test or nil
+//   - aesImpl is AES DUKPT implementation under test or nil
+//
+// Return Params:
+//   - err is nil when the implementations pass every shipped vector
+func CheckAll(desImpl DesImplementation, aesImpl AesImplementation) error {
+	var errs []error
+	if desImpl != nil {
+		for _, suite := range DesSuites {
+			errs = append(errs, suite.Check(desImpl))
+		}
+	}
+	if aesImpl != nil {
+		for _, suite := range AesSuites {
+			errs = append(errs, suite.Check(aesImpl))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Check runs TDES DUKPT implementation against the vector set
+//
+// NOTE:
+//   - Every vector is checked, mismatches of all vectors are joined into the result
+//
+// Params:
+//   - impl is implementation under test
+//
+// Return Params:
+//   - err is nil when the implementation passes every vector
+func (s DesSuite) Check(impl DesImplementation) error {
+	var errs []error
+	mismatch := func(ksn []byte, name string, got, want []byte) {
+		errs = append(errs, fmt.Errorf("%s ksn %X: %s mismatch: got %X, want %X", s.Name, ksn, name, got, want))
+	}
+
+	for _, v := range s.Vectors {
+		ik, err := impl.DerivationOfInitialKey(s.BDK, v.KSN)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s ksn %X: initial key: %w", s.Name, v.KSN, err))
+			continue
+		}
+		if !bytes.Equal(ik, s.InitialKey) {
+			mismatch(v.KSN, "initial key", ik, s.InitialKey)
+			continue
+		}
+
+		tk, err := impl.DeriveCurrentTransactionKey(ik, v.KSN)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s ksn %X: transaction key: %w", s.Name, v.KSN, err))
+			continue
+		}
+		if !bytes.Equal(tk, v.TransactionKey) {
+			mismatch(v.KSN, "transaction key", tk, v.TransactionKey)
+			continue
+		}
+
+		checks := []struct {
+			name string
+			want []byte
+			run  func() ([]byte, error)
+		}{
+			{"pin block", v.PinBlock, func() ([]byte, error) { return impl.EncryptPin(tk, s.Pin, s.Pan, s.PinFormat) }},
+			{"request data", v.DataRequest, func() ([]byte, error) { return impl.EncryptData(tk, nil, s.Data, pkg.ActionRequest) }},
+			{"response data", v.DataResponse, func() ([]byte, error) { return impl.EncryptData(tk, nil, s.Data, pkg.ActionResponse) }},
+			{"request mac", v.MacRequest, func() ([]byte, error) { return impl.GenerateMac(tk, s.Data, pkg.ActionRequest) }},
+			{"response mac", v.MacResponse, func() ([]byte, error) { return impl.GenerateMac(tk, s.Data, pkg.ActionResponse) }},
+		}
+		for _, c := range checks {
+			got, err := c.run()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s ksn %X: %s: %w", s.Name, v.KSN, c.name, err))
+			} else if !bytes.Equal(got, c.want) {
+				mismatch(v.KSN, c.name, got, c.want)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Check runs AES DUKPT implementation against the vector set
+//
+// NOTE:
+//   - Every vector is checked, mismatches of all vectors are joined into the result
+//   - PIN is checked by encrypting and decrypting under the transaction key of each vector
+//
+// Params:
+//   - impl is implementation under test
+//
+// Return Params:
+//   - err is nil when the implementation passes every vector
+func (s AesSuite) Check(impl AesImplementation) error {
+	var errs []error
+	mismatch := func(ksn []byte, name string, got, want []byte) {
+		errs = append(errs, fmt.Errorf("%s ksn %X: %s mismatch: got %X, want %X", s.Name, ksn, name, got, want))
+	}
+
+	ik, err := impl.DerivationOfInitialKey(s.BDK, s.InitialKeyID)
+	if err != nil {
+		return fmt.Errorf("%s: initial key: %w", s.Name, err)
+	}
+	if !bytes.Equal(ik, s.InitialKey) {
+		return fmt.Errorf("%s: initial key mismatch: got %X, want %X", s.Name, ik, s.InitialKey)
+	}
+
+	for _, v := range s.Vectors {
+		tk, err := impl.DeriveCurrentTransactionKey(ik, v.KSN)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s ksn %X: transaction key: %w", s.Name, v.KSN, err))
+			continue
+		}
+		if !bytes.Equal(tk, v.TransactionKey) {
+			mismatch(v.KSN, "transaction key", tk, v.TransactionKey)
+			continue
+		}
+
+		pinBlock, err := impl.EncryptPin(tk, v.KSN, s.Pin, s.Pan, s.KeyType)
+		if err == nil {
+			var pin string
+			pin, err = impl.DecryptPin(tk, v.KSN, pinBlock, s.Pan, s.KeyType)
+			if err == nil && pin != s.Pin {
+				err = fmt.Errorf("got %s, want %s", pin, s.Pin)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s ksn %X: pin round trip: %w", s.Name, v.KSN, err))
+		}
+
+		checks := []struct {
+			name string
+			want []byte
+			run  func() ([]byte, error)
+		}{
+			{"request cmac", v.CMACRequest, func() ([]byte, error) {
+				return impl.GenerateCMAC(tk, v.KSN, s.Data, s.KeyType, pkg.ActionRequest)
+			}},
+			{"response cmac", v.CMACResponse, func() ([]byte, error) {
+				return impl.GenerateCMAC(tk, v.KSN, s.Data, s.KeyType, pkg.ActionResponse)
+			}},
+			{"request hmac", v.HMACRequest, func() ([]byte, error) {
+				return impl.GenerateHMAC(tk, v.KSN, s.Data, s.HmacKeyType, pkg.ActionRequest)
+			}},
+			{"response hmac", v.HMACResponse, func() ([]byte, error) {
+				return impl.GenerateHMAC(tk, v.KSN, s.Data, s.HmacKeyType, pkg.ActionResponse)
+			}},
+			{"request data", v.DataRequest, func() ([]byte, error) {
+				return impl.EncryptData(tk, v.KSN, nil, s.Data, s.KeyType, pkg.ActionRequest)
+			}},
+			{"response data", v.DataResponse, func() ([]byte, error) {
+				return impl.EncryptData(tk, v.KSN, nil, s.Data, s.KeyType, pkg.ActionResponse)
+			}},
+		}
+		for _, c := range checks {
+			got, err := c.run()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s ksn %X: %s: %w", s.Name, v.KSN, c.name, err))
+			} else if !bytes.Equal(got, c.want) {
+				mismatch(v.KSN, c.name, got, c.want)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,107 @@
+package testvectors_test
+
+import (
+	"testing"
+
+	"github.com/moov-io/dukpt/pkg/aes"
+	"github.com/moov-io/dukpt/pkg/des"
+	"github.com/moov-io/dukpt/pkg/testvectors"
+	"github.com/stretchr/testify/require"
+)
+
+type desImpl struct{}
+
+func (desImpl) DerivationOfInitialKey(bdk, ksn []byte) ([]byte, error) {
+	return des.DerivationOfInitialKey(bdk, ksn)
+}
+
+func (desImpl) DeriveCurrentTransactionKey(ik, ksn []byte) ([]byte, error) {
+	return des.DeriveCurrentTransactionKey(ik, ksn)
+}
+
+func (desImpl) EncryptPin(currentKey []byte, pin, pan string, format string) ([]byte, error) {
+	return des.EncryptPin(currentKey, pin, pan, format)
+}
+
+func (desImpl) GenerateMac(currentKey []byte, plainText, action string) ([]byte, error) {
+	return des.GenerateMac(currentKey, plainText, action)
+}
+
+func (desImpl) EncryptData(currentKey, iv []byte, plainText, action string) ([]byte, error) {
+	return des.EncryptData(currentKey, iv, plainText, action)
+}
+
+type aesImpl struct{}
+
+func (aesImpl) DerivationOfInitialKey(bdk, initialKeyID []byte) ([]byte, error) {
+	return aes.DerivationOfInitialKey(bdk, initialKeyID)
+}
+
+func (aesImpl) DeriveCurrentTransactionKey(ik, ksn []byte) ([]byte, error) {
+	return aes.DeriveCurrentTransactionKey(ik, ksn)
+}
+
+func (aesImpl) EncryptPin(currentKey, ksn []byte, pin, pan string, keyType string) ([]byte, error) {
+	return aes.EncryptPin(currentKey, ksn, pin, pan, keyType)
+}
+
+func (aesImpl) DecryptPin(currentKey, ksn, ciphertext []byte, pan string, keyType string) (string, error) {
+	return aes.DecryptPin(currentKey, ksn, ciphertext, pan, keyType)
+}
+
+func (aesImpl) GenerateCMAC(currentKey, ksn []byte, plaintext string, keyType string, action string) ([]byte, error) {
+	return aes.GenerateCMAC(currentKey, ksn, plaintext, keyType, action)
+}
+
+func (aesImpl) GenerateHMAC(currentKey, ksn []byte, plaintext string, keyType string, action string) ([]byte, error) {
+	return aes.GenerateHMAC(currentKey, ksn, plaintext, keyType, action)
+}
+
+func (aesImpl) EncryptData(currentKey, ksn, iv []byte, plaintext, keyType, action string) ([]byte, error) {
+	return aes.EncryptData(currentKey, ksn, iv, plaintext, keyType, action)
+}
+
+// brokenMac flips the mac of the wrapped implementation
+type brokenMac struct{ desImpl }
+
+func (brokenMac) GenerateMac(currentKey []byte, plainText, action string) ([]byte, error) {
+	mac, err := des.GenerateMac(currentKey, plainText, action)
+	if err != nil {
+		return nil, err
+	}
+	mac[0] ^= 0xFF
+	return mac, nil
+}
+
+func TestDesSuites(t *testing.T) {
+	for _, suite := range testvectors.DesSuites {
+		t.Run(suite.Name, func(t *testing.T) {
+			require.NoError(t, suite.Check(desImpl{}))
+		})
+	}
+}
+
+func TestAesSuites(t *testing.T) {
+	for _, suite := range testvectors.AesSuites {
+		t.Run(suite.Name, func(t *testing.T) {
+			require.NoError(t, suite.Check(aesImpl{}))
+		})
+	}
+}
+
+func TestCheckAll(t *testing.T) {
+	require.NoError(t, testvectors.CheckAll(desImpl{}, aesImpl{}))
+	require.NoError(t, testvectors.CheckAll(nil, aesImpl{}))
+
+	err := testvectors.CheckAll(brokenMac{}, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "request mac mismatch")
+}
+
+func TestCheckReportsMismatch(t *testing.T) {
+	err := testvectors.X9241InitialSequence.Check(brokenMac{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ksn FFFF9876543210E00001: request mac mismatch")
+	require.Contains(t, err.Error(), "ksn FFFF9876543210E00005: response mac mismatch")
+	require.NotContains(t, err.Error(), "pin block")
+}
@@ -0,0 +1,50 @@
+package pkg
+
+// Usages of working keys derived from transaction key
+const (
+	UsagePin  = "pin"
+	UsageMac  = "mac"
+	UsageData = "data"
+)
+
+// TraceStep is one step of key derivation
+type TraceStep struct {
+	// Name of the step (e.g. "shift register", "intermediate key", "derivation data")
+	Name string
+	// Detail of the step (e.g. shift bit or transaction counter)
+	Detail string
+	// Value of the step, a copy owned by the tracer
+	Value []byte
+	// Secret is true when value is key material
+	Secret bool
+}
+
+// Tracer observes steps of key derivation for troubleshooting
+//
+// NOTE:
+//   - Secret steps carry clear key material, tracers must not log them unless asked for explicitly
+type Tracer interface {
+	Trace(step TraceStep)
+}
+
+// TracerFunc is function adapter of Tracer
+type TracerFunc func(step TraceStep)
+
+// Trace calls f(step)
+func (f TracerFunc) Trace(step TraceStep) {
+	f(step)
+}
+
+// TraceValue sends step with a copy of value to tracer, nil tracer is ignored
+func TraceValue(tracer Tracer, name, detail string, value []byte, secret bool) {
+	if tracer == nil {
+		return
+	}
+
+	tracer.Trace(TraceStep{
+		Name:   name,
+		Detail: detail,
+		Value:  append([]byte(nil), value...),
+		Secret: secret,
+	})
+}
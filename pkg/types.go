@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"errors"
+)
+
+// Action selects request or response working keys of transaction key
+type Action uint8
+
+const (
+	Request Action = iota + 1
+	Response
+)
+
+// Parse action from name ("request" or "response"), unknown names are rejected
+func ParseAction(name string) (Action, error) {
+	switch name {
+	case ActionRequest:
+		return Request, nil
+	case ActionResponse:
+		return Response, nil
+	}
+	return 0, errors.New("invalid action")
+}
+
+func (a Action) String() string {
+	switch a {
+	case Request:
+		return ActionRequest
+	case Response:
+		return ActionResponse
+	}
+	return ""
+}
+
+// Validate returns error when action is not Request or Response
+func (a Action) Validate() error {
+	if a != Request && a != Response {
+		return errors.New("invalid action")
+	}
+	return nil
+}
+
+// MacType selects message authentication algorithm
+type MacType uint8
+
+const (
+	CMAC MacType = iota + 1
+	HMAC
+)
+
+// Parse mac type from name ("cmac" or "hmac"), unknown names are rejected
+func ParseMacType(name string) (MacType, error) {
+	switch name {
+	case MaxTypeCmac:
+		return CMAC, nil
+	case MaxTypeHmac:
+		return HMAC, nil
+	}
+	return 0, errors.New("invalid mac type")
+}
+
+func (m MacType) String() string {
+	switch m {
+	case CMAC:
+		return MaxTypeCmac
+	case HMAC:
+		return MaxTypeHmac
+	}
+	return ""
+}
+
+// Validate returns error when mac type is not CMAC or HMAC
+func (m MacType) Validate() error {
+	if m != CMAC && m != HMAC {
+		return errors.New("invalid mac type")
+	}
+	return nil
+}
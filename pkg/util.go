@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 )
 
 const (
@@ -154,3 +155,69 @@ const (
 	ActionRequest  = "request"
 	ActionResponse = "response"
 )
+
+// Key serial number lengths, legacy TDES ksn ends with 21 bits counter
+const (
+	LegacyKsnLength = 10
+	AesKsnLength    = 12
+
+	legacyTcMask   = 0x1FFFFF
+	legacyBaseMask = 0xE0
+)
+
+// Map legacy 10 bytes key serial number onto AES DUKPT key serial number (compatibility mode)
+//
+// NOTE:
+//   - First 4 bytes of legacy ksn are BDK ID
+//   - Next 4 bytes with the 21 counter bits cleared are Derivation ID (3 bytes and 3 bits of device id)
+//   - The 21 bits counter of legacy ksn is the AES transaction counter, so the mapping is reversible
+//
+// Params:
+//   - ksn is 10 bytes legacy key serial number
+//
+// Return Params:
+//   - result is 12 bytes aes key serial number
+//   - err
+func LegacyKsnToAesKsn(ksn []byte) ([]byte, error) {
+	if len(ksn) != LegacyKsnLength {
+		return nil, fmt.Errorf("legacy key serial number length must be %d bytes", LegacyKsnLength)
+	}
+
+	out := make([]byte, AesKsnLength)
+	copy(out, ksn[:8])
+	out[7] &= legacyBaseMask
+	binary.BigEndian.PutUint32(out[8:], GetDesTcFromKsn(ksn))
+
+	return out, nil
+}
+
+// Map AES DUKPT key serial number of compatibility mode back to legacy 10 bytes key serial number
+//
+// NOTE:
+//   - Reverse of LegacyKsnToAesKsn, the low 5 bits of Derivation ID must be zero
+//     and the counter must fit into 21 bits
+//
+// Params:
+//   - ksn is 12 bytes aes key serial number
+//
+// Return Params:
+//   - result is 10 bytes legacy key serial number
+//   - err
+func AesKsnToLegacyKsn(ksn []byte) ([]byte, error) {
+	if len(ksn) != AesKsnLength {
+		return nil, fmt.Errorf("key serial number length must be %d bytes", AesKsnLength)
+	}
+
+	tc := GetAesTcFromKsn(ksn)
+	if ksn[7]&^legacyBaseMask != 0 || tc > legacyTcMask {
+		return nil, errors.New("key serial number is not mapped from legacy key serial number")
+	}
+
+	out := make([]byte, LegacyKsnLength)
+	copy(out, ksn[:8])
+	out[7] |= byte((tc >> 16) & 0x1F)
+	out[8] = byte((tc >> 8) & 0xFF)
+	out[9] = byte(tc & 0xFF)
+
+	return out, nil
+}
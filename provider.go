@@ -0,0 +1,100 @@
+package dukpt
+
+import "errors"
+
+// KeyHandle is opaque reference to a key held by KeyProvider, its format is defined by the provider
+type KeyHandle string
+
+// KeyProvider derives DUKPT keys and runs pin, mac and data operations on keys it holds
+//
+// NOTE:
+//   - Keys never leave the provider, callers keep only key handles (e.g. in server.Machine)
+//   - Methods follow the functions of this package, options are read with ResolveOptions
+//   - hsm.Software keeps keys encrypted under a local master key, hsm/pkcs11 keeps them in a PKCS#11 token
+type KeyProvider interface {
+	// DeriveIK derives initial key of base derivative key
+	DeriveIK(bdk KeyHandle, ksn []byte, opts ...Option) (KeyHandle, error)
+	// DeriveTransactionKey derives current transaction key of initial key
+	DeriveTransactionKey(ik KeyHandle, ksn []byte, opts ...Option) (KeyHandle, error)
+	// EncryptPin encrypts PIN block with pin key of transaction key
+	EncryptPin(tk KeyHandle, ksn, pin, pan []byte, opts ...Option) ([]byte, error)
+	// DecryptPin decrypts PIN block with pin key of transaction key
+	DecryptPin(tk KeyHandle, ksn, ciphertext, pan []byte, opts ...Option) ([]byte, error)
+	// MAC generates message authentication code with mac key of transaction key
+	MAC(tk KeyHandle, ksn, msg []byte, opts ...Option) ([]byte, error)
+	// EncryptData encrypts data in CBC mode with data key of transaction key
+	EncryptData(tk KeyHandle, ksn, iv, plaintext []byte, opts ...Option) ([]byte, error)
+	// DecryptData decrypts data in CBC mode with data key of transaction key
+	DecryptData(tk KeyHandle, ksn, iv, ciphertext []byte, opts ...Option) ([]byte, error)
+}
+
+// Provider runs the functions of this package on keys held by a KeyProvider
+//
+// NOTE:
+//   - Keys are key handles of the provider, the clear keys never reach the caller
+//   - Options are the options of the package functions (WithAlgorithm, WithPinFormat, ...)
+type Provider struct {
+	keys KeyProvider
+}
+
+// NewProvider returns façade of key provider
+func NewProvider(keys KeyProvider) (*Provider, error) {
+	if keys == nil {
+		return nil, errors.New("key provider is required")
+	}
+	return &Provider{keys: keys}, nil
+}
+
+// DeriveIK derives initial key from base derivative key held by provider
+func (p *Provider) DeriveIK(bdk KeyHandle, ksn []byte, opts ...Option) (KeyHandle, error) {
+	return p.keys.DeriveIK(bdk, ksn, opts...)
+}
+
+// DeriveTransactionKey derives current transaction key from initial key held by provider
+func (p *Provider) DeriveTransactionKey(ik KeyHandle, ksn []byte, opts ...Option) (KeyHandle, error) {
+	return p.keys.DeriveTransactionKey(ik, ksn, opts...)
+}
+
+// DeriveTransactionKeyFromBDK derives initial key and current transaction key from base derivative key held by provider
+func (p *Provider) DeriveTransactionKeyFromBDK(bdk KeyHandle, ksn []byte, opts ...Option) (KeyHandle, error) {
+	ik, err := p.keys.DeriveIK(bdk, ksn, opts...)
+	if err != nil {
+		return "", err
+	}
+	return p.keys.DeriveTransactionKey(ik, ksn, opts...)
+}
+
+// EncryptPin encrypts PIN block with pin key of transaction key held by provider
+func (p *Provider) EncryptPin(tk KeyHandle, ksn, pin, pan []byte, opts ...Option) ([]byte, error) {
+	return p.keys.EncryptPin(tk, ksn, pin, pan, opts...)
+}
+
+// DecryptPin decrypts PIN block with pin key of transaction key held by provider
+func (p *Provider) DecryptPin(tk KeyHandle, ksn, ciphertext, pan []byte, opts ...Option) ([]byte, error) {
+	return p.keys.DecryptPin(tk, ksn, ciphertext, pan, opts...)
+}
+
+// MAC generates message authentication code with mac key of transaction key held by provider
+func (p *Provider) MAC(tk KeyHandle, ksn, msg []byte, opts ...Option) ([]byte, error) {
+	return p.keys.MAC(tk, ksn, msg, opts...)
+}
+
+// VerifyMAC verifies message authentication code like VerifyMAC with transaction key held by provider
+func (p *Provider) VerifyMAC(tk KeyHandle, ksn, msg, mac []byte, opts ...Option) error {
+	expected, err := p.keys.MAC(tk, ksn, msg, opts...)
+	if err != nil {
+		return err
+	}
+
+	return compareMac(expected, mac)
+}
+
+// EncryptData encrypts data in CBC mode with data key of transaction key held by provider
+func (p *Provider) EncryptData(tk KeyHandle, ksn, iv, plaintext []byte, opts ...Option) ([]byte, error) {
+	return p.keys.EncryptData(tk, ksn, iv, plaintext, opts...)
+}
+
+// DecryptData decrypts data in CBC mode with data key of transaction key held by provider
+func (p *Provider) DecryptData(tk KeyHandle, ksn, iv, ciphertext []byte, opts ...Option) ([]byte, error) {
+	return p.keys.DecryptData(tk, ksn, iv, ciphertext, opts...)
+}